@@ -0,0 +1,32 @@
+//go:build linux
+
+// micropod-agent serves the micropod guest agent, which the daemon uses for
+// cp, health checks and clock synchronization, in VMs that boot the image's
+// own init. Add it to the image and start it as a service; micropod-init
+// serves the same agent itself.
+//
+// It must be built as a static binary for the guest architecture:
+//
+//	CGO_ENABLED=0 go build -o micropod-agent ./cmd/micropod-agent
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"micropod/pkg/agent"
+)
+
+func main() {
+	accept, err := agent.ListenVsock(agent.Port)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "micropod-agent: %v\n", err)
+		os.Exit(1)
+	}
+
+	server := &agent.Server{Name: "micropod-agent"}
+	if err := server.Serve(accept); err != nil {
+		fmt.Fprintf(os.Stderr, "micropod-agent: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,500 @@
+//go:build linux
+
+// micropod-init is the init process micropod installs into every rootfs. It
+// prepares the guest, mounts the VM's volumes, runs the workload and powers
+// the VM off when the workload exits, reporting its exit status to the host
+// over vsock. While the workload runs, it serves the host's agent requests,
+// such as micropod cp and health checks, over vsock. A VM booted for a pool
+// waits for the agent to hand it its workload.
+//
+// It must be built as a static binary for the guest architecture:
+//
+//	CGO_ENABLED=0 go build -o micropod-init ./cmd/micropod-init
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"micropod/pkg/agent"
+	"micropod/pkg/guest"
+)
+
+// Exit statuses reported when the workload cannot be run, as in a shell.
+const (
+	exitSetupFailed     = 1
+	exitCannotRun       = 126
+	exitCommandNotFound = 127
+)
+
+// exitReportTimeout bounds reporting the exit status to the host, which
+// may not be listening.
+const exitReportTimeout = time.Second
+
+func main() {
+	code, err := run()
+	if err != nil {
+		logf("%v", err)
+	}
+
+	fmt.Fprintln(os.Stderr, guest.FormatExitStatus(code))
+	reportExit(code)
+	poweroff()
+}
+
+// reportExit sends the workload's exit status to the host.
+func reportExit(code int) {
+	conn, err := agent.DialVsockHost(agent.ExitPort, exitReportTimeout)
+	if err != nil {
+		logf("failed to report exit status: %v", err)
+		return
+	}
+	defer conn.Close()
+	if err := agent.ReportExit(conn, code); err != nil {
+		logf("failed to report exit status: %v", err)
+	}
+}
+
+// run sets up the guest and runs the workload, returning its exit status.
+func run() (int, error) {
+	mountSystemFilesystems()
+
+	// micropodd stops a VM with Ctrl+Alt+Del. Instead of rebooting at once,
+	// the kernel then sends SIGINT, which is forwarded to the workload, so
+	// the guest powers off through poweroff and its filesystems are synced.
+	if err := syscall.Reboot(syscall.LINUX_REBOOT_CMD_CAD_OFF); err != nil {
+		logf("failed to disable Ctrl+Alt+Del: %v", err)
+	}
+
+	f, err := os.Open(guest.ConfigDevice)
+	if err != nil {
+		return exitSetupFailed, fmt.Errorf("failed to open config device: %w", err)
+	}
+	config, err := guest.ReadConfig(f)
+	f.Close()
+	if err != nil {
+		return exitSetupFailed, err
+	}
+
+	// A pooled guest is handed out later, with the config of its workload.
+	pooled := config.Pooled
+	if pooled {
+		starts := make(chan guest.Config, 1)
+		go serveAgent(startOnce(starts))
+		config = <-starts
+	}
+
+	if config.Hostname != "" {
+		if err := syscall.Sethostname([]byte(config.Hostname)); err != nil {
+			logf("failed to set hostname: %v", err)
+		}
+	}
+
+	if err := mountWritable(config); err != nil {
+		return exitSetupFailed, err
+	}
+
+	if err := configureNetwork(config); err != nil {
+		return exitSetupFailed, err
+	}
+
+	for _, m := range config.Mounts {
+		if err := mountDrive(m); err != nil {
+			return exitSetupFailed, err
+		}
+	}
+
+	// Commands run by the agent see the workload's environment too.
+	for _, kv := range config.Env {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			os.Setenv(k, v)
+		}
+	}
+
+	if !pooled {
+		go serveAgent(nil)
+	}
+
+	return runCommand(config)
+}
+
+// startOnce returns the agent's start hook of a pooled guest, which passes
+// the first workload config it receives on to starts.
+func startOnce(starts chan<- guest.Config) func(guest.Config) error {
+	var once sync.Once
+	return func(config guest.Config) error {
+		err := fmt.Errorf("the workload was already started")
+		once.Do(func() {
+			starts <- config
+			err = nil
+		})
+		return err
+	}
+}
+
+// mountSystemFilesystems mounts the pseudo filesystems a workload expects.
+// Failures are logged only, since the image may already provide them.
+func mountSystemFilesystems() {
+	mounts := []struct {
+		source, target, fstype string
+		flags                  uintptr
+		data                   string
+	}{
+		{"proc", "/proc", "proc", syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC, ""},
+		{"sysfs", "/sys", "sysfs", syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC, ""},
+		{"devtmpfs", "/dev", "devtmpfs", syscall.MS_NOSUID, "mode=0755"},
+		{"devpts", "/dev/pts", "devpts", syscall.MS_NOSUID | syscall.MS_NOEXEC, "mode=0620,ptmxmode=0666"},
+		{"tmpfs", "/dev/shm", "tmpfs", syscall.MS_NOSUID | syscall.MS_NODEV, "mode=1777"},
+		{"tmpfs", "/run", "tmpfs", syscall.MS_NOSUID | syscall.MS_NODEV, "mode=0755"},
+	}
+
+	for _, m := range mounts {
+		if err := os.MkdirAll(m.target, 0755); err != nil {
+			logf("failed to create %s: %v", m.target, err)
+			continue
+		}
+		if err := syscall.Mount(m.source, m.target, m.fstype, m.flags, m.data); err != nil && err != syscall.EBUSY {
+			logf("failed to mount %s: %v", m.target, err)
+		}
+	}
+}
+
+// mountWritable mounts the tmpfs and overlay directories, and the scratch
+// directories of a read-only root.
+func mountWritable(config guest.Config) error {
+	if config.ReadOnlyRoot {
+		for _, dir := range guest.ScratchDirs {
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				continue
+			}
+			if err := mountTmpfs(dir); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, dir := range config.Tmpfs {
+		if err := mountTmpfs(dir); err != nil {
+			return err
+		}
+	}
+
+	for i, dir := range config.Overlays {
+		if err := mountOverlay(dir, fmt.Sprintf("%s/%d", guest.OverlayDir, i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func mountTmpfs(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create tmpfs mount point %s: %w", dir, err)
+	}
+	if err := syscall.Mount("tmpfs", dir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+		return fmt.Errorf("failed to mount tmpfs on %s: %w", dir, err)
+	}
+	return nil
+}
+
+// mountOverlay makes dir writable by overlaying it with upper and work
+// directories under layerDir, which lives on a tmpfs.
+func mountOverlay(dir, layerDir string) error {
+	upper, work := layerDir+"/upper", layerDir+"/work"
+	for _, d := range []string{dir, upper, work} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return fmt.Errorf("failed to create overlay directory %s: %w", d, err)
+		}
+	}
+
+	// The root of the overlay takes its mode and owner from upper.
+	var st syscall.Stat_t
+	if err := syscall.Stat(dir, &st); err != nil {
+		return fmt.Errorf("failed to stat %s: %w", dir, err)
+	}
+	if err := syscall.Chmod(upper, st.Mode&07777); err != nil {
+		return fmt.Errorf("failed to set mode of %s: %w", upper, err)
+	}
+	if err := os.Lchown(upper, int(st.Uid), int(st.Gid)); err != nil {
+		return fmt.Errorf("failed to set owner of %s: %w", upper, err)
+	}
+
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", dir, upper, work)
+	if err := syscall.Mount("overlay", dir, "overlay", 0, data); err != nil {
+		return fmt.Errorf("failed to mount overlay on %s: %w", dir, err)
+	}
+	return nil
+}
+
+// configureNetwork brings up the loopback device and the VM's network
+// interfaces, and adds the hosts of its networks to /etc/hosts.
+func configureNetwork(config guest.Config) error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to create network socket: %w", err)
+	}
+	defer unix.Close(fd)
+
+	if err := setLinkUp(fd, "lo"); err != nil {
+		logf("failed to bring up lo: %v", err)
+	}
+
+	for _, iface := range config.Interfaces {
+		name, err := linkByMAC(iface.MAC)
+		if err != nil {
+			return err
+		}
+		if err := setAddress(fd, name, iface.Address); err != nil {
+			return err
+		}
+		if err := setLinkUp(fd, name); err != nil {
+			return err
+		}
+	}
+
+	if len(config.Hosts) > 0 {
+		if err := guest.WriteHosts(guest.HostsFile, config.Hosts); err != nil {
+			// A read-only root has no writable /etc/hosts.
+			logf("failed to update /etc/hosts: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// linkByMAC returns the name of the network device with the given MAC
+// address.
+func linkByMAC(mac string) (string, error) {
+	entries, err := os.ReadDir("/sys/class/net")
+	if err != nil {
+		return "", fmt.Errorf("failed to list network devices: %w", err)
+	}
+	for _, entry := range entries {
+		address, err := os.ReadFile("/sys/class/net/" + entry.Name() + "/address")
+		if err == nil && strings.EqualFold(strings.TrimSpace(string(address)), mac) {
+			return entry.Name(), nil
+		}
+	}
+	return "", fmt.Errorf("no network device with MAC address %s", mac)
+}
+
+func setAddress(fd int, name, cidr string) error {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil || !prefix.Addr().Is4() {
+		return fmt.Errorf("invalid address %q for %s", cidr, name)
+	}
+
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		return err
+	}
+	addr := prefix.Addr().As4()
+	if err := ifr.SetInet4Addr(addr[:]); err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFADDR, ifr); err != nil {
+		return fmt.Errorf("failed to set address of %s: %w", name, err)
+	}
+
+	mask := net.CIDRMask(prefix.Bits(), 32)
+	if err := ifr.SetInet4Addr(mask); err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFNETMASK, ifr); err != nil {
+		return fmt.Errorf("failed to set netmask of %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func setLinkUp(fd int, name string) error {
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return fmt.Errorf("failed to get flags of %s: %w", name, err)
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr); err != nil {
+		return fmt.Errorf("failed to bring up %s: %w", name, err)
+	}
+	return nil
+}
+
+func mountDrive(m guest.Mount) error {
+	if err := os.MkdirAll(m.Target, 0755); err != nil {
+		return fmt.Errorf("failed to create mount point %s: %w", m.Target, err)
+	}
+
+	var flags uintptr
+	if m.ReadOnly {
+		flags |= syscall.MS_RDONLY
+	}
+	if err := syscall.Mount(m.Device, m.Target, m.FSType, flags, ""); err != nil {
+		return fmt.Errorf("failed to mount %s on %s: %w", m.Device, m.Target, err)
+	}
+
+	return nil
+}
+
+// runCommand starts the workload and, as PID 1, reaps every process until
+// the workload itself exits. Termination signals are forwarded to it. The
+// exit status of a workload killed by a signal is 128 plus the signal.
+func runCommand(config guest.Config) (int, error) {
+	if len(config.Command) == 0 {
+		return exitSetupFailed, fmt.Errorf("no command specified")
+	}
+
+	path, err := exec.LookPath(config.Command[0])
+	if err != nil {
+		return exitCommandNotFound, fmt.Errorf("command not found: %w", err)
+	}
+
+	dir := config.WorkingDir
+	if dir == "" {
+		dir = "/"
+	}
+
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	sys := &syscall.SysProcAttr{Setsid: true}
+	if config.TTY {
+		// /dev/console cannot be a controlling terminal; the serial port
+		// behind it can, which gives the workload job control and Ctrl-C.
+		tty, err := os.OpenFile(guest.ConsoleDevice, os.O_RDWR, 0)
+		if err != nil {
+			return exitSetupFailed, fmt.Errorf("failed to open console: %w", err)
+		}
+		defer tty.Close()
+		files = []*os.File{tty, tty, tty}
+		sys.Setctty = true
+		sys.Ctty = 0
+	}
+
+	process, err := os.StartProcess(path, config.Command, &os.ProcAttr{
+		Dir:   dir,
+		Env:   os.Environ(),
+		Files: files,
+		Sys:   sys,
+	})
+	if err != nil {
+		return exitCannotRun, fmt.Errorf("failed to start command: %w", err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	go func() {
+		for sig := range signals {
+			process.Signal(sig)
+		}
+	}()
+
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, 0, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return exitSetupFailed, fmt.Errorf("failed to wait for command: %w", err)
+		}
+		if pid == process.Pid {
+			if status.Signaled() {
+				return 128 + int(status.Signal()), nil
+			}
+			return status.ExitStatus(), nil
+		}
+		agentProcesses.reaped(pid, status)
+	}
+}
+
+// agentProcesses starts the agent's exec commands. Their exit statuses are
+// collected by the reaping loop of runCommand, which hands them over.
+var agentProcesses = &childProcesses{waiting: make(map[int]chan syscall.WaitStatus)}
+
+type childProcesses struct {
+	mu      sync.Mutex
+	waiting map[int]chan syscall.WaitStatus
+}
+
+// Start registers the process while holding the lock, so that reaped waits
+// for the registration of a process that exits at once.
+func (c *childProcesses) Start(path string, argv []string, attr *os.ProcAttr) (*os.Process, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	process, err := os.StartProcess(path, argv, attr)
+	if err != nil {
+		return nil, err
+	}
+	c.waiting[process.Pid] = make(chan syscall.WaitStatus, 1)
+	return process, nil
+}
+
+func (c *childProcesses) Wait(p *os.Process) (syscall.WaitStatus, error) {
+	c.mu.Lock()
+	ch, ok := c.waiting[p.Pid]
+	c.mu.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("process %d was not started by the agent", p.Pid)
+	}
+	status := <-ch
+
+	c.mu.Lock()
+	delete(c.waiting, p.Pid)
+	c.mu.Unlock()
+	return status, nil
+}
+
+// reaped hands the status of a reaped child to the agent if it started it.
+// Other children are orphans adopted by init.
+func (c *childProcesses) reaped(pid int, status syscall.WaitStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ch, ok := c.waiting[pid]; ok {
+		ch <- status
+	}
+}
+
+// serveAgent answers the host's requests on the agent vsock port for as long
+// as the guest runs. start, if not nil, starts the workload of a pooled
+// guest.
+func serveAgent(start func(guest.Config) error) {
+	accept, err := agent.ListenVsock(agent.Port)
+	if err != nil {
+		logf("failed to start agent: %v", err)
+		return
+	}
+
+	server := &agent.Server{Name: "micropod-init", Processes: agentProcesses, Start: start}
+	err = server.Serve(accept)
+	logf("agent stopped: %v", err)
+}
+
+// poweroff flushes the filesystems and reboots, which makes Firecracker
+// exit since the kernel is booted with reboot=k.
+func poweroff() {
+	syscall.Sync()
+	if err := syscall.Reboot(syscall.LINUX_REBOOT_CMD_RESTART); err != nil {
+		logf("failed to reboot: %v", err)
+	}
+	// PID 1 must never exit.
+	for {
+		time.Sleep(time.Hour)
+	}
+}
+
+func logf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "micropod-init: "+format+"\n", args...)
+}
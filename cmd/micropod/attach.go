@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"micropod/pkg/api"
+	"micropod/pkg/console"
+)
+
+var attachCmd = &cobra.Command{
+	Use:   "attach [vm]",
+	Short: "Attach to the serial console of a VM started with --tty",
+	Long: `Attach the terminal to the serial console of a VM started with --tty. Detach with
+Ctrl-P Ctrl-Q; the VM keeps running and can be attached to again.
+
+Only one session types into the console at a time. While it is attached, further
+sessions mirror the console read-only.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		detached, err := attachConsole(newClient(cmd), args[0])
+		if err != nil {
+			return err
+		}
+		if detached {
+			fmt.Fprintf(os.Stderr, "\r\nDetached from VM %s\r\n", args[0])
+		}
+		return nil
+	},
+}
+
+// attachConsole connects the terminal to the console of a VM until the VM
+// exits or the user detaches, which it reports.
+func attachConsole(client *api.Client, vmRef string) (detached bool, err error) {
+	conn, err := client.Attach(vmRef)
+	if err != nil {
+		return false, fmt.Errorf("failed to attach to VM %s: %w", vmRef, err)
+	}
+	defer conn.Close()
+
+	if conn.ReadOnly {
+		fmt.Fprintf(os.Stderr, "Another session is attached to VM %s; mirroring its console read-only\r\n", vmRef)
+	}
+
+	// Raw mode passes every key, such as Ctrl-C, to the guest, and makes
+	// the detach keys work without Enter.
+	if console.IsTerminal(os.Stdin) {
+		restore, err := console.MakeRaw(os.Stdin)
+		if err != nil {
+			return false, err
+		}
+		defer restore()
+	}
+
+	output := make(chan struct{})
+	go func() {
+		io.Copy(os.Stdout, conn)
+		close(output)
+	}()
+
+	input := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(conn, console.NewDetachReader(os.Stdin))
+		input <- err
+	}()
+
+	select {
+	case <-output:
+		return false, nil
+	case err := <-input:
+		if errors.Is(err, console.ErrDetached) {
+			return true, nil
+		}
+		// Without more input, keep printing the output.
+		<-output
+		return false, nil
+	}
+}
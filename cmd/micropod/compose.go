@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"micropod/pkg/api"
+	"micropod/pkg/compose"
+	"micropod/pkg/manager"
+	"micropod/pkg/state"
+)
+
+// composeFiles are the files compose looks for when -f is not given.
+var composeFiles = []string{"compose.yaml", "compose.yml"}
+
+var composeCmd = &cobra.Command{
+	Use:   "compose",
+	Short: "Run projects of VMs described by a compose file",
+	Long: `Run the services of a compose file as VMs on a network of their own. Each service
+holds the fields of a VM spec file plus depends_on; its VM is named <project>-<service> and
+labelled micropod.project and micropod.service. The project is named by the file's name
+field, --project-name, or the directory of the file.`,
+}
+
+var composeUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Create the project network and start the services in dependency order",
+	Long: `Create the project network, <project>-default, if it does not exist, and apply the
+services in dependency order: a service starts once its dependencies have started, are
+healthy (service_healthy) or have exited with status 0 (service_completed_successfully).
+Services whose VM runs from the same spec are left running; the others are replaced.
+VMs of the project whose service is no longer in the file are removed first.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		project, err := loadProject(cmd)
+		if err != nil {
+			return err
+		}
+		services, err := project.Order()
+		if err != nil {
+			return err
+		}
+
+		client := newClient(cmd)
+		if err := ensureProjectNetwork(client, project); err != nil {
+			return err
+		}
+		if err := removeOrphans(client, project); err != nil {
+			return err
+		}
+
+		for _, service := range services {
+			if err := waitForDependencies(client, project, service); err != nil {
+				return err
+			}
+
+			spec, err := project.VMSpec(service)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", service.Name, err)
+			}
+			result, err := client.ApplyVM(spec)
+			if err != nil {
+				return fmt.Errorf("failed to start service %s: %w", service.Name, err)
+			}
+			fmt.Printf("%s %s (%s)\n", result.Name, result.Action, result.ID)
+		}
+		return nil
+	},
+}
+
+var composeDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Stop and remove the project's VMs and network",
+	Long: `Stop and remove every VM labelled with the project, services before their
+dependencies, then remove the project network. VMs of services no longer in the compose
+file stop first; with only --project-name and no compose file, VMs stop in the reverse
+order of their creation.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		project, err := downProject(cmd)
+		if err != nil {
+			return err
+		}
+
+		client := newClient(cmd)
+		vms, err := projectVMs(client, project.Name)
+		if err != nil {
+			return err
+		}
+		vms, err = project.StopOrder(vms)
+		if err != nil {
+			return err
+		}
+
+		for _, vm := range vms {
+			if err := client.StopVM(vm.ID); err != nil {
+				return fmt.Errorf("failed to stop VM %s: %w", vm.Name, err)
+			}
+			fmt.Printf("%s removed\n", vm.Name)
+		}
+
+		networkName := project.NetworkName()
+		networks, err := client.ListNetworks()
+		if err != nil {
+			return fmt.Errorf("failed to list networks: %w", err)
+		}
+		for _, n := range networks {
+			if n.Name == networkName {
+				if err := client.RemoveNetwork(n.Name); err != nil {
+					return fmt.Errorf("failed to remove network %s: %w", n.Name, err)
+				}
+				fmt.Printf("%s removed\n", n.Name)
+			}
+		}
+		return nil
+	},
+}
+
+var composePsCmd = &cobra.Command{
+	Use:   "ps",
+	Short: "List the project's VMs",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		quiet, _ := cmd.Flags().GetBool("quiet")
+		format, _ := cmd.Flags().GetString("format")
+
+		name, err := projectName(cmd)
+		if err != nil {
+			return err
+		}
+
+		client := newClient(cmd)
+		vms, err := projectVMs(client, name)
+		if err != nil {
+			return err
+		}
+
+		if quiet {
+			for _, vm := range vms {
+				fmt.Println(vm.ID)
+			}
+			return nil
+		}
+
+		if ok, err := printFormatted(os.Stdout, format, vms); ok {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSERVICE\tIMAGE\tSTATE\tADDRESS")
+		for _, vm := range vms {
+			var addresses []string
+			for _, attachment := range vm.Networks {
+				addresses = append(addresses, attachment.Address)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				vm.Name, vm.Labels[manager.ServiceLabel], vm.ImageName, stateWithHealth(vm), strings.Join(addresses, ","))
+		}
+		return w.Flush()
+	},
+}
+
+var composeLogsCmd = &cobra.Command{
+	Use:   "logs [service...]",
+	Short: "Print the console output of the project's VMs",
+	Long: `Print the console output of the given services, or of every service, each line
+prefixed with its service. With --follow, keep printing new output until the VMs exit.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		follow, _ := cmd.Flags().GetBool("follow")
+
+		name, err := projectName(cmd)
+		if err != nil {
+			return err
+		}
+
+		client := newClient(cmd)
+		vms, err := projectVMs(client, name)
+		if err != nil {
+			return err
+		}
+
+		if len(args) > 0 {
+			wanted := make(map[string]bool, len(args))
+			for _, service := range args {
+				wanted[service] = true
+			}
+			var selected []state.VM
+			for _, vm := range vms {
+				if wanted[vm.Labels[manager.ServiceLabel]] {
+					selected = append(selected, vm)
+					delete(wanted, vm.Labels[manager.ServiceLabel])
+				}
+			}
+			for service := range wanted {
+				return fmt.Errorf("no VM for service %s in project %s", service, name)
+			}
+			vms = selected
+		}
+
+		width := 0
+		for _, vm := range vms {
+			width = max(width, len(vm.Labels[manager.ServiceLabel]))
+		}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, vm := range vms {
+			logs, err := client.Logs(vm.ID, follow)
+			if err != nil {
+				return fmt.Errorf("failed to get logs of %s: %w", vm.Name, err)
+			}
+			prefix := fmt.Sprintf("%-*s | ", width, vm.Labels[manager.ServiceLabel])
+			if !follow {
+				// Print services one after the other.
+				printPrefixed(os.Stdout, logs, prefix, &mu)
+				continue
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				printPrefixed(os.Stdout, logs, prefix, &mu)
+			}()
+		}
+		wg.Wait()
+		return nil
+	},
+}
+
+// printPrefixed copies logs to w line by line, each line prefixed, and
+// closes logs.
+func printPrefixed(w io.Writer, logs io.ReadCloser, prefix string, mu *sync.Mutex) {
+	defer logs.Close()
+
+	reader := bufio.NewReader(logs)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+			mu.Lock()
+			io.WriteString(w, prefix+line)
+			mu.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// waitForDependencies blocks until the dependencies of service meet their
+// conditions.
+func waitForDependencies(client *api.Client, project *compose.Project, service compose.Service) error {
+	dependencies := make([]string, 0, len(service.DependsOn))
+	for name := range service.DependsOn {
+		dependencies = append(dependencies, name)
+	}
+	sort.Strings(dependencies)
+
+	for _, name := range dependencies {
+		vmName := project.VMName(name)
+		switch service.DependsOn[name] {
+		case compose.ConditionHealthy:
+			fmt.Printf("Waiting for %s to be healthy\n", vmName)
+			if err := client.WaitHealthy(vmName); err != nil {
+				return fmt.Errorf("service %s: dependency %s did not become healthy: %w", service.Name, name, err)
+			}
+		case compose.ConditionCompletedSuccessfully:
+			fmt.Printf("Waiting for %s to complete\n", vmName)
+			result, err := client.WaitVM(vmName)
+			if err != nil {
+				return fmt.Errorf("service %s: failed to wait for dependency %s: %w", service.Name, name, err)
+			}
+			if result.ExitCode != 0 {
+				return fmt.Errorf("service %s: dependency %s exited with status %d", service.Name, name, result.ExitCode)
+			}
+		}
+	}
+	return nil
+}
+
+// removeOrphans removes the VMs of a project whose service is no longer
+// defined. Their dependencies are not known, so they go newest first.
+func removeOrphans(client *api.Client, project *compose.Project) error {
+	vms, err := projectVMs(client, project.Name)
+	if err != nil {
+		return err
+	}
+	vms, err = project.StopOrder(vms)
+	if err != nil {
+		return err
+	}
+
+	defined := make(map[string]bool, len(project.Services))
+	for _, service := range project.Services {
+		defined[project.VMName(service.Name)] = true
+	}
+	for _, vm := range vms {
+		if defined[vm.Name] {
+			continue
+		}
+		if err := client.StopVM(vm.ID); err != nil {
+			return fmt.Errorf("failed to remove orphan VM %s: %w", vm.Name, err)
+		}
+		fmt.Printf("%s removed\n", vm.Name)
+	}
+	return nil
+}
+
+// ensureProjectNetwork creates the project network unless it exists.
+func ensureProjectNetwork(client *api.Client, project *compose.Project) error {
+	networks, err := client.ListNetworks()
+	if err != nil {
+		return fmt.Errorf("failed to list networks: %w", err)
+	}
+	for _, n := range networks {
+		if n.Name == project.NetworkName() {
+			return nil
+		}
+	}
+
+	labels := map[string]string{manager.ProjectLabel: project.Name}
+	if _, err := client.CreateNetwork(project.NetworkName(), labels); err != nil {
+		return fmt.Errorf("failed to create network %s: %w", project.NetworkName(), err)
+	}
+	fmt.Printf("%s created\n", project.NetworkName())
+	return nil
+}
+
+// projectVMs returns the VMs of a project, running or not, in the order
+// they were created.
+func projectVMs(client *api.Client, name string) ([]state.VM, error) {
+	filters := []string{"label=" + manager.ProjectLabel + "=" + name}
+	vms, err := client.ListVMs(api.ListOptions{All: true, Filters: filters})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VMs: %w", err)
+	}
+
+	sort.Slice(vms, func(i, j int) bool {
+		return vms[i].CreatedAt.Before(vms[j].CreatedAt)
+	})
+	return vms, nil
+}
+
+// loadProject reads the compose file given with -f, or found in the
+// current directory. Relative host paths of the services are taken
+// relative to the file.
+func loadProject(cmd *cobra.Command) (*compose.Project, error) {
+	file, err := composeFile(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compose file: %w", err)
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(file))
+	if err != nil {
+		return nil, err
+	}
+	project, err := compose.Parse(data, compose.ProjectName(filepath.Base(dir)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid compose file %s: %w", file, err)
+	}
+	if name, _ := cmd.Flags().GetString("project-name"); name != "" {
+		if err := compose.ValidateProjectName(name); err != nil {
+			return nil, err
+		}
+		project.Name = name
+	}
+
+	for i := range project.Services {
+		if err := resolveSpecPaths(&project.Services[i].Spec, dir); err != nil {
+			return nil, err
+		}
+	}
+	return project, nil
+}
+
+// projectName returns the name given with --project-name, or else that of
+// the compose file's project.
+func projectName(cmd *cobra.Command) (string, error) {
+	if name, _ := cmd.Flags().GetString("project-name"); name != "" {
+		return name, compose.ValidateProjectName(name)
+	}
+
+	project, err := loadProject(cmd)
+	if err != nil {
+		return "", err
+	}
+	return project.Name, nil
+}
+
+// downProject returns the project to take down: that of the compose file,
+// or, given only --project-name with no compose file around, a project
+// without services whose VMs stop in the reverse order of their creation.
+func downProject(cmd *cobra.Command) (*compose.Project, error) {
+	name, _ := cmd.Flags().GetString("project-name")
+	if _, err := composeFile(cmd); err != nil && name != "" {
+		if err := compose.ValidateProjectName(name); err != nil {
+			return nil, err
+		}
+		return &compose.Project{Name: name}, nil
+	}
+	return loadProject(cmd)
+}
+
+func composeFile(cmd *cobra.Command) (string, error) {
+	if file, _ := cmd.Flags().GetString("file"); file != "" {
+		return file, nil
+	}
+
+	for _, file := range composeFiles {
+		if _, err := os.Stat(file); err == nil {
+			return file, nil
+		}
+	}
+	return "", fmt.Errorf("no compose file found: use -f <file> or create %s", composeFiles[0])
+}
+
+func init() {
+	composeCmd.PersistentFlags().StringP("file", "f", "", "Compose file (default compose.yaml or compose.yml)")
+	composeCmd.PersistentFlags().StringP("project-name", "p", "", "Project name (default: the file's name field or its directory)")
+
+	composePsCmd.Flags().BoolP("quiet", "q", false, "Only print VM IDs")
+	composePsCmd.Flags().String("format", "table", formatHelp)
+	composeLogsCmd.Flags().Bool("follow", false, "Follow the output until the VMs exit")
+
+	composeCmd.AddCommand(composeUpCmd)
+	composeCmd.AddCommand(composeDownCmd)
+	composeCmd.AddCommand(composePsCmd)
+	composeCmd.AddCommand(composeLogsCmd)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"micropod/pkg/api"
+	"micropod/pkg/archive"
+)
+
+var cpCmd = &cobra.Command{
+	Use:   "cp [vm:]src [vm:]dest",
+	Short: "Copy files between a running VM and the host",
+	Long: `Copy files or directories between a running VM and the host, like docker cp.
+
+  micropod cp <vm>:/guest/path ./local
+  micropod cp ./local <vm>:/guest/path
+
+A directory is copied into an existing destination directory, or created under
+the destination name; src/. copies only its contents. A file replaces an existing
+file, goes into an existing directory, or is created under the destination name.
+Symlinks in src are copied as links unless --follow-link is given. Modes are kept,
+and ownership wherever the copy is extracted as root, as it always is in the guest.
+Use - as the host path to write a tar archive to stdout or extract one from stdin.
+
+The VM must have been started with micropod-init, whose agent serves the copy over vsock.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		followLink, _ := cmd.Flags().GetBool("follow-link")
+
+		srcVM, src := splitCpArg(args[0])
+		dstVM, dst := splitCpArg(args[1])
+
+		client := newClient(cmd)
+		switch {
+		case srcVM != "" && dstVM != "":
+			return fmt.Errorf("copying between VMs is not supported")
+		case srcVM != "":
+			return copyFromVM(client, srcVM, src, dst, followLink)
+		case dstVM != "":
+			return copyToVM(client, src, dstVM, dst, followLink)
+		default:
+			return fmt.Errorf("one of src and dest must be a VM path (<vm>:/path)")
+		}
+	},
+}
+
+// splitCpArg splits vm:path. Paths starting with / or . are always host
+// paths, so host files with a colon in their name can be copied.
+func splitCpArg(arg string) (vm, p string) {
+	if strings.HasPrefix(arg, "/") || strings.HasPrefix(arg, ".") {
+		return "", arg
+	}
+	if vm, p, ok := strings.Cut(arg, ":"); ok {
+		return vm, p
+	}
+	return "", arg
+}
+
+func copyFromVM(client *api.Client, vmRef, src, dst string, followLink bool) error {
+	srcStat, err := client.StatPath(vmRef, src, followLink)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s:%s: %w", vmRef, src, err)
+	}
+	if srcStat == nil {
+		return fmt.Errorf("no such file or directory in VM %s: %s", vmRef, src)
+	}
+
+	if dst == "-" {
+		tr, err := client.GetArchive(vmRef, src, path.Base(path.Clean(src)), followLink)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s:%s: %w", vmRef, src, err)
+		}
+		defer tr.Close()
+		_, err = io.Copy(os.Stdout, tr)
+		return err
+	}
+
+	dstStat, err := archive.Stat(dst, true)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to stat %s: %w", dst, err)
+	}
+
+	dir, rebase, err := archive.Destination(src, *srcStat, dst, dstStat)
+	if err != nil {
+		return err
+	}
+
+	tr, err := client.GetArchive(vmRef, src, rebase, followLink)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s:%s: %w", vmRef, src, err)
+	}
+	defer tr.Close()
+
+	if err := archive.Untar(tr, dir); err != nil {
+		return fmt.Errorf("failed to copy %s:%s: %w", vmRef, src, err)
+	}
+	return nil
+}
+
+func copyToVM(client *api.Client, src, vmRef, dst string, followLink bool) error {
+	if src == "-" {
+		if err := client.PutArchive(vmRef, dst, os.Stdin); err != nil {
+			return fmt.Errorf("failed to copy to %s:%s: %w", vmRef, dst, err)
+		}
+		return nil
+	}
+
+	srcStat, err := archive.Stat(src, followLink)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", src, err)
+	}
+
+	dstStat, err := client.StatPath(vmRef, dst, true)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s:%s: %w", vmRef, dst, err)
+	}
+
+	dir, rebase, err := archive.Destination(src, *srcStat, dst, dstStat)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		pw.CloseWithError(archive.Tar(pw, src, rebase, followLink))
+	}()
+
+	if err := client.PutArchive(vmRef, dir, pr); err != nil {
+		return fmt.Errorf("failed to copy to %s:%s: %w", vmRef, dst, err)
+	}
+	return nil
+}
+
+func init() {
+	cpCmd.Flags().BoolP("follow-link", "L", false, "Always follow a symlink in src")
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"micropod/pkg/api"
+	"micropod/pkg/manager"
+)
+
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Stream VM lifecycle events",
+	Long: `Follow new VM lifecycle events (create, start, pause, resume, stop, die, destroy).
+With --since, first print the recorded events since that time. With --until, print the recorded
+events up to that time and exit.
+
+Filters are key=value pairs with the keys type, vm (ID or name) and image. Repeating a key matches any of its values.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sinceFlag, _ := cmd.Flags().GetString("since")
+		untilFlag, _ := cmd.Flags().GetString("until")
+		filters, _ := cmd.Flags().GetStringArray("filter")
+		format, _ := cmd.Flags().GetString("format")
+
+		if format != "text" && format != "json" {
+			return fmt.Errorf("unsupported format %q (use text or json)", format)
+		}
+
+		now := time.Now()
+		opts := api.EventsOptions{Filters: filters, Follow: untilFlag == ""}
+
+		var err error
+		if sinceFlag != "" {
+			if opts.Since, err = manager.ParseTimestamp(sinceFlag, now); err != nil {
+				return fmt.Errorf("invalid --since: %w", err)
+			}
+		}
+		if untilFlag != "" {
+			if opts.Until, err = manager.ParseTimestamp(untilFlag, now); err != nil {
+				return fmt.Errorf("invalid --until: %w", err)
+			}
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		client := newClient(cmd)
+		return client.Events(opts, func(e manager.Event) error {
+			if format == "json" {
+				return encoder.Encode(e)
+			}
+			printEvent(e)
+			return nil
+		})
+	},
+}
+
+func printEvent(e manager.Event) {
+	attrs := "image=" + e.Image
+	if e.Name != "" {
+		attrs += ", name=" + e.Name
+	}
+	if e.ExitReason != "" {
+		attrs += ", exitReason=" + e.ExitReason
+	}
+	if e.HealthStatus != "" {
+		attrs += ", healthStatus=" + e.HealthStatus
+	}
+	fmt.Printf("%s vm %s %s (%s)\n", e.Time.Format(time.RFC3339Nano), e.Type, e.VMID, attrs)
+}
+
+func init() {
+	eventsCmd.Flags().String("since", "", "Show events since a timestamp, Unix time or duration (e.g. 10m)")
+	eventsCmd.Flags().String("until", "", "Show events until a timestamp, Unix time or duration, then exit")
+	eventsCmd.Flags().StringArray("filter", nil, "Filter events (type=die, vm=<id|name>, image=<ref>)")
+	eventsCmd.Flags().String("format", "text", "Output format: text or json")
+}
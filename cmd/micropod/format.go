@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// formatHelp documents the --format values accepted by printFormatted.
+const formatHelp = "Output format: table, json, yaml or go-template=<template>"
+
+// printFormatted writes items as a JSON or YAML list, or executes a Go
+// template for each of them, one per line. It reports false for the table
+// format, which every command renders itself.
+func printFormatted[T any](w io.Writer, format string, items []T) (bool, error) {
+	if items == nil {
+		items = []T{}
+	}
+
+	switch format {
+	case "table":
+		return false, nil
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return true, encoder.Encode(items)
+	case "yaml":
+		return true, writeYAML(w, items)
+	}
+
+	tmpl, err := parseTemplate(format)
+	if err != nil {
+		return true, err
+	}
+	for _, item := range items {
+		if err := tmpl.Execute(w, item); err != nil {
+			return true, fmt.Errorf("failed to execute template: %w", err)
+		}
+		fmt.Fprintln(w)
+	}
+	return true, nil
+}
+
+// parseTemplate accepts go-template=<template> or a bare template such as
+// '{{.ID}} {{.State}}'. Templates can render a value as JSON with {{json .}}.
+func parseTemplate(format string) (*template.Template, error) {
+	text, ok := strings.CutPrefix(format, "go-template=")
+	if !ok && !strings.Contains(format, "{{") {
+		return nil, fmt.Errorf("unsupported format %q (use table, json, yaml or go-template=<template>)", format)
+	}
+
+	tmpl, err := template.New("format").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// writeYAML renders v as YAML with the same field names and order as its
+// JSON encoding.
+func writeYAML(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal output: %w", err)
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return fmt.Errorf("failed to convert output to YAML: %w", err)
+	}
+	clearStyle(&node)
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return fmt.Errorf("failed to write YAML: %w", err)
+	}
+	return encoder.Close()
+}
+
+// clearStyle drops the flow and quoting styles a JSON document is parsed
+// with, so it is written as block YAML.
+func clearStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		clearStyle(child)
+	}
+}
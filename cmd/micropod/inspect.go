@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"micropod/pkg/manager"
+)
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect [vm...]",
+	Short: "Display the full state and Firecracker configuration of VMs",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		if format == "table" {
+			return fmt.Errorf("inspect does not support the table format")
+		}
+
+		client := newClient(cmd)
+
+		var inspects []manager.VMInspect
+		for _, vmRef := range args {
+			inspect, err := client.InspectVM(vmRef)
+			if err != nil {
+				return fmt.Errorf("failed to inspect VM %s: %w", vmRef, err)
+			}
+			inspects = append(inspects, *inspect)
+		}
+
+		_, err := printFormatted(os.Stdout, format, inspects)
+		return err
+	},
+}
+
+func init() {
+	inspectCmd.Flags().String("format", "json", "Output format: json, yaml or go-template=<template>")
+}
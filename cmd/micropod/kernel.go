@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"micropod/pkg/api"
+	"micropod/pkg/kernel"
+)
+
+var kernelCmd = &cobra.Command{
+	Use:   "kernel",
+	Short: "Manage guest kernels",
+	Long: `Manage the registry of guest kernels. VMs boot the default kernel unless run
+selects another with --kernel. Without a default, they boot the legacy
+~/.config/micropod/vmlinux/vmlinux.elf.`,
+}
+
+var kernelAddCmd = &cobra.Command{
+	Use:   "add [name] [file]",
+	Short: "Import a kernel image from a local file",
+	Long: `Import an uncompressed ELF vmlinux or a bzImage into the registry. The copy is
+verified against --sha256 or, without it, against a <file>.sha256 checksum file
+next to the image if there is one. The kernel release is read from the image.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sha256, _ := cmd.Flags().GetString("sha256")
+		makeDefault, _ := cmd.Flags().GetBool("default")
+
+		// The daemon reads the file, so it needs a path independent of
+		// the client's working directory.
+		path, err := filepath.Abs(args[1])
+		if err != nil {
+			return err
+		}
+
+		client := newClient(cmd)
+		k, err := client.AddKernel(api.AddKernelRequest{
+			Name:    args[0],
+			Path:    path,
+			SHA256:  sha256,
+			Default: makeDefault,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add kernel: %w", err)
+		}
+
+		fmt.Printf("%s\t%s\tsha256:%s\n", k.Name, versionOrUnknown(k.Version), k.SHA256)
+		return nil
+	},
+}
+
+var kernelListCmd = &cobra.Command{
+	Use:     "ls",
+	Aliases: []string{"list"},
+	Short:   "List kernels and the VMs booting them",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		quiet, _ := cmd.Flags().GetBool("quiet")
+		format, _ := cmd.Flags().GetString("format")
+
+		client := newClient(cmd)
+		kernels, err := client.ListKernels()
+		if err != nil {
+			return fmt.Errorf("failed to list kernels: %w", err)
+		}
+
+		if quiet {
+			for _, k := range kernels {
+				fmt.Println(k.Name)
+			}
+			return nil
+		}
+
+		if ok, err := printFormatted(os.Stdout, format, kernels); ok {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "NAME\tVERSION\tFORMAT\tSHA256\tDEFAULT\tUSED BY")
+		for _, k := range kernels {
+			isDefault := ""
+			if k.Default {
+				isDefault = "*"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", k.Name, versionOrUnknown(k.Version), k.Format, k.SHA256[:12], isDefault, strings.Join(k.UsedBy, ","))
+		}
+		return w.Flush()
+	},
+}
+
+var kernelRemoveCmd = &cobra.Command{
+	Use:     "rm [name...]",
+	Aliases: []string{"remove"},
+	Short:   "Remove kernels that no VM boots",
+	Args:    cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client := newClient(cmd)
+
+		var failed bool
+		for _, name := range args {
+			if err := client.RemoveKernel(name); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: failed to remove kernel %s: %v\n", name, err)
+				failed = true
+				continue
+			}
+			fmt.Println(name)
+		}
+
+		if failed {
+			return fmt.Errorf("failed to remove some kernels")
+		}
+		return nil
+	},
+}
+
+var kernelDefaultCmd = &cobra.Command{
+	Use:   "default [name]",
+	Short: "Set or print the default kernel",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client := newClient(cmd)
+
+		if len(args) == 1 {
+			if err := client.SetDefaultKernel(args[0]); err != nil {
+				return fmt.Errorf("failed to set default kernel: %w", err)
+			}
+			return nil
+		}
+
+		kernels, err := client.ListKernels()
+		if err != nil {
+			return fmt.Errorf("failed to list kernels: %w", err)
+		}
+		for _, k := range kernels {
+			if k.Default {
+				fmt.Println(k.Name)
+				return nil
+			}
+		}
+		return fmt.Errorf("no default kernel is set")
+	},
+}
+
+var kernelInspectCmd = &cobra.Command{
+	Use:   "inspect [name...]",
+	Short: "Display kernel details",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		if format == "table" {
+			return fmt.Errorf("inspect does not support the table format")
+		}
+
+		client := newClient(cmd)
+
+		var kernels []kernel.Kernel
+		for _, name := range args {
+			k, err := client.GetKernel(name)
+			if err != nil {
+				return fmt.Errorf("failed to inspect kernel %s: %w", name, err)
+			}
+			kernels = append(kernels, *k)
+		}
+
+		_, err := printFormatted(os.Stdout, format, kernels)
+		return err
+	},
+}
+
+func versionOrUnknown(version string) string {
+	if version == "" {
+		return "unknown"
+	}
+	return version
+}
+
+func init() {
+	kernelAddCmd.Flags().String("sha256", "", "Expected SHA-256 of the image")
+	kernelAddCmd.Flags().Bool("default", false, "Make the kernel the default")
+	kernelListCmd.Flags().BoolP("quiet", "q", false, "Only print kernel names")
+	kernelListCmd.Flags().String("format", "table", formatHelp)
+	kernelInspectCmd.Flags().String("format", "json", "Output format: json, yaml or go-template=<template>")
+
+	kernelCmd.AddCommand(kernelAddCmd)
+	kernelCmd.AddCommand(kernelListCmd)
+	kernelCmd.AddCommand(kernelRemoveCmd)
+	kernelCmd.AddCommand(kernelDefaultCmd)
+	kernelCmd.AddCommand(kernelInspectCmd)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"micropod/pkg/api"
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs [vm]",
+	Short: "Print the console output of a VM",
+	Long: `Print the serial console of a VM: the output of its workload and of
+micropod-init, across restarts. With --follow, keep printing new output until the VM exits.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		follow, _ := cmd.Flags().GetBool("follow")
+
+		client := newClient(cmd)
+		logs, err := client.Logs(args[0], follow)
+		if err != nil {
+			return fmt.Errorf("failed to get logs: %w", err)
+		}
+		defer logs.Close()
+
+		_, err = io.Copy(os.Stdout, logs)
+		return err
+	},
+}
+
+var waitCmd = &cobra.Command{
+	Use:   "wait [vm...]",
+	Short: "Wait for VMs to exit and print their exit codes",
+	Long: `Block until each VM exits without being restarted, or is stopped, and print its
+exit code: the exit status of the workload as reported by micropod-init, 137 for a
+stopped VM, or 0 or 1 for VMs booting their own init.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client := newClient(cmd)
+		for _, vmRef := range args {
+			result, err := client.WaitVM(vmRef)
+			if err != nil {
+				return fmt.Errorf("failed to wait for VM %s: %w", vmRef, err)
+			}
+			fmt.Println(result.ExitCode)
+		}
+		return nil
+	},
+}
+
+// runAttached prints the console of a VM until it exits and then exits with
+// the VM's exit code, as run --wait does.
+func runAttached(client *api.Client, vmID string) error {
+	logs, err := client.Logs(vmID, true)
+	if err != nil {
+		// The VM may already be gone; its exit code is still known.
+		fmt.Fprintf(os.Stderr, "Warning: failed to attach to VM %s: %v\n", vmID, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if logs != nil {
+			io.Copy(os.Stdout, logs)
+			logs.Close()
+		}
+	}()
+
+	result, err := client.WaitVM(vmID)
+	if err != nil {
+		return fmt.Errorf("failed to wait for VM %s: %w", vmID, err)
+	}
+	<-done
+
+	exitWith(result.ExitCode)
+	return nil
+}
+
+// exitWithVM waits for a VM to exit and exits with its exit code.
+func exitWithVM(client *api.Client, vmID string) error {
+	result, err := client.WaitVM(vmID)
+	if err != nil {
+		return fmt.Errorf("failed to wait for VM %s: %w", vmID, err)
+	}
+
+	exitWith(result.ExitCode)
+	return nil
+}
+
+func exitWith(code int) {
+	if code != 0 {
+		os.Exit(code)
+	}
+}
+
+func init() {
+	logsCmd.Flags().BoolP("follow", "f", false, "Follow the output until the VM exits")
+}
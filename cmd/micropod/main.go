@@ -3,9 +3,16 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/spf13/cobra"
+	"micropod/pkg/api"
+	"micropod/pkg/config"
 	"micropod/pkg/manager"
+	"micropod/pkg/state"
 )
 
 var rootCmd = &cobra.Command{
@@ -14,72 +21,477 @@ var rootCmd = &cobra.Command{
 	Long:  `MicroPod is a command line tool that runs OCI container images in Firecracker microVMs for enhanced security isolation.`,
 }
 
+// newClient returns a client for the daemon. Every command is a thin client
+// of micropodd, which owns the VMs.
+func newClient(cmd *cobra.Command) *api.Client {
+	socketPath, _ := cmd.Flags().GetString("socket")
+	if socketPath == "" {
+		socketPath = config.NewConfig().GetDaemonSocketPath()
+	}
+	return api.NewClient(socketPath)
+}
+
 var runCmd = &cobra.Command{
-	Use:   "run [image]",
+	Use:   "run [image] [command...]",
 	Short: "Run a container image in a Firecracker microVM",
-	Args:  cobra.ExactArgs(1),
+	Long: `Run a container image in a Firecracker microVM. The VM is kept in the Exited
+state when it exits, unless --rm is given or its restart policy restarts it.
+
+With --wait, run prints the VM's console until the VM exits and exits with the exit
+status of the workload, which micropod-init reports. Combined with --rm, this runs
+a batch job to completion and removes its rootfs and state.
+
+With -it, run attaches the terminal to the VM's serial console, which the workload
+runs on, and exits with its exit status. Detach with Ctrl-P Ctrl-Q and reattach
+with micropod attach.`,
+	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		imageName := args[0]
-		
-		mgr := manager.NewManager()
-		vmID, err := mgr.RunVM(imageName)
+		spec := manager.DefaultVMSpec()
+		spec.Image = args[0]
+		spec.Jailer, _ = cmd.Flags().GetBool("jailer")
+		spec.Limits.CPUs, _ = cmd.Flags().GetFloat64("cpus")
+		spec.Limits.CPUWeight, _ = cmd.Flags().GetInt("cpu-weight")
+		spec.Limits.PidsMax, _ = cmd.Flags().GetInt("pids-limit")
+		spec.Limits.IOWeight, _ = cmd.Flags().GetInt("io-weight")
+		spec.MemoryOverheadMB, _ = cmd.Flags().GetInt("memory-overhead")
+		spec.Restart, _ = cmd.Flags().GetString("restart")
+		spec.Name, _ = cmd.Flags().GetString("name")
+		spec.AutoRemove, _ = cmd.Flags().GetBool("rm")
+		spec.Kernel, _ = cmd.Flags().GetString("kernel")
+		spec.KernelArgs, _ = cmd.Flags().GetString("kernel-args")
+		spec.Profile, _ = cmd.Flags().GetString("profile")
+		if initrd, _ := cmd.Flags().GetString("initrd"); initrd != "" {
+			// The daemon reads the file, so it needs a path independent
+			// of the client's working directory.
+			path, err := filepath.Abs(initrd)
+			if err != nil {
+				return err
+			}
+			spec.Initrd = path
+		}
+		wait, _ := cmd.Flags().GetBool("wait")
+		interactive, _ := cmd.Flags().GetBool("interactive")
+		spec.TTY, _ = cmd.Flags().GetBool("tty")
+		if interactive && !spec.TTY {
+			return fmt.Errorf("--interactive requires --tty: input reaches the guest through its console")
+		}
+
+		labels, _ := cmd.Flags().GetStringArray("label")
+		annotations, _ := cmd.Flags().GetStringArray("annotation")
+		var err error
+		if spec.Labels, err = manager.ParseLabels(labels); err != nil {
+			return err
+		}
+		if spec.Annotations, err = manager.ParseLabels(annotations); err != nil {
+			return fmt.Errorf("invalid annotation: %w", err)
+		}
+
+		env, _ := cmd.Flags().GetStringArray("env")
+		secrets, _ := cmd.Flags().GetStringArray("secret")
+		spec.Env = parseEnv(env)
+		if spec.Secrets, err = manager.ParseLabels(secrets); err != nil {
+			return fmt.Errorf("invalid secret: %w", err)
+		}
+		spec.Command = args[1:]
+		spec.MMDSVersion, _ = cmd.Flags().GetString("mmds-version")
+
+		volumes, _ := cmd.Flags().GetStringArray("volume")
+		for _, arg := range volumes {
+			mount, err := manager.ParseVolumeSpec(arg)
+			if err != nil {
+				return err
+			}
+			spec.Mounts = append(spec.Mounts, mount)
+		}
+		mounts, _ := cmd.Flags().GetStringArray("mount")
+		for _, arg := range mounts {
+			mount, err := manager.ParseMountSpec(arg)
+			if err != nil {
+				return err
+			}
+			spec.Mounts = append(spec.Mounts, mount)
+		}
+		spec.ReadOnly, _ = cmd.Flags().GetBool("read-only")
+		spec.Tmpfs, _ = cmd.Flags().GetStringArray("tmpfs")
+		spec.Overlays, _ = cmd.Flags().GetStringArray("overlay")
+		spec.Networks, _ = cmd.Flags().GetStringArray("network")
+		spec.BootTimeout, _ = cmd.Flags().GetString("boot-timeout")
+		if spec.HealthCheck, err = healthCheckSpec(cmd); err != nil {
+			return err
+		}
+		copyIns, _ := cmd.Flags().GetStringArray("copy-in")
+		for _, arg := range copyIns {
+			mount, err := manager.ParseCopyInSpec(arg)
+			if err != nil {
+				return err
+			}
+			spec.Mounts = append(spec.Mounts, mount)
+		}
+		copyOuts, _ := cmd.Flags().GetStringArray("copy-out")
+		for _, arg := range copyOuts {
+			mount, err := manager.ParseCopyOutSpec(arg)
+			if err != nil {
+				return err
+			}
+			spec.Mounts = append(spec.Mounts, mount)
+		}
+
+		client := newClient(cmd)
+		vmID, vm, err := client.RunVM(spec)
 		if err != nil {
 			return fmt.Errorf("failed to run VM: %w", err)
 		}
 		
+		if interactive {
+			detached, err := attachConsole(client, vmID)
+			if err != nil {
+				return err
+			}
+			if detached {
+				fmt.Fprintf(os.Stderr, "\r\nDetached from VM %s\r\n", vmID)
+				return nil
+			}
+			return exitWithVM(client, vmID)
+		}
+		if wait {
+			return runAttached(client, vmID)
+		}
+
 		fmt.Printf("VM started successfully with ID: %s\n", vmID)
+		if vm != nil {
+			printVMSummary(*vm)
+		}
 		return nil
 	},
 }
 
+// printVMSummary prints where the daemon put a VM that was just started.
+func printVMSummary(vm state.VM) {
+	if vm.Name != "" {
+		fmt.Printf("  Name: %s\n", vm.Name)
+	}
+	fmt.Printf("  Image: %s\n", vm.ImageName)
+	if vm.KernelVersion != "" {
+		fmt.Printf("  Kernel: %s\n", vm.KernelVersion)
+	}
+	fmt.Printf("  PID: %d\n", vm.FirecrackerPid)
+	fmt.Printf("  Socket: %s\n", vm.VMSocketPath)
+	fmt.Printf("  Rootfs: %s\n", vm.RootfsPath)
+	if vm.Jailer != nil {
+		fmt.Printf("  Chroot: %s\n", vm.Jailer.ChrootPath)
+	}
+}
+
+// parseEnv turns NAME=value pairs into a map. A bare NAME takes its value
+// from the caller's environment.
+func parseEnv(values []string) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+
+	env := make(map[string]string, len(values))
+	for _, value := range values {
+		name, val, ok := strings.Cut(value, "=")
+		if !ok {
+			val = os.Getenv(name)
+		}
+		env[name] = val
+	}
+	return env
+}
+
+// healthCheckSpec builds the health check override of run's --health-*
+// flags, or returns nil when none is set.
+func healthCheckSpec(cmd *cobra.Command) (*manager.HealthCheckSpec, error) {
+	flags := cmd.Flags()
+	if !flags.Changed("health-cmd") && !flags.Changed("health-tcp") && !flags.Changed("no-healthcheck") &&
+		!flags.Changed("health-interval") && !flags.Changed("health-timeout") &&
+		!flags.Changed("health-start-period") && !flags.Changed("health-retries") {
+		return nil, nil
+	}
+
+	var check manager.HealthCheckSpec
+	command, _ := flags.GetString("health-cmd")
+	port, _ := flags.GetInt("health-tcp")
+	disable, _ := flags.GetBool("no-healthcheck")
+	switch {
+	case disable && (command != "" || port != 0):
+		return nil, fmt.Errorf("--no-healthcheck conflicts with --health-cmd and --health-tcp")
+	case command != "" && port != 0:
+		return nil, fmt.Errorf("--health-cmd and --health-tcp are mutually exclusive")
+	case disable:
+		check.Test = []string{"NONE"}
+	case command != "":
+		check.Test = []string{"CMD-SHELL", command}
+	case port != 0:
+		check.Test = []string{"TCP", strconv.Itoa(port)}
+	}
+
+	check.Interval, _ = flags.GetString("health-interval")
+	check.Timeout, _ = flags.GetString("health-timeout")
+	check.StartPeriod, _ = flags.GetString("health-start-period")
+	check.Retries, _ = flags.GetInt("health-retries")
+	return &check, nil
+}
+
+// stateWithHealth renders the state of a VM followed by its health status,
+// if it has a health check.
+func stateWithHealth(vm state.VM) string {
+	if vm.Health == nil {
+		return vm.State
+	}
+	return fmt.Sprintf("%s (%s)", vm.State, vm.Health.Status)
+}
+
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List running VMs managed by micropod",
+	Long: `List running VMs managed by micropod. With --all, VMs without a running
+process, such as exited VMs, are listed too.
+
+Filters are key=value pairs with the keys id (prefix), name, image, state, health, label, since
+and before. health is starting, healthy, unhealthy or none; since and before bound the creation time with a timestamp, Unix time or duration (e.g. 1h).
+Repeating a key matches any of its values, except for label, where every term must match.
+--selector takes comma-separated label terms: key, !key, key=value or key!=value.`,
+	Args: cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		mgr := manager.NewManager()
-		vms, err := mgr.ListVMs()
+		all, _ := cmd.Flags().GetBool("all")
+		quiet, _ := cmd.Flags().GetBool("quiet")
+		filters, _ := cmd.Flags().GetStringArray("filter")
+		format, _ := cmd.Flags().GetString("format")
+
+		selectorFilters, err := selectorFilters(cmd)
+		if err != nil {
+			return err
+		}
+		filters = append(filters, selectorFilters...)
+
+		client := newClient(cmd)
+		vms, err := client.ListVMs(api.ListOptions{All: all, Filters: filters})
 		if err != nil {
 			return fmt.Errorf("failed to list VMs: %w", err)
 		}
+
+		if quiet {
+			for _, vm := range vms {
+				fmt.Println(vm.ID)
+			}
+			return nil
+		}
+
+		if ok, err := printFormatted(os.Stdout, format, vms); ok {
+			return err
+		}
 		
 		if len(vms) == 0 {
 			fmt.Println("No running VMs found")
 			return nil
 		}
 		
-		fmt.Printf("%-36s %-20s %-10s %-10s %s\n", "VM ID", "IMAGE", "STATE", "PID", "CREATED")
-		fmt.Println("------------------------------------------------------------------------------------")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "VM ID\tNAME\tIMAGE\tSTATE\tPID\tCREATED")
 		for _, vm := range vms {
-			fmt.Printf("%-36s %-20s %-10s %-10d %s\n", 
-				vm.ID, vm.ImageName, vm.State, vm.FirecrackerPid, vm.CreatedAt.Format("2006-01-02 15:04:05"))
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n",
+				vm.ID, vm.Name, vm.ImageName, stateWithHealth(vm), vm.FirecrackerPid, vm.CreatedAt.Format("2006-01-02 15:04:05"))
 		}
-		
-		return nil
+		return w.Flush()
 	},
 }
 
 var stopCmd = &cobra.Command{
-	Use:   "stop [vm-id]",
-	Short: "Stop and clean up a running VM",
-	Args:  cobra.ExactArgs(1),
+	Use:   "stop [vm...]",
+	Short: "Stop and clean up VMs",
+	Long: `Stop and clean up the given VMs, or every VM matching --selector, including
+exited VMs.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		vmID := args[0]
+		selector, _ := cmd.Flags().GetString("selector")
+		if (len(args) == 0) == (selector == "") {
+			return fmt.Errorf("specify either VMs or a --selector")
+		}
 		
-		mgr := manager.NewManager()
-		err := mgr.StopVM(vmID)
-		if err != nil {
-			return fmt.Errorf("failed to stop VM: %w", err)
+		client := newClient(cmd)
+
+		vmRefs := args
+		if selector != "" {
+			filters, err := selectorFilters(cmd)
+			if err != nil {
+				return err
+			}
+			vms, err := client.ListVMs(api.ListOptions{All: true, Filters: filters})
+			if err != nil {
+				return fmt.Errorf("failed to list VMs: %w", err)
+			}
+			for _, vm := range vms {
+				vmRefs = append(vmRefs, vm.ID)
+			}
 		}
 		
-		fmt.Printf("VM %s stopped successfully\n", vmID)
+		for _, vmRef := range vmRefs {
+			if err := client.StopVM(vmRef); err != nil {
+				return fmt.Errorf("failed to stop VM %s: %w", vmRef, err)
+			}
+			fmt.Printf("VM %s stopped successfully\n", vmRef)
+		}
+
+		return nil
+	},
+}
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove exited VMs",
+	Long:  `Remove the VMs that have exited, together with their rootfs and console log.`,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filters, _ := cmd.Flags().GetStringArray("filter")
+		selectorFilters, err := selectorFilters(cmd)
+		if err != nil {
+			return err
+		}
+		filters = append(filters, selectorFilters...)
+
+		client := newClient(cmd)
+		removed, err := client.PruneVMs(filters)
+		if err != nil {
+			return fmt.Errorf("failed to prune VMs: %w", err)
+		}
+
+		for _, vmID := range removed {
+			fmt.Println(vmID)
+		}
+		fmt.Printf("Removed %d VMs\n", len(removed))
+		return nil
+	},
+}
+
+// selectorFilters turns the comma-separated terms of --selector into label
+// filters.
+func selectorFilters(cmd *cobra.Command) ([]string, error) {
+	selector, _ := cmd.Flags().GetString("selector")
+	if selector == "" {
+		return nil, nil
+	}
+
+	var filters []string
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if _, err := manager.ParseLabelRequirement(term); err != nil {
+			return nil, err
+		}
+		filters = append(filters, "label="+term)
+	}
+	return filters, nil
+}
+
+var pauseCmd = &cobra.Command{
+	Use:   "pause [vm]",
+	Short: "Pause the vCPUs of a running VM",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		vmRef := args[0]
+
+		client := newClient(cmd)
+		if err := client.PauseVM(vmRef); err != nil {
+			return fmt.Errorf("failed to pause VM: %w", err)
+		}
+
+		fmt.Printf("VM %s paused\n", vmRef)
+		return nil
+	},
+}
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume [vm]",
+	Short: "Resume a paused VM",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		vmRef := args[0]
+
+		client := newClient(cmd)
+		if err := client.ResumeVM(vmRef); err != nil {
+			return fmt.Errorf("failed to resume VM: %w", err)
+		}
+
+		fmt.Printf("VM %s resumed\n", vmRef)
 		return nil
 	},
 }
 
 func init() {
+	rootCmd.PersistentFlags().String("socket", "", "micropodd API socket (default $MICROPOD_SOCKET or ~/.config/micropod/micropodd.sock)")
+
+	// Everything after the image is the guest command, as with docker run.
+	runCmd.Flags().SetInterspersed(false)
+	runCmd.Flags().String("name", "", "Assign a unique name to the VM")
+	runCmd.Flags().String("kernel", "", "Boot a kernel from the registry instead of the default (see micropod kernel ls)")
+	runCmd.Flags().String("kernel-args", "", "Kernel parameters merged into the default command line, replacing defaults with the same key")
+	runCmd.Flags().String("initrd", "", "Boot with this initial ramdisk")
+	runCmd.Flags().String("profile", "", "Profile of config.json to take kernel, kernel args and initrd from (default: the profile named default)")
+	runCmd.Flags().Bool("jailer", false, "Launch Firecracker through the jailer (requires root)")
+	runCmd.Flags().Float64("cpus", 0, "CPU quota of the Firecracker process, in CPUs")
+	runCmd.Flags().Int("cpu-weight", 0, "Relative CPU weight of the Firecracker process (1-10000)")
+	runCmd.Flags().Int("memory-overhead", 0, "Hard memory limit for VMM overhead in MiB, on top of guest memory")
+	runCmd.Flags().Int("pids-limit", 0, "Maximum number of tasks of the Firecracker process")
+	runCmd.Flags().Int("io-weight", 0, "Relative I/O weight of the Firecracker process (1-10000)")
+	runCmd.Flags().String("restart", "no", "Restart policy: no, on-failure[:N], always or unless-stopped")
+	runCmd.Flags().Bool("rm", false, "Remove the VM when it exits")
+	runCmd.Flags().BoolP("tty", "t", false, "Connect the serial console to a pseudo-terminal owned by the daemon, for attach")
+	runCmd.Flags().BoolP("interactive", "i", false, "Attach to the console after starting the VM (requires --tty)")
+	runCmd.Flags().Bool("wait", false, "Print the console until the VM exits and exit with the workload's exit status")
+	runCmd.Flags().StringArray("label", nil, "Set a label on the VM (key=value)")
+	runCmd.Flags().StringArray("annotation", nil, "Set an annotation published to the guest through MMDS (key=value)")
+	runCmd.Flags().StringArrayP("env", "e", nil, "Set an environment variable of the workload, also published through MMDS (NAME=value)")
+	runCmd.Flags().StringArray("secret", nil, "Publish a secret reference to the guest through MMDS (name=reference)")
+	runCmd.Flags().String("mmds-version", "", "MMDS version, V1 or V2 (default V2 when there is metadata)")
+	runCmd.Flags().StringArrayP("volume", "v", nil, "Attach a named volume or ext4 image file (name:/path[:ro] or /image.ext4:/path[:ro])")
+	runCmd.Flags().StringArray("mount", nil, "Attach a drive (type=volume|block,src=<name|file>,dst=<path>[,readonly])")
+	runCmd.Flags().Bool("read-only", false, "Attach the rootfs read-only, sharing one cached image between read-only VMs of the same image")
+	runCmd.Flags().StringArray("tmpfs", nil, "Mount an empty tmpfs on a guest directory")
+	runCmd.Flags().StringArray("overlay", nil, "Make a guest directory writable with a tmpfs layer over the image's content")
+	runCmd.Flags().StringArray("network", nil, "Attach the VM to a network (see micropod network ls)")
+	runCmd.Flags().StringArray("copy-in", nil, "Copy a host directory into the VM on its own drive (hostdir:/path[:ro])")
+	runCmd.Flags().String("boot-timeout", "", "Fail if the guest has not booted within this time, showing its last console lines (default 30s, 0 to not wait)")
+	runCmd.Flags().String("health-cmd", "", "Command run in the guest to check the workload's health, through /bin/sh -c")
+	runCmd.Flags().Int("health-tcp", 0, "Check the workload's health by connecting to this port on the VM's first network")
+	runCmd.Flags().String("health-interval", "", "Time between health checks (default: the image's, or 30s)")
+	runCmd.Flags().String("health-timeout", "", "Time a health check may take (default: the image's, or 30s)")
+	runCmd.Flags().String("health-start-period", "", "Time after boot during which failed health checks do not count")
+	runCmd.Flags().Int("health-retries", 0, "Consecutive failed health checks that make the VM unhealthy (default: the image's, or 3)")
+	runCmd.Flags().Bool("no-healthcheck", false, "Disable the image's health check")
+	runCmd.Flags().StringArray("copy-out", nil, "Copy a guest directory back to the host after the VM exits (/path:hostdir)")
+
+	listCmd.Flags().BoolP("all", "a", false, "Include VMs without a running process")
+	listCmd.Flags().BoolP("quiet", "q", false, "Only print VM IDs")
+	listCmd.Flags().StringArrayP("filter", "f", nil, "Filter VMs (image=<ref>, state=running, name=<name>, since=1h)")
+	listCmd.Flags().String("format", "table", formatHelp)
+	listCmd.Flags().StringP("selector", "l", "", "Label selector (e.g. team=infra,ci,env!=prod)")
+
+	stopCmd.Flags().StringP("selector", "l", "", "Stop every VM matching the label selector")
+
+	pruneCmd.Flags().StringArrayP("filter", "f", nil, "Filter VMs (label=team=infra, image=<ref>, before=24h)")
+	pruneCmd.Flags().StringP("selector", "l", "", "Label selector (e.g. team=infra,ci,env!=prod)")
+
 	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(createCmd)
+	rootCmd.AddCommand(applyCmd)
 	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(inspectCmd)
 	rootCmd.AddCommand(stopCmd)
+	rootCmd.AddCommand(pruneCmd)
+	rootCmd.AddCommand(pauseCmd)
+	rootCmd.AddCommand(resumeCmd)
+	rootCmd.AddCommand(statsCmd)
+	rootCmd.AddCommand(eventsCmd)
+	rootCmd.AddCommand(metadataCmd)
+	rootCmd.AddCommand(volumeCmd)
+	rootCmd.AddCommand(networkCmd)
+	rootCmd.AddCommand(poolCmd)
+	rootCmd.AddCommand(composeCmd)
+	rootCmd.AddCommand(kernelCmd)
+	rootCmd.AddCommand(cpCmd)
+	rootCmd.AddCommand(logsCmd)
+	rootCmd.AddCommand(waitCmd)
+	rootCmd.AddCommand(attachCmd)
 }
 
 func main() {
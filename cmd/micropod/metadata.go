@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"micropod/pkg/manager"
+)
+
+var metadataCmd = &cobra.Command{
+	Use:   "metadata",
+	Short: "Manage the metadata published to VMs through MMDS",
+}
+
+var metadataGetCmd = &cobra.Command{
+	Use:   "get [vm]",
+	Short: "Print the metadata document of a VM",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+
+		client := newClient(cmd)
+		md, err := client.GetMetadata(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to get metadata: %w", err)
+		}
+
+		switch format {
+		case "json":
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(md)
+		case "yaml":
+			return writeYAML(os.Stdout, md)
+		default:
+			return fmt.Errorf("unsupported format %q (use json or yaml)", format)
+		}
+	},
+}
+
+var metadataSetCmd = &cobra.Command{
+	Use:   "set [vm] [key=value | key-]...",
+	Short: "Update the metadata of a VM",
+	Long: `Update the metadata document of a VM and publish it to the running guest.
+
+Keys are env.<NAME>, secrets.<NAME>, labels.<KEY>, annotations.<KEY> and command.
+A command is split on whitespace, or given as a JSON array. A key followed by "-"
+removes it, e.g. env.DEBUG-.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		vmRef := args[0]
+
+		client := newClient(cmd)
+		md, err := client.GetMetadata(vmRef)
+		if err != nil {
+			return fmt.Errorf("failed to get metadata: %w", err)
+		}
+
+		for _, arg := range args[1:] {
+			if err := updateMetadata(md, arg); err != nil {
+				return err
+			}
+		}
+
+		if err := client.SetMetadata(vmRef, *md); err != nil {
+			return fmt.Errorf("failed to set metadata: %w", err)
+		}
+
+		fmt.Printf("Metadata of VM %s updated\n", vmRef)
+		return nil
+	},
+}
+
+// updateMetadata applies a single key=value or key- argument of metadata set.
+func updateMetadata(md *manager.Metadata, arg string) error {
+	key, value, set := strings.Cut(arg, "=")
+	if !set {
+		var ok bool
+		if key, ok = strings.CutSuffix(arg, "-"); !ok {
+			return fmt.Errorf("invalid metadata update %q (expected key=value or key-)", arg)
+		}
+	}
+
+	if key == "command" {
+		if !set {
+			md.Command = nil
+			return nil
+		}
+		if strings.HasPrefix(value, "[") {
+			if err := json.Unmarshal([]byte(value), &md.Command); err != nil {
+				return fmt.Errorf("invalid command %q: %w", value, err)
+			}
+			return nil
+		}
+		md.Command = strings.Fields(value)
+		return nil
+	}
+
+	section, name, ok := strings.Cut(key, ".")
+	if !ok || name == "" {
+		return fmt.Errorf("invalid metadata key %q (use env.<NAME>, secrets.<NAME>, labels.<KEY>, annotations.<KEY> or command)", key)
+	}
+
+	var m *map[string]string
+	switch section {
+	case "env":
+		m = &md.Env
+	case "secrets":
+		m = &md.Secrets
+	case "labels":
+		m = &md.Labels
+	case "annotations":
+		m = &md.Annotations
+	default:
+		return fmt.Errorf("unknown metadata section %q (use env, secrets, labels or annotations)", section)
+	}
+
+	if !set {
+		delete(*m, name)
+		return nil
+	}
+	if *m == nil {
+		*m = make(map[string]string)
+	}
+	(*m)[name] = value
+	return nil
+}
+
+func init() {
+	metadataGetCmd.Flags().String("format", "json", "Output format: json or yaml")
+
+	metadataCmd.AddCommand(metadataGetCmd)
+	metadataCmd.AddCommand(metadataSetCmd)
+}
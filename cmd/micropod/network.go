@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"micropod/pkg/manager"
+	"micropod/pkg/network"
+)
+
+var networkCmd = &cobra.Command{
+	Use:   "network",
+	Short: "Manage networks VMs are attached to",
+}
+
+var networkCreateCmd = &cobra.Command{
+	Use:   "create [name]",
+	Short: "Create a network: a host bridge with a /24 subnet",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		labelValues, _ := cmd.Flags().GetStringArray("label")
+		labels, err := manager.ParseLabels(labelValues)
+		if err != nil {
+			return err
+		}
+
+		client := newClient(cmd)
+		n, err := client.CreateNetwork(args[0], labels)
+		if err != nil {
+			return fmt.Errorf("failed to create network: %w", err)
+		}
+
+		fmt.Println(n.Name)
+		return nil
+	},
+}
+
+var networkListCmd = &cobra.Command{
+	Use:     "ls",
+	Aliases: []string{"list"},
+	Short:   "List networks and the VMs attached to them",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		quiet, _ := cmd.Flags().GetBool("quiet")
+		format, _ := cmd.Flags().GetString("format")
+
+		client := newClient(cmd)
+		networks, err := client.ListNetworks()
+		if err != nil {
+			return fmt.Errorf("failed to list networks: %w", err)
+		}
+
+		if quiet {
+			for _, n := range networks {
+				fmt.Println(n.Name)
+			}
+			return nil
+		}
+
+		if ok, err := printFormatted(os.Stdout, format, networks); ok {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSUBNET\tBRIDGE\tUSED BY")
+		for _, n := range networks {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", n.Name, n.Subnet, n.Bridge, strings.Join(n.UsedBy, ","))
+		}
+		return w.Flush()
+	},
+}
+
+var networkRemoveCmd = &cobra.Command{
+	Use:     "rm [name...]",
+	Aliases: []string{"remove"},
+	Short:   "Remove networks no VM is attached to",
+	Args:    cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client := newClient(cmd)
+
+		var failed bool
+		for _, name := range args {
+			if err := client.RemoveNetwork(name); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: failed to remove network %s: %v\n", name, err)
+				failed = true
+				continue
+			}
+			fmt.Println(name)
+		}
+
+		if failed {
+			return fmt.Errorf("failed to remove some networks")
+		}
+		return nil
+	},
+}
+
+var networkInspectCmd = &cobra.Command{
+	Use:   "inspect [name...]",
+	Short: "Display network details",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		if format == "table" {
+			return fmt.Errorf("inspect does not support the table format")
+		}
+
+		client := newClient(cmd)
+
+		var networks []network.Network
+		for _, name := range args {
+			n, err := client.GetNetwork(name)
+			if err != nil {
+				return fmt.Errorf("failed to inspect network %s: %w", name, err)
+			}
+			networks = append(networks, *n)
+		}
+
+		_, err := printFormatted(os.Stdout, format, networks)
+		return err
+	},
+}
+
+func init() {
+	networkCreateCmd.Flags().StringArray("label", nil, "Set a label on the network (key=value)")
+	networkListCmd.Flags().BoolP("quiet", "q", false, "Only print network names")
+	networkListCmd.Flags().String("format", "table", formatHelp)
+	networkInspectCmd.Flags().String("format", "json", "Output format: json, yaml or go-template=<template>")
+
+	networkCmd.AddCommand(networkCreateCmd)
+	networkCmd.AddCommand(networkListCmd)
+	networkCmd.AddCommand(networkRemoveCmd)
+	networkCmd.AddCommand(networkInspectCmd)
+}
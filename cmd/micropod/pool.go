@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var poolCmd = &cobra.Command{
+	Use:   "pool",
+	Short: "Inspect the pools of pre-booted VMs configured in config.json",
+}
+
+var poolListCmd = &cobra.Command{
+	Use:     "ls",
+	Aliases: []string{"list"},
+	Short:   "List pools with their ready VMs and hit rates",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+
+		client := newClient(cmd)
+		pools, err := client.ListPools()
+		if err != nil {
+			return fmt.Errorf("failed to list pools: %w", err)
+		}
+
+		if ok, err := printFormatted(os.Stdout, format, pools); ok {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "IMAGE\tPROFILE\tVCPUS\tMEMORY\tREADY\tBOOTING\tHITS\tMISSES\tHIT RATE")
+		for _, p := range pools {
+			profile := p.Profile
+			if profile == "" {
+				profile = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%dMB\t%d/%d\t%d\t%d\t%d\t%.0f%%\n",
+				p.Image, profile, p.VCPUs, p.MemoryMB, p.Ready, p.Size, p.Booting, p.Hits, p.Misses, p.HitRate*100)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	poolListCmd.Flags().String("format", "table", formatHelp)
+
+	poolCmd.AddCommand(poolListCmd)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"micropod/pkg/manager"
+)
+
+var createCmd = &cobra.Command{
+	Use:   "create -f [file]",
+	Short: "Create and start the VMs of spec files",
+	Long: `Create and start a VM for every document of the given spec files, YAML documents
+separated by --- or JSON, each with apiVersion: micropod/v1 and kind: VM. Fails if a
+VM with a spec's name exists; use apply to replace it.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		specs, err := readSpecFiles(cmd)
+		if err != nil {
+			return err
+		}
+
+		client := newClient(cmd)
+		for _, spec := range specs {
+			vmID, _, err := client.RunVM(spec)
+			if err != nil {
+				return fmt.Errorf("failed to create VM %s: %w", specLabel(spec), err)
+			}
+			fmt.Println(vmID)
+		}
+		return nil
+	},
+}
+
+var applyCmd = &cobra.Command{
+	Use:   "apply -f [file]",
+	Short: "Reconcile VMs with spec files by name",
+	Long: `Make the VMs named in the given spec files match them: create the VMs that do
+not exist, replace the ones whose spec changed or that exited, and leave the others
+running. Every spec must have a name. VMs not named in the files are not touched.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		specs, err := readSpecFiles(cmd)
+		if err != nil {
+			return err
+		}
+		for _, spec := range specs {
+			if spec.Name == "" {
+				return fmt.Errorf("every spec needs a name for apply (image %s has none)", spec.Image)
+			}
+		}
+
+		client := newClient(cmd)
+		for _, spec := range specs {
+			result, err := client.ApplyVM(spec)
+			if err != nil {
+				return fmt.Errorf("failed to apply VM %s: %w", spec.Name, err)
+			}
+			fmt.Printf("%s %s (%s)\n", result.Name, result.Action, result.ID)
+		}
+		return nil
+	},
+}
+
+// readSpecFiles parses the files given with -f, - being stdin. Relative
+// host paths in a spec are taken relative to its file, since the daemon
+// resolves them.
+func readSpecFiles(cmd *cobra.Command) ([]manager.VMSpec, error) {
+	files, _ := cmd.Flags().GetStringArray("filename")
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no spec file given: use -f <file>")
+	}
+
+	var specs []manager.VMSpec
+	for _, file := range files {
+		var data []byte
+		var err error
+		dir := "."
+		if file == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(file)
+			dir = filepath.Dir(file)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read spec file: %w", err)
+		}
+
+		fileSpecs, err := manager.ParseSpecs(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid spec file %s: %w", file, err)
+		}
+		for i := range fileSpecs {
+			if err := resolveSpecPaths(&fileSpecs[i], dir); err != nil {
+				return nil, err
+			}
+		}
+		specs = append(specs, fileSpecs...)
+	}
+	return specs, nil
+}
+
+// resolveSpecPaths makes the host paths of spec absolute, relative to dir.
+func resolveSpecPaths(spec *manager.VMSpec, dir string) error {
+	resolve := func(path string) (string, error) {
+		if path == "" || filepath.IsAbs(path) {
+			return path, nil
+		}
+		return filepath.Abs(filepath.Join(dir, path))
+	}
+
+	var err error
+	if spec.Initrd, err = resolve(spec.Initrd); err != nil {
+		return err
+	}
+	for i, mount := range spec.Mounts {
+		if mount.Type == "volume" {
+			continue
+		}
+		if mount.Type == "copy-out" && mount.Owner == "" {
+			spec.Mounts[i].Owner = manager.CurrentOwner()
+		}
+		if spec.Mounts[i].Source, err = resolve(mount.Source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func specLabel(spec manager.VMSpec) string {
+	if spec.Name != "" {
+		return spec.Name
+	}
+	return "for " + spec.Image
+}
+
+func init() {
+	createCmd.Flags().StringArrayP("filename", "f", nil, "Spec file, or - for stdin")
+	applyCmd.Flags().StringArrayP("filename", "f", nil, "Spec file, or - for stdin")
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+	"micropod/pkg/api"
+	"micropod/pkg/manager"
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats [vm...]",
+	Short: "Display a live stream of VM resource usage",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		noStream, _ := cmd.Flags().GetBool("no-stream")
+		format, _ := cmd.Flags().GetString("format")
+		interval, _ := cmd.Flags().GetDuration("interval")
+
+		if format != "table" && format != "json" {
+			return fmt.Errorf("unsupported format %q (use table or json)", format)
+		}
+		if interval < 100*time.Millisecond {
+			return fmt.Errorf("interval must be at least 100ms")
+		}
+
+		client := newClient(cmd)
+
+		// Usage rates need two samples, so even a single report waits for
+		// one interval.
+		previous, err := collectStats(client, args)
+		if err != nil {
+			return err
+		}
+
+		for {
+			time.Sleep(interval)
+
+			current, err := collectStats(client, args)
+			if err != nil {
+				return err
+			}
+
+			rows := statsRows(previous, current)
+			if format == "json" {
+				if err := printStatsJSON(rows, noStream); err != nil {
+					return err
+				}
+			} else {
+				if !noStream {
+					// Clear the screen and move the cursor home, like top.
+					fmt.Print("\033[H\033[2J")
+				}
+				printStatsTable(rows)
+			}
+
+			if noStream {
+				return nil
+			}
+			previous = current
+		}
+	},
+}
+
+// statsRow is the usage of a VM over one sampling interval.
+type statsRow struct {
+	ID              string  `json:"id"`
+	CPUPercent      float64 `json:"cpuPercent"`
+	MemoryBytes     uint64  `json:"memoryBytes"`
+	MemoryLimit     uint64  `json:"memoryLimit"`
+	Pids            uint64  `json:"pids"`
+	NetRxBytes      uint64  `json:"netRxBytes"`
+	NetTxBytes      uint64  `json:"netTxBytes"`
+	BlockReadBytes  uint64  `json:"blockReadBytes"`
+	BlockWriteBytes uint64  `json:"blockWriteBytes"`
+	VcpuExits       uint64  `json:"vcpuExits"`
+
+	Stats manager.VMStats `json:"stats"`
+}
+
+func collectStats(client *api.Client, vmIDs []string) (map[string]manager.VMStats, error) {
+	stats, err := client.GetVMStats(vmIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get VM stats: %w", err)
+	}
+
+	byID := make(map[string]manager.VMStats, len(stats))
+	for _, s := range stats {
+		byID[s.VM.ID] = s
+	}
+	return byID, nil
+}
+
+func statsRows(previous, current map[string]manager.VMStats) []statsRow {
+	var rows []statsRow
+	for id, cur := range current {
+		row := statsRow{ID: id, Stats: cur}
+
+		if prev, ok := previous[id]; ok {
+			elapsed := cur.CollectedAt.Sub(prev.CollectedAt)
+			before, after := cpuTimeUsec(prev), cpuTimeUsec(cur)
+			if elapsed > 0 && after >= before {
+				row.CPUPercent = float64(after-before) / float64(elapsed.Microseconds()) * 100
+			}
+		}
+
+		if cur.Cgroup != nil {
+			row.MemoryBytes = cur.Cgroup.MemoryCurrent
+			row.MemoryLimit = cur.Cgroup.MemoryMax
+			row.Pids = cur.Cgroup.PidsCurrent
+		} else if cur.Process != nil {
+			row.MemoryBytes = cur.Process.RSSBytes
+			row.Pids = cur.Process.Threads
+		}
+
+		// Firecracker metrics are totals since the VM started, so a row
+		// shows what changed since the previous sample.
+		if prev, ok := previous[id]; ok && cur.Firecracker != nil && prev.Firecracker != nil {
+			after, before := cur.Firecracker, prev.Firecracker
+			row.NetRxBytes = counterDelta(before.Net.RxBytes, after.Net.RxBytes)
+			row.NetTxBytes = counterDelta(before.Net.TxBytes, after.Net.TxBytes)
+			row.BlockReadBytes = counterDelta(before.Block.ReadBytes, after.Block.ReadBytes)
+			row.BlockWriteBytes = counterDelta(before.Block.WriteBytes, after.Block.WriteBytes)
+			row.VcpuExits = counterDelta(before.Vcpu.Exits(), after.Vcpu.Exits())
+		}
+
+		rows = append(rows, row)
+	}
+
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+	return rows
+}
+
+// counterDelta returns the increase of a counter, or zero if it started over.
+func counterDelta(before, after uint64) uint64 {
+	if after < before {
+		return 0
+	}
+	return after - before
+}
+
+func cpuTimeUsec(s manager.VMStats) uint64 {
+	if s.Cgroup != nil {
+		return s.Cgroup.CPUUsageUsec
+	}
+	if s.Process != nil {
+		return s.Process.CPUTimeUsec
+	}
+	return 0
+}
+
+func printStatsTable(rows []statsRow) {
+	if len(rows) == 0 {
+		fmt.Println("No running VMs found")
+		return
+	}
+
+	fmt.Printf("%-36s %-8s %-21s %-6s %-21s %-21s %s\n",
+		"VM ID", "CPU %", "MEM USAGE / LIMIT", "PIDS", "NET RX / TX", "BLOCK R / W", "VCPU EXITS")
+	for _, row := range rows {
+		fmt.Printf("%-36s %-8s %-21s %-6d %-21s %-21s %d\n",
+			row.ID,
+			fmt.Sprintf("%.2f%%", row.CPUPercent),
+			formatBytes(row.MemoryBytes)+" / "+formatLimit(row.MemoryLimit),
+			row.Pids,
+			formatBytes(row.NetRxBytes)+" / "+formatBytes(row.NetTxBytes),
+			formatBytes(row.BlockReadBytes)+" / "+formatBytes(row.BlockWriteBytes),
+			row.VcpuExits)
+	}
+}
+
+// printStatsJSON prints a single JSON array, or one array per line when
+// streaming.
+func printStatsJSON(rows []statsRow, indent bool) error {
+	if rows == nil {
+		rows = []statsRow{}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	if indent {
+		encoder.SetIndent("", "  ")
+	}
+	return encoder.Encode(rows)
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatLimit(n uint64) string {
+	if n == 0 {
+		return "max"
+	}
+	return formatBytes(n)
+}
+
+func init() {
+	statsCmd.Flags().Bool("no-stream", false, "Print a single report instead of streaming")
+	statsCmd.Flags().String("format", "table", "Output format: table or json")
+	statsCmd.Flags().Duration("interval", 2*time.Second, "Sampling interval")
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"micropod/pkg/volume"
+)
+
+var volumeCmd = &cobra.Command{
+	Use:   "volume",
+	Short: "Manage named volumes",
+}
+
+var volumeCreateCmd = &cobra.Command{
+	Use:   "create [name]",
+	Short: "Create an empty ext4 volume",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		size, _ := cmd.Flags().GetString("size")
+		sizeMB, err := parseSizeMB(size)
+		if err != nil {
+			return err
+		}
+
+		client := newClient(cmd)
+		vol, err := client.CreateVolume(args[0], sizeMB)
+		if err != nil {
+			return fmt.Errorf("failed to create volume: %w", err)
+		}
+
+		fmt.Println(vol.Name)
+		return nil
+	},
+}
+
+var volumeListCmd = &cobra.Command{
+	Use:     "ls",
+	Aliases: []string{"list"},
+	Short:   "List volumes and the VMs using them",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		quiet, _ := cmd.Flags().GetBool("quiet")
+		format, _ := cmd.Flags().GetString("format")
+
+		client := newClient(cmd)
+		volumes, err := client.ListVolumes()
+		if err != nil {
+			return fmt.Errorf("failed to list volumes: %w", err)
+		}
+
+		if quiet {
+			for _, vol := range volumes {
+				fmt.Println(vol.Name)
+			}
+			return nil
+		}
+
+		if ok, err := printFormatted(os.Stdout, format, volumes); ok {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSIZE\tUSED BY")
+		for _, vol := range volumes {
+			fmt.Fprintf(w, "%s\t%dMiB\t%s\n", vol.Name, vol.SizeMB, strings.Join(vol.UsedBy, ","))
+		}
+		return w.Flush()
+	},
+}
+
+var volumeRemoveCmd = &cobra.Command{
+	Use:     "rm [name...]",
+	Aliases: []string{"remove"},
+	Short:   "Remove volumes that no VM uses",
+	Args:    cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client := newClient(cmd)
+
+		var failed bool
+		for _, name := range args {
+			if err := client.RemoveVolume(name); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: failed to remove volume %s: %v\n", name, err)
+				failed = true
+				continue
+			}
+			fmt.Println(name)
+		}
+
+		if failed {
+			return fmt.Errorf("failed to remove some volumes")
+		}
+		return nil
+	},
+}
+
+var volumeInspectCmd = &cobra.Command{
+	Use:   "inspect [name...]",
+	Short: "Display volume details",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		if format == "table" {
+			return fmt.Errorf("inspect does not support the table format")
+		}
+
+		client := newClient(cmd)
+
+		var volumes []volume.Volume
+		for _, name := range args {
+			vol, err := client.GetVolume(name)
+			if err != nil {
+				return fmt.Errorf("failed to inspect volume %s: %w", name, err)
+			}
+			volumes = append(volumes, *vol)
+		}
+
+		_, err := printFormatted(os.Stdout, format, volumes)
+		return err
+	},
+}
+
+// parseSizeMB parses a size such as 512M or 2G into MiB. A plain number is
+// taken as MiB.
+func parseSizeMB(size string) (int, error) {
+	multiplier := 1
+	number := strings.ToUpper(strings.TrimSuffix(strings.TrimSuffix(size, "iB"), "B"))
+	switch {
+	case strings.HasSuffix(number, "G"):
+		multiplier = 1024
+		number = strings.TrimSuffix(number, "G")
+	case strings.HasSuffix(number, "M"):
+		number = strings.TrimSuffix(number, "M")
+	}
+
+	n, err := strconv.Atoi(number)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q (e.g. 512M or 2G)", size)
+	}
+	return n * multiplier, nil
+}
+
+func init() {
+	volumeCreateCmd.Flags().String("size", "1G", "Size of the volume (e.g. 512M or 2G)")
+	volumeListCmd.Flags().BoolP("quiet", "q", false, "Only print volume names")
+	volumeListCmd.Flags().String("format", "table", formatHelp)
+	volumeInspectCmd.Flags().String("format", "json", "Output format: json, yaml or go-template=<template>")
+
+	volumeCmd.AddCommand(volumeCreateCmd)
+	volumeCmd.AddCommand(volumeListCmd)
+	volumeCmd.AddCommand(volumeRemoveCmd)
+	volumeCmd.AddCommand(volumeInspectCmd)
+}
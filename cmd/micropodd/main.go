@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"micropod/pkg/api"
+	"micropod/pkg/config"
+	"micropod/pkg/manager"
+	"micropod/pkg/metrics"
+)
+
+const (
+	// readHeaderTimeout bounds how long a client may take to send request
+	// headers.
+	readHeaderTimeout = 10 * time.Second
+	// idleTimeout closes keep-alive connections left idle between requests.
+	idleTimeout = 2 * time.Minute
+)
+
+// newHTTPServer returns a server for handler. It sets no read or write
+// timeout for whole requests, since logs, events, waits and attached
+// consoles stream for as long as the client wants.
+func newHTTPServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+}
+
+var rootCmd = &cobra.Command{
+	Use:   "micropodd",
+	Short: "The micropod daemon",
+	Long:  `micropodd owns the VM manager, supervises Firecracker processes and serves the micropod API on a Unix socket.`,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		socketPath, _ := cmd.Flags().GetString("socket")
+		if socketPath == "" {
+			socketPath = config.NewConfig().GetDaemonSocketPath()
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		mgr := manager.NewManager()
+
+		listener, err := api.Listen(socketPath)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(socketPath)
+
+		server := newHTTPServer(api.NewServer(mgr))
+
+		go mgr.Supervise(ctx)
+
+		poolsDone := make(chan struct{})
+		go func() {
+			mgr.RunPools(ctx)
+			close(poolsDone)
+		}()
+
+		errCh := make(chan error, 2)
+		go func() {
+			errCh <- server.Serve(listener)
+		}()
+
+		fmt.Printf("micropodd listening on %s (API %s)\n", socketPath, api.Version)
+
+		// The API socket serves /metrics too, but Prometheus scrapes TCP.
+		var metricsServer *http.Server
+		if metricsAddr, _ := cmd.Flags().GetString("metrics-addr"); metricsAddr != "" {
+			metricsListener, err := net.Listen("tcp", metricsAddr)
+			if err != nil {
+				return fmt.Errorf("failed to listen on %s: %w", metricsAddr, err)
+			}
+			mux := http.NewServeMux()
+			mux.Handle("GET /metrics", metrics.Default.Handler())
+			metricsServer = newHTTPServer(mux)
+			go func() {
+				errCh <- metricsServer.Serve(metricsListener)
+			}()
+			fmt.Printf("Serving metrics on http://%s/metrics\n", metricsListener.Addr())
+		}
+
+		select {
+		case err := <-errCh:
+			return fmt.Errorf("server failed: %w", err)
+		case <-ctx.Done():
+		}
+
+		// VMs keep running across daemon restarts; only the API and the
+		// pooled VMs, which no run took yet, go away.
+		fmt.Println("Shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if metricsServer != nil {
+			metricsServer.Shutdown(shutdownCtx)
+		}
+		err = server.Shutdown(shutdownCtx)
+		<-poolsDone
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("failed to shut down server: %w", err)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.Flags().String("socket", "", "Unix socket to serve the API on (default $MICROPOD_SOCKET or ~/.config/micropod/micropodd.sock)")
+	rootCmd.Flags().String("metrics-addr", "", "TCP address, such as :9090, to serve Prometheus metrics on at /metrics")
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
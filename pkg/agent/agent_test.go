@@ -0,0 +1,307 @@
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"micropod/pkg/archive"
+	"micropod/pkg/guest"
+)
+
+// socketPair returns the two ends of a connected Unix socket pair, standing
+// in for the host and guest ends of a vsock connection.
+func socketPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var conns [2]net.Conn
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		conn, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns[i] = conn
+	}
+	return conns[0], conns[1]
+}
+
+func newTestClient(t *testing.T, server *Server) *Client {
+	return NewClient(func() (io.ReadWriteCloser, error) {
+		host, guest := socketPair(t)
+		go server.ServeConn(guest)
+		return host, nil
+	})
+}
+
+func TestPing(t *testing.T) {
+	client := newTestClient(t, &Server{Name: "test-agent"})
+
+	hello, err := client.Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hello.Version != ProtocolVersion || hello.Agent != "test-agent" {
+		t.Errorf("hello = %+v", hello)
+	}
+}
+
+func TestVersionMismatch(t *testing.T) {
+	client := NewClient(func() (io.ReadWriteCloser, error) {
+		host, guest := socketPair(t)
+		go func() {
+			defer guest.Close()
+			var hello Hello
+			if readMessage(guest, frameHello, &hello) == nil {
+				writeMessage(guest, frameHello, Hello{Version: ProtocolVersion + 1})
+			}
+		}()
+		return host, nil
+	})
+
+	_, err := client.Stat("/", false)
+	var versionErr *VersionError
+	if !errors.As(err, &versionErr) {
+		t.Fatalf("Stat() error = %v, want VersionError", err)
+	}
+	if versionErr.Guest != ProtocolVersion+1 {
+		t.Errorf("guest version = %d", versionErr.Guest)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	hostDir := t.TempDir()
+	guestDir := t.TempDir()
+
+	src := filepath.Join(hostDir, "app")
+	if err := os.MkdirAll(filepath.Join(src, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "bin", "run.sh"), []byte("#!/bin/sh\n"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	// Spans several data frames.
+	if err := os.WriteFile(filepath.Join(src, "data"), bytes.Repeat([]byte("x"), 3*dataChunkSize+1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("bin/run.sh", filepath.Join(src, "run")); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(t, &Server{})
+
+	// Copy host:app to guest:/srv, which exists, as micropod cp would.
+	srcStat, err := archive.Stat(src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstStat, err := client.Stat(guestDir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, rebase, err := archive.Destination(src, *srcStat, guestDir, dstStat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		pw.CloseWithError(archive.Tar(pw, src, rebase, false))
+	}()
+	if err := client.Extract(dir, pr); err != nil {
+		t.Fatalf("Extract() error: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(guestDir, "app", "bin", "run.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0750 {
+		t.Errorf("mode = %v, want 0750", info.Mode().Perm())
+	}
+	if info, err := os.Stat(filepath.Join(guestDir, "app", "data")); err != nil || info.Size() != 3*dataChunkSize+1 {
+		t.Errorf("data = %v, %v; want %d bytes", info, err, 3*dataChunkSize+1)
+	}
+	if link, err := os.Readlink(filepath.Join(guestDir, "app", "run")); err != nil || link != "bin/run.sh" {
+		t.Errorf("symlink = %q, %v; want bin/run.sh", link, err)
+	}
+
+	// Copy guest:/srv/app/run back without following the link.
+	out := filepath.Join(hostDir, "out")
+	rc, err := client.Archive(filepath.Join(guestDir, "app", "run"), "out", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = archive.Untar(rc, hostDir)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("Untar() error: %v", err)
+	}
+	if link, err := os.Readlink(out); err != nil || link != "bin/run.sh" {
+		t.Errorf("copied symlink = %q, %v; want bin/run.sh", link, err)
+	}
+
+	var notFound *NotFoundError
+	if _, err := client.Stat(filepath.Join(guestDir, "missing"), false); !errors.As(err, &notFound) {
+		t.Errorf("Stat(missing) error = %v, want NotFoundError", err)
+	}
+	if err := client.Extract(filepath.Join(guestDir, "app", "data"), strings.NewReader("")); err == nil {
+		t.Error("Extract() into a file succeeded")
+	}
+}
+
+func TestExec(t *testing.T) {
+	client := newTestClient(t, &Server{})
+	dir := t.TempDir()
+
+	result, err := client.Exec([]string{"sh", "-c", "pwd; echo failing >&2; exit 3"}, dir, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ExitCode != 3 {
+		t.Errorf("exit code = %d, want 3", result.ExitCode)
+	}
+	if result.Output != dir+"\nfailing\n" {
+		t.Errorf("output = %q", result.Output)
+	}
+
+	if _, err := client.Exec([]string{"sleep", "10"}, "", 100*time.Millisecond); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Exec(sleep) error = %v, want a timeout", err)
+	}
+	if _, err := client.Exec([]string{"no-such-command"}, "", time.Second); err == nil {
+		t.Error("Exec(no-such-command) succeeded")
+	}
+}
+
+func TestSyncTime(t *testing.T) {
+	var got time.Time
+	client := newTestClient(t, &Server{SetClock: func(now time.Time) error {
+		got = now
+		return nil
+	}})
+
+	want := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := client.SyncTime(want); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(want) {
+		t.Errorf("clock set to %v, want %v", got, want)
+	}
+
+	client = newTestClient(t, &Server{SetClock: func(time.Time) error {
+		return syscall.EPERM
+	}})
+	if err := client.SyncTime(want); err == nil {
+		t.Error("SyncTime() succeeded with a failing clock")
+	}
+}
+
+func TestStart(t *testing.T) {
+	var got guest.Config
+	client := newTestClient(t, &Server{Start: func(config guest.Config) error {
+		got = config
+		return nil
+	}})
+
+	want := guest.Config{Hostname: "web", Command: []string{"sleep", "1"}, Env: []string{"MODE=test"}}
+	if err := client.Start(want); err != nil {
+		t.Fatal(err)
+	}
+	if got.Hostname != want.Hostname || len(got.Command) != 2 || len(got.Env) != 1 {
+		t.Errorf("started config = %+v, want %+v", got, want)
+	}
+
+	client = newTestClient(t, &Server{})
+	if err := client.Start(want); err == nil {
+		t.Error("Start() succeeded on an agent that cannot start workloads")
+	}
+}
+
+func TestStream(t *testing.T) {
+	var buf bytes.Buffer
+	streamWriter{&buf}.Write([]byte("partial"))
+	endStream(&buf, errors.New("disk failed"))
+
+	data, err := io.ReadAll(&streamReader{r: &buf})
+	if string(data) != "partial" {
+		t.Errorf("data = %q, want partial", data)
+	}
+	if err == nil || !strings.Contains(err.Error(), "disk failed") {
+		t.Errorf("error = %v, want the sender's error", err)
+	}
+
+	// A stream cut off without an end frame is an error too.
+	buf.Reset()
+	streamWriter{&buf}.Write([]byte("partial"))
+	if _, err := io.ReadAll(&streamReader{r: &buf}); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated stream error = %v, want ErrUnexpectedEOF", err)
+	}
+}
+
+func TestReportExit(t *testing.T) {
+	host, guest := socketPair(t)
+	defer host.Close()
+	defer guest.Close()
+
+	reported := make(chan error, 1)
+	go func() { reported <- ReportExit(guest, 3) }()
+
+	code, err := ReadExitReport(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 3 {
+		t.Errorf("exit code = %d, want 3", code)
+	}
+	if err := AcknowledgeExit(host); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-reported; err != nil {
+		t.Errorf("ReportExit: %v", err)
+	}
+}
+
+func TestSetHosts(t *testing.T) {
+	hostsFile := filepath.Join(t.TempDir(), "hosts")
+	client := newTestClient(t, &Server{HostsFile: hostsFile})
+
+	if err := client.SetHosts([]guest.Host{{Address: "10.0.0.2", Names: []string{"db"}}}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(hostsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "10.0.0.2\tdb\n") {
+		t.Errorf("hosts file = %q", data)
+	}
+}
+
+func TestLegacyAgent(t *testing.T) {
+	client := NewClient(func() (io.ReadWriteCloser, error) {
+		host, guest := socketPair(t)
+		go func() {
+			defer guest.Close()
+			// The legacy agent fails to decode the hello as a JSON request.
+			guest.Write([]byte(`{"error":"invalid request: invalid character '\x00' looking for beginning of value"}` + "\n"))
+		}()
+		return host, nil
+	})
+
+	if _, err := client.Ping(); !errors.Is(err, ErrLegacyAgent) {
+		t.Errorf("Ping() error = %v, want %v", err, ErrLegacyAgent)
+	}
+}
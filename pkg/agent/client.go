@@ -0,0 +1,227 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"micropod/pkg/archive"
+	"micropod/pkg/guest"
+)
+
+const (
+	// requestTimeout bounds a request, or for requests streaming data, the
+	// exchange before the stream.
+	requestTimeout = 30 * time.Second
+	// streamIdleTimeout bounds how long a stream may stall.
+	streamIdleTimeout = 30 * time.Second
+)
+
+// Client sends requests to the agent of a guest. Every request uses its own
+// connection.
+type Client struct {
+	dial func() (io.ReadWriteCloser, error)
+}
+
+// NewClient returns a client that connects to the agent with dial.
+func NewClient(dial func() (io.ReadWriteCloser, error)) *Client {
+	return &Client{dial: dial}
+}
+
+// Ping exchanges hellos with the agent and returns the agent's.
+func (c *Client) Ping() (*Hello, error) {
+	conn, hello, err := c.connect(time.Now().Add(requestTimeout))
+	if err != nil {
+		return nil, err
+	}
+	conn.Close()
+	return hello, nil
+}
+
+// Stat describes a guest path.
+func (c *Client) Stat(path string, followLink bool) (*archive.PathStat, error) {
+	conn, resp, err := c.request(Request{Op: OpStat, Path: path, FollowLink: followLink}, time.Now().Add(requestTimeout))
+	if err != nil {
+		return nil, err
+	}
+	conn.Close()
+	return resp.Stat, nil
+}
+
+// Archive returns a tar stream of a guest path; see archive.Tar. Reading it
+// fails if the guest fails to archive the path.
+func (c *Client) Archive(path, rebase string, followLink bool) (io.ReadCloser, error) {
+	conn, _, err := c.request(Request{Op: OpArchive, Path: path, Rebase: rebase, FollowLink: followLink}, time.Now().Add(requestTimeout))
+	if err != nil {
+		return nil, err
+	}
+	stream := idleConn{conn}
+	return readCloser{&streamReader{r: stream}, conn}, nil
+}
+
+// Extract extracts the tar stream r into an existing guest directory.
+func (c *Client) Extract(dir string, r io.Reader) error {
+	conn, _, err := c.request(Request{Op: OpExtract, Path: dir}, time.Now().Add(requestTimeout))
+	if err != nil {
+		return err
+	}
+	stream := idleConn{conn}
+
+	// The guest answers early when extracting fails, so read its response
+	// while sending. Closing the connection then aborts the send.
+	sent := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(streamWriter{stream}, r)
+		if endErr := endStream(stream, err); err == nil {
+			err = endErr
+		}
+		sent <- err
+	}()
+
+	var resp Response
+	err = readMessage(stream, frameResponse, &resp)
+	conn.Close()
+	sendErr := <-sent
+
+	switch {
+	case err == nil && resp.Error != "":
+		return fmt.Errorf("guest: %s", resp.Error)
+	case err != nil && sendErr != nil:
+		return fmt.Errorf("failed to send archive: %w", sendErr)
+	case err != nil:
+		return fmt.Errorf("failed to read agent response: %w", err)
+	}
+	return nil
+}
+
+// Exec runs a command in the guest directory dir and returns its exit
+// status and the start of its output. The command is killed after timeout,
+// which is then reported as an error.
+func (c *Client) Exec(command []string, dir string, timeout time.Duration) (*ExecResult, error) {
+	req := Request{Op: OpExec, Path: dir, Command: command, Timeout: timeout}
+	conn, resp, err := c.request(req, time.Now().Add(timeout+execGrace))
+	if err != nil {
+		return nil, err
+	}
+	conn.Close()
+	if resp.Exec == nil {
+		return nil, fmt.Errorf("invalid exec response from guest agent")
+	}
+	return resp.Exec, nil
+}
+
+// SyncTime sets the guest's clock to t.
+func (c *Client) SyncTime(t time.Time) error {
+	conn, _, err := c.request(Request{Op: OpSyncTime, Time: t}, time.Now().Add(requestTimeout))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// SetHosts replaces the hosts of the guest's networks in its hosts file.
+func (c *Client) SetHosts(hosts []guest.Host) error {
+	conn, _, err := c.request(Request{Op: OpSetHosts, Hosts: hosts}, time.Now().Add(requestTimeout))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Start hands the workload config to a pooled guest, which then starts it.
+func (c *Client) Start(config guest.Config) error {
+	conn, _, err := c.request(Request{Op: OpStart, Config: &config}, time.Now().Add(requestTimeout))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// connect dials the agent and exchanges hellos. The connection gives up at
+// deadline.
+func (c *Client) connect(deadline time.Time) (io.ReadWriteCloser, *Hello, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to guest agent: %w", err)
+	}
+	if d, ok := conn.(deadliner); ok {
+		d.SetDeadline(deadline)
+	}
+
+	if err := writeMessage(conn, frameHello, Hello{Version: ProtocolVersion}); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to send hello to guest agent: %w", err)
+	}
+	var hello Hello
+	if err := readMessage(conn, frameHello, &hello); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to read hello of guest agent: %w", err)
+	}
+	if hello.Version != ProtocolVersion {
+		conn.Close()
+		return nil, nil, &VersionError{Host: ProtocolVersion, Guest: hello.Version}
+	}
+	return conn, &hello, nil
+}
+
+// request sends req and reads the first response. It returns the
+// connection, from which any stream following the response is read.
+func (c *Client) request(req Request, deadline time.Time) (io.ReadWriteCloser, *Response, error) {
+	conn, _, err := c.connect(deadline)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := writeMessage(conn, frameRequest, req); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to send agent request: %w", err)
+	}
+
+	var resp Response
+	if err := readMessage(conn, frameResponse, &resp); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to read agent response: %w", err)
+	}
+	if resp.NotFound {
+		conn.Close()
+		return nil, nil, &NotFoundError{Path: req.Path}
+	}
+	if resp.Error != "" {
+		conn.Close()
+		return nil, nil, fmt.Errorf("guest: %s", resp.Error)
+	}
+
+	return conn, &resp, nil
+}
+
+type deadliner interface {
+	SetDeadline(time.Time) error
+}
+
+// idleConn extends the deadline of a connection before every read and
+// write, so that a stream fails once it stalls rather than once it takes
+// long.
+type idleConn struct {
+	io.ReadWriter
+}
+
+func (c idleConn) Read(p []byte) (int, error) {
+	c.extend()
+	return c.ReadWriter.Read(p)
+}
+
+func (c idleConn) Write(p []byte) (int, error) {
+	c.extend()
+	return c.ReadWriter.Write(p)
+}
+
+func (c idleConn) extend() {
+	if d, ok := c.ReadWriter.(deadliner); ok {
+		d.SetDeadline(time.Now().Add(streamIdleTimeout))
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
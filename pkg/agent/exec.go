@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+const (
+	// execOutputLimit is how much of a command's output an exec response
+	// carries.
+	execOutputLimit = 4096
+	// execGrace is how long the host waits for an exec response beyond the
+	// command's timeout.
+	execGrace = 5 * time.Second
+)
+
+// ProcessRunner starts the commands of exec requests and waits for them.
+// micropod-init, which as PID 1 reaps every child itself, has its own.
+type ProcessRunner interface {
+	Start(path string, argv []string, attr *os.ProcAttr) (*os.Process, error)
+	Wait(p *os.Process) (syscall.WaitStatus, error)
+}
+
+type osProcesses struct{}
+
+func (osProcesses) Start(path string, argv []string, attr *os.ProcAttr) (*os.Process, error) {
+	return os.StartProcess(path, argv, attr)
+}
+
+func (osProcesses) Wait(p *os.Process) (syscall.WaitStatus, error) {
+	state, err := p.Wait()
+	if err != nil {
+		return 0, err
+	}
+	return state.Sys().(syscall.WaitStatus), nil
+}
+
+// execCommand runs the command of an exec request in its own session and
+// kills the session once the timeout expires.
+func execCommand(processes ProcessRunner, req Request) (*ExecResult, error) {
+	if len(req.Command) == 0 {
+		return nil, fmt.Errorf("no command specified")
+	}
+	path, err := exec.LookPath(req.Command[0])
+	if err != nil {
+		return nil, fmt.Errorf("command not found: %w", err)
+	}
+
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return nil, err
+	}
+	defer devNull.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	dir := req.Path
+	if dir == "" {
+		dir = "/"
+	}
+	process, err := processes.Start(path, req.Command, &os.ProcAttr{
+		Dir:   dir,
+		Env:   os.Environ(),
+		Files: []*os.File{devNull, w, w},
+		Sys:   &syscall.SysProcAttr{Setsid: true},
+	})
+	w.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
+
+	output := make(chan []byte, 1)
+	go func() {
+		data, _ := io.ReadAll(io.LimitReader(r, execOutputLimit))
+		io.Copy(io.Discard, r)
+		output <- data
+	}()
+
+	timedOut := make(chan struct{})
+	if req.Timeout > 0 {
+		timer := time.AfterFunc(req.Timeout, func() {
+			close(timedOut)
+			syscall.Kill(-process.Pid, syscall.SIGKILL)
+		})
+		defer timer.Stop()
+	}
+
+	status, err := processes.Wait(process)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for command: %w", err)
+	}
+	select {
+	case <-timedOut:
+		return nil, fmt.Errorf("command timed out after %s", req.Timeout)
+	default:
+	}
+
+	// Processes left behind by the command may hold the pipe open.
+	var data []byte
+	select {
+	case data = <-output:
+	case <-time.After(time.Second):
+		r.Close()
+		data = <-output
+	}
+
+	result := &ExecResult{ExitCode: status.ExitStatus(), Output: string(data)}
+	if status.Signaled() {
+		result.ExitCode = 128 + int(status.Signal())
+	}
+	return result, nil
+}
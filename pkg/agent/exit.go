@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+)
+
+// ExitPort is the host vsock port the guest reports the exit status of its
+// workload to. Unlike agent connections, the guest opens this one.
+const ExitPort = 1025
+
+// ExitReport carries the exit status of the workload.
+type ExitReport struct {
+	Code int `json:"code"`
+}
+
+// ReportExit sends the exit status of the workload to the host and waits
+// for the host to acknowledge it, so that the guest powers off only once
+// the status left it.
+func ReportExit(conn io.ReadWriter, code int) error {
+	if err := writeMessage(conn, frameHello, Hello{Version: ProtocolVersion}); err != nil {
+		return fmt.Errorf("failed to send hello: %w", err)
+	}
+	if err := writeMessage(conn, frameExit, ExitReport{Code: code}); err != nil {
+		return fmt.Errorf("failed to send exit status: %w", err)
+	}
+	var resp Response
+	if err := readMessage(conn, frameResponse, &resp); err != nil {
+		return fmt.Errorf("failed to read host acknowledgement: %w", err)
+	}
+	return nil
+}
+
+// ReadExitReport reads the exit status reported by a guest on conn. The
+// guest waits for AcknowledgeExit.
+func ReadExitReport(conn io.Reader) (int, error) {
+	var hello Hello
+	if err := readMessage(conn, frameHello, &hello); err != nil {
+		return 0, fmt.Errorf("failed to read guest hello: %w", err)
+	}
+	if hello.Version != ProtocolVersion {
+		return 0, &VersionError{Host: ProtocolVersion, Guest: hello.Version}
+	}
+
+	var report ExitReport
+	if err := readMessage(conn, frameExit, &report); err != nil {
+		return 0, fmt.Errorf("failed to read exit status: %w", err)
+	}
+	return report.Code, nil
+}
+
+// AcknowledgeExit tells the guest its exit status was received.
+func AcknowledgeExit(conn io.Writer) error {
+	return writeMessage(conn, frameResponse, Response{})
+}
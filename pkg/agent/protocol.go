@@ -0,0 +1,254 @@
+// Package agent implements the control channel between micropodd and the
+// agent running in a guest, over vsock: a versioned protocol of
+// length-prefixed frames, the agent's server side and the host's client.
+//
+// Each connection carries one exchange. The client sends a hello frame with
+// its protocol version and the agent answers with its own; both then
+// continue only if the versions match. The client sends a request frame and
+// reads a response frame. Streams, such as tar archives, follow as data
+// frames ended by an end frame. The guest reports the exit status of its
+// workload the other way round, on a connection of its own; see ReportExit.
+package agent
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"micropod/pkg/archive"
+	"micropod/pkg/guest"
+)
+
+// Port is the vsock port the agent listens on.
+const Port = 1024
+
+// ProtocolVersion is the version of the protocol spoken by this package.
+const ProtocolVersion = 1
+
+// Operations of requests.
+const (
+	OpStat     = "stat"
+	OpArchive  = "archive"
+	OpExtract  = "extract"
+	OpExec     = "exec"
+	OpSyncTime = "sync_time"
+	OpStart    = "start"
+	OpSetHosts = "set_hosts"
+)
+
+// Frame types.
+const (
+	frameHello    byte = 1
+	frameRequest  byte = 2
+	frameResponse byte = 3
+	frameData     byte = 4
+	frameEnd      byte = 5
+	frameExit     byte = 6
+)
+
+const (
+	// maxFramePayload bounds the payload of a frame.
+	maxFramePayload = 1 << 20
+	// dataChunkSize is the largest payload of a data frame.
+	dataChunkSize = 32 * 1024
+)
+
+// Hello opens a connection in both directions.
+type Hello struct {
+	Version int `json:"version"`
+	// Agent names the guest program serving the agent, in the agent's
+	// hello.
+	Agent string `json:"agent,omitempty"`
+}
+
+// Request asks the agent to perform an operation.
+type Request struct {
+	Op string `json:"op"`
+	// Path is the guest path of stat, archive and extract, and the working
+	// directory of exec.
+	Path string `json:"path,omitempty"`
+	// Rebase names the top entry of an archive; see archive.Tar.
+	Rebase     string `json:"rebase,omitempty"`
+	FollowLink bool   `json:"followLink,omitempty"`
+	// Command is run by exec; it is killed after Timeout.
+	Command []string      `json:"command,omitempty"`
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// Time is what sync_time sets the guest's clock to.
+	Time time.Time `json:"time,omitempty"`
+	// Config is the workload start hands to a pooled guest.
+	Config *guest.Config `json:"config,omitempty"`
+	// Hosts replace the network hosts of the guest for set_hosts.
+	Hosts []guest.Host `json:"hosts,omitempty"`
+}
+
+// Response answers a request. An archive stream follows the response to an
+// archive request. An extract request gets a second response once the
+// stream sent by the host is extracted.
+type Response struct {
+	Error    string            `json:"error,omitempty"`
+	NotFound bool              `json:"notFound,omitempty"`
+	Stat     *archive.PathStat `json:"stat,omitempty"`
+	Exec     *ExecResult       `json:"exec,omitempty"`
+}
+
+// ExecResult is the outcome of a command run by an exec request.
+type ExecResult struct {
+	ExitCode int `json:"exitCode"`
+	// Output holds the start of the command's stdout and stderr.
+	Output string `json:"output,omitempty"`
+}
+
+// VersionError is returned when the two ends speak different versions of
+// the protocol.
+type VersionError struct {
+	Host  int
+	Guest int
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("guest agent speaks protocol version %d, the host version %d", e.Guest, e.Host)
+}
+
+// ErrLegacyAgent is returned when the guest answers in the line-based JSON
+// protocol spoken by micropod-init before this one. The agent comes with
+// the rootfs, so only recreating the VM updates it.
+var ErrLegacyAgent = errors.New("guest runs an older micropod-init speaking the line-based JSON agent protocol; recreate the VM to update it")
+
+// NotFoundError is returned by the Client when the guest path does not
+// exist.
+type NotFoundError struct {
+	Path string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("no such file or directory in guest: %s", e.Path)
+}
+
+// writeFrame writes a frame: the length of the payload as a big-endian
+// uint32, the frame type, and the payload.
+func writeFrame(w io.Writer, frameType byte, payload []byte) error {
+	if len(payload) > maxFramePayload {
+		return fmt.Errorf("frame payload of %d bytes exceeds %d", len(payload), maxFramePayload)
+	}
+
+	frame := make([]byte, 5+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	frame[4] = frameType
+	copy(frame[5:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame reads a frame and returns its type and payload.
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	// A frame starting with '{' would exceed maxFramePayload; responses of
+	// the legacy protocol do.
+	if header[0] == '{' {
+		return 0, nil, ErrLegacyAgent
+	}
+
+	n := binary.BigEndian.Uint32(header[:4])
+	if n > maxFramePayload {
+		return 0, nil, fmt.Errorf("frame payload of %d bytes exceeds %d", n, maxFramePayload)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, fmt.Errorf("truncated frame: %w", err)
+	}
+	return header[4], payload, nil
+}
+
+func writeMessage(w io.Writer, frameType byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFrame(w, frameType, data)
+}
+
+// readMessage reads a frame of the given type and decodes its JSON payload
+// into v.
+func readMessage(r io.Reader, frameType byte, v interface{}) error {
+	got, payload, err := readFrame(r)
+	if err != nil {
+		return err
+	}
+	if got != frameType {
+		return fmt.Errorf("unexpected frame type %d, expected %d", got, frameType)
+	}
+	return json.Unmarshal(payload, v)
+}
+
+// streamWriter sends what is written to it as data frames.
+type streamWriter struct {
+	w io.Writer
+}
+
+func (s streamWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > dataChunkSize {
+			chunk = chunk[:dataChunkSize]
+		}
+		if err := writeFrame(s.w, frameData, chunk); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// endStream ends a stream, successfully when err is nil.
+func endStream(w io.Writer, err error) error {
+	var payload []byte
+	if err != nil {
+		payload = []byte(err.Error())
+	}
+	return writeFrame(w, frameEnd, payload)
+}
+
+// streamReader reads the data frames of a stream. It returns io.EOF at the
+// end frame, or the error the sender ended the stream with.
+type streamReader struct {
+	r   io.Reader
+	buf []byte
+	err error
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+
+		frameType, payload, err := readFrame(s.r)
+		switch {
+		case err != nil:
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			s.err = fmt.Errorf("stream interrupted: %w", err)
+		case frameType == frameData:
+			s.buf = payload
+		case frameType == frameEnd && len(payload) > 0:
+			s.err = fmt.Errorf("guest: %s", payload)
+		case frameType == frameEnd:
+			s.err = io.EOF
+		default:
+			s.err = fmt.Errorf("unexpected frame type %d in stream", frameType)
+		}
+	}
+
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"micropod/pkg/archive"
+	"micropod/pkg/guest"
+)
+
+// Server is the agent's side of the protocol, run in the guest.
+type Server struct {
+	// Name is sent to clients in the agent's hello.
+	Name string
+	// Processes starts the commands of exec requests and waits for them;
+	// nil means os.StartProcess and Process.Wait.
+	Processes ProcessRunner
+	// SetClock sets the guest's clock for sync_time requests; nil means
+	// settimeofday(2).
+	SetClock func(time.Time) error
+	// Start starts the workload of a pooled guest for start requests; nil
+	// means the agent cannot start workloads.
+	Start func(guest.Config) error
+	// HostsFile is the file set_hosts requests write; empty means
+	// guest.HostsFile.
+	HostsFile string
+}
+
+// Serve handles the connections returned by accept until it fails.
+func (s *Server) Serve(accept func() (io.ReadWriteCloser, error)) error {
+	for {
+		conn, err := accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn answers the hello and the request of a connection, and closes
+// it. A client that closes the connection after the hello has pinged the
+// agent.
+func (s *Server) ServeConn(conn io.ReadWriteCloser) {
+	defer conn.Close()
+
+	var hello Hello
+	if err := readMessage(conn, frameHello, &hello); err != nil {
+		return
+	}
+	if err := writeMessage(conn, frameHello, Hello{Version: ProtocolVersion, Agent: s.Name}); err != nil {
+		return
+	}
+	if hello.Version != ProtocolVersion {
+		return
+	}
+
+	var req Request
+	if err := readMessage(conn, frameRequest, &req); err != nil {
+		if !errors.Is(err, io.EOF) {
+			writeMessage(conn, frameResponse, errorResponse(fmt.Errorf("invalid request: %w", err)))
+		}
+		return
+	}
+
+	switch req.Op {
+	case OpStat:
+		stat, err := archive.Stat(req.Path, req.FollowLink)
+		if err != nil {
+			writeMessage(conn, frameResponse, errorResponse(err))
+			return
+		}
+		writeMessage(conn, frameResponse, Response{Stat: stat})
+	case OpArchive:
+		if _, err := archive.Stat(req.Path, req.FollowLink); err != nil {
+			writeMessage(conn, frameResponse, errorResponse(err))
+			return
+		}
+		if err := writeMessage(conn, frameResponse, Response{}); err != nil {
+			return
+		}
+		err := archive.Tar(streamWriter{conn}, req.Path, req.Rebase, req.FollowLink)
+		endStream(conn, err)
+	case OpExtract:
+		if info, err := os.Stat(req.Path); err != nil {
+			writeMessage(conn, frameResponse, errorResponse(err))
+			return
+		} else if !info.IsDir() {
+			writeMessage(conn, frameResponse, errorResponse(fmt.Errorf("%s is not a directory", req.Path)))
+			return
+		}
+		if err := writeMessage(conn, frameResponse, Response{}); err != nil {
+			return
+		}
+		stream := &streamReader{r: conn}
+		if err := archive.Untar(stream, req.Path); err != nil {
+			writeMessage(conn, frameResponse, errorResponse(err))
+			return
+		}
+		// The archive may end before the stream does.
+		if _, err := io.Copy(io.Discard, stream); err != nil {
+			writeMessage(conn, frameResponse, errorResponse(err))
+			return
+		}
+		writeMessage(conn, frameResponse, Response{})
+	case OpExec:
+		processes := s.Processes
+		if processes == nil {
+			processes = osProcesses{}
+		}
+		result, err := execCommand(processes, req)
+		if err != nil {
+			writeMessage(conn, frameResponse, Response{Error: err.Error()})
+			return
+		}
+		writeMessage(conn, frameResponse, Response{Exec: result})
+	case OpSyncTime:
+		setClock := s.SetClock
+		if setClock == nil {
+			setClock = setSystemClock
+		}
+		if err := setClock(req.Time); err != nil {
+			writeMessage(conn, frameResponse, Response{Error: fmt.Sprintf("failed to set clock: %v", err)})
+			return
+		}
+		writeMessage(conn, frameResponse, Response{})
+	case OpStart:
+		if s.Start == nil || req.Config == nil {
+			writeMessage(conn, frameResponse, Response{Error: "this agent does not start workloads"})
+			return
+		}
+		if err := s.Start(*req.Config); err != nil {
+			writeMessage(conn, frameResponse, errorResponse(err))
+			return
+		}
+		writeMessage(conn, frameResponse, Response{})
+	case OpSetHosts:
+		hostsFile := s.HostsFile
+		if hostsFile == "" {
+			hostsFile = guest.HostsFile
+		}
+		if err := guest.WriteHosts(hostsFile, req.Hosts); err != nil {
+			writeMessage(conn, frameResponse, Response{Error: fmt.Sprintf("failed to update hosts: %v", err)})
+			return
+		}
+		writeMessage(conn, frameResponse, Response{})
+	default:
+		writeMessage(conn, frameResponse, errorResponse(fmt.Errorf("unknown operation %q", req.Op)))
+	}
+}
+
+func errorResponse(err error) Response {
+	return Response{Error: err.Error(), NotFound: errors.Is(err, os.ErrNotExist)}
+}
+
+func setSystemClock(t time.Time) error {
+	tv := syscall.NsecToTimeval(t.UnixNano())
+	return syscall.Settimeofday(&tv)
+}
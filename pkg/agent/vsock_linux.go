@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ListenVsock listens on a vsock port of the guest and returns a function
+// accepting its connections, for Server.Serve.
+func ListenVsock(port uint32) (func() (io.ReadWriteCloser, error), error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vsock socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrVM{CID: unix.VMADDR_CID_ANY, Port: port}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind vsock port %d: %w", port, err)
+	}
+	if err := unix.Listen(fd, 16); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to listen on vsock port %d: %w", port, err)
+	}
+
+	// The net package does not support vsock, so connections are plain
+	// files.
+	return func() (io.ReadWriteCloser, error) {
+		for {
+			conn, _, err := unix.Accept4(fd, unix.SOCK_CLOEXEC)
+			if err == unix.EINTR {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			return os.NewFile(uintptr(conn), "vsock"), nil
+		}
+	}, nil
+}
+
+// DialVsockHost connects to a vsock port of the host. Reads and writes on
+// the connection time out after timeout.
+func DialVsockHost(port uint32, timeout time.Duration) (io.ReadWriteCloser, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vsock socket: %w", err)
+	}
+	tv := unix.NsecToTimeval(timeout.Nanoseconds())
+	for _, opt := range []int{unix.SO_RCVTIMEO, unix.SO_SNDTIMEO} {
+		if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, opt, &tv); err != nil {
+			unix.Close(fd)
+			return nil, fmt.Errorf("failed to set vsock timeout: %w", err)
+		}
+	}
+	if err := unix.Connect(fd, &unix.SockaddrVM{CID: unix.VMADDR_CID_HOST, Port: port}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to connect to host vsock port %d: %w", port, err)
+	}
+	return os.NewFile(uintptr(fd), "vsock"), nil
+}
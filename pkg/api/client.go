@@ -0,0 +1,503 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"micropod/pkg/archive"
+	"micropod/pkg/kernel"
+	"micropod/pkg/manager"
+	"micropod/pkg/network"
+	"micropod/pkg/state"
+	"micropod/pkg/volume"
+)
+
+// Client talks to micropodd over its Unix socket.
+type Client struct {
+	socketPath string
+	httpClient *http.Client
+}
+
+// NewClient returns a client for the daemon listening on socketPath.
+func NewClient(socketPath string) *Client {
+	return &Client{
+		socketPath: socketPath,
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// Version returns the API version of the daemon.
+func (c *Client) Version() (string, error) {
+	var resp VersionResponse
+	if err := c.do("GET", "/version", nil, nil, &resp); err != nil {
+		return "", err
+	}
+	return resp.APIVersion, nil
+}
+
+// RunVM starts a VM as described by spec and returns its ID and record.
+// The record is nil if the VM was already removed.
+func (c *Client) RunVM(spec manager.VMSpec) (string, *state.VM, error) {
+	var resp RunVMResponse
+	if err := c.do("POST", "/vms", nil, spec, &resp); err != nil {
+		return "", nil, err
+	}
+	return resp.ID, resp.VM, nil
+}
+
+// ApplyVM creates, replaces or keeps the VM named in spec so that it runs
+// as spec describes.
+func (c *Client) ApplyVM(spec manager.VMSpec) (*manager.ApplyResult, error) {
+	var result manager.ApplyResult
+	if err := c.do("POST", "/vms/apply", nil, spec, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ListOptions selects the VMs returned by ListVMs.
+type ListOptions struct {
+	// All includes VMs without a running process.
+	All bool
+	// Filters are key=value pairs as accepted by manager.ParseVMFilter.
+	Filters []string
+}
+
+// ListVMs returns the running VMs, or all recorded VMs with opts.All, that
+// match the filters.
+func (c *Client) ListVMs(opts ListOptions) ([]state.VM, error) {
+	query := url.Values{"filter": opts.Filters}
+	if opts.All {
+		query.Set("all", "true")
+	}
+
+	var vms []state.VM
+	if err := c.do("GET", "/vms", query, nil, &vms); err != nil {
+		return nil, err
+	}
+	return vms, nil
+}
+
+// PruneVMs removes the exited VMs that match the filters and returns their
+// IDs.
+func (c *Client) PruneVMs(filters []string) ([]string, error) {
+	var resp PruneResponse
+	query := url.Values{"filter": filters}
+	if err := c.do("POST", "/vms/prune", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Removed, nil
+}
+
+// GetVM returns the state of a single VM.
+func (c *Client) GetVM(vmID string) (*state.VM, error) {
+	var vm state.VM
+	if err := c.do("GET", "/vms/"+url.PathEscape(vmID), nil, nil, &vm); err != nil {
+		return nil, err
+	}
+	return &vm, nil
+}
+
+// InspectVM returns the full state of a VM together with its live
+// Firecracker configuration.
+func (c *Client) InspectVM(vmID string) (*manager.VMInspect, error) {
+	var inspect manager.VMInspect
+	if err := c.do("GET", "/vms/"+url.PathEscape(vmID)+"/inspect", nil, nil, &inspect); err != nil {
+		return nil, err
+	}
+	return &inspect, nil
+}
+
+// GetMetadata returns the metadata document published to a VM's guest.
+func (c *Client) GetMetadata(vmID string) (*manager.Metadata, error) {
+	var md manager.Metadata
+	if err := c.do("GET", "/vms/"+url.PathEscape(vmID)+"/metadata", nil, nil, &md); err != nil {
+		return nil, err
+	}
+	return &md, nil
+}
+
+// SetMetadata replaces the metadata document of a VM and publishes it to the
+// running guest.
+func (c *Client) SetMetadata(vmID string, md manager.Metadata) error {
+	return c.do("PUT", "/vms/"+url.PathEscape(vmID)+"/metadata", nil, md, nil)
+}
+
+// StopVM stops and cleans up a VM.
+func (c *Client) StopVM(vmID string) error {
+	return c.do("DELETE", "/vms/"+url.PathEscape(vmID), nil, nil, nil)
+}
+
+// PauseVM pauses a running VM.
+func (c *Client) PauseVM(vmID string) error {
+	return c.do("POST", "/vms/"+url.PathEscape(vmID)+"/pause", nil, nil, nil)
+}
+
+// ResumeVM resumes a paused VM.
+func (c *Client) ResumeVM(vmID string) error {
+	return c.do("POST", "/vms/"+url.PathEscape(vmID)+"/resume", nil, nil, nil)
+}
+
+// Logs returns the console log of a VM. With follow, the stream continues
+// until the VM exits.
+func (c *Client) Logs(vmID string, follow bool) (io.ReadCloser, error) {
+	query := url.Values{}
+	if follow {
+		query.Set("follow", "true")
+	}
+	resp, err := c.stream("GET", "/vms/"+url.PathEscape(vmID)+"/logs", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// WaitVM blocks until a VM exits and returns its exit code.
+func (c *Client) WaitVM(vmID string) (*manager.WaitResult, error) {
+	var result manager.WaitResult
+	if err := c.do("POST", "/vms/"+url.PathEscape(vmID)+"/wait", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// WaitHealthy blocks until a VM is healthy, and fails if it exits first.
+func (c *Client) WaitHealthy(vmID string) error {
+	query := url.Values{"condition": {"healthy"}}
+	return c.do("POST", "/vms/"+url.PathEscape(vmID)+"/wait", query, nil, nil)
+}
+
+// ConsoleConn is a session attached to the console of a VM. Reads return
+// console output until the VM exits; writes are typed into the console
+// unless the session is read-only.
+type ConsoleConn struct {
+	io.ReadWriteCloser
+	ReadOnly bool
+}
+
+// Attach attaches to the console of a VM started with a TTY.
+func (c *Client) Attach(vmID string) (*ConsoleConn, error) {
+	req, err := http.NewRequest("POST", "http://micropodd/"+Version+"/vms/"+url.PathEscape(vmID)+"/attach", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", attachProtocol)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to micropodd at %s (is the daemon running?): %w", c.socketPath, err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
+	}
+
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, fmt.Errorf("attach response is not a stream")
+	}
+	return &ConsoleConn{ReadWriteCloser: conn, ReadOnly: resp.Header.Get(readOnlyHeader) == "true"}, nil
+}
+
+// StatPath describes a path in a running VM. It returns nil when the path
+// does not exist.
+func (c *Client) StatPath(vmID, path string, followLink bool) (*archive.PathStat, error) {
+	var resp PathStatResponse
+	query := url.Values{"path": {path}}
+	if followLink {
+		query.Set("followLink", "true")
+	}
+	if err := c.do("GET", "/vms/"+url.PathEscape(vmID)+"/archive/stat", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Stat, nil
+}
+
+// GetArchive returns a tar stream of a path in a running VM whose top entry
+// is named rebase.
+func (c *Client) GetArchive(vmID, path, rebase string, followLink bool) (io.ReadCloser, error) {
+	query := url.Values{"path": {path}, "rebase": {rebase}}
+	if followLink {
+		query.Set("followLink", "true")
+	}
+	resp, err := c.stream("GET", "/vms/"+url.PathEscape(vmID)+"/archive", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// PutArchive extracts a tar stream into an existing directory of a running
+// VM.
+func (c *Client) PutArchive(vmID, dir string, r io.Reader) error {
+	query := url.Values{"path": {dir}}
+	resp, err := c.stream("PUT", "/vms/"+url.PathEscape(vmID)+"/archive", query, r)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// CreateVolume creates an empty named volume of sizeMB MiB.
+func (c *Client) CreateVolume(name string, sizeMB int) (*volume.Volume, error) {
+	var vol volume.Volume
+	req := CreateVolumeRequest{Name: name, SizeMB: sizeMB}
+	if err := c.do("POST", "/volumes", nil, req, &vol); err != nil {
+		return nil, err
+	}
+	return &vol, nil
+}
+
+// ListVolumes returns all named volumes and the VMs using them.
+func (c *Client) ListVolumes() ([]volume.Volume, error) {
+	var volumes []volume.Volume
+	if err := c.do("GET", "/volumes", nil, nil, &volumes); err != nil {
+		return nil, err
+	}
+	return volumes, nil
+}
+
+// GetVolume returns a single volume and the VMs using it.
+func (c *Client) GetVolume(name string) (*volume.Volume, error) {
+	var vol volume.Volume
+	if err := c.do("GET", "/volumes/"+url.PathEscape(name), nil, nil, &vol); err != nil {
+		return nil, err
+	}
+	return &vol, nil
+}
+
+// RemoveVolume deletes a volume no VM uses.
+func (c *Client) RemoveVolume(name string) error {
+	return c.do("DELETE", "/volumes/"+url.PathEscape(name), nil, nil, nil)
+}
+
+// CreateNetwork creates a network and its bridge.
+func (c *Client) CreateNetwork(name string, labels map[string]string) (*network.Network, error) {
+	var n network.Network
+	req := CreateNetworkRequest{Name: name, Labels: labels}
+	if err := c.do("POST", "/networks", nil, req, &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// ListNetworks returns all networks and the VMs attached to them.
+func (c *Client) ListNetworks() ([]network.Network, error) {
+	var networks []network.Network
+	if err := c.do("GET", "/networks", nil, nil, &networks); err != nil {
+		return nil, err
+	}
+	return networks, nil
+}
+
+// GetNetwork returns a single network and the VMs attached to it.
+func (c *Client) GetNetwork(name string) (*network.Network, error) {
+	var n network.Network
+	if err := c.do("GET", "/networks/"+url.PathEscape(name), nil, nil, &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// ListPools returns the pools of pre-booted VMs and their hit rates.
+func (c *Client) ListPools() ([]manager.PoolStats, error) {
+	var pools []manager.PoolStats
+	if err := c.do("GET", "/pools", nil, nil, &pools); err != nil {
+		return nil, err
+	}
+	return pools, nil
+}
+
+// RemoveNetwork deletes a network no VM is attached to.
+func (c *Client) RemoveNetwork(name string) error {
+	return c.do("DELETE", "/networks/"+url.PathEscape(name), nil, nil, nil)
+}
+
+// AddKernel imports the kernel image at path, a path on the daemon's host,
+// into the kernel registry.
+func (c *Client) AddKernel(req AddKernelRequest) (*kernel.Kernel, error) {
+	var k kernel.Kernel
+	if err := c.do("POST", "/kernels", nil, req, &k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// ListKernels returns all registered kernels and the VMs booting them.
+func (c *Client) ListKernels() ([]kernel.Kernel, error) {
+	var kernels []kernel.Kernel
+	if err := c.do("GET", "/kernels", nil, nil, &kernels); err != nil {
+		return nil, err
+	}
+	return kernels, nil
+}
+
+// GetKernel returns a single kernel and the VMs booting it.
+func (c *Client) GetKernel(name string) (*kernel.Kernel, error) {
+	var k kernel.Kernel
+	if err := c.do("GET", "/kernels/"+url.PathEscape(name), nil, nil, &k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// RemoveKernel deletes a kernel no VM boots.
+func (c *Client) RemoveKernel(name string) error {
+	return c.do("DELETE", "/kernels/"+url.PathEscape(name), nil, nil, nil)
+}
+
+// SetDefaultKernel makes a kernel the one VMs boot unless they select another.
+func (c *Client) SetDefaultKernel(name string) error {
+	return c.do("POST", "/kernels/"+url.PathEscape(name)+"/default", nil, nil, nil)
+}
+
+// GetVMStats returns the resource usage of the given VMs, or of every
+// running VM when no ID is given.
+func (c *Client) GetVMStats(vmIDs []string) ([]manager.VMStats, error) {
+	var stats []manager.VMStats
+	query := url.Values{"vm": vmIDs}
+	if err := c.do("GET", "/stats", query, nil, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// EventsOptions selects the events returned by Events.
+type EventsOptions struct {
+	Since   time.Time
+	Until   time.Time
+	Filters []string
+	// Follow keeps the stream open for new events.
+	Follow bool
+}
+
+// Events calls fn for every recorded event matching opts and, when
+// following, for every new one until fn returns an error or the stream ends.
+func (c *Client) Events(opts EventsOptions, fn func(manager.Event) error) error {
+	query := url.Values{"filter": opts.Filters}
+	if !opts.Since.IsZero() {
+		query.Set("since", opts.Since.Format(time.RFC3339Nano))
+	}
+	if !opts.Until.IsZero() {
+		query.Set("until", opts.Until.Format(time.RFC3339Nano))
+	}
+	if opts.Follow {
+		query.Set("follow", "true")
+	}
+
+	resp, err := c.stream("GET", "/events", query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var e manager.Event
+		if err := decoder.Decode(&e); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to decode event: %w", err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
+
+// stream sends a request with a raw body, if given, to a versioned route
+// and returns the response for the caller to read incrementally.
+func (c *Client) stream(method, path string, query url.Values, body io.Reader) (*http.Response, error) {
+	u := "http://micropodd/" + Version + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to micropodd at %s (is the daemon running?): %w", c.socketPath, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
+	}
+
+	return resp, nil
+}
+
+// do sends a request to a versioned route and decodes the JSON response
+// into out, if given.
+func (c *Client) do(method, path string, query url.Values, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	u := "http://micropodd/" + Version + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot connect to micropodd at %s (is the daemon running?): %w", c.socketPath, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return decodeError(resp)
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
+
+func decodeError(resp *http.Response) error {
+	var errResp ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Message == "" {
+		return fmt.Errorf("daemon returned status %d", resp.StatusCode)
+	}
+	return fmt.Errorf("%s", errResp.Message)
+}
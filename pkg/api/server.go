@@ -0,0 +1,723 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"micropod/pkg/kernel"
+	"micropod/pkg/manager"
+	"micropod/pkg/metrics"
+	"micropod/pkg/network"
+	"micropod/pkg/state"
+	"micropod/pkg/volume"
+)
+
+// Server exposes a Manager over HTTP.
+type Server struct {
+	manager *manager.Manager
+	mux     *http.ServeMux
+}
+
+// NewServer creates a server for mgr and registers its routes.
+func NewServer(mgr *manager.Manager) *Server {
+	s := &Server{
+		manager: mgr,
+		mux:     http.NewServeMux(),
+	}
+
+	s.handle("GET", "/version", s.getVersion)
+	s.handle("POST", "/vms", s.runVM)
+	s.handle("GET", "/vms", s.listVMs)
+	s.handle("POST", "/vms/prune", s.pruneVMs)
+	s.handle("POST", "/vms/apply", s.applyVM)
+	s.handle("GET", "/vms/{id}", s.getVM)
+	s.handle("GET", "/vms/{id}/inspect", s.inspectVM)
+	s.handle("GET", "/vms/{id}/metadata", s.getMetadata)
+	s.handle("PUT", "/vms/{id}/metadata", s.setMetadata)
+	s.handle("GET", "/vms/{id}/archive/stat", s.statPath)
+	s.handle("GET", "/vms/{id}/archive", s.getArchive)
+	s.handle("PUT", "/vms/{id}/archive", s.putArchive)
+	s.handle("GET", "/vms/{id}/logs", s.getLogs)
+	s.handle("POST", "/vms/{id}/wait", s.waitVM)
+	s.handle("POST", "/vms/{id}/attach", s.attachVM)
+	s.handle("DELETE", "/vms/{id}", s.stopVM)
+	s.handle("POST", "/vms/{id}/pause", s.pauseVM)
+	s.handle("POST", "/vms/{id}/resume", s.resumeVM)
+	s.handle("POST", "/volumes", s.createVolume)
+	s.handle("GET", "/volumes", s.listVolumes)
+	s.handle("GET", "/volumes/{name}", s.getVolume)
+	s.handle("DELETE", "/volumes/{name}", s.removeVolume)
+	s.handle("POST", "/networks", s.createNetwork)
+	s.handle("GET", "/networks", s.listNetworks)
+	s.handle("GET", "/networks/{name}", s.getNetwork)
+	s.handle("DELETE", "/networks/{name}", s.removeNetwork)
+	s.handle("POST", "/kernels", s.addKernel)
+	s.handle("GET", "/kernels", s.listKernels)
+	s.handle("GET", "/kernels/{name}", s.getKernel)
+	s.handle("DELETE", "/kernels/{name}", s.removeKernel)
+	s.handle("POST", "/kernels/{name}/default", s.setDefaultKernel)
+	s.handle("GET", "/pools", s.listPools)
+	s.handle("GET", "/stats", s.getStats)
+	s.handle("GET", "/events", s.getEvents)
+	// Metrics are scraped at the path Prometheus expects, outside the
+	// versioned API.
+	s.mux.Handle("GET /metrics", metrics.Default.Handler())
+
+	return s
+}
+
+// Listen creates the Unix socket the server is reached on. Only the owner
+// may connect to it.
+func Listen(socketPath string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+	}
+
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+
+	return listener, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// handle registers a versioned route. Handlers return an error to have it
+// written as an ErrorResponse.
+func (s *Server) handle(method, path string, handler func(w http.ResponseWriter, r *http.Request) error) {
+	s.mux.HandleFunc(method+" /"+Version+path, func(w http.ResponseWriter, r *http.Request) {
+		if err := handler(w, r); err != nil {
+			writeError(w, err)
+		}
+	})
+}
+
+func (s *Server) getVersion(w http.ResponseWriter, r *http.Request) error {
+	return writeJSON(w, http.StatusOK, VersionResponse{APIVersion: Version})
+}
+
+func (s *Server) runVM(w http.ResponseWriter, r *http.Request) error {
+	spec, err := decodeSpec(r)
+	if err != nil {
+		return err
+	}
+
+	vmID, err := s.manager.RunVM(spec)
+	if err != nil {
+		return err
+	}
+
+	resp := RunVMResponse{ID: vmID}
+	if vm, err := s.manager.GetVM(vmID); err == nil {
+		resp.VM = vm
+	}
+	return writeJSON(w, http.StatusCreated, resp)
+}
+
+func (s *Server) applyVM(w http.ResponseWriter, r *http.Request) error {
+	spec, err := decodeSpec(r)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.manager.ApplyVM(spec)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, result)
+}
+
+// decodeSpec reads a VM spec body. Invalid specs are client errors.
+func decodeSpec(r *http.Request) (manager.VMSpec, error) {
+	spec := manager.DefaultVMSpec()
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		return spec, &httpError{status: http.StatusBadRequest, err: fmt.Errorf("invalid request body: %w", err)}
+	}
+	if err := spec.Validate(); err != nil {
+		return spec, &httpError{status: http.StatusBadRequest, err: err}
+	}
+	return spec, nil
+}
+
+func (s *Server) listVMs(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+
+	filter, err := manager.ParseVMFilter(query["filter"], time.Now())
+	if err != nil {
+		return &httpError{status: http.StatusBadRequest, err: err}
+	}
+
+	opts := manager.ListOptions{All: query.Get("all") == "true", Filter: filter}
+	vms, err := s.manager.ListVMs(opts)
+	if err != nil {
+		return err
+	}
+	if vms == nil {
+		vms = []state.VM{}
+	}
+
+	return writeJSON(w, http.StatusOK, vms)
+}
+
+func (s *Server) pruneVMs(w http.ResponseWriter, r *http.Request) error {
+	filter, err := manager.ParseVMFilter(r.URL.Query()["filter"], time.Now())
+	if err != nil {
+		return &httpError{status: http.StatusBadRequest, err: err}
+	}
+
+	removed, err := s.manager.PruneVMs(filter)
+	if err != nil {
+		return err
+	}
+	if removed == nil {
+		removed = []string{}
+	}
+
+	return writeJSON(w, http.StatusOK, PruneResponse{Removed: removed})
+}
+
+func (s *Server) getVM(w http.ResponseWriter, r *http.Request) error {
+	vm, err := s.manager.GetVM(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, vm)
+}
+
+func (s *Server) inspectVM(w http.ResponseWriter, r *http.Request) error {
+	inspect, err := s.manager.InspectVM(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, inspect)
+}
+
+func (s *Server) getMetadata(w http.ResponseWriter, r *http.Request) error {
+	md, err := s.manager.GetMetadata(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, md)
+}
+
+func (s *Server) setMetadata(w http.ResponseWriter, r *http.Request) error {
+	var md manager.Metadata
+	if err := json.NewDecoder(r.Body).Decode(&md); err != nil {
+		return &httpError{status: http.StatusBadRequest, err: fmt.Errorf("invalid request body: %w", err)}
+	}
+
+	if err := s.manager.SetMetadata(r.PathValue("id"), md); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) statPath(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	stat, err := s.manager.StatPath(r.PathValue("id"), query.Get("path"), query.Get("followLink") == "true")
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, PathStatResponse{Stat: stat})
+}
+
+// getArchive streams a tar archive of a guest path. Errors after the
+// archive started show as a truncated archive.
+func (s *Server) getArchive(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	guestPath := query.Get("path")
+	if guestPath == "" {
+		return &httpError{status: http.StatusBadRequest, err: fmt.Errorf("path is required")}
+	}
+	rebase := query.Get("rebase")
+	if rebase == "" {
+		rebase = path.Base(guestPath)
+	}
+
+	tr, err := s.manager.ArchivePath(r.PathValue("id"), guestPath, rebase, query.Get("followLink") == "true")
+	if err != nil {
+		return err
+	}
+	defer tr.Close()
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, tr)
+	return nil
+}
+
+func (s *Server) putArchive(w http.ResponseWriter, r *http.Request) error {
+	guestDir := r.URL.Query().Get("path")
+	if guestDir == "" {
+		return &httpError{status: http.StatusBadRequest, err: fmt.Errorf("path is required")}
+	}
+
+	if err := s.manager.ExtractArchive(r.PathValue("id"), guestDir, r.Body); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// getLogs streams the console log of a VM and, with follow=true, keeps
+// streaming its output until the VM exits.
+func (s *Server) getLogs(w http.ResponseWriter, r *http.Request) error {
+	follow := r.URL.Query().Get("follow") == "true"
+	logs, err := s.manager.Logs(r.Context(), r.PathValue("id"), follow)
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(flushWriter{w}, logs)
+	return nil
+}
+
+// waitVM answers once the VM has exited, with its exit code, or with
+// condition=healthy, as soon as it is healthy.
+func (s *Server) waitVM(w http.ResponseWriter, r *http.Request) error {
+	switch condition := r.URL.Query().Get("condition"); condition {
+	case "", "exited":
+	case "healthy":
+		if err := s.manager.WaitHealthy(r.Context(), r.PathValue("id")); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	default:
+		return &httpError{status: http.StatusBadRequest, err: fmt.Errorf("unknown wait condition %q (use exited or healthy)", condition)}
+	}
+
+	result, err := s.manager.WaitVM(r.Context(), r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, result)
+}
+
+// attachVM upgrades the connection to a raw stream: console output is sent
+// to the client and, for a read-write session, its input is typed into the
+// console. The stream ends when either side closes it or the VM exits.
+func (s *Server) attachVM(w http.ResponseWriter, r *http.Request) error {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return fmt.Errorf("connection cannot be upgraded")
+	}
+	if !strings.EqualFold(r.Header.Get("Upgrade"), attachProtocol) {
+		return &httpError{status: http.StatusBadRequest, err: fmt.Errorf("attach requires Upgrade: %s", attachProtocol)}
+	}
+
+	// Output only starts once the upgrade response was written.
+	ready := make(chan struct{})
+	var conn net.Conn
+	session, err := s.manager.AttachVM(r.PathValue("id"), writerFunc(func(p []byte) (int, error) {
+		<-ready
+		if conn == nil {
+			return 0, net.ErrClosed
+		}
+		return conn.Write(p)
+	}))
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		close(ready)
+		return err
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n%s: %t\r\n\r\n",
+		attachProtocol, readOnlyHeader, session.ReadOnly())
+	close(ready)
+
+	go func() {
+		// Input of read-only sessions is dropped, but still read to
+		// notice when the client goes away.
+		if session.ReadOnly() {
+			io.Copy(io.Discard, buffered)
+		} else {
+			io.Copy(session, buffered)
+		}
+		session.Close()
+	}()
+
+	<-session.Done()
+	return nil
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func (s *Server) stopVM(w http.ResponseWriter, r *http.Request) error {
+	if err := s.manager.StopVM(r.PathValue("id")); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) pauseVM(w http.ResponseWriter, r *http.Request) error {
+	if err := s.manager.PauseVM(r.PathValue("id")); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) resumeVM(w http.ResponseWriter, r *http.Request) error {
+	if err := s.manager.ResumeVM(r.PathValue("id")); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) createVolume(w http.ResponseWriter, r *http.Request) error {
+	var req CreateVolumeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &httpError{status: http.StatusBadRequest, err: fmt.Errorf("invalid request body: %w", err)}
+	}
+
+	vol, err := s.manager.CreateVolume(req.Name, req.SizeMB)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, vol)
+}
+
+func (s *Server) listVolumes(w http.ResponseWriter, r *http.Request) error {
+	volumes, err := s.manager.ListVolumes()
+	if err != nil {
+		return err
+	}
+	if volumes == nil {
+		volumes = []volume.Volume{}
+	}
+
+	return writeJSON(w, http.StatusOK, volumes)
+}
+
+func (s *Server) getVolume(w http.ResponseWriter, r *http.Request) error {
+	vol, err := s.manager.GetVolume(r.PathValue("name"))
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, vol)
+}
+
+func (s *Server) removeVolume(w http.ResponseWriter, r *http.Request) error {
+	if err := s.manager.RemoveVolume(r.PathValue("name")); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) createNetwork(w http.ResponseWriter, r *http.Request) error {
+	var req CreateNetworkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &httpError{status: http.StatusBadRequest, err: fmt.Errorf("invalid request body: %w", err)}
+	}
+
+	n, err := s.manager.CreateNetwork(req.Name, req.Labels)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, n)
+}
+
+func (s *Server) listNetworks(w http.ResponseWriter, r *http.Request) error {
+	networks, err := s.manager.ListNetworks()
+	if err != nil {
+		return err
+	}
+	if networks == nil {
+		networks = []network.Network{}
+	}
+
+	return writeJSON(w, http.StatusOK, networks)
+}
+
+func (s *Server) getNetwork(w http.ResponseWriter, r *http.Request) error {
+	n, err := s.manager.GetNetwork(r.PathValue("name"))
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, n)
+}
+
+func (s *Server) removeNetwork(w http.ResponseWriter, r *http.Request) error {
+	if err := s.manager.RemoveNetwork(r.PathValue("name")); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) addKernel(w http.ResponseWriter, r *http.Request) error {
+	var req AddKernelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &httpError{status: http.StatusBadRequest, err: fmt.Errorf("invalid request body: %w", err)}
+	}
+
+	k, err := s.manager.AddKernel(req.Name, req.Path, req.SHA256, req.Default)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, k)
+}
+
+func (s *Server) listKernels(w http.ResponseWriter, r *http.Request) error {
+	kernels, err := s.manager.ListKernels()
+	if err != nil {
+		return err
+	}
+	if kernels == nil {
+		kernels = []kernel.Kernel{}
+	}
+
+	return writeJSON(w, http.StatusOK, kernels)
+}
+
+func (s *Server) getKernel(w http.ResponseWriter, r *http.Request) error {
+	k, err := s.manager.GetKernel(r.PathValue("name"))
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, k)
+}
+
+func (s *Server) removeKernel(w http.ResponseWriter, r *http.Request) error {
+	if err := s.manager.RemoveKernel(r.PathValue("name")); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) setDefaultKernel(w http.ResponseWriter, r *http.Request) error {
+	if err := s.manager.SetDefaultKernel(r.PathValue("name")); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) listPools(w http.ResponseWriter, r *http.Request) error {
+	return writeJSON(w, http.StatusOK, s.manager.PoolStats())
+}
+
+func (s *Server) getStats(w http.ResponseWriter, r *http.Request) error {
+	stats, err := s.manager.GetVMStats(r.URL.Query()["vm"])
+	if err != nil {
+		return err
+	}
+	if stats == nil {
+		stats = []manager.VMStats{}
+	}
+
+	return writeJSON(w, http.StatusOK, stats)
+}
+
+// httpError carries the status code for errors that are not derived from
+// manager errors.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+
+	var he *httpError
+	var notFound *state.NotFoundError
+	var nameInUse *state.NameInUseError
+	var ambiguous *state.AmbiguousError
+	var volumeNotFound *volume.NotFoundError
+	var volumeExists *volume.ExistsError
+	var volumeInUse *volume.InUseError
+	var networkNotFound *network.NotFoundError
+	var networkExists *network.ExistsError
+	var networkInUse *network.InUseError
+	var kernelNotFound *kernel.NotFoundError
+	var kernelExists *kernel.ExistsError
+	var kernelInUse *kernel.InUseError
+	var checksum *kernel.ChecksumError
+	switch {
+	case errors.As(err, &he):
+		status = he.status
+	case errors.As(err, &notFound):
+		status = http.StatusNotFound
+	case errors.As(err, &nameInUse):
+		status = http.StatusConflict
+	case errors.As(err, &ambiguous):
+		status = http.StatusBadRequest
+	case errors.As(err, &volumeNotFound):
+		status = http.StatusNotFound
+	case errors.As(err, &volumeExists), errors.As(err, &volumeInUse):
+		status = http.StatusConflict
+	case errors.As(err, &networkNotFound):
+		status = http.StatusNotFound
+	case errors.As(err, &networkExists), errors.As(err, &networkInUse):
+		status = http.StatusConflict
+	case errors.As(err, &kernelNotFound):
+		status = http.StatusNotFound
+	case errors.As(err, &kernelExists), errors.As(err, &kernelInUse):
+		status = http.StatusConflict
+	case errors.As(err, &checksum):
+		status = http.StatusBadRequest
+	}
+
+	writeJSON(w, status, ErrorResponse{Message: err.Error()})
+}
+
+// flushWriter sends every write to the client right away.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// getEvents writes the recorded events as newline-delimited JSON and, with
+// follow=true, keeps streaming new ones until the client goes away.
+func (s *Server) getEvents(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+
+	filter, err := manager.ParseEventFilter(query["filter"])
+	if err != nil {
+		return &httpError{status: http.StatusBadRequest, err: err}
+	}
+
+	var since, until time.Time
+	if v := query.Get("since"); v != "" {
+		if since, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return &httpError{status: http.StatusBadRequest, err: fmt.Errorf("invalid since: %w", err)}
+		}
+	}
+	if v := query.Get("until"); v != "" {
+		if until, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return &httpError{status: http.StatusBadRequest, err: fmt.Errorf("invalid until: %w", err)}
+		}
+	}
+	follow := query.Get("follow") == "true"
+
+	// Subscribe before reading the history so no event falls in between.
+	var events <-chan manager.Event
+	if follow {
+		var cancel func()
+		events, cancel = s.manager.Events().Subscribe()
+		defer cancel()
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	// A follower only gets recorded events when it asks for them with since.
+	var lastID uint64
+	var history []manager.Event
+	if !follow || !since.IsZero() {
+		history = s.manager.Events().History(since, until, filter)
+	}
+	for _, e := range history {
+		if err := encoder.Encode(e); err != nil {
+			return nil
+		}
+		lastID = e.ID
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	if !follow {
+		return nil
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case e := <-events:
+			if e.ID <= lastID || !filter.Match(e) {
+				continue
+			}
+			if !until.IsZero() && e.Time.After(until) {
+				return nil
+			}
+			if err := encoder.Encode(e); err != nil {
+				return nil
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
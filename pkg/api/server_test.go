@@ -0,0 +1,200 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"micropod/pkg/kernel"
+	"micropod/pkg/manager"
+	"micropod/pkg/network"
+	"micropod/pkg/state"
+	"micropod/pkg/volume"
+)
+
+// newTestServer serves a manager whose stores live in a temporary
+// directory, and returns a client for it and the manager's VM store.
+func newTestServer(t *testing.T) (*Client, *manager.Manager, *state.Store) {
+	dir := t.TempDir()
+	t.Setenv("MICROPOD_CONFIG_DIR", dir)
+	statePath := filepath.Join(dir, "vms.json")
+	if err := os.WriteFile(statePath, []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mgr := manager.NewManager()
+	store, err := state.NewStore(statePath)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+
+	socketPath := filepath.Join(dir, "micropod.sock")
+	listener, err := Listen(socketPath)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := &http.Server{Handler: NewServer(mgr)}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return NewClient(socketPath), mgr, store
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errors.New("boom"), http.StatusInternalServerError},
+		{&httpError{status: http.StatusBadRequest, err: errors.New("bad")}, http.StatusBadRequest},
+		{fmt.Errorf("failed to stop: %w", &state.NotFoundError{ID: "web"}), http.StatusNotFound},
+		{&state.NameInUseError{Name: "web", ID: "abc"}, http.StatusConflict},
+		{&state.AmbiguousError{Ref: "a"}, http.StatusBadRequest},
+		{&volume.NotFoundError{Name: "data"}, http.StatusNotFound},
+		{&volume.InUseError{Name: "data"}, http.StatusConflict},
+		{&network.ExistsError{Name: "backend"}, http.StatusConflict},
+		{&kernel.NotFoundError{Name: "6.1"}, http.StatusNotFound},
+		{&kernel.ChecksumError{}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		writeError(w, tt.err)
+		if w.Code != tt.want {
+			t.Errorf("writeError(%T) status = %d, want %d", tt.err, w.Code, tt.want)
+		}
+		if !strings.Contains(w.Body.String(), `"message"`) {
+			t.Errorf("writeError(%T) body = %s", tt.err, w.Body.String())
+		}
+	}
+}
+
+func TestGetEvents(t *testing.T) {
+	client, mgr, _ := newTestServer(t)
+	bus := mgr.Events()
+
+	start := time.Now()
+	bus.Publish(manager.Event{Type: manager.EventCreate, VMID: "vm1", Time: start})
+	bus.Publish(manager.Event{Type: manager.EventStart, VMID: "vm1", Time: start.Add(time.Second)})
+	bus.Publish(manager.Event{Type: manager.EventCreate, VMID: "vm2", Time: start.Add(2 * time.Second)})
+
+	collect := func(opts EventsOptions) []string {
+		var got []string
+		err := client.Events(opts, func(e manager.Event) error {
+			got = append(got, string(e.Type)+" "+e.VMID)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Events(%+v) error: %v", opts, err)
+		}
+		return got
+	}
+
+	if got := collect(EventsOptions{}); len(got) != 3 {
+		t.Errorf("Events() = %v, want the 3 recorded events", got)
+	}
+	if got := collect(EventsOptions{Since: start.Add(time.Second), Until: start.Add(time.Second)}); strings.Join(got, ",") != "start vm1" {
+		t.Errorf("Events(since, until) = %v, want [start vm1]", got)
+	}
+	if got := collect(EventsOptions{Filters: []string{"type=create"}}); strings.Join(got, ",") != "create vm1,create vm2" {
+		t.Errorf("Events(type=create) = %v", got)
+	}
+	if err := client.Events(EventsOptions{Filters: []string{"color=red"}}, nil); err == nil {
+		t.Error("Events() with an unknown filter key succeeded")
+	}
+
+	t.Run("follow", func(t *testing.T) {
+		until := start.Add(time.Hour)
+		received := make(chan string, 10)
+		done := make(chan error, 1)
+		go func() {
+			opts := EventsOptions{Since: start.Add(2 * time.Second), Until: until, Filters: []string{"vm=vm2"}, Follow: true}
+			done <- client.Events(opts, func(e manager.Event) error {
+				received <- string(e.Type) + " " + e.VMID
+				return nil
+			})
+		}()
+		receive := func() string {
+			select {
+			case e := <-received:
+				return e
+			case <-time.After(time.Second):
+				t.Fatal("Timed out waiting for an event")
+				return ""
+			}
+		}
+
+		// The recorded event arrives once the stream subscribed.
+		if e := receive(); e != "create vm2" {
+			t.Fatalf("first followed event = %s, want create vm2", e)
+		}
+		bus.Publish(manager.Event{Type: manager.EventStart, VMID: "vm1", Time: start.Add(3 * time.Second)})
+		bus.Publish(manager.Event{Type: manager.EventStart, VMID: "vm2", Time: start.Add(3 * time.Second)})
+		if e := receive(); e != "start vm2" {
+			t.Errorf("followed event = %s, want start vm2", e)
+		}
+
+		// An event past until ends the stream.
+		bus.Publish(manager.Event{Type: manager.EventDie, VMID: "vm2", Time: until.Add(time.Second)})
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Events() error: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("stream did not end after until")
+		}
+		if len(received) != 0 {
+			t.Errorf("unexpected event %s", <-received)
+		}
+	})
+}
+
+func TestWaitVM(t *testing.T) {
+	client, _, store := newTestServer(t)
+
+	code := 3
+	if err := store.AddVM(state.VM{ID: "vm-exited", Name: "job", State: "Exited", ExitCode: &code}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := client.WaitVM("job")
+	if err != nil {
+		t.Fatalf("WaitVM() error: %v", err)
+	}
+	if result.ExitCode != 3 {
+		t.Errorf("WaitVM() exit code = %d, want 3", result.ExitCode)
+	}
+
+	if _, err := client.WaitVM("missing"); err == nil {
+		t.Error("WaitVM() of a missing VM succeeded")
+	}
+	if err := client.do("POST", "/vms/job/wait", url.Values{"condition": {"ready"}}, nil, nil); err == nil || !strings.Contains(err.Error(), "unknown wait condition") {
+		t.Errorf("wait with an unknown condition: %v", err)
+	}
+}
+
+func TestAttachVM(t *testing.T) {
+	client, _, store := newTestServer(t)
+	if err := store.AddVM(state.VM{ID: "vm-exited", State: "Exited"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the upgrade the request is refused before the VM is looked up.
+	err := client.do("POST", "/vms/missing/attach", nil, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "Upgrade") {
+		t.Errorf("attach without upgrade: %v", err)
+	}
+	if _, err := client.Attach("missing"); err == nil {
+		t.Error("Attach() to a missing VM succeeded")
+	}
+	if _, err := client.Attach("vm-exited"); err == nil {
+		t.Error("Attach() to an exited VM succeeded")
+	}
+}
@@ -0,0 +1,69 @@
+// Package api implements the versioned HTTP API that micropodd serves on a
+// Unix socket, and a client for it.
+package api
+
+import (
+	"micropod/pkg/archive"
+	"micropod/pkg/state"
+)
+
+// Version is the API version prefixed to every route.
+const Version = "v1"
+
+const (
+	// attachProtocol is what POST /v1/vms/{id}/attach upgrades the
+	// connection to: the raw console stream.
+	attachProtocol = "tcp"
+	// readOnlyHeader tells an attaching client whether its input is
+	// ignored because another session is typing.
+	readOnlyHeader = "Micropod-Read-Only"
+)
+
+// VersionResponse is returned by GET /v1/version.
+type VersionResponse struct {
+	APIVersion string `json:"apiVersion"`
+}
+
+// RunVMResponse is returned by POST /v1/vms. VM is the started VM's record;
+// it is missing if the VM was removed before the response was written.
+type RunVMResponse struct {
+	ID string    `json:"id"`
+	VM *state.VM `json:"vm,omitempty"`
+}
+
+// PruneResponse is returned by POST /v1/vms/prune.
+type PruneResponse struct {
+	Removed []string `json:"removed"`
+}
+
+// CreateVolumeRequest is the body of POST /v1/volumes.
+type CreateVolumeRequest struct {
+	Name   string `json:"name"`
+	SizeMB int    `json:"sizeMB"`
+}
+
+// CreateNetworkRequest is the body of POST /v1/networks.
+type CreateNetworkRequest struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// AddKernelRequest is the body of POST /v1/kernels. Path is a kernel image
+// on the daemon's host; SHA256 is verified when given.
+type AddKernelRequest struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	SHA256  string `json:"sha256,omitempty"`
+	Default bool   `json:"default,omitempty"`
+}
+
+// PathStatResponse is returned by GET /v1/vms/{id}/archive/stat. Stat is
+// nil when the path does not exist.
+type PathStatResponse struct {
+	Stat *archive.PathStat `json:"stat"`
+}
+
+// ErrorResponse is the body of every non-2xx response.
+type ErrorResponse struct {
+	Message string `json:"message"`
+}
@@ -0,0 +1,337 @@
+// Package archive implements the tar streams of micropod cp and the way a
+// copy's source and destination are resolved, following docker cp.
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// PathStat describes a path on either side of a copy.
+type PathStat struct {
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	// LinkTarget is set when the path is a symlink that was not followed.
+	LinkTarget string `json:"linkTarget,omitempty"`
+}
+
+// IsDir reports whether the path is a directory.
+func (s PathStat) IsDir() bool {
+	return s.Mode.IsDir()
+}
+
+// Stat describes path. A final symlink is followed with followLink, or when
+// path ends with a separator or "/.", as docker cp does.
+func Stat(path string, followLink bool) (*PathStat, error) {
+	source, err := resolveSource(path, followLink)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Lstat(source)
+	if err != nil {
+		return nil, err
+	}
+
+	stat := &PathStat{
+		Name:    filepath.Base(filepath.Clean(path)),
+		Size:    info.Size(),
+		Mode:    info.Mode(),
+		ModTime: info.ModTime(),
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if stat.LinkTarget, err = os.Readlink(source); err != nil {
+			return nil, err
+		}
+	}
+	return stat, nil
+}
+
+// Destination resolves a copy of src to dst. It returns the existing
+// directory the archive of src is extracted into and the name the archive's
+// top entry must have, "." when only the contents of src are copied. dstStat
+// is nil when dst does not exist; it must be taken with links followed.
+func Destination(src string, srcStat PathStat, dst string, dstStat *PathStat) (dir, rebase string, err error) {
+	contentsOnly := hasTrailingDot(src)
+	if contentsOnly && !srcStat.IsDir() {
+		return "", "", fmt.Errorf("source %s is not a directory", strings.TrimSuffix(src, "/."))
+	}
+
+	switch {
+	case dstStat != nil && dstStat.IsDir():
+		if contentsOnly {
+			return dst, ".", nil
+		}
+		return dst, srcStat.Name, nil
+	case dstStat != nil:
+		if srcStat.IsDir() {
+			return "", "", fmt.Errorf("cannot copy a directory to file %s", dst)
+		}
+	case hasTrailingSeparator(dst) && !srcStat.IsDir():
+		return "", "", fmt.Errorf("destination directory %s does not exist", dst)
+	}
+
+	clean := filepath.Clean(dst)
+	return filepath.Dir(clean), filepath.Base(clean), nil
+}
+
+// Tar writes an archive of path to w whose top entry is named rebase, or
+// which holds only the contents of path when rebase is ".". Modes,
+// ownership and symlinks are preserved.
+func Tar(w io.Writer, path, rebase string, followLink bool) error {
+	source, err := resolveSource(path, followLink)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	err = filepath.Walk(source, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(source, file)
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(rebase, rel)
+		if name == "." {
+			return nil
+		}
+
+		return writeEntry(tw, file, filepath.ToSlash(name), info)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %w", path, err)
+	}
+
+	return tw.Close()
+}
+
+func writeEntry(tw *tar.Writer, file, name string, info os.FileInfo) error {
+	if info.Mode()&os.ModeSocket != 0 {
+		return nil
+	}
+
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(file); err != nil {
+			return err
+		}
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		hdr.Uid, hdr.Gid = int(st.Uid), int(st.Gid)
+		hdr.Uname, hdr.Gname = "", ""
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// Untar extracts an archive into the existing directory dir. Entries may not
+// escape dir, including through symlinks extracted earlier. Ownership is
+// restored when running as root.
+func Untar(r io.Reader, dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	type dirTimes struct {
+		path    string
+		modTime time.Time
+	}
+	var dirs []dirTimes
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		target, err := entryPath(root, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if err := extractEntry(tr, hdr, root, target); err != nil {
+			return fmt.Errorf("failed to extract %s: %w", hdr.Name, err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, dirTimes{target, hdr.ModTime})
+		}
+	}
+
+	// Extracting into a directory changes its mtime, so restore them last.
+	for _, d := range dirs {
+		os.Chtimes(d.path, d.modTime, d.modTime)
+	}
+
+	return nil
+}
+
+// entryPath returns where an entry is extracted, making sure that neither
+// the name nor symlinks in its parent directories lead outside root.
+func entryPath(root, name string) (string, error) {
+	clean := filepath.Clean("/" + name)
+	if clean == "/" {
+		return root, nil
+	}
+	target := filepath.Join(root, clean)
+
+	parent, err := filepath.EvalSymlinks(filepath.Dir(target))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("invalid archive: parent directory of %s is missing", name)
+		}
+		return "", err
+	}
+	if parent != root && !strings.HasPrefix(parent, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid archive: %s escapes the destination", name)
+	}
+
+	return filepath.Join(parent, filepath.Base(target)), nil
+}
+
+func extractEntry(r io.Reader, hdr *tar.Header, root, target string) error {
+	mode := hdr.FileInfo().Mode()
+
+	// Replace anything but a directory in the way, never writing through
+	// an existing symlink.
+	if existing, err := os.Lstat(target); err == nil && !(existing.IsDir() && hdr.Typeflag == tar.TypeDir) {
+		if existing.IsDir() {
+			return fmt.Errorf("cannot overwrite directory %s", target)
+		}
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+	case tar.TypeReg:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		source, err := entryPath(root, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := os.Link(source, target); err != nil {
+			return err
+		}
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		if os.Geteuid() != 0 && hdr.Typeflag != tar.TypeFifo {
+			return nil
+		}
+		devMode := uint32(mode.Perm())
+		switch hdr.Typeflag {
+		case tar.TypeChar:
+			devMode |= syscall.S_IFCHR
+		case tar.TypeBlock:
+			devMode |= syscall.S_IFBLK
+		case tar.TypeFifo:
+			devMode |= syscall.S_IFIFO
+		}
+		dev := unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+		if err := unix.Mknod(target, devMode, int(dev)); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	if os.Geteuid() == 0 {
+		if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+	}
+	if hdr.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+	// Set the mode explicitly: the umask applies to creation, and chown
+	// clears setuid bits.
+	if err := os.Chmod(target, mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+	return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+}
+
+// resolveSource returns the path that is archived for path: the target of a
+// final symlink when it is followed.
+func resolveSource(path string, followLink bool) (string, error) {
+	if !followLink && !hasTrailingSeparator(path) && !hasTrailingDot(path) {
+		return filepath.Clean(path), nil
+	}
+
+	resolved, err := filepath.EvalSymlinks(filepath.Clean(path))
+	if err != nil {
+		return "", err
+	}
+	return resolved, nil
+}
+
+func hasTrailingSeparator(path string) bool {
+	return strings.HasSuffix(path, "/")
+}
+
+func hasTrailingDot(path string) bool {
+	return path == "." || strings.HasSuffix(path, "/.")
+}
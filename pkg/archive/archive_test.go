@@ -0,0 +1,47 @@
+package archive
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDestination(t *testing.T) {
+	file := PathStat{Name: "app.conf", Mode: 0644}
+	dir := PathStat{Name: "src", Mode: os.ModeDir | 0755}
+
+	tests := []struct {
+		src     string
+		srcStat PathStat
+		dst     string
+		dstStat *PathStat
+		dir     string
+		rebase  string
+		wantErr bool
+	}{
+		// A file goes into an existing directory, replaces an existing
+		// file or is created under the destination name.
+		{src: "/etc/app.conf", srcStat: file, dst: "/tmp", dstStat: &dir, dir: "/tmp", rebase: "app.conf"},
+		{src: "/etc/app.conf", srcStat: file, dst: "/tmp/old.conf", dstStat: &file, dir: "/tmp", rebase: "old.conf"},
+		{src: "/etc/app.conf", srcStat: file, dst: "/tmp/new.conf", dir: "/tmp", rebase: "new.conf"},
+		{src: "/etc/app.conf", srcStat: file, dst: "/tmp/missing/", wantErr: true},
+		// A directory is copied into an existing directory, or created
+		// under the destination name; /. copies only its contents.
+		{src: "/src", srcStat: dir, dst: "/tmp", dstStat: &dir, dir: "/tmp", rebase: "src"},
+		{src: "/src", srcStat: dir, dst: "/tmp/copy", dir: "/tmp", rebase: "copy"},
+		{src: "/src/.", srcStat: dir, dst: "/tmp", dstStat: &dir, dir: "/tmp", rebase: "."},
+		{src: "/src/.", srcStat: dir, dst: "/tmp/copy", dir: "/tmp", rebase: "copy"},
+		{src: "/src", srcStat: dir, dst: "/tmp/old.conf", dstStat: &file, wantErr: true},
+		{src: "/etc/app.conf/.", srcStat: file, dst: "/tmp", dstStat: &dir, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		dir, rebase, err := Destination(tt.src, tt.srcStat, tt.dst, tt.dstStat)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Destination(%q, %q) error = %v, wantErr %v", tt.src, tt.dst, err, tt.wantErr)
+			continue
+		}
+		if dir != tt.dir || rebase != tt.rebase {
+			t.Errorf("Destination(%q, %q) = %q, %q; want %q, %q", tt.src, tt.dst, dir, rebase, tt.dir, tt.rebase)
+		}
+	}
+}
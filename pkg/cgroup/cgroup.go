@@ -0,0 +1,217 @@
+package cgroup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// Root is the mount point of the cgroup v2 unified hierarchy.
+	Root = "/sys/fs/cgroup"
+	// Slice is the parent cgroup of every micropod VM.
+	Slice = "micropod.slice"
+
+	// cpuPeriod is the cpu.max period in microseconds.
+	cpuPeriod = 100000
+	// cpuMinQuota is the smallest cpu.max quota the kernel accepts, in
+	// microseconds.
+	cpuMinQuota = 1000
+)
+
+// controllers are enabled for the children of the micropod slice.
+var controllers = []string{"cpu", "memory", "pids", "io"}
+
+// Limits are the resource controls applied to a Firecracker process. Zero
+// values leave the kernel defaults in place.
+type Limits struct {
+	// CPUs is the CPU bandwidth quota in CPUs, e.g. 1.5.
+	CPUs float64 `json:"cpus,omitempty"`
+	// CPUWeight is the relative CPU weight (1-10000).
+	CPUWeight int `json:"cpuWeight,omitempty"`
+	// MemoryMaxMB is the hard memory limit in MiB.
+	MemoryMaxMB int `json:"memoryMaxMB,omitempty"`
+	// PidsMax is the maximum number of tasks.
+	PidsMax int `json:"pidsMax,omitempty"`
+	// IOWeight is the relative I/O weight (1-10000).
+	IOWeight int `json:"ioWeight,omitempty"`
+}
+
+// Property is a single cgroup interface file and the value written to it.
+type Property struct {
+	Name  string
+	Value string
+}
+
+// Validate checks that the limits are within the ranges accepted by the kernel.
+func (l Limits) Validate() error {
+	if l.CPUs < 0 {
+		return fmt.Errorf("cpu quota must not be negative")
+	}
+	if l.CPUs > 0 && l.cpuQuota() < cpuMinQuota {
+		return fmt.Errorf("cpu quota must be at least %.2f CPUs", float64(cpuMinQuota)/cpuPeriod)
+	}
+	if l.CPUWeight != 0 && (l.CPUWeight < 1 || l.CPUWeight > 10000) {
+		return fmt.Errorf("cpu weight must be between 1 and 10000")
+	}
+	if l.IOWeight != 0 && (l.IOWeight < 1 || l.IOWeight > 10000) {
+		return fmt.Errorf("io weight must be between 1 and 10000")
+	}
+	if l.MemoryMaxMB < 0 {
+		return fmt.Errorf("memory limit must not be negative")
+	}
+	if l.PidsMax < 0 {
+		return fmt.Errorf("pids limit must not be negative")
+	}
+	return nil
+}
+
+// IsZero reports whether no limit is set.
+func (l Limits) IsZero() bool {
+	return l == Limits{}
+}
+
+// Properties returns the cgroup interface files that implement the limits.
+func (l Limits) Properties() []Property {
+	var props []Property
+	if l.CPUs > 0 {
+		props = append(props, Property{"cpu.max", fmt.Sprintf("%d %d", l.cpuQuota(), cpuPeriod)})
+	}
+	if l.CPUWeight > 0 {
+		props = append(props, Property{"cpu.weight", strconv.Itoa(l.CPUWeight)})
+	}
+	if l.MemoryMaxMB > 0 {
+		props = append(props, Property{"memory.max", strconv.Itoa(l.MemoryMaxMB * 1024 * 1024)})
+	}
+	if l.PidsMax > 0 {
+		props = append(props, Property{"pids.max", strconv.Itoa(l.PidsMax)})
+	}
+	if l.IOWeight > 0 {
+		props = append(props, Property{"io.weight", fmt.Sprintf("default %d", l.IOWeight)})
+	}
+	return props
+}
+
+// cpuQuota returns the cpu.max quota of the CPU limit, in microseconds.
+func (l Limits) cpuQuota() int {
+	return int(l.CPUs * cpuPeriod)
+}
+
+// Path returns the cgroup directory of a VM.
+func Path(vmID string) string {
+	return filepath.Join(Root, Slice, vmID)
+}
+
+// Create creates the cgroup of a VM under the micropod slice and applies the
+// limits to it. It returns the path of the new cgroup.
+func Create(vmID string, limits Limits) (string, error) {
+	if err := ensureSlice(); err != nil {
+		return "", err
+	}
+
+	path := Path(vmID)
+	if err := os.Mkdir(path, 0755); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("failed to create cgroup %s: %w", path, err)
+	}
+
+	for _, prop := range limits.Properties() {
+		if err := writeFile(path, prop.Name, prop.Value); err != nil {
+			os.Remove(path)
+			return "", err
+		}
+	}
+
+	return path, nil
+}
+
+// Remove deletes a VM cgroup. Processes leave their cgroup asynchronously
+// after being killed, so a busy cgroup is retried for a short while.
+func Remove(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	var err error
+	for i := 0; i < 10; i++ {
+		err = os.Remove(path)
+		if err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		if !errors.Is(err, syscall.EBUSY) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return fmt.Errorf("failed to remove cgroup %s: %w", path, err)
+}
+
+// ensureSlice creates the micropod slice and delegates the controllers to it.
+func ensureSlice() error {
+	slicePath := filepath.Join(Root, Slice)
+	if err := os.MkdirAll(slicePath, 0755); err != nil {
+		return fmt.Errorf("failed to create cgroup slice: %w", err)
+	}
+
+	for _, dir := range []string{Root, slicePath} {
+		for _, controller := range controllers {
+			if err := writeFile(dir, "cgroup.subtree_control", "+"+controller); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func writeFile(dir, name, value string) error {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to write %s=%q in %s: %w", name, value, dir, err)
+	}
+	return nil
+}
+
+// readKeyValues parses flat keyed files such as cpu.stat and memory.events.
+func readKeyValues(path string) (map[string]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = v
+		}
+	}
+
+	return values, scanner.Err()
+}
+
+// readUint parses single value files such as memory.current. "max" is
+// reported as 0.
+func readUint(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+
+	return strconv.ParseUint(value, 10, 64)
+}
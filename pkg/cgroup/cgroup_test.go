@@ -0,0 +1,99 @@
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLimitsValidate(t *testing.T) {
+	valid := []Limits{
+		{},
+		{CPUs: 0.01},
+		{CPUs: 1.5, CPUWeight: 1, IOWeight: 10000, MemoryMaxMB: 512, PidsMax: 64},
+	}
+	for _, l := range valid {
+		if err := l.Validate(); err != nil {
+			t.Errorf("Validate(%+v) error: %v", l, err)
+		}
+	}
+
+	invalid := []Limits{
+		{CPUs: -1},
+		{CPUs: 0.005},
+		{CPUWeight: 10001},
+		{IOWeight: -1},
+		{MemoryMaxMB: -1},
+		{PidsMax: -1},
+	}
+	for _, l := range invalid {
+		if err := l.Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded", l)
+		}
+	}
+}
+
+func TestLimitsProperties(t *testing.T) {
+	l := Limits{CPUs: 1.5, CPUWeight: 200, MemoryMaxMB: 128, PidsMax: 64, IOWeight: 50}
+	want := []Property{
+		{"cpu.max", "150000 100000"},
+		{"cpu.weight", "200"},
+		{"memory.max", "134217728"},
+		{"pids.max", "64"},
+		{"io.weight", "default 50"},
+	}
+	if got := l.Properties(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Properties() = %v, want %v", got, want)
+	}
+
+	if got := (Limits{}).Properties(); len(got) != 0 {
+		t.Errorf("Properties() of no limits = %v", got)
+	}
+}
+
+func TestReadStats(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"cpu.stat":       "usage_usec 300\nuser_usec 200\nsystem_usec 100\nnr_periods 4\nthrottled_usec 50\n",
+		"memory.events":  "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n",
+		"memory.current": "1048576\n",
+		"memory.max":     "max\n",
+		"pids.current":   "7\n",
+		"pids.max":       "64\n",
+		"io.stat":        "8:0 rbytes=4096 wbytes=512 rios=1 wios=1 dbytes=0 dios=0\n8:16 rbytes=1024 wbytes=0 rios=1 wios=0 dbytes=0 dios=0\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := ReadStats(dir)
+	if err != nil {
+		t.Fatalf("ReadStats() error: %v", err)
+	}
+	want := Stats{
+		CPUUsageUsec:     300,
+		CPUUserUsec:      200,
+		CPUSystemUsec:    100,
+		CPUThrottledUsec: 50,
+		MemoryCurrent:    1048576,
+		OOMKills:         1,
+		PidsCurrent:      7,
+		PidsMax:          64,
+		IOReadBytes:      5120,
+		IOWriteBytes:     512,
+	}
+	if *stats != want {
+		t.Errorf("ReadStats() = %+v, want %+v", *stats, want)
+	}
+
+	// Controllers that are not enabled leave their fields at zero.
+	empty := t.TempDir()
+	if stats, err := ReadStats(empty); err != nil || *stats != (Stats{}) {
+		t.Errorf("ReadStats() of an empty cgroup = %+v, %v", stats, err)
+	}
+	if _, err := ReadStats(filepath.Join(empty, "missing")); err == nil {
+		t.Error("ReadStats() of a missing cgroup succeeded")
+	}
+}
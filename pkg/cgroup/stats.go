@@ -0,0 +1,92 @@
+package cgroup
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Stats is a snapshot of the resource usage accounted to a cgroup.
+type Stats struct {
+	CPUUsageUsec     uint64 `json:"cpuUsageUsec"`
+	CPUUserUsec      uint64 `json:"cpuUserUsec"`
+	CPUSystemUsec    uint64 `json:"cpuSystemUsec"`
+	CPUThrottledUsec uint64 `json:"cpuThrottledUsec"`
+	MemoryCurrent    uint64 `json:"memoryCurrent"`
+	MemoryMax        uint64 `json:"memoryMax"`
+	OOMKills         uint64 `json:"oomKills"`
+	PidsCurrent      uint64 `json:"pidsCurrent"`
+	PidsMax          uint64 `json:"pidsMax"`
+	IOReadBytes      uint64 `json:"ioReadBytes"`
+	IOWriteBytes     uint64 `json:"ioWriteBytes"`
+}
+
+// ReadStats reads the current usage of the cgroup at path.
+func ReadStats(path string) (*Stats, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("cgroup %s not available: %w", path, err)
+	}
+
+	stats := &Stats{}
+
+	if cpu, err := readKeyValues(filepath.Join(path, "cpu.stat")); err == nil {
+		stats.CPUUsageUsec = cpu["usage_usec"]
+		stats.CPUUserUsec = cpu["user_usec"]
+		stats.CPUSystemUsec = cpu["system_usec"]
+		stats.CPUThrottledUsec = cpu["throttled_usec"]
+	}
+
+	if events, err := readKeyValues(filepath.Join(path, "memory.events")); err == nil {
+		stats.OOMKills = events["oom_kill"]
+	}
+
+	stats.MemoryCurrent, _ = readUint(filepath.Join(path, "memory.current"))
+	stats.MemoryMax, _ = readUint(filepath.Join(path, "memory.max"))
+	stats.PidsCurrent, _ = readUint(filepath.Join(path, "pids.current"))
+	stats.PidsMax, _ = readUint(filepath.Join(path, "pids.max"))
+
+	if err := readIOStat(filepath.Join(path, "io.stat"), stats); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read io.stat: %w", err)
+	}
+
+	return stats, nil
+}
+
+// readIOStat sums the per-device byte counters of io.stat, whose lines look
+// like "8:0 rbytes=1 wbytes=2 rios=3 wios=4 dbytes=0 dios=0".
+func readIOStat(path string, stats *Stats) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			switch key {
+			case "rbytes":
+				stats.IOReadBytes += n
+			case "wbytes":
+				stats.IOWriteBytes += n
+			}
+		}
+	}
+
+	return scanner.Err()
+}
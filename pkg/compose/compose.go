@@ -0,0 +1,314 @@
+// Package compose reads compose files: projects of VMs that are started
+// together, in dependency order, on a network of their own.
+package compose
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"micropod/pkg/manager"
+	"micropod/pkg/network"
+	"micropod/pkg/state"
+)
+
+// Conditions a service can wait for before its dependents start.
+const (
+	// ConditionStarted is met once the dependency's VM runs.
+	ConditionStarted = "service_started"
+	// ConditionHealthy is met once the dependency's VM is healthy.
+	ConditionHealthy = "service_healthy"
+	// ConditionCompletedSuccessfully is met once the dependency's VM has
+	// exited with status 0, as a one-shot task such as a migration does.
+	ConditionCompletedSuccessfully = "service_completed_successfully"
+)
+
+// Project is the set of services of a compose file.
+type Project struct {
+	Name string
+	// Services are sorted by name.
+	Services []Service
+}
+
+// Service is a VM of a project.
+type Service struct {
+	Name string
+	// Spec holds the service's VM spec fields as written in the file.
+	Spec manager.VMSpec
+	// DependsOn maps the services this one depends on to the condition
+	// each must meet before it starts.
+	DependsOn map[string]string
+}
+
+// file is the layout of a compose file. Services are decoded into
+// VMSpecs separately so that unknown fields are rejected.
+type file struct {
+	Name     string                            `yaml:"name"`
+	Services map[string]map[string]interface{} `yaml:"services"`
+}
+
+var serviceNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Parse decodes a compose file. Each service holds the fields of a VM spec
+// file, without name, apiVersion and kind, plus depends_on: a list of
+// services, or a map of services to {condition: ...}. The project is called
+// defaultName unless the file has a name.
+func Parse(data []byte, defaultName string) (*Project, error) {
+	var f file
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&f); err != nil {
+		return nil, fmt.Errorf("failed to parse compose file: %w", err)
+	}
+	if len(f.Services) == 0 {
+		return nil, fmt.Errorf("compose file has no services")
+	}
+
+	p := &Project{Name: f.Name}
+	if p.Name == "" {
+		p.Name = defaultName
+	}
+	if err := ValidateProjectName(p.Name); err != nil {
+		return nil, err
+	}
+
+	for name, fields := range f.Services {
+		if !serviceNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid service name %q: only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+		}
+		service, err := parseService(name, fields)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+		p.Services = append(p.Services, service)
+	}
+
+	sort.Slice(p.Services, func(i, j int) bool {
+		return p.Services[i].Name < p.Services[j].Name
+	})
+
+	if _, err := p.Order(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func parseService(name string, fields map[string]interface{}) (Service, error) {
+	service := Service{Name: name}
+
+	if dependsOn, ok := fields["depends_on"]; ok {
+		var err error
+		if service.DependsOn, err = parseDependsOn(dependsOn); err != nil {
+			return service, err
+		}
+		delete(fields, "depends_on")
+	}
+	if _, ok := fields["name"]; ok {
+		return service, fmt.Errorf("name is not allowed: VMs are named after the project and service")
+	}
+
+	// Decode through JSON so that the field names and checks are those of
+	// spec files.
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return service, err
+	}
+	service.Spec = manager.DefaultVMSpec()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&service.Spec); err != nil {
+		return service, err
+	}
+	if err := service.Spec.Validate(); err != nil {
+		return service, err
+	}
+
+	return service, nil
+}
+
+// parseDependsOn accepts the short form, a list of services, which waits
+// for them to start, and the long form, a map of services to a condition.
+func parseDependsOn(value interface{}) (map[string]string, error) {
+	dependsOn := make(map[string]string)
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid depends_on entry %v", item)
+			}
+			dependsOn[name] = ConditionStarted
+		}
+	case map[string]interface{}:
+		for name, options := range v {
+			condition := ConditionStarted
+			if options != nil {
+				fields, ok := options.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("invalid depends_on entry for %s", name)
+				}
+				for key, value := range fields {
+					if key != "condition" {
+						return nil, fmt.Errorf("unknown depends_on option %q for %s", key, name)
+					}
+					if condition, ok = value.(string); !ok {
+						return nil, fmt.Errorf("invalid condition for %s", name)
+					}
+				}
+			}
+			switch condition {
+			case ConditionStarted, ConditionHealthy, ConditionCompletedSuccessfully:
+			default:
+				return nil, fmt.Errorf("unknown condition %q for %s (use %s, %s or %s)", condition, name,
+					ConditionStarted, ConditionHealthy, ConditionCompletedSuccessfully)
+			}
+			dependsOn[name] = condition
+		}
+	default:
+		return nil, fmt.Errorf("depends_on must be a list or a map of services")
+	}
+	return dependsOn, nil
+}
+
+// Order returns the services so that each comes after the services it
+// depends on, and fails on unknown dependencies and cycles. Services that
+// do not depend on each other keep their order by name.
+func (p *Project) Order() ([]Service, error) {
+	byName := make(map[string]Service, len(p.Services))
+	for _, service := range p.Services {
+		byName[service.Name] = service
+	}
+
+	var ordered []Service
+	done := make(map[string]bool)
+	visiting := make(map[string]bool)
+	var visit func(service Service, path []string) error
+	visit = func(service Service, path []string) error {
+		if done[service.Name] {
+			return nil
+		}
+		path = append(path, service.Name)
+		if visiting[service.Name] {
+			return fmt.Errorf("dependency cycle: %s", strings.Join(path, " -> "))
+		}
+		visiting[service.Name] = true
+
+		dependencies := make([]string, 0, len(service.DependsOn))
+		for name := range service.DependsOn {
+			dependencies = append(dependencies, name)
+		}
+		sort.Strings(dependencies)
+		for _, name := range dependencies {
+			dependency, ok := byName[name]
+			if !ok {
+				return fmt.Errorf("service %s depends on undefined service %s", service.Name, name)
+			}
+			if err := visit(dependency, path); err != nil {
+				return err
+			}
+		}
+
+		visiting[service.Name] = false
+		done[service.Name] = true
+		ordered = append(ordered, service)
+		return nil
+	}
+
+	for _, service := range p.Services {
+		if err := visit(service, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// StopOrder returns the project's VMs, given in the order they were
+// created, in the order to stop them: the VMs of services not in the
+// project first, newest first, then those of its services in reverse
+// dependency order, so that services stop before their dependencies even
+// when a dependency was replaced after them.
+func (p *Project) StopOrder(vms []state.VM) ([]state.VM, error) {
+	services, err := p.Order()
+	if err != nil {
+		return nil, err
+	}
+
+	defined := make(map[string]bool, len(services))
+	for _, service := range services {
+		defined[p.VMName(service.Name)] = true
+	}
+	byName := make(map[string]state.VM, len(vms))
+	var ordered []state.VM
+	for i := len(vms) - 1; i >= 0; i-- {
+		if defined[vms[i].Name] {
+			byName[vms[i].Name] = vms[i]
+		} else {
+			ordered = append(ordered, vms[i])
+		}
+	}
+	for i := len(services) - 1; i >= 0; i-- {
+		if vm, ok := byName[p.VMName(services[i].Name)]; ok {
+			ordered = append(ordered, vm)
+		}
+	}
+	return ordered, nil
+}
+
+// ValidateProjectName checks that the network and VMs of a project can be
+// named after it.
+func ValidateProjectName(name string) error {
+	if err := network.ValidateName(name + "-default"); err != nil {
+		return fmt.Errorf("invalid project name %q: only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	return nil
+}
+
+// NetworkName returns the name of the network the project's VMs share.
+func (p *Project) NetworkName() string {
+	return p.Name + "-default"
+}
+
+// VMName returns the name of a service's VM.
+func (p *Project) VMName(service string) string {
+	return p.Name + "-" + service
+}
+
+// VMSpec returns the spec of a service's VM: named after the project and
+// service, labelled with both, and attached to the project network before
+// any other network the service names.
+func (p *Project) VMSpec(service Service) (manager.VMSpec, error) {
+	spec := service.Spec
+	spec.Name = p.VMName(service.Name)
+	if err := state.ValidateName(spec.Name); err != nil {
+		return spec, err
+	}
+
+	labels := make(map[string]string, len(spec.Labels)+2)
+	for key, value := range spec.Labels {
+		labels[key] = value
+	}
+	labels[manager.ProjectLabel] = p.Name
+	labels[manager.ServiceLabel] = service.Name
+	spec.Labels = labels
+
+	spec.Networks = append([]string{p.NetworkName()}, spec.Networks...)
+	return spec, nil
+}
+
+// ProjectName derives a project name from the name of the directory of the
+// compose file: lowercased, with the characters names cannot contain
+// removed.
+func ProjectName(dir string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(dir) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			b.WriteRune(r)
+		}
+	}
+	return strings.TrimLeft(b.String(), "_-")
+}
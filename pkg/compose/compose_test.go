@@ -0,0 +1,147 @@
+package compose
+
+import (
+	"strings"
+	"testing"
+
+	"micropod/pkg/manager"
+	"micropod/pkg/state"
+)
+
+const stack = `
+name: shop
+services:
+  app:
+    image: shop:latest
+    memoryMB: 256
+    labels:
+      tier: web
+    depends_on:
+      db:
+        condition: service_healthy
+      migrate:
+        condition: service_completed_successfully
+      cache: {}
+  migrate:
+    image: shop:latest
+    command: [shop, migrate]
+    depends_on: [db]
+  db:
+    image: postgres:16
+    env:
+      POSTGRES_PASSWORD: secret
+  cache:
+    image: redis:7
+`
+
+func TestParse(t *testing.T) {
+	p, err := Parse([]byte(stack), "ignored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "shop" || len(p.Services) != 4 {
+		t.Fatalf("Parse() = %+v", p)
+	}
+
+	ordered, err := p.Order()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, service := range ordered {
+		names = append(names, service.Name)
+	}
+	if got := strings.Join(names, " "); got != "cache db migrate app" {
+		t.Errorf("Order() = %s, want cache db migrate app", got)
+	}
+
+	app := ordered[3]
+	if app.DependsOn["db"] != ConditionHealthy || app.DependsOn["cache"] != ConditionStarted || app.DependsOn["migrate"] != ConditionCompletedSuccessfully {
+		t.Errorf("app depends_on = %v", app.DependsOn)
+	}
+	if ordered[2].DependsOn["db"] != ConditionStarted {
+		t.Errorf("migrate depends_on = %v", ordered[2].DependsOn)
+	}
+
+	spec, err := p.VMSpec(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Name != "shop-app" || spec.MemoryMB != 256 || spec.VCPUs != 1 {
+		t.Errorf("app spec = %+v", spec)
+	}
+	if spec.Labels[manager.ProjectLabel] != "shop" || spec.Labels[manager.ServiceLabel] != "app" || spec.Labels["tier"] != "web" {
+		t.Errorf("app labels = %v", spec.Labels)
+	}
+	if len(spec.Networks) != 1 || spec.Networks[0] != "shop-default" {
+		t.Errorf("app networks = %v", spec.Networks)
+	}
+	if _, ok := app.Spec.Labels[manager.ProjectLabel]; ok {
+		t.Error("VMSpec modified the service's labels")
+	}
+}
+
+func TestStopOrder(t *testing.T) {
+	p, err := Parse([]byte(stack), "ignored")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// db was replaced after its dependents started; old was removed from
+	// the file.
+	var vms []state.VM
+	for _, name := range []string{"shop-cache", "shop-old", "shop-migrate", "shop-app", "shop-db"} {
+		vms = append(vms, state.VM{Name: name})
+	}
+	ordered, err := p.StopOrder(vms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, vm := range ordered {
+		names = append(names, vm.Name)
+	}
+	if got := strings.Join(names, " "); got != "shop-old shop-app shop-migrate shop-db shop-cache" {
+		t.Errorf("StopOrder() = %s, want shop-old shop-app shop-migrate shop-db shop-cache", got)
+	}
+
+	// Without the file, VMs stop newest first.
+	ordered, _ = (&Project{Name: "shop"}).StopOrder(vms)
+	if ordered[0].Name != "shop-db" || ordered[4].Name != "shop-cache" {
+		t.Errorf("StopOrder() without services = %v", ordered)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, doc := range map[string]string{
+		"no services":       "name: shop\n",
+		"unknown key":       "services:\n  db:\n    image: postgres\nvolumes: {}\n",
+		"unknown field":     "services:\n  db:\n    image: postgres\n    memory: 256\n",
+		"no image":          "services:\n  db:\n    memoryMB: 256\n",
+		"vm name":           "services:\n  db:\n    image: postgres\n    name: pg\n",
+		"unknown service":   "services:\n  app:\n    image: app\n    depends_on: [db]\n",
+		"unknown condition": "services:\n  db:\n    image: postgres\n  app:\n    image: app\n    depends_on:\n      db:\n        condition: service_ready\n",
+		"cycle":             "services:\n  a:\n    image: a\n    depends_on: [b]\n  b:\n    image: b\n    depends_on: [a]\n",
+		"bad service name":  "services:\n  ../db:\n    image: postgres\n",
+	} {
+		if _, err := Parse([]byte(doc), "shop"); err == nil {
+			t.Errorf("Parse(%s) succeeded", name)
+		}
+	}
+
+	if _, err := Parse([]byte("services:\n  db:\n    image: postgres\n"), ""); err == nil {
+		t.Error("Parse() without a project name succeeded")
+	}
+}
+
+func TestProjectName(t *testing.T) {
+	for dir, want := range map[string]string{
+		"shop":       "shop",
+		"My Shop.v2": "myshopv2",
+		"_tmp":       "tmp",
+	} {
+		if got := ProjectName(dir); got != want {
+			t.Errorf("ProjectName(%q) = %q, want %q", dir, got, want)
+		}
+	}
+}
@@ -30,12 +30,16 @@ func getConfigDir() string {
 	return filepath.Join(homeDir, ".config", "micropod")
 }
 
+// GetKernelPath returns the kernel VMs boot when the kernel registry has no
+// default: vmlinux/vmlinux.elf in the config directory. The file may not
+// exist.
 func (c *Config) GetKernelPath() string {
-	kernelPath := filepath.Join(c.ConfigDir, "vmlinux", "vmlinux.elf")
-	if _, err := os.Stat(kernelPath); os.IsNotExist(err) {
-		log.Fatalf("Kernel file not found at %s. Please download a compatible kernel.", kernelPath)
-	}
-	return kernelPath
+	return filepath.Join(c.ConfigDir, "vmlinux", "vmlinux.elf")
+}
+
+// GetKernelsDir returns the directory of the kernel registry.
+func (c *Config) GetKernelsDir() string {
+	return filepath.Join(c.ConfigDir, "kernels")
 }
 
 func (c *Config) GetStateFilePath() string {
@@ -46,6 +50,10 @@ func (c *Config) GetStateFilePath() string {
 	return stateFilePath
 }
 
+func (c *Config) GetEventsFilePath() string {
+	return filepath.Join(c.ConfigDir, "events.jsonl")
+}
+
 func (c *Config) GetRootfsDir() string {
 	rootfsDir := filepath.Join(c.ConfigDir, "rootfs")
 	if _, err := os.Stat(rootfsDir); os.IsNotExist(err) {
@@ -66,6 +74,73 @@ func (c *Config) GetImageDir() string {
 	return imageDir
 }
 
+func (c *Config) GetMetricsDir() string {
+	metricsDir := filepath.Join(c.ConfigDir, "metrics")
+	if _, err := os.Stat(metricsDir); os.IsNotExist(err) {
+		if err := os.MkdirAll(metricsDir, 0755); err != nil {
+			log.Fatalf("Failed to create metrics directory: %v", err)
+		}
+	}
+	return metricsDir
+}
+
+// GetDaemonSocketPath returns the Unix socket micropodd serves its API on.
+func (c *Config) GetDaemonSocketPath() string {
+	if socketPath := os.Getenv("MICROPOD_SOCKET"); socketPath != "" {
+		return socketPath
+	}
+	return filepath.Join(c.ConfigDir, "micropodd.sock")
+}
+
+// GetLogsDir returns the directory holding the console log of every VM.
+func (c *Config) GetLogsDir() string {
+	logsDir := filepath.Join(c.ConfigDir, "logs")
+	if _, err := os.Stat(logsDir); os.IsNotExist(err) {
+		if err := os.MkdirAll(logsDir, 0755); err != nil {
+			log.Fatalf("Failed to create logs directory: %v", err)
+		}
+	}
+	return logsDir
+}
+
+// GetJailerBaseDir returns the directory under which jailer chroots are built.
+func (c *Config) GetJailerBaseDir() string {
+	if jailerDir := os.Getenv("MICROPOD_JAILER_DIR"); jailerDir != "" {
+		return jailerDir
+	}
+	return "/srv/jailer"
+}
+
+func (c *Config) GetVolumesDir() string {
+	return filepath.Join(c.ConfigDir, "volumes")
+}
+
+func (c *Config) GetNetworksDir() string {
+	return filepath.Join(c.ConfigDir, "networks")
+}
+
+// GetGuestInitPath returns the micropod-init binary installed into every
+// rootfs: $MICROPOD_INIT, micropod-init next to the running executable, or
+// bin/micropod-init in the config directory, whichever exists first. It
+// returns an empty string when none does.
+func (c *Config) GetGuestInitPath() string {
+	candidates := []string{os.Getenv("MICROPOD_INIT")}
+	if executable, err := os.Executable(); err == nil {
+		candidates = append(candidates, filepath.Join(filepath.Dir(executable), "micropod-init"))
+	}
+	candidates = append(candidates, filepath.Join(c.ConfigDir, "bin", "micropod-init"))
+
+	for _, path := range candidates {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
 func (c *Config) EnsureConfigDir() error {
 	return os.MkdirAll(c.ConfigDir, 0755)
 }
\ No newline at end of file
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DefaultProfile is applied to VMs that select no profile, if it exists.
+const DefaultProfile = "default"
+
+// File is the optional config.json in the config directory.
+type File struct {
+	// Profiles are named sets of run settings, selected with run --profile.
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+	// Pools keep VMs booted in advance for run to hand out.
+	Pools []Pool `json:"pools,omitempty"`
+}
+
+// Pool keeps Size VMs of an image and profile booted and paused, so that
+// runs with the same settings start without building and booting a VM.
+// VCPUs and MemoryMB default to those of run.
+type Pool struct {
+	Image    string `json:"image"`
+	Profile  string `json:"profile,omitempty"`
+	VCPUs    int    `json:"vcpus,omitempty"`
+	MemoryMB int    `json:"memoryMB,omitempty"`
+	Size     int    `json:"size"`
+}
+
+// Profile overrides micropod's defaults for the VMs using it; run flags
+// override the profile in turn.
+type Profile struct {
+	// Kernel names the registry kernel to boot.
+	Kernel string `json:"kernel,omitempty"`
+	// KernelArgs are merged into the default kernel command line.
+	KernelArgs string `json:"kernelArgs,omitempty"`
+	// Initrd is the path of an initial ramdisk to boot with.
+	Initrd string `json:"initrd,omitempty"`
+}
+
+// GetConfigFilePath returns the path of config.json.
+func (c *Config) GetConfigFilePath() string {
+	return filepath.Join(c.ConfigDir, "config.json")
+}
+
+// Load reads config.json. A missing file is an empty configuration.
+func (c *Config) Load() (*File, error) {
+	data, err := os.ReadFile(c.GetConfigFilePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &File{}, nil
+		}
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var file File
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", c.GetConfigFilePath(), err)
+	}
+	return &file, nil
+}
+
+// GetProfile returns the named profile from config.json. An empty name
+// selects DefaultProfile, and yields an empty profile when that does not
+// exist.
+func (c *Config) GetProfile(name string) (*Profile, error) {
+	file, err := c.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		profile := file.Profiles[DefaultProfile]
+		return &profile, nil
+	}
+
+	profile, ok := file.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %s not found in %s", name, c.GetConfigFilePath())
+	}
+	return &profile, nil
+}
@@ -0,0 +1,214 @@
+// Package console multiplexes the serial console of a VM, connected to a
+// pseudo-terminal owned by the daemon, between its log and attached
+// sessions.
+package console
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	// scrollbackSize is how much recent output a new session is sent
+	// first, so that it sees the current prompt.
+	scrollbackSize = 4096
+	// sessionBuffer is how many chunks of output a session may lag behind
+	// before it is detached.
+	sessionBuffer = 256
+)
+
+// ErrReadOnly is returned when a read-only session sends input.
+var ErrReadOnly = errors.New("console session is read-only")
+
+// Console copies the output of a pseudo-terminal master to a log and to the
+// attached sessions, and passes the input of one of them back.
+type Console struct {
+	master *os.File
+	log    io.WriteCloser
+
+	mu         sync.Mutex
+	sessions   map[*Session]struct{}
+	writer     *Session
+	scrollback []byte
+	closed     bool
+
+	done chan struct{}
+}
+
+// New starts copying the output of master to log. The console owns both.
+func New(master *os.File, log io.WriteCloser) *Console {
+	c := &Console{
+		master:   master,
+		log:      log,
+		sessions: make(map[*Session]struct{}),
+		done:     make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+func (c *Console) run() {
+	defer close(c.done)
+	defer c.log.Close()
+
+	logFailed := false
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := c.master.Read(buf)
+		if n > 0 {
+			chunk := append([]byte(nil), buf[:n]...)
+			if _, err := c.log.Write(chunk); err != nil && !logFailed {
+				fmt.Printf("Warning: failed to write console log: %v\n", err)
+				logFailed = true
+			}
+			c.broadcast(chunk)
+		}
+		// Reads fail with EIO once the last process holding the terminal
+		// is gone, and with ErrClosed after Close.
+		if err != nil {
+			c.mu.Lock()
+			c.closed = true
+			for s := range c.sessions {
+				c.detachLocked(s)
+			}
+			c.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (c *Console) broadcast(chunk []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.scrollback = append(c.scrollback, chunk...)
+	if len(c.scrollback) > scrollbackSize {
+		c.scrollback = append([]byte(nil), c.scrollback[len(c.scrollback)-scrollbackSize:]...)
+	}
+
+	for s := range c.sessions {
+		select {
+		case s.output <- chunk:
+		default:
+			c.detachLocked(s)
+		}
+	}
+}
+
+// Done is closed once the console has copied all output, after the process
+// on the terminal exited or Close was called.
+func (c *Console) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close detaches every session and closes the terminal.
+func (c *Console) Close() error {
+	err := c.master.Close()
+	<-c.done
+	return err
+}
+
+// Attach starts a session that writes the console's recent and future
+// output to out. The session is read-write unless another read-write
+// session is attached, in which case it mirrors the console read-only.
+func (c *Console) Attach(out io.Writer) *Session {
+	s := &Session{
+		console: c,
+		output:  make(chan []byte, sessionBuffer),
+		done:    make(chan struct{}),
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		close(s.output)
+		c.mu.Unlock()
+		go s.deliver(out)
+		return s
+	}
+	if c.writer == nil {
+		c.writer = s
+	} else {
+		s.readOnly = true
+	}
+	if len(c.scrollback) > 0 {
+		s.output <- append([]byte(nil), c.scrollback...)
+	}
+	c.sessions[s] = struct{}{}
+	c.mu.Unlock()
+
+	go s.deliver(out)
+	return s
+}
+
+func (c *Console) detachLocked(s *Session) {
+	if _, ok := c.sessions[s]; !ok {
+		return
+	}
+	delete(c.sessions, s)
+	if c.writer == s {
+		c.writer = nil
+	}
+	close(s.output)
+}
+
+// Session is a client attached to a Console.
+type Session struct {
+	console  *Console
+	output   chan []byte
+	readOnly bool
+	done     chan struct{}
+}
+
+// ReadOnly reports whether the session mirrors the console without being
+// able to type into it.
+func (s *Session) ReadOnly() bool {
+	return s.readOnly
+}
+
+// Write types p into the console.
+func (s *Session) Write(p []byte) (int, error) {
+	if s.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	s.console.mu.Lock()
+	attached := s.console.writer == s
+	s.console.mu.Unlock()
+	if !attached {
+		return 0, io.ErrClosedPipe
+	}
+
+	return s.console.master.Write(p)
+}
+
+// Done is closed once all output for the session was written, after it was
+// closed, fell behind, or the console closed.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close detaches the session. A read-write session frees its place for the
+// next session to attach.
+func (s *Session) Close() error {
+	s.console.mu.Lock()
+	s.console.detachLocked(s)
+	s.console.mu.Unlock()
+	return nil
+}
+
+func (s *Session) deliver(out io.Writer) {
+	defer close(s.done)
+
+	for chunk := range s.output {
+		if _, err := out.Write(chunk); err != nil {
+			s.Close()
+			// Drain until detachLocked closes the channel.
+			for range s.output {
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package console
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDetachReader(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		want         string
+		wantDetached bool
+	}{
+		{name: "plain input", input: "ls -l\r", want: "ls -l\r"},
+		{name: "detach", input: "ls\x10\x11more", want: "ls", wantDetached: true},
+		{name: "lone ctrl-p", input: "a\x10b\x10", want: "a\x10b\x10"},
+		{name: "repeated ctrl-p", input: "\x10\x10\x11", want: "\x10", wantDetached: true},
+	}
+
+	for _, tt := range tests {
+		// One byte per read exercises sequences split across reads.
+		r := NewDetachReader(io.MultiReader(oneByteReaders(tt.input)...))
+		got, err := io.ReadAll(r)
+		if detached := errors.Is(err, ErrDetached); detached != tt.wantDetached || (err != nil && !detached) {
+			t.Errorf("%s: error = %v, want detached %v", tt.name, err, tt.wantDetached)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: read %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func oneByteReaders(s string) []io.Reader {
+	var readers []io.Reader
+	for i := range s {
+		readers = append(readers, strings.NewReader(s[i:i+1]))
+	}
+	return readers
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use, usable as a log.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Close() error {
+	return nil
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConsole(t *testing.T) {
+	master, slave, err := OpenPTY()
+	if err != nil {
+		t.Skipf("no pseudo-terminals available: %v", err)
+	}
+	defer slave.Close()
+	if _, err := MakeRaw(slave); err != nil {
+		t.Fatal(err)
+	}
+
+	var log syncBuffer
+	c := New(master, &log)
+
+	if _, err := slave.Write([]byte("login: ")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the log", func() bool { return log.String() == "login: " })
+
+	var first, second syncBuffer
+	rw := c.Attach(&first)
+	ro := c.Attach(&second)
+	if rw.ReadOnly() || !ro.ReadOnly() {
+		t.Fatalf("ReadOnly() = %v, %v; want false, true", rw.ReadOnly(), ro.ReadOnly())
+	}
+	if _, err := ro.Write([]byte("x")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("read-only Write() error = %v, want ErrReadOnly", err)
+	}
+
+	// Both sessions see the scrollback and the output that follows.
+	if _, err := slave.Write([]byte("root\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "mirrored output", func() bool {
+		return first.String() == "login: root\r\n" && second.String() == "login: root\r\n"
+	})
+
+	if _, err := rw.Write([]byte("id\r")); err != nil {
+		t.Fatal(err)
+	}
+	input := make([]byte, 3)
+	if _, err := io.ReadFull(slave, input); err != nil || string(input) != "id\r" {
+		t.Errorf("guest read %q, %v; want %q", input, err, "id\r")
+	}
+
+	// Once the writer detaches, the next session may type.
+	rw.Close()
+	<-rw.Done()
+	if next := c.Attach(io.Discard); next.ReadOnly() {
+		t.Error("session attached after the writer detached is read-only")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Errorf("Close() error: %v", err)
+	}
+	select {
+	case <-ro.Done():
+	case <-time.After(time.Second):
+		t.Fatal("session not detached when the console closed")
+	}
+}
@@ -0,0 +1,75 @@
+package console
+
+import (
+	"errors"
+	"io"
+)
+
+// DetachKeys is the key sequence that detaches from a console: Ctrl-P
+// Ctrl-Q, as in docker attach.
+var DetachKeys = []byte{0x10, 0x11}
+
+// ErrDetached is returned by a DetachReader once the detach keys were read.
+var ErrDetached = errors.New("detached from console")
+
+// DetachReader passes input through until it reads DetachKeys, which are
+// not passed on. A partial sequence followed by other input is passed on
+// unchanged.
+type DetachReader struct {
+	r        io.Reader
+	matched  int
+	pending  []byte
+	detached bool
+}
+
+// NewDetachReader returns a DetachReader reading from r.
+func NewDetachReader(r io.Reader) *DetachReader {
+	return &DetachReader{r: r}
+}
+
+func (d *DetachReader) Read(p []byte) (int, error) {
+	if len(d.pending) > 0 {
+		n := copy(p, d.pending)
+		d.pending = d.pending[n:]
+		return n, nil
+	}
+	if d.detached {
+		return 0, ErrDetached
+	}
+
+	buf := make([]byte, len(p))
+	n, err := d.r.Read(buf)
+
+	var out []byte
+	for _, b := range buf[:n] {
+		if b == DetachKeys[d.matched] {
+			d.matched++
+			if d.matched == len(DetachKeys) {
+				d.detached = true
+				break
+			}
+			continue
+		}
+		out = append(out, DetachKeys[:d.matched]...)
+		d.matched = 0
+		if b == DetachKeys[0] {
+			d.matched = 1
+			continue
+		}
+		out = append(out, b)
+	}
+	if err != nil && d.matched > 0 && !d.detached {
+		out = append(out, DetachKeys[:d.matched]...)
+		d.matched = 0
+	}
+
+	if d.detached && len(out) == 0 {
+		return 0, ErrDetached
+	}
+	copied := copy(p, out)
+	d.pending = out[copied:]
+	if len(d.pending) > 0 || d.detached {
+		return copied, nil
+	}
+	return copied, err
+}
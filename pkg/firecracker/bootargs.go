@@ -0,0 +1,63 @@
+package firecracker
+
+import "strings"
+
+// repeatableBootArgs are the keys of kernel parameters that may be given
+// several times, each occurrence adding to the earlier ones.
+var repeatableBootArgs = map[string]bool{
+	"console":    true,
+	"hugepagesz": true,
+	"hugepages":  true,
+	"memmap":     true,
+}
+
+// MergeBootArgs merges kernel command lines in order of increasing
+// precedence: a parameter replaces an earlier one with the same key, the
+// part before any '=', and keeps its position. Repeatable parameters, such
+// as console, are added after the earlier ones instead, unless given
+// already. Arguments after "--" are passed to init; they are collected in
+// order at the end.
+func MergeBootArgs(cmdlines ...string) string {
+	var params, initArgs []string
+	index := make(map[string]int)
+
+	for _, cmdline := range cmdlines {
+		fields := strings.Fields(cmdline)
+		for i, field := range fields {
+			if field == "--" {
+				initArgs = append(initArgs, fields[i+1:]...)
+				break
+			}
+
+			key := BootArgKey(field)
+			if repeatableBootArgs[key] {
+				if _, ok := index[field]; !ok {
+					index[field] = len(params)
+					params = append(params, field)
+				}
+				continue
+			}
+			if j, ok := index[key]; ok {
+				params[j] = field
+				continue
+			}
+			index[key] = len(params)
+			params = append(params, field)
+		}
+	}
+
+	if len(initArgs) > 0 {
+		params = append(append(params, "--"), initArgs...)
+	}
+	return strings.Join(params, " ")
+}
+
+// BootArgKey returns the key of a kernel parameter: root for root=/dev/vda.
+// ro and rw share the key rw, since either one replaces the other.
+func BootArgKey(param string) string {
+	if param == "ro" {
+		return "rw"
+	}
+	key, _, _ := strings.Cut(param, "=")
+	return key
+}
@@ -0,0 +1,34 @@
+package firecracker
+
+import "testing"
+
+func TestMergeBootArgs(t *testing.T) {
+	tests := []struct {
+		cmdlines []string
+		want     string
+	}{
+		{[]string{defaultBootArgs}, defaultBootArgs},
+		{
+			[]string{defaultBootArgs, "quiet panic=0", "root=/dev/vdb sysctl.vm.swappiness=10"},
+			"console=ttyS0 reboot=k panic=0 pci=off root=/dev/vdb rw quiet sysctl.vm.swappiness=10",
+		},
+		{
+			[]string{defaultBootArgs, "console=tty0 console=ttyS0 hugepagesz=2M hugepages=64", "hugepagesz=1G hugepages=2"},
+			"console=ttyS0 reboot=k panic=1 pci=off root=/dev/vda rw console=tty0 hugepagesz=2M hugepages=64 hugepagesz=1G hugepages=2",
+		},
+		{
+			[]string{defaultBootArgs, "ro"},
+			"console=ttyS0 reboot=k panic=1 pci=off root=/dev/vda ro",
+		},
+		{
+			[]string{"init=/sbin/init -- single", "quiet -- debug"},
+			"init=/sbin/init quiet -- single debug",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := MergeBootArgs(tt.cmdlines...); got != tt.want {
+			t.Errorf("MergeBootArgs(%q) = %q, want %q", tt.cmdlines, got, tt.want)
+		}
+	}
+}
@@ -12,17 +12,88 @@ import (
 	"os/exec"
 	"syscall"
 	"time"
+
+	"micropod/pkg/cgroup"
 )
 
 type Client struct {
-	socketPath string
-	httpClient *http.Client
-	process    *os.Process
+	socketPath  string
+	httpClient  *http.Client
+	process     *os.Process
+	jailer      *JailerConfig
+	cgroupPath  string
+	metricsPath string
+	metrics     *MetricsReader
+	vsockPath   string
+	// vsockListeners accept the connections the guest opens to host
+	// ports, by port.
+	vsockListeners map[uint32]net.Listener
+
+	// exited is closed once the Firecracker process has been reaped;
+	// exitState is valid after that.
+	exited    chan struct{}
+	exitState *os.ProcessState
+}
+
+// LaunchConfig describes the microVM to launch.
+type LaunchConfig struct {
+	// VMID names the cgroup the Firecracker process is placed in.
+	VMID       string
+	KernelPath string
+	RootfsPath string
+	// RootReadOnly attaches the root device read-only.
+	RootReadOnly bool
+	VCPUs        int
+	MemoryMB     int
+	// Limits are applied to the cgroup of the Firecracker process.
+	Limits cgroup.Limits
+	// MetricsPath is the file Firecracker writes its metrics to. Jailed VMs
+	// use a file inside the chroot instead.
+	MetricsPath string
+	// Drives are attached after the root device, in order, so the guest
+	// sees them as /dev/vdb, /dev/vdc, ...
+	Drives []ExtraDrive
+	// BootArgs are merged into the default kernel command line in order,
+	// as by MergeBootArgs.
+	BootArgs []string
+	// InitrdPath, when set, is loaded as the guest's initial ramdisk.
+	InitrdPath string
+	// MMDSVersion enables MMDS, V1 or V2, on TapDevice and publishes
+	// Metadata to the guest.
+	MMDSVersion string
+	TapDevice   string
+	Metadata    interface{}
+	// NetworkInterfaces are attached after the MMDS interface, if any.
+	NetworkInterfaces []NetworkInterface
+	// VsockPath, when set, attaches a vsock device whose host side is this
+	// Unix socket. Jailed VMs use a socket inside the chroot instead.
+	VsockPath string
+	// VsockListenPorts are the host ports the guest may connect to over
+	// vsock. They are listened on before the guest boots; see
+	// VsockListener.
+	VsockListenPorts []uint32
+	// ConsoleLogPath is the file the guest's serial console is appended
+	// to. The console is discarded when it is empty.
+	ConsoleLogPath string
+	// Console, when set, is the terminal the serial console is connected
+	// to, for both output and input, instead of ConsoleLogPath.
+	Console *os.File
+}
+
+const defaultBootArgs = "console=ttyS0 reboot=k panic=1 pci=off root=/dev/vda rw"
+
+// ExtraDrive is a block device attached in addition to the root device.
+type ExtraDrive struct {
+	// ID names the drive in the Firecracker API and inside a jail.
+	ID       string
+	HostPath string
+	ReadOnly bool
 }
 
 type BootSource struct {
 	KernelImagePath string `json:"kernel_image_path"`
 	BootArgs        string `json:"boot_args"`
+	InitrdPath      string `json:"initrd_path,omitempty"`
 }
 
 type Drive struct {
@@ -55,8 +126,25 @@ func NewClient(socketPath string) *Client {
 	}
 }
 
-func (c *Client) LaunchVM(kernelPath, rootfsPath string, vcpus int, memoryMB int) error {
-	if err := c.startFirecrackerProcess(); err != nil {
+// NewJailedClient returns a client that launches Firecracker through the
+// jailer described by jailer.
+func NewJailedClient(jailer *JailerConfig) *Client {
+	c := NewClient(jailer.SocketPath())
+	c.jailer = jailer
+	return c
+}
+
+func (c *Client) LaunchVM(cfg LaunchConfig) error {
+	kernelPath, initrdPath, rootfsPath, drives := cfg.KernelPath, cfg.InitrdPath, cfg.RootfsPath, cfg.Drives
+	if c.jailer != nil {
+		var err error
+		kernelPath, initrdPath, rootfsPath, drives, err = c.stageJailFiles(kernelPath, initrdPath, rootfsPath, !cfg.RootReadOnly, drives)
+		if err != nil {
+			return fmt.Errorf("failed to prepare jail: %w", err)
+		}
+	}
+
+	if err := c.startFirecrackerProcess(cfg); err != nil {
 		return fmt.Errorf("failed to start firecracker process: %w", err)
 	}
 
@@ -65,17 +153,58 @@ func (c *Client) LaunchVM(kernelPath, rootfsPath string, vcpus int, memoryMB int
 		return fmt.Errorf("failed to wait for socket: %w", err)
 	}
 
-	if err := c.configureBootSource(kernelPath); err != nil {
+	if cfg.MetricsPath != "" || c.jailer != nil {
+		if err := c.configureMetrics(cfg.MetricsPath); err != nil {
+			c.killProcess()
+			return fmt.Errorf("failed to configure metrics: %w", err)
+		}
+	}
+
+	bootArgs := MergeBootArgs(append([]string{defaultBootArgs}, cfg.BootArgs...)...)
+	if cfg.MMDSVersion != "" {
+		if err := c.configureMMDS(cfg.TapDevice, cfg.MMDSVersion); err != nil {
+			c.killProcess()
+			return fmt.Errorf("failed to configure MMDS: %w", err)
+		}
+		if err := c.PutMetadata(cfg.Metadata); err != nil {
+			c.killProcess()
+			return fmt.Errorf("failed to publish metadata: %w", err)
+		}
+		bootArgs = MergeBootArgs(bootArgs, mmdsGuestIPArg)
+	}
+
+	for _, iface := range cfg.NetworkInterfaces {
+		if err := c.configureNetworkInterface(iface); err != nil {
+			c.killProcess()
+			return fmt.Errorf("failed to attach network interface %s: %w", iface.IfaceID, err)
+		}
+	}
+
+	if err := c.configureBootSource(kernelPath, initrdPath, bootArgs); err != nil {
 		c.killProcess()
 		return fmt.Errorf("failed to configure boot source: %w", err)
 	}
 
-	if err := c.configureDrive(rootfsPath); err != nil {
+	if err := c.configureDrive(rootfsPath, cfg.RootReadOnly); err != nil {
 		c.killProcess()
 		return fmt.Errorf("failed to configure drive: %w", err)
 	}
 
-	if err := c.configureMachine(vcpus, memoryMB); err != nil {
+	for _, drive := range drives {
+		if err := c.configureExtraDrive(drive); err != nil {
+			c.killProcess()
+			return fmt.Errorf("failed to configure drive %s: %w", drive.ID, err)
+		}
+	}
+
+	if cfg.VsockPath != "" {
+		if err := c.configureVsock(cfg.VsockPath, cfg.VsockListenPorts); err != nil {
+			c.killProcess()
+			return fmt.Errorf("failed to configure vsock: %w", err)
+		}
+	}
+
+	if err := c.configureMachine(cfg.VCPUs, cfg.MemoryMB); err != nil {
 		c.killProcess()
 		return fmt.Errorf("failed to configure machine: %w", err)
 	}
@@ -88,7 +217,7 @@ func (c *Client) LaunchVM(kernelPath, rootfsPath string, vcpus int, memoryMB int
 	return nil
 }
 
-func (c *Client) startFirecrackerProcess() error {
+func (c *Client) startFirecrackerProcess(cfg LaunchConfig) error {
 	if err := c.checkFirecrackerBinary(); err != nil {
 		return err
 	}
@@ -104,19 +233,88 @@ func (c *Client) startFirecrackerProcess() error {
 		Setpgid: true,
 	}
 
+	if c.jailer != nil {
+		// The jailer creates and populates the cgroup itself.
+		var err error
+		if cmd, err = c.jailerCommand(cfg.Limits); err != nil {
+			return err
+		}
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Setpgid: true,
+		}
+		c.cgroupPath = cgroup.Path(c.jailer.ID)
+	} else if cfg.VMID != "" {
+		cgroupDir, err := c.createCgroup(cfg)
+		if err != nil {
+			return err
+		}
+		if cgroupDir != nil {
+			defer cgroupDir.Close()
+			cmd.SysProcAttr.UseCgroupFD = true
+			cmd.SysProcAttr.CgroupFD = int(cgroupDir.Fd())
+		}
+	}
+
+	// Firecracker writes the serial console to its stdout and reads its
+	// input from stdin.
+	if cfg.Console != nil {
+		cmd.Stdin = cfg.Console
+		cmd.Stdout = cfg.Console
+	} else if cfg.ConsoleLogPath != "" {
+		consoleLog, err := os.OpenFile(cfg.ConsoleLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			cgroup.Remove(c.cgroupPath)
+			return fmt.Errorf("failed to open console log: %w", err)
+		}
+		defer consoleLog.Close()
+		cmd.Stdout = consoleLog
+	}
+
 	if err := cmd.Start(); err != nil {
+		cgroup.Remove(c.cgroupPath)
 		return fmt.Errorf("failed to start firecracker: %w", err)
 	}
 
 	c.process = cmd.Process
+	c.exited = make(chan struct{})
 
 	go func() {
 		cmd.Wait()
+		c.exitState = cmd.ProcessState
+		close(c.exited)
 	}()
 
 	return nil
 }
 
+// createCgroup creates the cgroup the Firecracker process is started in and
+// returns its opened directory. Without limits a missing cgroup is not fatal,
+// so unprivileged users can still run VMs.
+func (c *Client) createCgroup(cfg LaunchConfig) (*os.File, error) {
+	path, err := cgroup.Create(cfg.VMID, cfg.Limits)
+	if err != nil {
+		if cfg.Limits.IsZero() {
+			fmt.Printf("Warning: running without a cgroup: %v\n", err)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		cgroup.Remove(path)
+		return nil, fmt.Errorf("failed to open cgroup: %w", err)
+	}
+
+	c.cgroupPath = path
+	return dir, nil
+}
+
+// GetCgroupPath returns the cgroup the Firecracker process runs in, if any.
+func (c *Client) GetCgroupPath() string {
+	return c.cgroupPath
+}
+
 func (c *Client) checkFirecrackerBinary() error {
 	cmd := exec.Command("firecracker", "--version")
 	if err := cmd.Run(); err != nil {
@@ -145,26 +343,38 @@ func (c *Client) waitForSocket() error {
 	return fmt.Errorf("timeout waiting for socket %s", c.socketPath)
 }
 
-func (c *Client) configureBootSource(kernelPath string) error {
+func (c *Client) configureBootSource(kernelPath, initrdPath, bootArgs string) error {
 	bootSource := BootSource{
 		KernelImagePath: kernelPath,
-		BootArgs:        "console=ttyS0 reboot=k panic=1 pci=off root=/dev/vda rw",
+		BootArgs:        bootArgs,
+		InitrdPath:      initrdPath,
 	}
 
 	return c.makeAPIRequest("PUT", "/boot-source", bootSource)
 }
 
-func (c *Client) configureDrive(rootfsPath string) error {
+func (c *Client) configureDrive(rootfsPath string, readOnly bool) error {
 	drive := Drive{
 		DriveID:      "vda",
 		PathOnHost:   rootfsPath,
-		IsReadOnly:   false,
+		IsReadOnly:   readOnly,
 		IsRootDevice: true,
 	}
 
 	return c.makeAPIRequest("PUT", "/drives/vda", drive)
 }
 
+func (c *Client) configureExtraDrive(extra ExtraDrive) error {
+	drive := Drive{
+		DriveID:      extra.ID,
+		PathOnHost:   extra.HostPath,
+		IsReadOnly:   extra.ReadOnly,
+		IsRootDevice: false,
+	}
+
+	return c.makeAPIRequest("PUT", "/drives/"+extra.ID, drive)
+}
+
 func (c *Client) configureMachine(vcpus int, memoryMB int) error {
 	machineConfig := MachineConfig{
 		VcpuCount:  vcpus,
@@ -182,18 +392,62 @@ func (c *Client) startInstance() error {
 	return c.makeAPIRequest("PUT", "/actions", action)
 }
 
+type vmState struct {
+	State string `json:"state"`
+}
+
+// Pause pauses the vCPUs of a running microVM.
+func (c *Client) Pause() error {
+	return c.makeAPIRequest("PATCH", "/vm", vmState{State: "Paused"})
+}
+
+// Resume resumes a paused microVM.
+func (c *Client) Resume() error {
+	return c.makeAPIRequest("PATCH", "/vm", vmState{State: "Resumed"})
+}
+
+// SendCtrlAltDel presses Ctrl+Alt+Del on the guest's keyboard, which asks a
+// guest init that handles it to shut down. Firecracker only supports it on
+// x86_64.
+func (c *Client) SendCtrlAltDel() error {
+	return c.makeAPIRequest("PUT", "/actions", Action{ActionType: "SendCtrlAltDel"})
+}
+
+// GetVMConfig returns the full configuration of the microVM as reported by
+// Firecracker's GET /vm/config. It is kept raw so that fields added by newer
+// Firecracker versions are passed through.
+func (c *Client) GetVMConfig() (json.RawMessage, error) {
+	var config json.RawMessage
+	if err := c.doAPIRequest("GET", "/vm/config", nil, &config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
 func (c *Client) makeAPIRequest(method, path string, body interface{}) error {
-	jsonBody, err := json.Marshal(body)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request body: %w", err)
+	return c.doAPIRequest(method, path, body, nil)
+}
+
+// doAPIRequest sends a request to the Firecracker API. A nil body sends no
+// payload; a non-nil out receives the decoded JSON response.
+func (c *Client) doAPIRequest(method, path string, body interface{}, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		jsonBody, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		reqBody = bytes.NewBuffer(jsonBody)
 	}
 
-	req, err := http.NewRequest(method, "http://localhost"+path, bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequest(method, "http://localhost"+path, reqBody)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -206,6 +460,12 @@ func (c *Client) makeAPIRequest(method, path string, body interface{}) error {
 		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode API response: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -218,10 +478,15 @@ func (c *Client) GetPID() int {
 
 func (c *Client) Stop() error {
 	if c.process != nil {
-		if err := c.process.Kill(); err != nil {
-			return fmt.Errorf("failed to kill process: %w", err)
+		select {
+		case <-c.exited:
+			// The guest already shut down.
+		default:
+			if err := c.process.Kill(); err != nil {
+				return fmt.Errorf("failed to kill process: %w", err)
+			}
+			<-c.exited
 		}
-		c.process.Wait()
 	}
 
 	if err := c.removeSocketFile(); err != nil {
@@ -231,10 +496,37 @@ func (c *Client) Stop() error {
 	return nil
 }
 
+// killProcess kills a process whose launch failed, stops reading its
+// metrics and closes its vsock listeners.
 func (c *Client) killProcess() {
 	if c.process != nil {
 		c.process.Kill()
-		c.process.Wait()
+		<-c.exited
+	}
+	if c.metrics != nil {
+		c.metrics.Close()
+		c.metrics = nil
+	}
+	for port, listener := range c.vsockListeners {
+		listener.Close()
+		delete(c.vsockListeners, port)
+	}
+}
+
+// Exited returns a channel that is closed when the Firecracker process
+// started by this client exits.
+func (c *Client) Exited() <-chan struct{} {
+	return c.exited
+}
+
+// ExitState returns how the Firecracker process exited. It is nil until the
+// channel returned by Exited is closed.
+func (c *Client) ExitState() *os.ProcessState {
+	select {
+	case <-c.exited:
+		return c.exitState
+	default:
+		return nil
 	}
 }
 
@@ -0,0 +1,222 @@
+package firecracker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"micropod/pkg/cgroup"
+)
+
+// jailerSocketPath is the API socket path as seen from inside the chroot.
+const jailerSocketPath = "/run/firecracker.socket"
+
+// JailerConfig describes how to launch Firecracker through the jailer.
+type JailerConfig struct {
+	// ID identifies the jail. It must be unique per VM.
+	ID string
+	// UID and GID the Firecracker process drops to.
+	UID int
+	GID int
+	// ChrootBaseDir is the directory under which the jailer builds the chroot.
+	ChrootBaseDir string
+	// NetNS is the path of the network namespace to join, e.g. /var/run/netns/foo.
+	NetNS string
+}
+
+// ChrootDir returns the root directory of the jail on the host.
+func (j *JailerConfig) ChrootDir() string {
+	return filepath.Join(j.ChrootBaseDir, "firecracker", j.ID, "root")
+}
+
+// JailDir returns the per-VM jail directory, the parent of ChrootDir.
+func (j *JailerConfig) JailDir() string {
+	return filepath.Dir(j.ChrootDir())
+}
+
+// SocketPath returns the host path of the API socket inside the chroot.
+func (j *JailerConfig) SocketPath() string {
+	return filepath.Join(j.ChrootDir(), jailerSocketPath)
+}
+
+func (c *Client) jailerCommand(limits cgroup.Limits) (*exec.Cmd, error) {
+	if os.Geteuid() != 0 {
+		return nil, fmt.Errorf("jailer mode requires micropod to run as root")
+	}
+
+	jailerPath, err := exec.LookPath("jailer")
+	if err != nil {
+		return nil, fmt.Errorf("jailer binary not available in PATH: %w", err)
+	}
+
+	// The jailer requires an absolute path to the firecracker binary and
+	// names the chroot after its base name.
+	firecrackerPath, err := exec.LookPath("firecracker")
+	if err != nil {
+		return nil, fmt.Errorf("firecracker binary not available in PATH: %w", err)
+	}
+	firecrackerPath, err = filepath.Abs(firecrackerPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve firecracker path: %w", err)
+	}
+
+	j := c.jailer
+	args := []string{
+		"--id", j.ID,
+		"--exec-file", firecrackerPath,
+		"--uid", fmt.Sprint(j.UID),
+		"--gid", fmt.Sprint(j.GID),
+		"--chroot-base-dir", j.ChrootBaseDir,
+		"--cgroup-version", "2",
+		"--parent-cgroup", cgroup.Slice,
+	}
+
+	props := limits.Properties()
+	if len(props) == 0 {
+		// The jailer only creates the per-VM cgroup when at least one
+		// property is given; 100 is the kernel default weight.
+		props = []cgroup.Property{{Name: "cpu.weight", Value: "100"}}
+	}
+	for _, prop := range props {
+		args = append(args, "--cgroup", prop.Name+"="+prop.Value)
+	}
+	if j.NetNS != "" {
+		args = append(args, "--netns", j.NetNS)
+	}
+	args = append(args, "--", "--api-sock", jailerSocketPath)
+
+	return exec.Command(jailerPath, args...), nil
+}
+
+// stageJailFiles makes the kernel, initrd, rootfs and extra drives available
+// inside the chroot and returns their paths as seen by the jailed Firecracker
+// process.
+func (c *Client) stageJailFiles(kernelPath, initrdPath, rootfsPath string, rootfsWritable bool, drives []ExtraDrive) (string, string, string, []ExtraDrive, error) {
+	chrootDir := c.jailer.ChrootDir()
+	if err := os.MkdirAll(chrootDir, 0755); err != nil {
+		return "", "", "", nil, fmt.Errorf("failed to create chroot directory: %w", err)
+	}
+
+	// Firecracker creates its API socket here after dropping privileges.
+	runDir := filepath.Join(chrootDir, filepath.Dir(jailerSocketPath))
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		return "", "", "", nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if err := os.Chown(runDir, c.jailer.UID, c.jailer.GID); err != nil {
+		return "", "", "", nil, fmt.Errorf("failed to chown socket directory: %w", err)
+	}
+
+	jailedKernel, err := c.stageJailFile(kernelPath, "vmlinux", false)
+	if err != nil {
+		return "", "", "", nil, fmt.Errorf("failed to stage kernel: %w", err)
+	}
+
+	var jailedInitrd string
+	if initrdPath != "" {
+		jailedInitrd, err = c.stageJailFile(initrdPath, "initrd", false)
+		if err != nil {
+			return "", "", "", nil, fmt.Errorf("failed to stage initrd: %w", err)
+		}
+	}
+
+	jailedRootfs, err := c.stageJailFile(rootfsPath, "rootfs.ext4", rootfsWritable)
+	if err != nil {
+		return "", "", "", nil, fmt.Errorf("failed to stage rootfs: %w", err)
+	}
+
+	jailedDrives := make([]ExtraDrive, len(drives))
+	for i, drive := range drives {
+		jailedPath, err := c.stageJailFile(drive.HostPath, "drive-"+drive.ID, !drive.ReadOnly)
+		if err != nil {
+			return "", "", "", nil, fmt.Errorf("failed to stage drive %s: %w", drive.ID, err)
+		}
+		jailedDrives[i] = ExtraDrive{ID: drive.ID, HostPath: jailedPath, ReadOnly: drive.ReadOnly}
+	}
+
+	return jailedKernel, jailedInitrd, jailedRootfs, jailedDrives, nil
+}
+
+// stageJailFile hard-links hostPath into the chroot, falling back to a bind
+// mount when the chroot lives on a different filesystem. Writable files are
+// handed over to the jail's uid; read-only ones keep their owner since a hard
+// link shares it with the original.
+func (c *Client) stageJailFile(hostPath, name string, writable bool) (string, error) {
+	target := filepath.Join(c.jailer.ChrootDir(), name)
+
+	if err := os.Link(hostPath, target); err != nil {
+		if !errors.Is(err, syscall.EXDEV) {
+			return "", fmt.Errorf("failed to link %s: %w", hostPath, err)
+		}
+
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return "", fmt.Errorf("failed to create bind mount target: %w", err)
+		}
+		f.Close()
+
+		if err := syscall.Mount(hostPath, target, "", syscall.MS_BIND, ""); err != nil {
+			return "", fmt.Errorf("failed to bind mount %s: %w", hostPath, err)
+		}
+	}
+
+	if writable {
+		if err := os.Chown(target, c.jailer.UID, c.jailer.GID); err != nil {
+			return "", fmt.Errorf("failed to chown %s: %w", target, err)
+		}
+	}
+
+	return "/" + name, nil
+}
+
+// RemoveJail unmounts anything bind-mounted into a jail and removes the
+// per-VM jail directory.
+func RemoveJail(chrootDir string) error {
+	if chrootDir == "" {
+		return nil
+	}
+
+	mounts, err := mountsUnder(chrootDir)
+	if err != nil {
+		return err
+	}
+	for _, mountPoint := range mounts {
+		if err := syscall.Unmount(mountPoint, syscall.MNT_DETACH); err != nil {
+			return fmt.Errorf("failed to unmount %s: %w", mountPoint, err)
+		}
+	}
+
+	if err := os.RemoveAll(filepath.Dir(chrootDir)); err != nil {
+		return fmt.Errorf("failed to remove jail directory: %w", err)
+	}
+
+	return nil
+}
+
+func mountsUnder(dir string) ([]string, error) {
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mounts: %w", err)
+	}
+	defer f.Close()
+
+	prefix := filepath.Clean(dir) + string(os.PathSeparator)
+
+	var mounts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if strings.HasPrefix(fields[1], prefix) {
+			mounts = append(mounts, fields[1])
+		}
+	}
+
+	return mounts, scanner.Err()
+}
@@ -0,0 +1,230 @@
+package firecracker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// jailedMetricsFile is the metrics FIFO name inside a jail's chroot.
+	jailedMetricsFile = "metrics.fifo"
+	// metricsFlushTimeout bounds how long Flush waits for the flushed record.
+	metricsFlushTimeout = 500 * time.Millisecond
+)
+
+// Metrics is the subset of Firecracker's periodic metrics micropod reports.
+// Firecracker's counters are reset on every flush, so each value is the
+// change since the previous record.
+type Metrics struct {
+	UTCTimestampMs int64        `json:"utc_timestamp_ms"`
+	Block          BlockMetrics `json:"block"`
+	Net            NetMetrics   `json:"net"`
+	Vcpu           VcpuMetrics  `json:"vcpu"`
+}
+
+// BlockMetrics aggregates all block devices of a VM.
+type BlockMetrics struct {
+	ReadBytes  uint64 `json:"read_bytes"`
+	WriteBytes uint64 `json:"write_bytes"`
+	ReadCount  uint64 `json:"read_count"`
+	WriteCount uint64 `json:"write_count"`
+	FlushCount uint64 `json:"flush_count"`
+}
+
+// NetMetrics aggregates all network devices of a VM.
+type NetMetrics struct {
+	RxBytes   uint64 `json:"rx_bytes_count"`
+	TxBytes   uint64 `json:"tx_bytes_count"`
+	RxPackets uint64 `json:"rx_packets_count"`
+	TxPackets uint64 `json:"tx_packets_count"`
+}
+
+// VcpuMetrics counts vCPU exits to the VMM.
+type VcpuMetrics struct {
+	ExitIOIn      uint64 `json:"exit_io_in"`
+	ExitIOOut     uint64 `json:"exit_io_out"`
+	ExitMMIORead  uint64 `json:"exit_mmio_read"`
+	ExitMMIOWrite uint64 `json:"exit_mmio_write"`
+	Failures      uint64 `json:"failures"`
+}
+
+// Exits returns the total number of vCPU exits.
+func (v VcpuMetrics) Exits() uint64 {
+	return v.ExitIOIn + v.ExitIOOut + v.ExitMMIORead + v.ExitMMIOWrite
+}
+
+// Add adds the counters of other to m, turning per-flush deltas into totals.
+func (m *Metrics) Add(other Metrics) {
+	m.UTCTimestampMs = other.UTCTimestampMs
+
+	m.Block.ReadBytes += other.Block.ReadBytes
+	m.Block.WriteBytes += other.Block.WriteBytes
+	m.Block.ReadCount += other.Block.ReadCount
+	m.Block.WriteCount += other.Block.WriteCount
+	m.Block.FlushCount += other.Block.FlushCount
+
+	m.Net.RxBytes += other.Net.RxBytes
+	m.Net.TxBytes += other.Net.TxBytes
+	m.Net.RxPackets += other.Net.RxPackets
+	m.Net.TxPackets += other.Net.TxPackets
+
+	m.Vcpu.ExitIOIn += other.Vcpu.ExitIOIn
+	m.Vcpu.ExitIOOut += other.Vcpu.ExitIOOut
+	m.Vcpu.ExitMMIORead += other.Vcpu.ExitMMIORead
+	m.Vcpu.ExitMMIOWrite += other.Vcpu.ExitMMIOWrite
+	m.Vcpu.Failures += other.Vcpu.Failures
+}
+
+type metricsConfig struct {
+	MetricsPath string `json:"metrics_path"`
+}
+
+// configureMetrics points Firecracker at a FIFO read by a MetricsReader, so
+// that records are summed up as they are written instead of piling up in a
+// file. The reader must hold the FIFO open before Firecracker opens it.
+// Jailed VMs keep it inside the chroot.
+func (c *Client) configureMetrics(metricsPath string) error {
+	if c.jailer != nil {
+		metricsPath = filepath.Join(c.jailer.ChrootDir(), jailedMetricsFile)
+	}
+
+	if err := os.Remove(metricsPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale metrics FIFO: %w", err)
+	}
+	if err := syscall.Mkfifo(metricsPath, 0600); err != nil {
+		return fmt.Errorf("failed to create metrics FIFO: %w", err)
+	}
+
+	apiPath := metricsPath
+	if c.jailer != nil {
+		if err := os.Chown(metricsPath, c.jailer.UID, c.jailer.GID); err != nil {
+			return fmt.Errorf("failed to chown metrics FIFO: %w", err)
+		}
+		apiPath = "/" + jailedMetricsFile
+	}
+
+	reader, err := OpenMetrics(metricsPath)
+	if err != nil {
+		return err
+	}
+	if err := c.makeAPIRequest("PUT", "/metrics", metricsConfig{MetricsPath: apiPath}); err != nil {
+		reader.Close()
+		return err
+	}
+
+	c.metricsPath = metricsPath
+	c.metrics = reader
+	return nil
+}
+
+// GetMetricsPath returns the host path of the metrics FIFO, if configured.
+func (c *Client) GetMetricsPath() string {
+	return c.metricsPath
+}
+
+// Metrics returns the reader of the VM's metrics FIFO, or nil when metrics
+// are not configured. The caller owns it once LaunchVM succeeded.
+func (c *Client) Metrics() *MetricsReader {
+	return c.metrics
+}
+
+// FlushMetrics asks Firecracker to write a metrics record immediately.
+func (c *Client) FlushMetrics() error {
+	return c.makeAPIRequest("PUT", "/actions", Action{ActionType: "FlushMetrics"})
+}
+
+// MetricsReader sums up the records Firecracker writes to a metrics FIFO.
+type MetricsReader struct {
+	f    *os.File
+	done chan struct{}
+
+	mu    sync.Mutex
+	total Metrics
+	// added is closed and replaced whenever a record is added.
+	added chan struct{}
+}
+
+// OpenMetrics starts reading the metrics FIFO at path. It also picks up the
+// FIFO of a VM started by an earlier daemon; records written while nobody
+// read the FIFO are lost.
+func OpenMetrics(path string) (*MetricsReader, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat metrics FIFO: %w", err)
+	}
+	if info.Mode()&os.ModeNamedPipe == 0 {
+		return nil, fmt.Errorf("%s is not a FIFO", path)
+	}
+
+	// Opening read-write does not block waiting for a writer, and the FIFO
+	// does not report EOF when Firecracker exits.
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metrics FIFO: %w", err)
+	}
+
+	r := &MetricsReader{f: f, done: make(chan struct{}), added: make(chan struct{})}
+	go r.read()
+	return r, nil
+}
+
+func (r *MetricsReader) read() {
+	defer close(r.done)
+
+	br := bufio.NewReader(r.f)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+
+		// A reader joining mid-record sees its tail first.
+		var record Metrics
+		if err := json.Unmarshal(line, &record); err != nil {
+			continue
+		}
+
+		r.mu.Lock()
+		r.total.Add(record)
+		close(r.added)
+		r.added = make(chan struct{})
+		r.mu.Unlock()
+	}
+}
+
+// Totals returns the sum of the records read so far.
+func (r *MetricsReader) Totals() Metrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.total
+}
+
+// Flush calls flush, which asks Firecracker for a record, and returns the
+// totals once a record was added or after a short timeout.
+func (r *MetricsReader) Flush(flush func() error) Metrics {
+	r.mu.Lock()
+	added := r.added
+	r.mu.Unlock()
+
+	if flush() == nil {
+		select {
+		case <-added:
+		case <-time.After(metricsFlushTimeout):
+		}
+	}
+
+	return r.Totals()
+}
+
+// Close stops reading the FIFO.
+func (r *MetricsReader) Close() error {
+	err := r.f.Close()
+	<-r.done
+	return err
+}
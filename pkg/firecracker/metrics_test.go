@@ -0,0 +1,66 @@
+package firecracker
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestMetricsReader(t *testing.T) {
+	metricsPath := filepath.Join(t.TempDir(), "metrics.fifo")
+	if err := syscall.Mkfifo(metricsPath, 0600); err != nil {
+		t.Fatalf("Failed to create FIFO: %v", err)
+	}
+
+	reader, err := OpenMetrics(metricsPath)
+	if err != nil {
+		t.Fatalf("Failed to open metrics: %v", err)
+	}
+	defer reader.Close()
+
+	writer, err := os.OpenFile(metricsPath, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("Failed to open FIFO for writing: %v", err)
+	}
+	defer writer.Close()
+
+	// Flush returns once the record written by the flush was added.
+	write := func(record string) func() error {
+		return func() error {
+			_, err := writer.WriteString(record)
+			return err
+		}
+	}
+
+	totals := reader.Flush(write(`{"utc_timestamp_ms":1,"net":{"rx_bytes_count":10}}` + "\n"))
+	if totals.Net.RxBytes != 10 {
+		t.Errorf("Expected 10 rx bytes, got %d", totals.Net.RxBytes)
+	}
+
+	// The tail of a record written before the reader joined is skipped.
+	totals = reader.Flush(write(`"net":{"rx_bytes_count":99}}` + "\n" +
+		`{"utc_timestamp_ms":2,"net":{"rx_bytes_count":20},"block":{"read_bytes":4096},"vcpu":{"exit_io_in":1,"exit_mmio_write":2}}` + "\n"))
+	if totals.UTCTimestampMs != 2 {
+		t.Errorf("Expected record 2, got %d", totals.UTCTimestampMs)
+	}
+	if totals.Net.RxBytes != 30 {
+		t.Errorf("Expected 30 rx bytes, got %d", totals.Net.RxBytes)
+	}
+	if totals.Block.ReadBytes != 4096 {
+		t.Errorf("Expected 4096 block read bytes, got %d", totals.Block.ReadBytes)
+	}
+	if totals.Vcpu.Exits() != 3 {
+		t.Errorf("Expected 3 vcpu exits, got %d", totals.Vcpu.Exits())
+	}
+
+	t.Run("not a FIFO", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "metrics.json")
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenMetrics(path); err == nil {
+			t.Error("Expected an error for a regular file")
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package firecracker
+
+import "fmt"
+
+const (
+	// mmdsInterfaceID is the guest network interface MMDS is reachable on.
+	mmdsInterfaceID = "eth0"
+	// mmdsGuestIPArg configures the guest side of the MMDS interface through
+	// kernel IP autoconfiguration, so 169.254.169.254 is reachable on-link
+	// without setup scripts.
+	mmdsGuestIPArg = "ip=169.254.0.2:::255.255.0.0::eth0:off"
+)
+
+// NetworkInterface attaches a host tap device to the guest.
+type NetworkInterface struct {
+	IfaceID     string `json:"iface_id"`
+	HostDevName string `json:"host_dev_name"`
+	GuestMAC    string `json:"guest_mac,omitempty"`
+}
+
+// MMDSConfig enables the MicroVM Metadata Service on network interfaces.
+type MMDSConfig struct {
+	Version           string   `json:"version,omitempty"`
+	NetworkInterfaces []string `json:"network_interfaces"`
+}
+
+func (c *Client) configureNetworkInterface(iface NetworkInterface) error {
+	return c.makeAPIRequest("PUT", "/network-interfaces/"+iface.IfaceID, iface)
+}
+
+// ValidateMMDSVersion checks that version is one Firecracker supports. V2
+// requires guests to obtain a session token before reading metadata.
+func ValidateMMDSVersion(version string) error {
+	if version != "V1" && version != "V2" {
+		return fmt.Errorf("invalid MMDS version %q (use V1 or V2)", version)
+	}
+	return nil
+}
+
+// configureMMDS attaches tapDevice as the guest's eth0 and serves MMDS of the
+// given version on it. Firecracker answers requests to 169.254.169.254
+// itself; other traffic goes to the tap device.
+func (c *Client) configureMMDS(tapDevice, version string) error {
+	iface := NetworkInterface{IfaceID: mmdsInterfaceID, HostDevName: tapDevice}
+	if err := c.configureNetworkInterface(iface); err != nil {
+		return fmt.Errorf("failed to attach network interface: %w", err)
+	}
+
+	config := MMDSConfig{Version: version, NetworkInterfaces: []string{mmdsInterfaceID}}
+	return c.makeAPIRequest("PUT", "/mmds/config", config)
+}
+
+// PutMetadata replaces the MMDS data store. data must encode to a JSON
+// object. It can be called before and after the guest boots.
+func (c *Client) PutMetadata(data interface{}) error {
+	return c.makeAPIRequest("PUT", "/mmds", data)
+}
@@ -0,0 +1,129 @@
+package firecracker
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// vsockGuestCID is the context ID of the guest. Every VM has its own
+	// vsock device, so all guests can use the same one.
+	vsockGuestCID = 3
+	// jailedVsockSocket is the vsock Unix socket as seen from inside the
+	// chroot, next to the API socket.
+	jailedVsockSocket = "/run/vsock.sock"
+)
+
+// Vsock is a virtio-vsock device whose host side is a Unix socket.
+type Vsock struct {
+	ID       string `json:"vsock_id,omitempty"`
+	GuestCID uint32 `json:"guest_cid"`
+	UDSPath  string `json:"uds_path"`
+}
+
+// configureVsock attaches a vsock device backed by the Unix socket at
+// udsPath, which Firecracker creates, and listens on listenPorts. Jailed
+// VMs keep the sockets inside the chroot.
+func (c *Client) configureVsock(udsPath string, listenPorts []uint32) error {
+	apiPath := udsPath
+	uid, gid := -1, -1
+	if c.jailer != nil {
+		udsPath = filepath.Join(c.jailer.ChrootDir(), jailedVsockSocket)
+		apiPath = jailedVsockSocket
+		uid, gid = c.jailer.UID, c.jailer.GID
+	}
+	if err := os.Remove(udsPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale vsock socket: %w", err)
+	}
+	c.vsockPath = udsPath
+
+	c.vsockListeners = make(map[uint32]net.Listener)
+	for _, port := range listenPorts {
+		listener, err := ListenVsock(udsPath, port, uid, gid)
+		if err != nil {
+			return err
+		}
+		c.vsockListeners[port] = listener
+	}
+
+	return c.makeAPIRequest("PUT", "/vsock", Vsock{ID: "vsock0", GuestCID: vsockGuestCID, UDSPath: apiPath})
+}
+
+// GetVsockPath returns the host path of the vsock Unix socket, if configured.
+func (c *Client) GetVsockPath() string {
+	return c.vsockPath
+}
+
+// VsockListener returns the listener of a host port given in
+// VsockListenPorts, or nil. The caller owns it once LaunchVM succeeded.
+func (c *Client) VsockListener(port uint32) net.Listener {
+	return c.vsockListeners[port]
+}
+
+// ListenVsock listens for the connections the guest opens to a host port.
+// Firecracker forwards them to the Unix socket udsPath_port, which is
+// owned by uid and gid unless they are -1, for jailed Firecracker
+// processes. A stale socket is replaced.
+func ListenVsock(udsPath string, port uint32, uid, gid int) (net.Listener, error) {
+	path := fmt.Sprintf("%s_%d", udsPath, port)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale vsock socket: %w", err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on vsock port %d: %w", port, err)
+	}
+	if err := os.Chown(path, uid, gid); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to chown vsock socket: %w", err)
+	}
+	return listener, nil
+}
+
+// DialVsock connects to a port the guest listens on. Firecracker forwards
+// connections to the vsock Unix socket once they name the port with a
+// CONNECT handshake.
+func DialVsock(udsPath string, port uint32, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("unix", udsPath, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to vsock socket: %w", err)
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := fmt.Fprintf(conn, "CONNECT %d\n", port); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send vsock handshake: %w", err)
+	}
+
+	// Read the reply byte by byte so no data after it is buffered away.
+	reply, err := readLine(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read vsock handshake: %w", err)
+	}
+	if !strings.HasPrefix(reply, "OK ") {
+		conn.Close()
+		return nil, fmt.Errorf("guest refused connection to vsock port %d: %q", port, reply)
+	}
+	conn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
+
+func readLine(conn net.Conn) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return string(line), nil
+		}
+		line = append(line, b[0])
+	}
+}
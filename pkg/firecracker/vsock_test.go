@@ -0,0 +1,57 @@
+package firecracker
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDialVsock(t *testing.T) {
+	udsPath := filepath.Join(t.TempDir(), "vsock.sock")
+	l, err := net.Listen("unix", udsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Play Firecracker: acknowledge the handshake for port 1024 only, then
+	// echo.
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				if line, _ := r.ReadString('\n'); line != "CONNECT 1024\n" {
+					return
+				}
+				conn.Write([]byte("OK 1073741824\n"))
+				io.Copy(conn, r)
+			}()
+		}
+	}()
+
+	conn, err := DialVsock(udsPath, 1024, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("read %q, %v; want ping", buf, err)
+	}
+
+	if _, err := DialVsock(udsPath, 52, time.Second); err == nil {
+		t.Error("DialVsock succeeded for a port nobody listens on")
+	}
+}
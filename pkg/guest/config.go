@@ -0,0 +1,137 @@
+// Package guest defines the contract between micropod and micropod-init, the
+// init process it installs into every rootfs.
+package guest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// InitPath is where micropod-init is installed in the rootfs.
+	InitPath = "/sbin/micropod-init"
+	// ConfigDevice is the read-only drive carrying the Config, attached
+	// right after the root device.
+	ConfigDevice = "/dev/vdb"
+	// ConsoleDevice is the serial console, which becomes the controlling
+	// terminal of the workload when Config.TTY is set.
+	ConsoleDevice = "/dev/ttyS0"
+	// OverlayDir holds the writable layers of overlays, on the tmpfs
+	// mounted at /run.
+	OverlayDir = "/run/micropod/overlay"
+	// sectorSize is the granularity of virtio block devices; the guest
+	// does not see a trailing partial sector.
+	sectorSize = 512
+)
+
+// Config tells micropod-init how to set up the guest and what to run.
+type Config struct {
+	Hostname   string   `json:"hostname,omitempty"`
+	Command    []string `json:"command"`
+	Env        []string `json:"env,omitempty"`
+	WorkingDir string   `json:"workingDir,omitempty"`
+	Mounts     []Mount  `json:"mounts,omitempty"`
+	// TTY runs the command on the serial console as its controlling
+	// terminal, for interactive sessions.
+	TTY bool `json:"tty,omitempty"`
+	// ReadOnlyRoot is set when the root device is read-only. ScratchDirs
+	// present in the image then get a tmpfs.
+	ReadOnlyRoot bool `json:"readOnlyRoot,omitempty"`
+	// Tmpfs are directories replaced by an empty tmpfs; Overlays are
+	// directories whose image content is overlaid with a writable tmpfs
+	// layer. Both are mounted before the drives and discarded at exit.
+	Tmpfs    []string `json:"tmpfs,omitempty"`
+	Overlays []string `json:"overlays,omitempty"`
+	// Interfaces are configured before the command starts, and Hosts are
+	// added to /etc/hosts so that VMs on a network reach each other by
+	// name.
+	Interfaces []Interface `json:"interfaces,omitempty"`
+	Hosts      []Host      `json:"hosts,omitempty"`
+	// Pooled boots a VM kept ready in a pool: micropod-init serves the
+	// agent once the guest booted and waits for the agent's start request,
+	// which carries the Config of the workload to run.
+	Pooled bool `json:"pooled,omitempty"`
+}
+
+// Interface is a network interface of the guest, found by its MAC address.
+type Interface struct {
+	MAC string `json:"mac"`
+	// Address is the interface's IPv4 address in CIDR notation.
+	Address string `json:"address"`
+}
+
+// Host is an /etc/hosts entry.
+type Host struct {
+	Address string   `json:"address"`
+	Names   []string `json:"names"`
+}
+
+// ScratchDirs are the directories workloads expect to be able to write to.
+var ScratchDirs = []string{"/tmp", "/var/tmp"}
+
+// Mount is a filesystem on an extra drive that micropod-init mounts before
+// starting the command.
+type Mount struct {
+	Device   string `json:"device"`
+	Target   string `json:"target"`
+	FSType   string `json:"fsType"`
+	ReadOnly bool   `json:"readOnly,omitempty"`
+}
+
+// DeviceName returns the guest device of the drive attached at index, where
+// the root device is index 0.
+func DeviceName(index int) string {
+	return fmt.Sprintf("/dev/vd%c", 'a'+index)
+}
+
+// WriteConfig writes c to path as JSON, padded with whitespace to a whole
+// number of sectors.
+func WriteConfig(path string, c Config) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal guest config: %w", err)
+	}
+
+	padded := len(data) + sectorSize - len(data)%sectorSize
+	data = append(data, bytes.Repeat([]byte{' '}, padded-len(data))...)
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write guest config: %w", err)
+	}
+
+	return nil
+}
+
+// ReadConfig decodes a Config written by WriteConfig, ignoring the padding.
+func ReadConfig(r io.Reader) (Config, error) {
+	var c Config
+	if err := json.NewDecoder(r).Decode(&c); err != nil {
+		return c, fmt.Errorf("failed to decode guest config: %w", err)
+	}
+	return c, nil
+}
+
+// InstallInit copies the micropod-init binary into the root filesystem
+// tree at rootDir.
+func InstallInit(rootDir, initBinary string) error {
+	data, err := os.ReadFile(initBinary)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", initBinary, err)
+	}
+
+	target := rootDir + InitPath
+	if err := os.MkdirAll(rootDir+"/sbin", 0755); err != nil {
+		return fmt.Errorf("failed to create /sbin: %w", err)
+	}
+	// Images may ship /sbin/micropod-init as a symlink; never write
+	// through it.
+	os.Remove(target)
+	if err := os.WriteFile(target, data, 0755); err != nil {
+		return fmt.Errorf("failed to install init: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package guest
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConfigRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	want := Config{
+		Hostname: "web",
+		Command:  []string{"nginx", "-g", "daemon off;"},
+		Env:      []string{"PATH=/usr/bin:/bin"},
+		Mounts:   []Mount{{Device: DeviceName(2), Target: "/data", FSType: "ext4"}},
+	}
+
+	if err := WriteConfig(path, want); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size()%sectorSize != 0 {
+		t.Errorf("config size %d is not a multiple of %d", info.Size(), sectorSize)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	got, err := ReadConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadConfig = %+v, want %+v", got, want)
+	}
+	if got.Mounts[0].Device != "/dev/vdc" {
+		t.Errorf("DeviceName(2) = %s, want /dev/vdc", got.Mounts[0].Device)
+	}
+}
+
+func TestWriteHosts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte("127.0.0.1\tlocalhost\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteHosts(path, []Host{{Address: "10.0.0.2", Names: []string{"web", "0123456789ab"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteHosts(path, []Host{{Address: "10.0.0.3", Names: []string{"db"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "127.0.0.1\tlocalhost\n" + hostsBegin + "\n10.0.0.3\tdb\n" + hostsEnd + "\n"
+	if string(data) != want {
+		t.Errorf("hosts file = %q, want %q", data, want)
+	}
+}
@@ -0,0 +1,10 @@
+package guest
+
+import "fmt"
+
+// FormatExitStatus returns the console line micropod-init writes once the
+// workload has exited, for whoever reads the console log. The status
+// itself is reported to the host over vsock; see agent.ReportExit.
+func FormatExitStatus(code int) string {
+	return fmt.Sprintf("micropod-init: exit status %d", code)
+}
@@ -0,0 +1,54 @@
+package guest
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// HostsFile is the guest file that maps the names of VMs on the guest's
+// networks to their addresses.
+const HostsFile = "/etc/hosts"
+
+// Lines enclosing the hosts micropod manages in HostsFile.
+const (
+	hostsBegin = "# BEGIN micropod networks"
+	hostsEnd   = "# END micropod networks"
+)
+
+// WriteHosts replaces the hosts micropod manages in the hosts file at path
+// with hosts, keeping the entries of the image. The file is replaced in
+// place, since /etc/hosts may be a bind mount.
+func WriteHosts(path string, hosts []Host) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var lines []string
+	managed := false
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		switch {
+		case line == hostsBegin:
+			managed = true
+		case line == hostsEnd:
+			managed = false
+		case !managed && (line != "" || len(lines) > 0):
+			lines = append(lines, line)
+		}
+	}
+
+	if len(hosts) > 0 {
+		lines = append(lines, hostsBegin)
+		for _, host := range hosts {
+			lines = append(lines, fmt.Sprintf("%s\t%s", host.Address, strings.Join(host.Names, " ")))
+		}
+		lines = append(lines, hostsEnd)
+	}
+
+	var content string
+	if len(lines) > 0 {
+		content = strings.Join(lines, "\n") + "\n"
+	}
+	return os.WriteFile(path, []byte(content), 0644)
+}
@@ -8,12 +8,21 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/crane"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"micropod/pkg/metrics"
+)
+
+var (
+	pullDuration = metrics.Default.NewHistogram("micropod_image_pull_duration_seconds",
+		"Time taken by image pulls, by whether the image was already stored locally.", metrics.DurationBuckets, "source")
+	pullFailures = metrics.Default.NewCounter("micropod_image_pull_failures_total",
+		"Image pulls that failed.")
 )
 
 // Manager implements ImageService using OCI-native operations.
@@ -34,6 +43,7 @@ type image struct {
 	ref    string
 	digest string
 	layers []string
+	config Config
 }
 
 func (i *image) Ref() string {
@@ -48,29 +58,46 @@ func (i *image) Layers() []string {
 	return i.layers
 }
 
+func (i *image) Config() Config {
+	return i.config
+}
+
 // PullImage pulls an image from a remote registry and stores it locally.
 func (m *Manager) PullImage(ctx context.Context, refString string) (Image, error) {
+	start := time.Now()
+	img, source, err := m.pullImage(ctx, refString)
+	if err != nil {
+		pullFailures.Inc()
+		return nil, err
+	}
+	pullDuration.Since(start, source)
+	return img, nil
+}
+
+// pullImage pulls an image unless it is stored locally, and returns where it
+// came from: "local" or "registry".
+func (m *Manager) pullImage(ctx context.Context, refString string) (Image, string, error) {
 	// Parse the image reference to validate it
 	_, err := name.ParseReference(refString)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse image reference %s: %w", refString, err)
+		return nil, "", fmt.Errorf("failed to parse image reference %s: %w", refString, err)
 	}
 
 	// Check if image already exists locally
 	if img, err := m.GetImage(ctx, refString); err == nil {
-		return img, nil
+		return img, "local", nil
 	}
 
 	// Pull the image
 	img, err := crane.Pull(refString)
 	if err != nil {
-		return nil, fmt.Errorf("failed to pull image %s: %w", refString, err)
+		return nil, "", fmt.Errorf("failed to pull image %s: %w", refString, err)
 	}
 
 	// Get image digest
 	digest, err := img.Digest()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get image digest: %w", err)
+		return nil, "", fmt.Errorf("failed to get image digest: %w", err)
 	}
 
 	// Create or get the OCI layout path
@@ -80,26 +107,32 @@ func (m *Manager) PullImage(ctx context.Context, refString string) (Image, error
 		// If path does not exist, create it
 		p, err = layout.Write(layoutPath, empty.Index)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create layout at path %s: %w", layoutPath, err)
+			return nil, "", fmt.Errorf("failed to create layout at path %s: %w", layoutPath, err)
 		}
 	}
 
 	// Append the pulled image to the layout
 	if err := p.AppendImage(img); err != nil {
-		return nil, fmt.Errorf("failed to append image to layout: %w", err)
+		return nil, "", fmt.Errorf("failed to append image to layout: %w", err)
 	}
 
 	// Get layer information
 	layers, err := m.getImageLayers(img)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get image layers: %w", err)
+		return nil, "", fmt.Errorf("failed to get image layers: %w", err)
+	}
+
+	config, err := imageConfig(img)
+	if err != nil {
+		return nil, "", err
 	}
 
 	return &image{
 		ref:    refString,
 		digest: digest.String(),
 		layers: layers,
-	}, nil
+		config: config,
+	}, "registry", nil
 }
 
 // GetImage retrieves image information from local storage.
@@ -151,10 +184,16 @@ func (m *Manager) GetImage(ctx context.Context, refString string) (Image, error)
 		return nil, fmt.Errorf("failed to get image layers: %w", err)
 	}
 
+	config, err := imageConfig(img)
+	if err != nil {
+		return nil, err
+	}
+
 	return &image{
 		ref:    refString,
 		digest: digest.String(),
 		layers: layers,
+		config: config,
 	}, nil
 }
 
@@ -220,6 +259,33 @@ func (m *Manager) DeleteImage(ctx context.Context, refString string) error {
 	return nil
 }
 
+// imageConfig reads the runtime configuration from the image's config file.
+func imageConfig(img v1.Image) (Config, error) {
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to get image config: %w", err)
+	}
+
+	c := configFile.Config
+	config := Config{
+		Entrypoint: c.Entrypoint,
+		Cmd:        c.Cmd,
+		Env:        c.Env,
+		WorkingDir: c.WorkingDir,
+		User:       c.User,
+	}
+	if h := c.Healthcheck; h != nil && len(h.Test) > 0 {
+		config.Healthcheck = &HealthConfig{
+			Test:        h.Test,
+			Interval:    h.Interval,
+			Timeout:     h.Timeout,
+			StartPeriod: h.StartPeriod,
+			Retries:     h.Retries,
+		}
+	}
+	return config, nil
+}
+
 // getLayoutPath returns the OCI layout path for a given image reference.
 func (m *Manager) getLayoutPath(refString string) string {
 	// Convert image reference to a safe directory name
@@ -2,6 +2,7 @@ package image
 
 import (
 	"context"
+	"time"
 )
 
 // ImageService defines the interface for managing container images.
@@ -29,4 +30,33 @@ type Image interface {
 	Digest() string
 	// Layers returns the digests of all layers in order.
 	Layers() []string
+	// Config returns the runtime configuration of the image.
+	Config() Config
+}
+
+// Config is the subset of an image's runtime configuration that micropod
+// applies to the workload.
+type Config struct {
+	Entrypoint []string `json:"entrypoint,omitempty"`
+	Cmd        []string `json:"cmd,omitempty"`
+	Env        []string `json:"env,omitempty"`
+	WorkingDir string   `json:"workingDir,omitempty"`
+	User       string   `json:"user,omitempty"`
+	// Healthcheck is the image's HEALTHCHECK instruction, if any.
+	Healthcheck *HealthConfig `json:"healthcheck,omitempty"`
+}
+
+// HealthConfig describes how to check that a workload is healthy.
+type HealthConfig struct {
+	// Test is ["NONE"], which disables the check, ["CMD", args...],
+	// ["CMD-SHELL", command] or, as a micropod extension, ["TCP", port].
+	Test []string `json:"test,omitempty"`
+	// Interval is the time between checks and Timeout the time a check
+	// may take. Failures during StartPeriod do not count.
+	Interval    time.Duration `json:"interval,omitempty"`
+	Timeout     time.Duration `json:"timeout,omitempty"`
+	StartPeriod time.Duration `json:"startPeriod,omitempty"`
+	// Retries is the number of consecutive failures that make the workload
+	// unhealthy.
+	Retries int `json:"retries,omitempty"`
 }
\ No newline at end of file
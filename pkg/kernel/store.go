@@ -0,0 +1,317 @@
+// Package kernel manages the registry of guest kernels VMs can boot.
+package kernel
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	metadataFile = "kernel.json"
+	imageFile    = "vmlinux"
+	// defaultFile names the default kernel. Kernel names cannot start with
+	// a dot, so it never clashes with a kernel directory.
+	defaultFile = ".default"
+)
+
+// Kernel is a guest kernel image imported into the registry.
+type Kernel struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	Format    string    `json:"format"`
+	Version   string    `json:"version,omitempty"`
+	SHA256    string    `json:"sha256"`
+	SizeBytes int64     `json:"sizeBytes"`
+	AddedAt   time.Time `json:"addedAt"`
+	// Default and UsedBy are derived, not stored: whether VMs started
+	// without --kernel boot this kernel, and the IDs of the VMs booting it.
+	Default bool     `json:"default,omitempty"`
+	UsedBy  []string `json:"usedBy,omitempty"`
+}
+
+// NotFoundError is returned when no kernel has the requested name.
+type NotFoundError struct {
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("kernel %s not found", e.Name)
+}
+
+// ExistsError is returned when adding a kernel whose name is taken.
+type ExistsError struct {
+	Name string
+}
+
+func (e *ExistsError) Error() string {
+	return fmt.Sprintf("kernel %s already exists", e.Name)
+}
+
+// InUseError is returned when removing a kernel VMs boot.
+type InUseError struct {
+	Name  string
+	VMIDs []string
+}
+
+func (e *InUseError) Error() string {
+	return fmt.Sprintf("kernel %s is in use by VMs %v", e.Name, e.VMIDs)
+}
+
+// ChecksumError is returned when an imported kernel does not match its
+// expected SHA-256.
+type ChecksumError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch: expected sha256 %s, got %s", e.Expected, e.Actual)
+}
+
+var (
+	namePattern   = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+	sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// ValidateName checks that name can be used as a kernel name.
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid kernel name %q: only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	return nil
+}
+
+// Store keeps each kernel in its own directory with the image and its
+// metadata.
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create kernel directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Add imports the kernel image at src as name. The copy is verified against
+// expectedSHA256 or, when that is empty, against a src.sha256 file in
+// sha256sum format next to the image, if there is one.
+func (s *Store) Add(name, src, expectedSHA256 string) (*Kernel, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+
+	if expectedSHA256 == "" {
+		checksum, err := readChecksumFile(src + ".sha256")
+		if err != nil {
+			return nil, err
+		}
+		expectedSHA256 = checksum
+	}
+	expectedSHA256 = strings.ToLower(strings.TrimPrefix(expectedSHA256, "sha256:"))
+	if expectedSHA256 != "" && !sha256Pattern.MatchString(expectedSHA256) {
+		return nil, fmt.Errorf("invalid sha256 %q", expectedSHA256)
+	}
+
+	format, version, err := ReadVersion(src)
+	if err != nil {
+		return nil, err
+	}
+
+	kernelDir := filepath.Join(s.dir, name)
+	if err := os.Mkdir(kernelDir, 0755); err != nil {
+		if os.IsExist(err) {
+			return nil, &ExistsError{Name: name}
+		}
+		return nil, fmt.Errorf("failed to create kernel directory: %w", err)
+	}
+
+	k := &Kernel{
+		Name:    name,
+		Path:    filepath.Join(kernelDir, imageFile),
+		Format:  format,
+		Version: version,
+		AddedAt: time.Now(),
+	}
+
+	k.SHA256, k.SizeBytes, err = copyFile(src, k.Path)
+	if err != nil {
+		os.RemoveAll(kernelDir)
+		return nil, err
+	}
+	if expectedSHA256 != "" && k.SHA256 != expectedSHA256 {
+		os.RemoveAll(kernelDir)
+		return nil, &ChecksumError{Expected: expectedSHA256, Actual: k.SHA256}
+	}
+
+	data, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		os.RemoveAll(kernelDir)
+		return nil, fmt.Errorf("failed to marshal kernel: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(kernelDir, metadataFile), data, 0644); err != nil {
+		os.RemoveAll(kernelDir)
+		return nil, fmt.Errorf("failed to write kernel metadata: %w", err)
+	}
+
+	return s.withDefault(k), nil
+}
+
+// Get returns the kernel called name.
+func (s *Store) Get(name string) (*Kernel, error) {
+	if ValidateName(name) != nil {
+		return nil, &NotFoundError{Name: name}
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, name, metadataFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &NotFoundError{Name: name}
+		}
+		return nil, fmt.Errorf("failed to read kernel metadata: %w", err)
+	}
+
+	var k Kernel
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal kernel metadata: %w", err)
+	}
+
+	return s.withDefault(&k), nil
+}
+
+// List returns all kernels sorted by name.
+func (s *Store) List() ([]Kernel, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kernel directory: %w", err)
+	}
+
+	var kernels []Kernel
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		k, err := s.Get(entry.Name())
+		if err != nil {
+			// Half-imported kernels have no metadata yet.
+			continue
+		}
+		kernels = append(kernels, *k)
+	}
+
+	sort.Slice(kernels, func(i, j int) bool {
+		return kernels[i].Name < kernels[j].Name
+	})
+
+	return kernels, nil
+}
+
+// Remove deletes a kernel and its image. Removing the default kernel leaves
+// no default.
+func (s *Store) Remove(name string) error {
+	k, err := s.Get(name)
+	if err != nil {
+		return err
+	}
+
+	if k.Default {
+		if err := os.Remove(filepath.Join(s.dir, defaultFile)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to clear default kernel: %w", err)
+		}
+	}
+
+	if err := os.RemoveAll(filepath.Join(s.dir, name)); err != nil {
+		return fmt.Errorf("failed to remove kernel: %w", err)
+	}
+
+	return nil
+}
+
+// SetDefault makes name the kernel VMs boot unless they select another.
+func (s *Store) SetDefault(name string) error {
+	if _, err := s.Get(name); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(s.dir, defaultFile), []byte(name+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to set default kernel: %w", err)
+	}
+	return nil
+}
+
+// Default returns the default kernel, or nil if none is set.
+func (s *Store) Default() (*Kernel, error) {
+	name := s.defaultName()
+	if name == "" {
+		return nil, nil
+	}
+	return s.Get(name)
+}
+
+func (s *Store) defaultName() string {
+	data, err := os.ReadFile(filepath.Join(s.dir, defaultFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func (s *Store) withDefault(k *Kernel) *Kernel {
+	k.Default = k.Name == s.defaultName()
+	return k
+}
+
+// copyFile copies src to dst and returns the SHA-256 and size of what was
+// copied.
+func copyFile(src, dst string) (string, int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to open kernel: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create kernel image: %w", err)
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), in)
+	if err != nil {
+		out.Close()
+		return "", 0, fmt.Errorf("failed to copy kernel: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return "", 0, fmt.Errorf("failed to write kernel image: %w", err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// readChecksumFile returns the first field of a sha256sum file, or an empty
+// string if there is no such file.
+func readChecksumFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read checksum file: %w", err)
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("checksum file %s is empty", path)
+	}
+	return fields[0], nil
+}
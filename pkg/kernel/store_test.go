@@ -0,0 +1,72 @@
+package kernel
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := writeBzImage(t, "6.1.102 #1 SMP")
+
+	k, err := store.Add("lts", src, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Version != "6.1.102" || len(k.SHA256) != 64 || k.Default {
+		t.Fatalf("Add(lts) = %+v", k)
+	}
+
+	var exists *ExistsError
+	if _, err := store.Add("lts", src, ""); !errors.As(err, &exists) {
+		t.Errorf("Add(lts) again: got %v, want ExistsError", err)
+	}
+
+	var mismatch *ChecksumError
+	if _, err := store.Add("bad", src, "sha256:"+strings.Repeat("0", 64)); !errors.As(err, &mismatch) {
+		t.Errorf("Add with a wrong checksum: got %v, want ChecksumError", err)
+	}
+	if _, err := store.Get("bad"); err == nil {
+		t.Error("kernel with a wrong checksum was kept")
+	}
+
+	// A sha256sum file next to the image is verified too.
+	os.WriteFile(src+".sha256", []byte(strings.Repeat("0", 64)+"  bzImage\n"), 0644)
+	if _, err := store.Add("sidecar", src, ""); !errors.As(err, &mismatch) {
+		t.Errorf("Add with a wrong checksum file: got %v, want ChecksumError", err)
+	}
+	os.WriteFile(src+".sha256", []byte(k.SHA256+"  bzImage\n"), 0644)
+	if _, err := store.Add("sidecar", src, ""); err != nil {
+		t.Errorf("Add with a matching checksum file: %v", err)
+	}
+
+	if def, err := store.Default(); err != nil || def != nil {
+		t.Fatalf("Default() = %v, %v; want none", def, err)
+	}
+	if err := store.SetDefault("lts"); err != nil {
+		t.Fatal(err)
+	}
+	kernels, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kernels) != 2 || kernels[0].Name != "lts" || !kernels[0].Default || kernels[1].Default {
+		t.Fatalf("List() = %+v, want lts (default) and sidecar", kernels)
+	}
+
+	if err := store.Remove("lts"); err != nil {
+		t.Fatal(err)
+	}
+	if def, err := store.Default(); err != nil || def != nil {
+		t.Errorf("Default() after removing it = %v, %v; want none", def, err)
+	}
+	var notFound *NotFoundError
+	if err := store.SetDefault("lts"); !errors.As(err, &notFound) {
+		t.Errorf("SetDefault(lts) after Remove: got %v, want NotFoundError", err)
+	}
+}
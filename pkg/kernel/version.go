@@ -0,0 +1,162 @@
+package kernel
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	FormatELF     = "elf"
+	FormatBzImage = "bzImage"
+)
+
+const (
+	// linuxNoteBuildSalt is the type of the "Linux" ELF note holding
+	// CONFIG_BUILD_SALT, which distributions set to the kernel release.
+	linuxNoteBuildSalt = 0x100
+
+	// Offsets into the x86 boot protocol header of a bzImage.
+	bzImageMagicOffset   = 0x202
+	bzImageVersionOffset = 0x20e
+	bzImageSetupBase     = 0x200
+)
+
+var (
+	bzImageMagic = []byte("HdrS")
+	linuxBanner  = []byte("Linux version ")
+)
+
+// ReadVersion identifies the kernel image at path as an uncompressed ELF
+// vmlinux or a bzImage and returns its release, e.g. 6.1.102. The release is
+// empty when the image does not record one. Files that are neither format
+// are rejected.
+func ReadVersion(path string) (format, version string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open kernel: %w", err)
+	}
+	defer f.Close()
+
+	if elfFile, err := elf.NewFile(f); err == nil {
+		return FormatELF, elfVersion(elfFile), nil
+	}
+
+	header := make([]byte, bzImageVersionOffset+2)
+	if _, err := f.ReadAt(header, 0); err == nil && bytes.Equal(header[bzImageMagicOffset:bzImageMagicOffset+4], bzImageMagic) {
+		return FormatBzImage, bzImageVersion(f, header), nil
+	}
+
+	return "", "", fmt.Errorf("%s is neither an ELF vmlinux nor a bzImage", path)
+}
+
+// elfVersion reads the release from the build salt note, falling back to
+// the banner printed at boot.
+func elfVersion(f *elf.File) string {
+	for _, section := range f.Sections {
+		if section.Type != elf.SHT_NOTE {
+			continue
+		}
+		data, err := section.Data()
+		if err != nil {
+			continue
+		}
+		if version := noteVersion(data, f.ByteOrder); version != "" {
+			return version
+		}
+	}
+
+	if rodata := f.Section(".rodata"); rodata != nil {
+		if data, err := rodata.Data(); err == nil {
+			return bannerVersion(data)
+		}
+	}
+	return ""
+}
+
+// noteVersion returns the build salt of a "Linux" note in a note section,
+// if it looks like a release.
+func noteVersion(data []byte, order binary.ByteOrder) string {
+	for len(data) >= 12 {
+		nameSize := int(order.Uint32(data[0:4]))
+		descSize := int(order.Uint32(data[4:8]))
+		noteType := order.Uint32(data[8:12])
+		data = data[12:]
+
+		nameEnd := align4(nameSize)
+		descEnd := nameEnd + align4(descSize)
+		if descEnd > len(data) {
+			return ""
+		}
+
+		name := string(bytes.TrimRight(data[:nameSize], "\x00"))
+		desc := bytes.TrimRight(data[nameEnd:nameEnd+descSize], "\x00")
+		if name == "Linux" && noteType == linuxNoteBuildSalt && isRelease(desc) {
+			return string(desc)
+		}
+		data = data[descEnd:]
+	}
+	return ""
+}
+
+// bannerVersion returns the release from the "Linux version ..." banner.
+func bannerVersion(data []byte) string {
+	for {
+		i := bytes.Index(data, linuxBanner)
+		if i < 0 {
+			return ""
+		}
+		data = data[i+len(linuxBanner):]
+		if release := firstField(data); isRelease(release) {
+			return string(release)
+		}
+	}
+}
+
+// bzImageVersion follows the kernel_version pointer of the boot protocol
+// header to the version string, which starts with the release.
+func bzImageVersion(r io.ReaderAt, header []byte) string {
+	pointer := binary.LittleEndian.Uint16(header[bzImageVersionOffset:])
+	if pointer == 0 {
+		return ""
+	}
+
+	buf := make([]byte, 256)
+	n, err := r.ReadAt(buf, int64(pointer)+bzImageSetupBase)
+	if n == 0 && err != nil {
+		return ""
+	}
+	if release := firstField(buf[:n]); isRelease(release) {
+		return string(release)
+	}
+	return ""
+}
+
+func firstField(data []byte) []byte {
+	end := bytes.IndexAny(data, " \x00\n")
+	if end < 0 {
+		return data
+	}
+	return data[:end]
+}
+
+// isRelease reports whether s looks like a kernel release such as
+// 6.1.102 or 5.10.0-28-amd64.
+func isRelease(s []byte) bool {
+	if len(s) == 0 || s[0] < '0' || s[0] > '9' || !bytes.ContainsRune(s, '.') {
+		return false
+	}
+	for _, c := range s {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func align4(n int) int {
+	return (n + 3) &^ 3
+}
@@ -0,0 +1,80 @@
+package kernel
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeBzImage writes a minimal bzImage whose boot protocol header points at
+// version.
+func writeBzImage(t *testing.T, version string) string {
+	t.Helper()
+
+	image := make([]byte, 0x400)
+	copy(image[bzImageMagicOffset:], bzImageMagic)
+	binary.LittleEndian.PutUint16(image[bzImageVersionOffset:], 0x100)
+	copy(image[0x100+bzImageSetupBase:], version+"\x00")
+
+	path := filepath.Join(t.TempDir(), "bzImage")
+	if err := os.WriteFile(path, image, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadVersion(t *testing.T) {
+	format, version, err := ReadVersion(writeBzImage(t, "6.1.102 (builder@host) #1 SMP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != FormatBzImage || version != "6.1.102" {
+		t.Errorf("ReadVersion(bzImage) = %s, %s; want bzImage, 6.1.102", format, version)
+	}
+
+	// The test binary is an ELF without a kernel banner.
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format, _, err := ReadVersion(executable); err != nil || format != FormatELF {
+		t.Errorf("ReadVersion(test binary) = %s, %v; want elf", format, err)
+	}
+
+	text := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(text, []byte("not a kernel"), 0644)
+	if _, _, err := ReadVersion(text); err == nil {
+		t.Error("ReadVersion(text file) succeeded")
+	}
+}
+
+func TestNoteVersion(t *testing.T) {
+	note := func(name string, noteType uint32, desc string) []byte {
+		var b []byte
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(name)+1))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(desc)+1))
+		b = binary.LittleEndian.AppendUint32(b, noteType)
+		b = append(b, make([]byte, align4(len(name)+1))...)
+		copy(b[12:], name)
+		descStart := len(b)
+		b = append(b, make([]byte, align4(len(desc)+1))...)
+		copy(b[descStart:], desc)
+		return b
+	}
+
+	data := append(note("Xen", 6, "linux"), note("Linux", linuxNoteBuildSalt, "6.8.0-41-generic")...)
+	if got := noteVersion(data, binary.LittleEndian); got != "6.8.0-41-generic" {
+		t.Errorf("noteVersion() = %q, want 6.8.0-41-generic", got)
+	}
+	if got := noteVersion(note("Linux", linuxNoteBuildSalt, ""), binary.LittleEndian); got != "" {
+		t.Errorf("noteVersion(empty salt) = %q, want empty", got)
+	}
+}
+
+func TestBannerVersion(t *testing.T) {
+	data := []byte("\x00Linux version %s\x00Linux version 5.10.225 (root@build) (gcc) #1 SMP\n\x00")
+	if got := bannerVersion(data); got != "5.10.225" {
+		t.Errorf("bannerVersion() = %q, want 5.10.225", got)
+	}
+}
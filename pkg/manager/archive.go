@@ -0,0 +1,102 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"micropod/pkg/agent"
+	"micropod/pkg/archive"
+	"micropod/pkg/firecracker"
+	"micropod/pkg/state"
+)
+
+// agentDialTimeout bounds connecting to a guest agent.
+const agentDialTimeout = 5 * time.Second
+
+// agentClient returns a client for the agent of a running VM.
+func (m *Manager) agentClient(ref string) (*agent.Client, error) {
+	vm, err := m.store.ResolveVM(ref)
+	if err != nil {
+		return nil, err
+	}
+	if vm.State != "Running" {
+		return nil, fmt.Errorf("VM %s is not running", vm.ID)
+	}
+	if vm.VsockPath == "" {
+		return nil, fmt.Errorf("VM %s has no guest agent", vm.ID)
+	}
+
+	return agentClientFor(*vm), nil
+}
+
+// agentClientFor returns a client for the agent of a VM. Guests booting
+// micropod-init always run one; other guests only when their image starts
+// micropod-agent.
+func agentClientFor(vm state.VM) *agent.Client {
+	return agent.NewClient(func() (io.ReadWriteCloser, error) {
+		return firecracker.DialVsock(vm.VsockPath, agent.Port, agentDialTimeout)
+	})
+}
+
+// agentAnswers reports whether the agent of a VM answers. An older
+// micropod-init speaking the legacy protocol counts, with a warning: its
+// guest runs, but requests to it fail.
+func agentAnswers(vm state.VM) bool {
+	_, err := agentClientFor(vm).Ping()
+	if errors.Is(err, agent.ErrLegacyAgent) {
+		fmt.Printf("Warning: VM %s: %v\n", vm.ID, err)
+		return true
+	}
+	return err == nil
+}
+
+// StatPath describes a path in a running VM. It returns nil when the path
+// does not exist.
+func (m *Manager) StatPath(ref, guestPath string, followLink bool) (*archive.PathStat, error) {
+	client, err := m.agentClient(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := client.Stat(guestAbs(guestPath), followLink)
+	var notFound *agent.NotFoundError
+	if errors.As(err, &notFound) {
+		return nil, nil
+	}
+	return stat, err
+}
+
+// ArchivePath returns a tar stream of a path in a running VM whose top entry
+// is named rebase; see archive.Tar.
+func (m *Manager) ArchivePath(ref, guestPath, rebase string, followLink bool) (io.ReadCloser, error) {
+	client, err := m.agentClient(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Archive(guestAbs(guestPath), rebase, followLink)
+}
+
+// ExtractArchive extracts a tar stream into an existing directory of a
+// running VM.
+func (m *Manager) ExtractArchive(ref, guestDir string, r io.Reader) error {
+	client, err := m.agentClient(ref)
+	if err != nil {
+		return err
+	}
+
+	return client.Extract(guestAbs(guestDir), r)
+}
+
+// guestAbs makes a guest path absolute, taking relative paths from /, as
+// docker cp does. A trailing separator or /. is kept, since it changes how
+// the path is copied.
+func guestAbs(p string) string {
+	if path.IsAbs(p) {
+		return p
+	}
+	return "/" + p
+}
@@ -0,0 +1,201 @@
+package manager
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"micropod/pkg/firecracker"
+	"micropod/pkg/state"
+)
+
+const (
+	// defaultBootTimeout bounds the wait for a guest to become ready when
+	// the spec sets no boot timeout.
+	defaultBootTimeout = 30 * time.Second
+	// bootPollInterval is how often a booting guest is checked.
+	bootPollInterval = 50 * time.Millisecond
+	// bootErrorLines is the number of console lines a BootError carries.
+	bootErrorLines = 20
+	// noBootWait is the boot timeout of VMs that are taken as ready once
+	// Firecracker starts them.
+	noBootWait time.Duration = -1
+)
+
+// initMarker ends the line the kernel prints on the console when it starts
+// init: "Run /sbin/init as init process".
+var initMarker = []byte(" as init process")
+
+// BootError is returned when a guest exits or times out before it is ready.
+type BootError struct {
+	VMID   string
+	Reason string
+	// Console holds the last lines of the guest's console output.
+	Console []string
+}
+
+func (e *BootError) Error() string {
+	msg := fmt.Sprintf("VM %s %s", e.VMID, e.Reason)
+	if len(e.Console) == 0 {
+		return msg + " (no console output)"
+	}
+	return msg + "; last console output:\n" + strings.Join(e.Console, "\n")
+}
+
+// waitForBoot blocks until the guest of a launched VM is ready: its
+// micropod-init answers agent requests, once the guest is set up and the
+// workload is about to start, or, booting the image's own init, the kernel
+// reports starting init on the console. A guest whose workload finished
+// before it was seen ready booted too. VMs whose boot timeout is
+// noBootWait are only checked for having exited already.
+func (m *Manager) waitForBoot(vm state.VM, client *firecracker.Client) error {
+	if vm.BootTimeout == noBootWait {
+		select {
+		case <-client.Exited():
+			if _, ok := m.readExitStatus(vm); !ok {
+				return m.bootError(vm, "exited during boot")
+			}
+		default:
+		}
+		return nil
+	}
+
+	timeout := vm.BootTimeout
+	if timeout <= 0 {
+		timeout = defaultBootTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		exited := false
+		select {
+		case <-client.Exited():
+			exited = true
+		default:
+		}
+
+		if m.isBooted(vm) {
+			return nil
+		}
+		if exited {
+			if _, ok := m.readExitStatus(vm); ok {
+				return nil
+			}
+			return m.bootError(vm, "exited during boot")
+		}
+		if time.Now().After(deadline) {
+			reason := fmt.Sprintf("did not become ready within %s", timeout)
+			if !vm.GuestInit && silencesKernel(vm.KernelArgs) {
+				reason += " (its kernel arguments keep the start of init off the console; use a boot timeout of 0 not to wait)"
+			}
+			return m.bootError(vm, reason)
+		}
+
+		select {
+		case <-client.Exited():
+		case <-time.After(bootPollInterval):
+		}
+	}
+}
+
+// parseBootTimeout parses the boot timeout of a spec, a duration such as
+// 10s. Empty means the default and 0 noBootWait.
+func parseBootTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid boot timeout %q: expected a duration such as 30s, or 0 not to wait", value)
+	}
+	if d == 0 {
+		return noBootWait, nil
+	}
+	return d, nil
+}
+
+// checkBootObservable fails for a VM booting its own init whose kernel
+// arguments keep the start of init off the console, unless it does not
+// wait for the boot: it could never be seen booted.
+func checkBootObservable(kernelArgs string, guestInit bool, bootTimeout time.Duration) error {
+	if guestInit || bootTimeout == noBootWait || !silencesKernel(kernelArgs) {
+		return nil
+	}
+	return fmt.Errorf("the kernel arguments keep the start of init off the console, so the boot cannot be observed: remove quiet and loglevel, or use a boot timeout of 0 not to wait for it")
+}
+
+func (m *Manager) isBooted(vm state.VM) bool {
+	if vm.GuestInit {
+		return agentAnswers(vm)
+	}
+
+	f, err := openConsoleLog(vm)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return consoleShowsInit(f)
+}
+
+// consoleShowsInit reports whether console output contains the kernel's
+// message about starting init.
+func consoleShowsInit(r io.Reader) bool {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if bytes.HasSuffix(bytes.TrimRight(scanner.Bytes(), "\r"), initMarker) {
+			return true
+		}
+	}
+	return false
+}
+
+// silencesKernel reports whether kernel arguments keep informational kernel
+// messages, such as the start of init, off the console.
+func silencesKernel(kernelArgs string) bool {
+	for _, param := range strings.Fields(kernelArgs) {
+		if param == "--" {
+			break
+		}
+		if param == "quiet" {
+			return true
+		}
+		if level, ok := strings.CutPrefix(param, "loglevel="); ok {
+			if n, err := strconv.Atoi(level); err == nil && n <= 6 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *Manager) bootError(vm state.VM, reason string) error {
+	var console []string
+	if f, err := openConsoleLog(vm); err == nil {
+		console = lastLines(f, bootErrorLines)
+		f.Close()
+	}
+	return &BootError{VMID: vm.ID, Reason: reason, Console: console}
+}
+
+// lastLines returns the last n non-empty lines of r.
+func lastLines(r io.Reader, n int) []string {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	return lines
+}
@@ -0,0 +1,68 @@
+package manager
+
+import (
+	"strings"
+	"testing"
+)
+
+const panicConsole = `[    0.000000] Linux version 6.1.102 (builder@firecracker)
+[    0.412345] VFS: Cannot open root device "vda" or unknown-block(0,0): error -6
+[    0.412400] Kernel panic - not syncing: VFS: Unable to mount root fs on unknown-block(0,0)
+[    0.412500] Rebooting in 1 seconds..
+`
+
+func TestConsoleShowsInit(t *testing.T) {
+	booted := "[    0.301000] Freeing unused kernel memory: 1268K\r\n[    0.302000] Run /sbin/init as init process\r\nwelcome\r\n"
+	if !consoleShowsInit(strings.NewReader(booted)) {
+		t.Error("consoleShowsInit() = false for a guest that started init")
+	}
+	if consoleShowsInit(strings.NewReader(panicConsole)) {
+		t.Error("consoleShowsInit() = true for a guest that panicked")
+	}
+}
+
+func TestSilencesKernel(t *testing.T) {
+	for args, want := range map[string]bool{
+		"":                          false,
+		"quiet":                     true,
+		"loglevel=4":                true,
+		"loglevel=7 console=ttyS0":  false,
+		"ro -- quiet":               false,
+		"console=ttyS0 quiet=false": false,
+	} {
+		if got := silencesKernel(args); got != want {
+			t.Errorf("silencesKernel(%q) = %v, want %v", args, got, want)
+		}
+	}
+	if err := checkBootObservable("quiet", false, 0); err == nil {
+		t.Error("checkBootObservable() accepted a silenced console")
+	}
+	if err := checkBootObservable("quiet", false, noBootWait); err != nil {
+		t.Errorf("checkBootObservable() without boot wait: %v", err)
+	}
+	if err := checkBootObservable("quiet", true, 0); err != nil {
+		t.Errorf("checkBootObservable() with micropod-init: %v", err)
+	}
+}
+
+func TestBootError(t *testing.T) {
+	lines := lastLines(strings.NewReader(panicConsole), 2)
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "[    0.412400] Kernel panic") {
+		t.Fatalf("lastLines() = %q", lines)
+	}
+
+	err := &BootError{VMID: "abc", Reason: "exited during boot", Console: lines}
+	want := "VM abc exited during boot; last console output:\n" + strings.Join(lines, "\n")
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+
+	if d, err := parseBootTimeout("0"); err != nil || d != noBootWait {
+		t.Errorf("parseBootTimeout(\"0\") = %v, %v, want noBootWait", d, err)
+	}
+	for _, value := range []string{"-1s", "soon"} {
+		if _, err := parseBootTimeout(value); err == nil {
+			t.Errorf("parseBootTimeout(%q) succeeded", value)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package manager
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"micropod/pkg/console"
+	"micropod/pkg/state"
+)
+
+// consoleDrainTimeout bounds how long the remaining output of an exited
+// VM's console is waited for.
+const consoleDrainTimeout = 2 * time.Second
+
+// openConsole allocates the pseudo-terminal the serial console of a TTY VM
+// is connected to and starts copying its output to the VM's log. The
+// returned terminal is for Firecracker; the caller closes it once the
+// process has started.
+func (m *Manager) openConsole(vm state.VM) (*console.Console, *os.File, error) {
+	master, tty, err := console.OpenPTY()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The guest's own terminal echoes and translates input; the host side
+	// passes bytes through.
+	if _, err := console.MakeRaw(tty); err != nil {
+		master.Close()
+		tty.Close()
+		return nil, nil, err
+	}
+
+	log, err := os.OpenFile(vm.LogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		master.Close()
+		tty.Close()
+		return nil, nil, fmt.Errorf("failed to open console log: %w", err)
+	}
+
+	return console.New(master, log), tty, nil
+}
+
+func (m *Manager) trackConsole(vmID string, c *console.Console) {
+	m.consolesMu.Lock()
+	defer m.consolesMu.Unlock()
+
+	m.consoles[vmID] = c
+}
+
+// releaseConsole closes the console of a VM whose process is gone, once its
+// remaining output has reached the log.
+func (m *Manager) releaseConsole(vmID string) {
+	m.consolesMu.Lock()
+	c := m.consoles[vmID]
+	delete(m.consoles, vmID)
+	m.consolesMu.Unlock()
+
+	if c == nil {
+		return
+	}
+
+	select {
+	case <-c.Done():
+	case <-time.After(consoleDrainTimeout):
+	}
+	c.Close()
+}
+
+// AttachVM attaches a session writing the console output of a VM started
+// with a TTY to out. The first session may type into the console; while it
+// is attached, further sessions mirror it read-only.
+func (m *Manager) AttachVM(ref string, out io.Writer) (*console.Session, error) {
+	vm, err := m.store.ResolveVM(ref)
+	if err != nil {
+		return nil, err
+	}
+	if !vm.TTY {
+		return nil, fmt.Errorf("VM %s has no TTY: start it with --tty to attach, or follow its output with logs -f", vm.ID)
+	}
+
+	m.consolesMu.Lock()
+	c := m.consoles[vm.ID]
+	m.consolesMu.Unlock()
+
+	if c == nil {
+		return nil, fmt.Errorf("the console of VM %s is not available: the VM is not running or was started by an earlier daemon", vm.ID)
+	}
+
+	return c.Attach(out), nil
+}
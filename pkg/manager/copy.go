@@ -0,0 +1,146 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"micropod/pkg/state"
+)
+
+// defaultCopyOutSizeMB is the size of the writable drive a copy-out
+// directory is written to.
+const defaultCopyOutSizeMB = 1024
+
+// ParseCopyInSpec parses a run --copy-in value: hostdir:/guest/path[:ro].
+func ParseCopyInSpec(spec string) (state.Mount, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return state.Mount{}, fmt.Errorf("invalid copy-in %q (expected hostdir:/path[:ro])", spec)
+	}
+
+	source, err := filepath.Abs(parts[0])
+	if err != nil {
+		return state.Mount{}, fmt.Errorf("invalid copy-in source %q: %w", parts[0], err)
+	}
+	mount := state.Mount{Type: "copy-in", Source: source, Target: parts[1]}
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "ro":
+			mount.ReadOnly = true
+		case "rw":
+		default:
+			return state.Mount{}, fmt.Errorf("invalid copy-in option %q in %q (use ro or rw)", parts[2], spec)
+		}
+	}
+
+	return mount, nil
+}
+
+// ParseCopyOutSpec parses a run --copy-out value: /guest/path:hostdir. The
+// copied files are owned by the calling user.
+func ParseCopyOutSpec(spec string) (state.Mount, error) {
+	target, dest, ok := strings.Cut(spec, ":")
+	if !ok || target == "" || dest == "" {
+		return state.Mount{}, fmt.Errorf("invalid copy-out %q (expected /path:hostdir)", spec)
+	}
+
+	source, err := filepath.Abs(dest)
+	if err != nil {
+		return state.Mount{}, fmt.Errorf("invalid copy-out destination %q: %w", dest, err)
+	}
+	return state.Mount{Type: "copy-out", Source: source, Target: target, Owner: CurrentOwner()}, nil
+}
+
+// CurrentOwner returns the uid:gid of the calling process, for the Owner of
+// copy-out mounts.
+func CurrentOwner() string {
+	return fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+}
+
+// validateOwner checks that owner is empty or a numeric uid:gid.
+func validateOwner(owner string) error {
+	if owner == "" {
+		return nil
+	}
+	uid, gid, ok := strings.Cut(owner, ":")
+	if !ok {
+		return fmt.Errorf("invalid owner %q (expected uid:gid)", owner)
+	}
+	for _, id := range []string{uid, gid} {
+		if _, err := strconv.ParseUint(id, 10, 32); err != nil {
+			return fmt.Errorf("invalid owner %q (expected uid:gid)", owner)
+		}
+	}
+	return nil
+}
+
+// packMounts builds the drives of a new VM's copy-in and copy-out mounts.
+// Read-only copies are packed as squashfs when mksquashfs is available.
+func (m *Manager) packMounts(vmID string, mounts []state.Mount) error {
+	for i := range mounts {
+		mount := &mounts[i]
+
+		switch mount.Type {
+		case "copy-in":
+			mount.FSType = "ext4"
+			if mount.ReadOnly {
+				if _, err := exec.LookPath("mksquashfs"); err == nil {
+					mount.FSType = "squashfs"
+				}
+			}
+			mount.Image = m.getMountImagePath(vmID, i, mount.FSType)
+			if err := m.rootfsCreator.PackDir(mount.Source, mount.Image, mount.FSType); err != nil {
+				m.removeMountImages(mounts)
+				return fmt.Errorf("failed to pack %s: %w", mount.Source, err)
+			}
+		case "copy-out":
+			mount.FSType = "ext4"
+			mount.Image = m.getMountImagePath(vmID, i, mount.FSType)
+			if err := m.rootfsCreator.CreateEmpty(mount.Image, defaultCopyOutSizeMB); err != nil {
+				m.removeMountImages(mounts)
+				return fmt.Errorf("failed to create copy-out drive for %s: %w", mount.Target, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// copyOut copies the copy-out directories of a VM whose process is gone
+// back to the host. Failures are logged only, so they do not keep the VM
+// from being cleaned up.
+func (m *Manager) copyOut(vm state.VM) {
+	for _, mount := range vm.Mounts {
+		if mount.Type != "copy-out" || mount.Image == "" {
+			continue
+		}
+		if err := m.rootfsCreator.ExtractDir(mount.Image, mount.Source, mount.Owner); err != nil {
+			fmt.Printf("Warning: failed to copy %s out of VM %s: %v\n", mount.Target, vm.ID, err)
+		}
+	}
+}
+
+func (m *Manager) removeMountImages(mounts []state.Mount) error {
+	var errors []error
+	for _, mount := range mounts {
+		if mount.Image == "" {
+			continue
+		}
+		if err := os.Remove(mount.Image); err != nil && !os.IsNotExist(err) {
+			errors = append(errors, fmt.Errorf("failed to remove %s: %w", mount.Image, err))
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("cleanup errors: %v", errors)
+	}
+	return nil
+}
+
+func (m *Manager) getMountImagePath(vmID string, index int, fsType string) string {
+	return filepath.Join(m.config.GetRootfsDir(), fmt.Sprintf("%s-mount%d.%s", vmID, index, fsType))
+}
@@ -0,0 +1,49 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"micropod/pkg/state"
+)
+
+func TestParseCopySpecs(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseCopyInSpec("src:/src:ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := state.Mount{Type: "copy-in", Source: filepath.Join(wd, "src"), Target: "/src", ReadOnly: true}
+	if got != want {
+		t.Errorf("ParseCopyInSpec() = %+v, want %+v", got, want)
+	}
+
+	got, err = ParseCopyOutSpec("/out:/tmp/build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = state.Mount{Type: "copy-out", Source: "/tmp/build", Target: "/out", Owner: CurrentOwner()}
+	if got != want {
+		t.Errorf("ParseCopyOutSpec() = %+v, want %+v", got, want)
+	}
+
+	for _, spec := range []string{"src", "src:/src:noexec"} {
+		if _, err := ParseCopyInSpec(spec); err == nil {
+			t.Errorf("ParseCopyInSpec(%q) succeeded", spec)
+		}
+	}
+	if _, err := ParseCopyOutSpec("/out"); err == nil {
+		t.Error("ParseCopyOutSpec(/out) succeeded")
+	}
+
+	for _, owner := range []string{"1000", "alice:staff", "1000:-1"} {
+		if err := validateOwner(owner); err == nil {
+			t.Errorf("validateOwner(%q) succeeded", owner)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package manager
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType is the kind of VM lifecycle event.
+type EventType string
+
+const (
+	EventCreate  EventType = "create"
+	EventStart   EventType = "start"
+	EventPause   EventType = "pause"
+	EventResume  EventType = "resume"
+	EventStop    EventType = "stop"
+	EventDie     EventType = "die"
+	EventDestroy EventType = "destroy"
+	// EventHealthStatus is published when the health status of a VM
+	// changes.
+	EventHealthStatus EventType = "health_status"
+)
+
+// eventHistoryLimit bounds the number of events kept in memory and in each
+// event log file. A full log is rotated to a single older file.
+const eventHistoryLimit = 1000
+
+// subscriberBuffer is how many events a slow subscriber may lag behind
+// before further events are dropped for it.
+const subscriberBuffer = 64
+
+// Event is a VM lifecycle event.
+type Event struct {
+	// ID increases monotonically across the bus's history.
+	ID    uint64    `json:"id"`
+	Type  EventType `json:"type"`
+	VMID  string    `json:"vmId"`
+	Name  string    `json:"name,omitempty"`
+	Image string    `json:"image,omitempty"`
+	Time  time.Time `json:"time"`
+	// ExitReason explains a die event, e.g. "exited with code 1" or
+	// "oom-killed".
+	ExitReason string `json:"exitReason,omitempty"`
+	// ExitCode is set for die and stop events; see Manager.WaitVM.
+	ExitCode *int `json:"exitCode,omitempty"`
+	// HealthStatus is the new status of a health_status event.
+	HealthStatus string `json:"healthStatus,omitempty"`
+}
+
+// EventFilter selects events. Empty fields match everything; values within a
+// field are alternatives.
+type EventFilter struct {
+	Types  []EventType
+	VMIDs  []string
+	Images []string
+}
+
+// ParseEventFilter parses "key=value" filters with the keys type, vm and
+// image. vm matches a VM ID or name.
+func ParseEventFilter(filters []string) (EventFilter, error) {
+	var f EventFilter
+	for _, filter := range filters {
+		key, value, ok := strings.Cut(filter, "=")
+		if !ok || value == "" {
+			return f, fmt.Errorf("invalid filter %q (expected key=value)", filter)
+		}
+
+		switch key {
+		case "type", "event":
+			f.Types = append(f.Types, EventType(value))
+		case "vm", "id":
+			f.VMIDs = append(f.VMIDs, value)
+		case "image":
+			f.Images = append(f.Images, value)
+		default:
+			return f, fmt.Errorf("unknown filter key %q (use type, vm or image)", key)
+		}
+	}
+	return f, nil
+}
+
+// Match reports whether the event passes the filter.
+func (f EventFilter) Match(e Event) bool {
+	if len(f.Types) > 0 && !contains(f.Types, e.Type) {
+		return false
+	}
+	if len(f.VMIDs) > 0 && !contains(f.VMIDs, e.VMID) && (e.Name == "" || !contains(f.VMIDs, e.Name)) {
+		return false
+	}
+	if len(f.Images) > 0 && !contains(f.Images, e.Image) {
+		return false
+	}
+	return true
+}
+
+func contains[T comparable](values []T, v T) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// EventBus fans lifecycle events out to subscribers and keeps a bounded
+// history, persisted as a JSON-lines log.
+type EventBus struct {
+	filePath string
+
+	mu          sync.Mutex
+	history     []Event
+	nextID      uint64
+	subscribers map[chan Event]struct{}
+	// logged is the number of events in the current log file.
+	logged int
+	// subscribed, when set, is signalled by new subscriptions unless a
+	// signal is pending.
+	subscribed chan struct{}
+}
+
+// NewEventBus creates a bus whose history is appended to the log at
+// filePath, which is rotated to filePath.1 when full.
+func NewEventBus(filePath string) (*EventBus, error) {
+	b := &EventBus{
+		filePath:    filePath,
+		nextID:      1,
+		subscribers: make(map[chan Event]struct{}),
+	}
+
+	rotated, _, err := readEventLog(b.rotatedPath())
+	if err != nil {
+		return nil, err
+	}
+	current, complete, err := readEventLog(filePath)
+	if err != nil {
+		return nil, err
+	}
+	b.logged = len(current)
+
+	b.history = append(rotated, current...)
+	if len(b.history) > eventHistoryLimit {
+		b.history = b.history[len(b.history)-eventHistoryLimit:]
+	}
+	if n := len(b.history); n > 0 {
+		b.nextID = b.history[n-1].ID + 1
+	}
+
+	// Terminate a record cut short by a crash, so the next one starts on a
+	// line of its own.
+	if !complete {
+		if err := b.appendLine(nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+// readEventLog reads the events of a JSON-lines log, skipping lines that do
+// not parse. complete is false if the log does not end with a newline.
+func readEventLog(path string) (events []Event, complete bool, err error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read event history: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, len(data) == 0 || data[len(data)-1] == '\n', nil
+}
+
+// Publish records an event and delivers it to every subscriber.
+func (b *EventBus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e.ID = b.nextID
+	b.nextID++
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.history = append(b.history, e)
+	if len(b.history) > eventHistoryLimit {
+		b.history = b.history[len(b.history)-eventHistoryLimit:]
+	}
+	if err := b.log(e); err != nil {
+		fmt.Printf("Warning: failed to persist events: %v\n", err)
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns the events published from now on. The returned function
+// ends the subscription and closes the channel.
+func (b *EventBus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	if b.subscribed != nil {
+		select {
+		case b.subscribed <- struct{}{}:
+		default:
+		}
+	}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// History returns the recorded events in [since, until] that match the
+// filter. Zero times leave the range open.
+func (b *EventBus) History(since, until time.Time, filter EventFilter) []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var events []Event
+	for _, e := range b.history {
+		if !since.IsZero() && e.Time.Before(since) {
+			continue
+		}
+		if !until.IsZero() && e.Time.After(until) {
+			continue
+		}
+		if filter.Match(e) {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// log appends e to the event log, rotating the log first when it is full.
+func (b *EventBus) log(e Event) error {
+	if b.logged >= eventHistoryLimit {
+		if err := os.Rename(b.filePath, b.rotatedPath()); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate event log: %w", err)
+		}
+		b.logged = 0
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	if err := b.appendLine(data); err != nil {
+		return err
+	}
+	b.logged++
+	return nil
+}
+
+func (b *EventBus) appendLine(data []byte) error {
+	f, err := os.OpenFile(b.filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open event log: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write event log: %w", err)
+	}
+	return nil
+}
+
+func (b *EventBus) rotatedPath() string {
+	return b.filePath + ".1"
+}
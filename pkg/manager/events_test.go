@@ -0,0 +1,122 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventBus(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "micropod-events-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	eventsPath := filepath.Join(tempDir, "events.jsonl")
+
+	bus, err := NewEventBus(eventsPath)
+	if err != nil {
+		t.Fatalf("Failed to create event bus: %v", err)
+	}
+
+	t.Run("subscribers receive published events", func(t *testing.T) {
+		events, cancel := bus.Subscribe()
+		defer cancel()
+
+		bus.Publish(Event{Type: EventStart, VMID: "vm-1", Image: "alpine:latest"})
+
+		select {
+		case e := <-events:
+			if e.Type != EventStart || e.VMID != "vm-1" {
+				t.Errorf("Unexpected event %+v", e)
+			}
+			if e.ID == 0 || e.Time.IsZero() {
+				t.Errorf("Expected ID and time to be set, got %+v", e)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for event")
+		}
+	})
+
+	t.Run("history is filtered and persisted", func(t *testing.T) {
+		bus.Publish(Event{Type: EventDie, VMID: "vm-1", ExitReason: "oom-killed"})
+		bus.Publish(Event{Type: EventDie, VMID: "vm-2"})
+
+		filter, err := ParseEventFilter([]string{"type=die", "vm=vm-1"})
+		if err != nil {
+			t.Fatalf("Failed to parse filter: %v", err)
+		}
+
+		reloaded, err := NewEventBus(eventsPath)
+		if err != nil {
+			t.Fatalf("Failed to reload event bus: %v", err)
+		}
+
+		events := reloaded.History(time.Time{}, time.Time{}, filter)
+		if len(events) != 1 || events[0].ExitReason != "oom-killed" {
+			t.Fatalf("Expected the oom-killed die event, got %+v", events)
+		}
+
+		reloaded.Publish(Event{Type: EventDestroy, VMID: "vm-1"})
+		all := reloaded.History(time.Time{}, time.Time{}, EventFilter{})
+		if last := all[len(all)-1]; last.ID != 4 {
+			t.Errorf("Expected IDs to continue after reload, got %d", last.ID)
+		}
+	})
+
+	t.Run("the log is rotated when full", func(t *testing.T) {
+		path := filepath.Join(tempDir, "rotated.jsonl")
+		bus, err := NewEventBus(path)
+		if err != nil {
+			t.Fatalf("Failed to create event bus: %v", err)
+		}
+		for i := 0; i < eventHistoryLimit+10; i++ {
+			bus.Publish(Event{Type: EventStart, VMID: "vm-1"})
+		}
+
+		if _, err := os.Stat(path + ".1"); err != nil {
+			t.Fatalf("Expected a rotated log: %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.Count(string(data), "\n"); lines != 10 {
+			t.Errorf("Expected 10 events in the current log, got %d", lines)
+		}
+
+		// A record cut short by a crash is skipped.
+		if err := os.WriteFile(path, append(data, `{"id":`...), 0644); err != nil {
+			t.Fatal(err)
+		}
+		reloaded, err := NewEventBus(path)
+		if err != nil {
+			t.Fatalf("Failed to reload event bus: %v", err)
+		}
+		all := reloaded.History(time.Time{}, time.Time{}, EventFilter{})
+		if len(all) != eventHistoryLimit || all[len(all)-1].ID != eventHistoryLimit+10 {
+			t.Errorf("Expected the last %d events, got %d ending with %d", eventHistoryLimit, len(all), all[len(all)-1].ID)
+		}
+		reloaded.Publish(Event{Type: EventStop, VMID: "vm-1"})
+		reloaded, err = NewEventBus(path)
+		if err != nil {
+			t.Fatalf("Failed to reload event bus: %v", err)
+		}
+		all = reloaded.History(time.Time{}, time.Time{}, EventFilter{})
+		if last := all[len(all)-1]; last.Type != EventStop {
+			t.Errorf("Expected the event published after the crash last, got %+v", last)
+		}
+	})
+
+	t.Run("invalid filters are rejected", func(t *testing.T) {
+		if _, err := ParseEventFilter([]string{"color=red"}); err == nil {
+			t.Error("Expected an error for an unknown filter key")
+		}
+		if _, err := ParseEventFilter([]string{"type"}); err == nil {
+			t.Error("Expected an error for a filter without value")
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package manager
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"micropod/pkg/agent"
+	"micropod/pkg/firecracker"
+	"micropod/pkg/state"
+)
+
+// exitReportTimeout bounds how long a guest connected to report its exit
+// status may take to send it.
+const exitReportTimeout = 5 * time.Second
+
+// exitListener receives the exit status micropod-init reports over vsock
+// when the workload of a VM exits.
+type exitListener struct {
+	vmID     string
+	listener net.Listener
+	done     chan struct{}
+
+	mu    sync.Mutex
+	code  int
+	found bool
+}
+
+func newExitListener(vmID string, listener net.Listener) *exitListener {
+	l := &exitListener{vmID: vmID, listener: listener, done: make(chan struct{})}
+	go l.serve()
+	return l
+}
+
+func (l *exitListener) serve() {
+	defer close(l.done)
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return
+		}
+		l.receive(conn)
+	}
+}
+
+// receive records the status reported on conn before acknowledging it, so
+// that it is known by the time the guest powers off.
+func (l *exitListener) receive(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(exitReportTimeout))
+
+	code, err := agent.ReadExitReport(conn)
+	if err != nil {
+		fmt.Printf("Warning: invalid exit report from VM %s: %v\n", l.vmID, err)
+		return
+	}
+	l.mu.Lock()
+	l.code, l.found = code, true
+	l.mu.Unlock()
+
+	agent.AcknowledgeExit(conn)
+}
+
+func (l *exitListener) status() (int, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.code, l.found
+}
+
+func (l *exitListener) Close() {
+	l.listener.Close()
+	<-l.done
+}
+
+// exitListenPorts are the host vsock ports a guest connects to.
+func exitListenPorts(vm state.VM) []uint32 {
+	if !vm.GuestInit {
+		return nil
+	}
+	return []uint32{agent.ExitPort}
+}
+
+func (m *Manager) trackExitListener(vmID string, listener net.Listener) {
+	m.exitMu.Lock()
+	defer m.exitMu.Unlock()
+	m.exitListeners[vmID] = newExitListener(vmID, listener)
+}
+
+// untrackExitListener stops listening for the exit status of a VM whose
+// process is gone.
+func (m *Manager) untrackExitListener(vmID string) {
+	m.exitMu.Lock()
+	l := m.exitListeners[vmID]
+	delete(m.exitListeners, vmID)
+	m.exitMu.Unlock()
+
+	if l != nil {
+		l.Close()
+	}
+}
+
+// relistenForExit listens again for the exit status of a VM started by an
+// earlier daemon, whose listener went with that daemon.
+func (m *Manager) relistenForExit(vm state.VM) error {
+	if len(exitListenPorts(vm)) == 0 || vm.VsockPath == "" {
+		return nil
+	}
+	uid, gid := -1, -1
+	if vm.Jailer != nil {
+		uid, gid = vm.Jailer.UID, vm.Jailer.GID
+	}
+	listener, err := firecracker.ListenVsock(vm.VsockPath, agent.ExitPort, uid, gid)
+	if err != nil {
+		return err
+	}
+	m.trackExitListener(vm.ID, listener)
+	return nil
+}
+
+// readExitStatus returns the workload exit status micropod-init reported
+// during the VM's last run.
+func (m *Manager) readExitStatus(vm state.VM) (int, bool) {
+	m.exitMu.Lock()
+	l := m.exitListeners[vm.ID]
+	m.exitMu.Unlock()
+
+	if l == nil {
+		return 0, false
+	}
+	return l.status()
+}
@@ -0,0 +1,135 @@
+package manager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"micropod/pkg/state"
+)
+
+// ListOptions selects the VMs returned by ListVMs.
+type ListOptions struct {
+	// All includes VMs without a running process, such as exited VMs.
+	All    bool
+	Filter VMFilter
+}
+
+// VMFilter selects VMs. Empty fields match everything; values within a
+// field are alternatives.
+type VMFilter struct {
+	IDs    []string
+	Names  []string
+	Images []string
+	States []string
+	// Health matches the health status, or none for VMs without a
+	// health check.
+	Health []string
+	// Labels must all be satisfied.
+	Labels []LabelRequirement
+	// Since and Before bound the creation time of the VM.
+	Since  time.Time
+	Before time.Time
+}
+
+// ParseVMFilter parses "key=value" filters with the keys id, name, image,
+// state, health, label, since and before. id matches an ID prefix; label takes a
+// label selector term (key, !key, key=value or key!=value); since and before
+// take a timestamp, Unix time or duration relative to now.
+func ParseVMFilter(filters []string, now time.Time) (VMFilter, error) {
+	var f VMFilter
+	for _, filter := range filters {
+		key, value, ok := strings.Cut(filter, "=")
+		if !ok || value == "" {
+			return f, fmt.Errorf("invalid filter %q (expected key=value)", filter)
+		}
+
+		switch key {
+		case "id":
+			f.IDs = append(f.IDs, value)
+		case "name":
+			f.Names = append(f.Names, value)
+		case "image":
+			f.Images = append(f.Images, value)
+		case "state", "status":
+			f.States = append(f.States, value)
+		case "health":
+			f.Health = append(f.Health, value)
+		case "label":
+			r, err := ParseLabelRequirement(value)
+			if err != nil {
+				return f, err
+			}
+			f.Labels = append(f.Labels, r)
+		case "since", "before":
+			t, err := ParseTimestamp(value, now)
+			if err != nil {
+				return f, fmt.Errorf("invalid %s filter: %w", key, err)
+			}
+			if key == "since" {
+				f.Since = t
+			} else {
+				f.Before = t
+			}
+		default:
+			return f, fmt.Errorf("unknown filter key %q (use id, name, image, state, health, label, since or before)", key)
+		}
+	}
+	return f, nil
+}
+
+// Match reports whether the VM passes the filter.
+func (f VMFilter) Match(vm state.VM) bool {
+	if len(f.IDs) > 0 && !matchAny(f.IDs, func(id string) bool { return strings.HasPrefix(vm.ID, id) }) {
+		return false
+	}
+	if len(f.Names) > 0 && !contains(f.Names, vm.Name) {
+		return false
+	}
+	if len(f.Images) > 0 && !contains(f.Images, vm.ImageName) {
+		return false
+	}
+	if len(f.States) > 0 && !matchAny(f.States, func(s string) bool { return strings.EqualFold(s, vm.State) }) {
+		return false
+	}
+	if len(f.Health) > 0 && !contains(f.Health, HealthStatus(vm)) {
+		return false
+	}
+	for _, r := range f.Labels {
+		if !r.Matches(vm.Labels) {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && !vm.CreatedAt.After(f.Since) {
+		return false
+	}
+	if !f.Before.IsZero() && !vm.CreatedAt.Before(f.Before) {
+		return false
+	}
+	return true
+}
+
+func matchAny(values []string, match func(string) bool) bool {
+	for _, value := range values {
+		if match(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTimestamp accepts an RFC 3339 time, a Unix timestamp in seconds or a
+// duration relative to now, such as 10m.
+func ParseTimestamp(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a timestamp, Unix time or duration", value)
+}
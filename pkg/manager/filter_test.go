@@ -0,0 +1,63 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"micropod/pkg/state"
+)
+
+func TestVMFilter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	vm := state.VM{
+		ID:        "abc123",
+		Name:      "web",
+		ImageName: "nginx:latest",
+		State:     "Running",
+		CreatedAt: now.Add(-30 * time.Minute),
+		Labels:    map[string]string{"team": "infra", "ci": ""},
+	}
+
+	tests := []struct {
+		filters []string
+		want    bool
+	}{
+		{nil, true},
+		{[]string{"id=abc"}, true},
+		{[]string{"id=abd"}, false},
+		{[]string{"name=web"}, true},
+		{[]string{"image=redis:7", "image=nginx:latest"}, true},
+		{[]string{"image=nginx"}, false},
+		{[]string{"state=running"}, true},
+		{[]string{"state=exited"}, false},
+		{[]string{"since=1h"}, true},
+		{[]string{"since=10m"}, false},
+		{[]string{"before=10m"}, true},
+		{[]string{"before=2026-01-02T11:00:00Z"}, false},
+		{[]string{"name=web", "state=paused"}, false},
+		{[]string{"label=team=infra"}, true},
+		{[]string{"label=team=web"}, false},
+		{[]string{"label=team!=web", "label=ci"}, true},
+		{[]string{"label=team=infra", "label=!ci"}, false},
+		{[]string{"label=job"}, false},
+		{[]string{"label=!job"}, true},
+		{[]string{"health=none"}, true},
+		{[]string{"health=healthy"}, false},
+	}
+	for _, tt := range tests {
+		f, err := ParseVMFilter(tt.filters, now)
+		if err != nil {
+			t.Errorf("ParseVMFilter(%q): %v", tt.filters, err)
+			continue
+		}
+		if got := f.Match(vm); got != tt.want {
+			t.Errorf("filter %q matched = %v, want %v", tt.filters, got, tt.want)
+		}
+	}
+
+	for _, filters := range [][]string{{"bogus=1"}, {"state"}, {"since=yesterday"}, {"label=!"}} {
+		if _, err := ParseVMFilter(filters, now); err == nil {
+			t.Errorf("ParseVMFilter(%q) succeeded, want error", filters)
+		}
+	}
+}
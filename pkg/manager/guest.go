@@ -0,0 +1,137 @@
+package manager
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"micropod/pkg/firecracker"
+	"micropod/pkg/guest"
+	"micropod/pkg/image"
+	"micropod/pkg/state"
+)
+
+// defaultPath is set for workloads whose image does not define PATH.
+const defaultPath = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// defaultTerm is set for workloads with a TTY whose environment does not
+// define TERM, as docker run -t does.
+const defaultTerm = "TERM=xterm"
+
+// guestConfig derives what micropod-init runs from the image config and the
+// VM's command and environment. Like docker run, a command replaces the
+// image's CMD but not its ENTRYPOINT.
+func guestConfig(vm state.VM) guest.Config {
+	var imageConfig image.Config
+	if vm.ImageConfig != nil {
+		imageConfig = *vm.ImageConfig
+	}
+
+	command := vm.Command
+	if len(command) == 0 {
+		command = imageConfig.Cmd
+	}
+	command = append(append([]string{}, imageConfig.Entrypoint...), command...)
+
+	env := append([]string{}, imageConfig.Env...)
+	names := make([]string, 0, len(vm.Env))
+	for name := range vm.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = setEnv(env, name, vm.Env[name])
+	}
+	if !hasEnv(env, "PATH") {
+		env = append(env, defaultPath)
+	}
+	if vm.TTY && !hasEnv(env, "TERM") {
+		env = append(env, defaultTerm)
+	}
+
+	hostname := vm.Name
+	if hostname == "" {
+		hostname = vm.ID[:12]
+	}
+
+	return guest.Config{
+		Hostname:     hostname,
+		Command:      command,
+		Env:          env,
+		WorkingDir:   imageConfig.WorkingDir,
+		TTY:          vm.TTY,
+		ReadOnlyRoot: vm.ReadOnly,
+		Tmpfs:        vm.Tmpfs,
+		Overlays:     vm.Overlays,
+		Pooled:       vm.Pool != "",
+	}
+}
+
+func setEnv(env []string, name, value string) []string {
+	for i, kv := range env {
+		if k, _, _ := strings.Cut(kv, "="); k == name {
+			env[i] = name + "=" + value
+			return env
+		}
+	}
+	return append(env, name+"="+value)
+}
+
+func hasEnv(env []string, name string) bool {
+	for _, kv := range env {
+		if k, _, _ := strings.Cut(kv, "="); k == name {
+			return true
+		}
+	}
+	return false
+}
+
+// guestDrives returns the config drive followed by one drive per mount, and
+// the guest config describing them.
+func (m *Manager) guestDrives(vm state.VM) ([]firecracker.ExtraDrive, error) {
+	configPath := m.getGuestConfigPath(vm.ID)
+	drives := []firecracker.ExtraDrive{{ID: "config", HostPath: configPath, ReadOnly: true}}
+
+	config := guestConfig(vm)
+	var err error
+	if config.Interfaces, config.Hosts, err = m.guestNetwork(vm); err != nil {
+		return nil, err
+	}
+	for i, mount := range vm.Mounts {
+		hostPath, fsType := mount.Source, "ext4"
+		switch mount.Type {
+		case "volume":
+			vol, err := m.volumes.Get(mount.Source)
+			if err != nil {
+				return nil, err
+			}
+			hostPath = vol.Path
+		case "copy-in", "copy-out":
+			hostPath, fsType = mount.Image, mount.FSType
+		}
+
+		drives = append(drives, firecracker.ExtraDrive{
+			ID:       fmt.Sprintf("mount%d", i),
+			HostPath: hostPath,
+			ReadOnly: mount.ReadOnly,
+		})
+		config.Mounts = append(config.Mounts, guest.Mount{
+			// The root device and config drive come first.
+			Device:   guest.DeviceName(len(drives)),
+			Target:   mount.Target,
+			FSType:   fsType,
+			ReadOnly: mount.ReadOnly,
+		})
+	}
+
+	if err := guest.WriteConfig(configPath, config); err != nil {
+		return nil, err
+	}
+
+	return drives, nil
+}
+
+func (m *Manager) getGuestConfigPath(vmID string) string {
+	return filepath.Join(m.config.GetRootfsDir(), vmID+".config")
+}
@@ -0,0 +1,397 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"micropod/pkg/agent"
+	"micropod/pkg/image"
+	"micropod/pkg/state"
+)
+
+// Defaults of health checks that neither the image nor the spec configure.
+const (
+	defaultHealthInterval = 30 * time.Second
+	defaultHealthTimeout  = 30 * time.Second
+	defaultHealthRetries  = 3
+)
+
+// healthLogLimit is the number of check results kept in a VM's health.
+const healthLogLimit = 5
+
+// HealthCheckSpec overrides the health check of the image. Fields left
+// empty keep the image's values; durations are strings such as 30s.
+type HealthCheckSpec struct {
+	// Test is ["NONE"], which disables the image's check, ["CMD", args...]
+	// or ["CMD-SHELL", command], run in the guest through micropod-init,
+	// or ["TCP", port], a connection to the VM's first network address.
+	Test        []string `json:"test,omitempty"`
+	Interval    string   `json:"interval,omitempty"`
+	Timeout     string   `json:"timeout,omitempty"`
+	StartPeriod string   `json:"startPeriod,omitempty"`
+	Retries     int      `json:"retries,omitempty"`
+}
+
+// Validate checks the test and durations of a health check spec.
+func (s HealthCheckSpec) Validate() error {
+	if len(s.Test) > 0 {
+		if err := validateHealthTest(s.Test); err != nil {
+			return err
+		}
+	}
+	for name, value := range map[string]string{"interval": s.Interval, "timeout": s.Timeout, "startPeriod": s.StartPeriod} {
+		if _, err := parseHealthDuration(value); err != nil {
+			return fmt.Errorf("invalid health check %s: %w", name, err)
+		}
+	}
+	if s.Retries < 0 {
+		return fmt.Errorf("health check retries must not be negative")
+	}
+	return nil
+}
+
+func validateHealthTest(test []string) error {
+	switch test[0] {
+	case "NONE":
+		return nil
+	case "CMD":
+		if len(test) > 1 {
+			return nil
+		}
+	case "CMD-SHELL":
+		if len(test) == 2 {
+			return nil
+		}
+	case "TCP":
+		if len(test) == 2 {
+			if port, err := strconv.Atoi(test[1]); err == nil && port > 0 && port < 65536 {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("invalid health check test %q (use [NONE], [CMD, args...], [CMD-SHELL, command] or [TCP, port])", test)
+}
+
+func parseHealthDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%s is negative", value)
+	}
+	return d, nil
+}
+
+// resolveHealthCheck merges the health check of a spec into that of the
+// image and fills in the defaults. It returns nil when there is no check
+// or it is disabled. Commands run through micropod-init: an image's
+// command check is dropped with a warning without it, while one from the
+// spec is an error.
+func resolveHealthCheck(spec VMSpec, imageCheck *image.HealthConfig, guestInit bool) (*image.HealthConfig, error) {
+	var check image.HealthConfig
+	if imageCheck != nil {
+		check = *imageCheck
+	}
+	fromSpec := false
+	if s := spec.HealthCheck; s != nil {
+		if len(s.Test) > 0 {
+			check.Test = s.Test
+			fromSpec = true
+		}
+		for _, field := range []struct {
+			value string
+			d     *time.Duration
+		}{{s.Interval, &check.Interval}, {s.Timeout, &check.Timeout}, {s.StartPeriod, &check.StartPeriod}} {
+			d, err := parseHealthDuration(field.value)
+			if err != nil {
+				return nil, err
+			}
+			if d > 0 {
+				*field.d = d
+			}
+		}
+		if s.Retries > 0 {
+			check.Retries = s.Retries
+		}
+	}
+
+	if len(check.Test) == 0 || check.Test[0] == "NONE" {
+		return nil, nil
+	}
+	if err := validateHealthTest(check.Test); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case check.Test[0] == "TCP" && len(spec.Networks) == 0:
+		return nil, fmt.Errorf("TCP health checks require a network (use --network)")
+	case check.Test[0] != "TCP" && !guestInit:
+		if fromSpec {
+			return nil, fmt.Errorf("command health checks require micropod-init, which was not found (set MICROPOD_INIT)")
+		}
+		fmt.Printf("Warning: ignoring the image's health check, which requires micropod-init\n")
+		return nil, nil
+	}
+
+	if check.Interval <= 0 {
+		check.Interval = defaultHealthInterval
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = defaultHealthTimeout
+	}
+	if check.Retries <= 0 {
+		check.Retries = defaultHealthRetries
+	}
+	return &check, nil
+}
+
+// recordHealthResult adds the result of a check to health and returns the
+// updated health. A VM is healthy after a successful check and unhealthy
+// after Retries consecutive failures; failures during the start period do
+// not count until a check has succeeded.
+func recordHealthResult(health *state.Health, check image.HealthConfig, result state.HealthResult, inStartPeriod bool) *state.Health {
+	updated := state.Health{Status: state.HealthStarting}
+	if health != nil {
+		updated = *health
+	}
+	updated.Log = append(append([]state.HealthResult(nil), updated.Log...), result)
+	if len(updated.Log) > healthLogLimit {
+		updated.Log = updated.Log[len(updated.Log)-healthLogLimit:]
+	}
+
+	if result.ExitCode == 0 {
+		updated.Status = state.HealthHealthy
+		updated.FailingStreak = 0
+		return &updated
+	}
+	if inStartPeriod && updated.Status == state.HealthStarting {
+		return &updated
+	}
+	updated.FailingStreak++
+	if updated.FailingStreak >= check.Retries {
+		updated.Status = state.HealthUnhealthy
+	}
+	return &updated
+}
+
+// HealthStatus returns the health status of a VM: starting, healthy or
+// unhealthy while it runs with a health check, and none otherwise.
+func HealthStatus(vm state.VM) string {
+	if vm.Health == nil {
+		return "none"
+	}
+	return vm.Health.Status
+}
+
+// healthMonitor is the goroutine checking the health of a running VM.
+type healthMonitor struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// startHealthCheck starts checking the health of a VM that was just
+// launched, if it has a health check.
+func (m *Manager) startHealthCheck(vm state.VM) {
+	if vm.HealthCheck == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	monitor := &healthMonitor{cancel: cancel, done: make(chan struct{})}
+
+	m.healthMu.Lock()
+	previous := m.healthMonitors[vm.ID]
+	m.healthMonitors[vm.ID] = monitor
+	m.healthMu.Unlock()
+	if previous != nil {
+		previous.cancel()
+	}
+
+	go func() {
+		defer close(monitor.done)
+		m.monitorHealth(ctx, vm.ID, *vm.HealthCheck, vm.StartedAt)
+	}()
+}
+
+// stopHealthCheck stops checking the health of a VM and waits until no
+// result of it can be recorded anymore.
+func (m *Manager) stopHealthCheck(vmID string) {
+	m.healthMu.Lock()
+	monitor := m.healthMonitors[vmID]
+	delete(m.healthMonitors, vmID)
+	m.healthMu.Unlock()
+
+	if monitor != nil {
+		monitor.cancel()
+		<-monitor.done
+	}
+}
+
+// monitorHealth checks a VM every interval until ctx is done, starting
+// right away so that a healthy VM does not wait an interval to be found
+// healthy. Checks are skipped while the VM is paused.
+func (m *Manager) monitorHealth(ctx context.Context, vmID string, check image.HealthConfig, startedAt time.Time) {
+	ticker := time.NewTicker(check.Interval)
+	defer ticker.Stop()
+
+	for m.checkHealth(ctx, vmID, check, startedAt) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkHealth checks a VM once and records the result. It returns false
+// when monitoring ends: the VM is gone, ctx is done, or the VM turned
+// unhealthy and is restarted.
+func (m *Manager) checkHealth(ctx context.Context, vmID string, check image.HealthConfig, startedAt time.Time) bool {
+	vm, err := m.store.GetVM(vmID)
+	if err != nil {
+		return false
+	}
+	if vm.State != "Running" {
+		return true
+	}
+
+	result := m.runHealthCheck(ctx, *vm, check)
+	if ctx.Err() != nil {
+		return false
+	}
+
+	inStartPeriod := result.Start.Before(startedAt.Add(check.StartPeriod))
+	health := recordHealthResult(vm.Health, check, result, inStartPeriod)
+	if err := m.store.UpdateVMHealth(vmID, health); err != nil {
+		fmt.Printf("Warning: failed to record health of VM %s: %v\n", vmID, err)
+		return true
+	}
+
+	if vm.Health == nil || vm.Health.Status != health.Status {
+		m.events.Publish(Event{
+			Type:         EventHealthStatus,
+			VMID:         vm.ID,
+			Name:         vm.Name,
+			Image:        vm.ImageName,
+			HealthStatus: health.Status,
+		})
+		if health.Status == state.HealthUnhealthy && shouldRestart(vm.RestartPolicy, vm.RestartCount, true) {
+			// Restarting stops this monitor, which must not wait for
+			// itself.
+			go m.restartUnhealthy(vmID)
+			return false
+		}
+	}
+	return true
+}
+
+// runHealthCheck runs a single check against a VM. A check that cannot run
+// or times out fails with exit code 1.
+func (m *Manager) runHealthCheck(ctx context.Context, vm state.VM, check image.HealthConfig) state.HealthResult {
+	result := state.HealthResult{Start: time.Now()}
+
+	var err error
+	if check.Test[0] == "TCP" {
+		err = checkTCP(ctx, vm, check.Test[1], check.Timeout)
+	} else {
+		var exec *agent.ExecResult
+		if exec, err = execHealthCommand(ctx, vm, check); err == nil {
+			result.ExitCode, result.Output = exec.ExitCode, exec.Output
+		}
+	}
+	if err != nil {
+		result.ExitCode, result.Output = 1, err.Error()
+	}
+
+	result.End = time.Now()
+	return result
+}
+
+// execHealthCommand runs a CMD or CMD-SHELL check through the guest agent,
+// in the workload's working directory.
+func execHealthCommand(ctx context.Context, vm state.VM, check image.HealthConfig) (*agent.ExecResult, error) {
+	command := check.Test[1:]
+	if check.Test[0] == "CMD-SHELL" {
+		command = []string{"/bin/sh", "-c", check.Test[1]}
+	}
+	var dir string
+	if vm.ImageConfig != nil {
+		dir = vm.ImageConfig.WorkingDir
+	}
+
+	type outcome struct {
+		result *agent.ExecResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := agentClientFor(vm).Exec(command, dir, check.Timeout)
+		done <- outcome{result, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case o := <-done:
+		return o.result, o.err
+	}
+}
+
+// checkTCP connects to a port of the VM's first network address.
+func checkTCP(ctx context.Context, vm state.VM, port string, timeout time.Duration) error {
+	if len(vm.Networks) == 0 {
+		return fmt.Errorf("VM has no network address")
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(addressOf(vm.Networks[0]), port))
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+// restartUnhealthy kills a VM that turned unhealthy. Its exit is handled
+// like any other, by the watcher of its process or, for VMs started by an
+// earlier daemon, by the supervisor, and counts as a failure, so that its
+// restart policy restarts it.
+func (m *Manager) restartUnhealthy(vmID string) {
+	vm, err := m.store.GetVM(vmID)
+	if err != nil || vm.State != "Running" {
+		return
+	}
+
+	fmt.Printf("VM %s is unhealthy, killing it\n", vmID)
+	m.healthMu.Lock()
+	m.unhealthyKills[vmID] = true
+	m.healthMu.Unlock()
+
+	if client := m.trackedClient(vmID); client != nil {
+		if err := client.Stop(); err != nil {
+			fmt.Printf("Warning: failed to stop process %d: %v\n", vm.FirecrackerPid, err)
+		}
+	} else if m.isProcessRunning(*vm) {
+		if err := m.killProcess(vm.FirecrackerPid); err != nil {
+			fmt.Printf("Warning: failed to kill process %d: %v\n", vm.FirecrackerPid, err)
+		}
+	}
+}
+
+// killedUnhealthy reports whether restartUnhealthy killed a VM, and
+// forgets it.
+func (m *Manager) killedUnhealthy(vmID string) bool {
+	m.healthMu.Lock()
+	defer m.healthMu.Unlock()
+
+	killed := m.unhealthyKills[vmID]
+	delete(m.unhealthyKills, vmID)
+	return killed
+}
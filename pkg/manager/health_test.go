@@ -0,0 +1,99 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"micropod/pkg/image"
+	"micropod/pkg/state"
+)
+
+func TestResolveHealthCheck(t *testing.T) {
+	imageCheck := &image.HealthConfig{
+		Test:     []string{"CMD-SHELL", "pg_isready"},
+		Interval: 5 * time.Second,
+		Retries:  5,
+	}
+
+	check, err := resolveHealthCheck(VMSpec{}, imageCheck, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Interval != 5*time.Second || check.Timeout != defaultHealthTimeout || check.Retries != 5 {
+		t.Errorf("image check = %+v", check)
+	}
+
+	spec := VMSpec{HealthCheck: &HealthCheckSpec{Interval: "1s", StartPeriod: "10s"}}
+	check, err = resolveHealthCheck(spec, imageCheck, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Test[1] != "pg_isready" || check.Interval != time.Second || check.StartPeriod != 10*time.Second {
+		t.Errorf("overridden check = %+v", check)
+	}
+
+	spec = VMSpec{HealthCheck: &HealthCheckSpec{Test: []string{"NONE"}}}
+	if check, err := resolveHealthCheck(spec, imageCheck, true); err != nil || check != nil {
+		t.Errorf("disabled check = %+v, %v", check, err)
+	}
+
+	// The image's check needs micropod-init; one asked for is an error.
+	if check, err := resolveHealthCheck(VMSpec{}, imageCheck, false); err != nil || check != nil {
+		t.Errorf("image check without init = %+v, %v", check, err)
+	}
+	spec = VMSpec{HealthCheck: &HealthCheckSpec{Test: []string{"CMD", "true"}}}
+	if _, err := resolveHealthCheck(spec, nil, false); err == nil {
+		t.Error("command check without init succeeded")
+	}
+
+	spec = VMSpec{HealthCheck: &HealthCheckSpec{Test: []string{"TCP", "5432"}}}
+	if _, err := resolveHealthCheck(spec, nil, false); err == nil {
+		t.Error("TCP check without a network succeeded")
+	}
+	spec.Networks = []string{"backend"}
+	if check, err := resolveHealthCheck(spec, nil, false); err != nil || check == nil {
+		t.Errorf("TCP check = %+v, %v", check, err)
+	}
+
+	for _, test := range [][]string{{"CMD"}, {"CMD-SHELL", "a", "b"}, {"TCP", "http"}, {"HTTP", "/"}} {
+		if err := (HealthCheckSpec{Test: test}).Validate(); err == nil {
+			t.Errorf("Validate(%q) succeeded", test)
+		}
+	}
+	if err := (HealthCheckSpec{Interval: "often"}).Validate(); err == nil {
+		t.Error("Validate(interval: often) succeeded")
+	}
+}
+
+func TestRecordHealthResult(t *testing.T) {
+	check := image.HealthConfig{Retries: 2}
+	pass := state.HealthResult{ExitCode: 0}
+	fail := state.HealthResult{ExitCode: 1, Output: "connection refused"}
+
+	// Failures in the start period do not count while starting.
+	health := recordHealthResult(nil, check, fail, true)
+	if health.Status != state.HealthStarting || health.FailingStreak != 0 {
+		t.Errorf("after start period failure: %+v", health)
+	}
+
+	health = recordHealthResult(health, check, pass, true)
+	if health.Status != state.HealthHealthy {
+		t.Errorf("after success: %+v", health)
+	}
+
+	health = recordHealthResult(health, check, fail, true)
+	if health.Status != state.HealthHealthy || health.FailingStreak != 1 {
+		t.Errorf("after one failure: %+v", health)
+	}
+	health = recordHealthResult(health, check, fail, false)
+	if health.Status != state.HealthUnhealthy || health.FailingStreak != 2 {
+		t.Errorf("after two failures: %+v", health)
+	}
+
+	for i := 0; i < healthLogLimit; i++ {
+		health = recordHealthResult(health, check, pass, false)
+	}
+	if len(health.Log) != healthLogLimit || health.Status != state.HealthHealthy || health.FailingStreak != 0 {
+		t.Errorf("after recovering: %+v", health)
+	}
+}
@@ -0,0 +1,190 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"micropod/pkg/firecracker"
+	"micropod/pkg/kernel"
+)
+
+// resolveKernel returns the kernel a VM boots: the named one, else the
+// registry's default, else the legacy vmlinux/vmlinux.elf, which has no name.
+func (m *Manager) resolveKernel(name string) (*kernel.Kernel, error) {
+	if name != "" {
+		return m.kernels.Get(name)
+	}
+
+	k, err := m.kernels.Default()
+	if err != nil {
+		return nil, err
+	}
+	if k != nil {
+		return k, nil
+	}
+
+	path := m.config.GetKernelPath()
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("no kernel to boot: add one with micropod kernel add --default, or place one at %s", path)
+	}
+	format, version, err := kernel.ReadVersion(path)
+	if err != nil {
+		return nil, err
+	}
+	return &kernel.Kernel{Path: path, Format: format, Version: version}, nil
+}
+
+// bootSettings returns the kernel, initrd and kernel arguments of a spec:
+// its own, or those of its profile.
+func (m *Manager) bootSettings(spec VMSpec) (*kernel.Kernel, string, string, error) {
+	profile, err := m.config.GetProfile(spec.Profile)
+	if err != nil {
+		return nil, "", "", err
+	}
+	kernelName := spec.Kernel
+	if kernelName == "" {
+		kernelName = profile.Kernel
+	}
+	bootKernel, err := m.resolveKernel(kernelName)
+	if err != nil {
+		return nil, "", "", err
+	}
+	initrd := spec.Initrd
+	if initrd == "" {
+		initrd = profile.Initrd
+	}
+	if err := checkInitrd(initrd); err != nil {
+		return nil, "", "", err
+	}
+	return bootKernel, initrd, firecracker.MergeBootArgs(profile.KernelArgs, spec.KernelArgs), nil
+}
+
+// checkKernelArgs rejects parameters micropod sets itself and depends on:
+// the root device, the serial console the console log is read from, the
+// panic and reboot behaviour that make a guest's Firecracker process exit,
+// micropod-init as init, the address MMDS is reached from, and ro for a
+// read-only rootfs.
+func checkKernelArgs(args string, guestInit, mmds, readOnly bool) error {
+	for _, param := range strings.Fields(args) {
+		if param == "--" {
+			break
+		}
+
+		switch key := firecracker.BootArgKey(param); {
+		case key == "root":
+			return fmt.Errorf("kernel argument %s cannot be overridden: the rootfs is always /dev/vda", param)
+		case key == "console":
+			return fmt.Errorf("kernel argument %s cannot be overridden: the console log is read from ttyS0", param)
+		case key == "panic" || key == "reboot":
+			return fmt.Errorf("kernel argument %s cannot be overridden: the VM exits when its guest panics or reboots", param)
+		case key == "init" && guestInit:
+			return fmt.Errorf("kernel argument %s cannot be overridden: the VM boots micropod-init", param)
+		case key == "ip" && mmds:
+			return fmt.Errorf("kernel argument %s cannot be overridden: the VM reaches MMDS through it", param)
+		case key == "rw" && param != "ro" && readOnly:
+			return fmt.Errorf("kernel argument %s cannot be overridden: the rootfs is read-only", param)
+		}
+	}
+	return nil
+}
+
+// checkInitrd checks that an initrd, if any, is a file.
+func checkInitrd(path string) error {
+	if path == "" {
+		return nil
+	}
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("initrd path %s must be absolute", path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to find initrd: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("initrd %s is not a regular file", path)
+	}
+	return nil
+}
+
+// AddKernel imports the kernel image at path, verifying it against
+// sha256 when given, and optionally makes it the default.
+func (m *Manager) AddKernel(name, path, sha256 string, makeDefault bool) (*kernel.Kernel, error) {
+	k, err := m.kernels.Add(name, path, sha256)
+	if err != nil {
+		return nil, err
+	}
+
+	if makeDefault {
+		if err := m.kernels.SetDefault(name); err != nil {
+			return nil, err
+		}
+		k.Default = true
+	}
+	return k, nil
+}
+
+// GetKernel returns a kernel together with the VMs booting it.
+func (m *Manager) GetKernel(name string) (*kernel.Kernel, error) {
+	k, err := m.kernels.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if k.UsedBy, err = m.kernelUsers(name); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// ListKernels returns all kernels together with the VMs booting them.
+func (m *Manager) ListKernels() ([]kernel.Kernel, error) {
+	kernels, err := m.kernels.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range kernels {
+		if kernels[i].UsedBy, err = m.kernelUsers(kernels[i].Name); err != nil {
+			return nil, err
+		}
+	}
+	return kernels, nil
+}
+
+// RemoveKernel deletes a kernel that no VM boots, including exited VMs
+// whose records are kept and would boot it again on restart.
+func (m *Manager) RemoveKernel(name string) error {
+	users, err := m.kernelUsers(name)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return &kernel.InUseError{Name: name, VMIDs: users}
+	}
+
+	return m.kernels.Remove(name)
+}
+
+// SetDefaultKernel makes name the kernel VMs boot when they select none.
+func (m *Manager) SetDefaultKernel(name string) error {
+	return m.kernels.SetDefault(name)
+}
+
+// kernelUsers returns the IDs of the VMs booting a kernel.
+func (m *Manager) kernelUsers(name string) ([]string, error) {
+	vms, err := m.store.ListVMs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VMs: %w", err)
+	}
+
+	var users []string
+	for _, vm := range vms {
+		if vm.Kernel == name {
+			users = append(users, vm.ID)
+		}
+	}
+	return users, nil
+}
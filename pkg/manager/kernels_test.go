@@ -0,0 +1,31 @@
+package manager
+
+import "testing"
+
+func TestCheckKernelArgs(t *testing.T) {
+	tests := []struct {
+		args                      string
+		guestInit, mmds, readOnly bool
+		wantErr                   bool
+	}{
+		{"quiet sysctl.vm.swappiness=10", true, true, true, false},
+		{"root=/dev/vdb", false, false, false, true},
+		{"console=tty0", false, false, false, true},
+		{"panic=0", false, false, false, true},
+		{"reboot=t", false, false, false, true},
+		{"init=/bin/sh", true, false, false, true},
+		{"init=/bin/sh", false, false, false, false},
+		{"ip=dhcp", false, true, false, true},
+		{"ip=dhcp", false, false, false, false},
+		{"rw", false, false, true, true},
+		{"ro", false, false, true, false},
+		{"quiet -- root=/dev/vdb", false, false, false, false},
+	}
+
+	for _, tt := range tests {
+		err := checkKernelArgs(tt.args, tt.guestInit, tt.mmds, tt.readOnly)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkKernelArgs(%q, %v, %v, %v) = %v, want error %v", tt.args, tt.guestInit, tt.mmds, tt.readOnly, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package manager
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Labels compose sets on the VMs of a project. The service is also a name
+// the VM is reached by from the other VMs of its network.
+const (
+	ProjectLabel = "micropod.project"
+	ServiceLabel = "micropod.service"
+)
+
+var labelKeyPattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9_./-]*[a-zA-Z0-9])?$`)
+
+// ParseLabels parses "key=value" pairs, as given to run --label and
+// --annotation, into a map. A bare key has an empty value.
+func ParseLabels(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	labels := make(map[string]string, len(values))
+	for _, value := range values {
+		key, val, _ := strings.Cut(value, "=")
+		if err := validateLabelKey(key); err != nil {
+			return nil, err
+		}
+		labels[key] = val
+	}
+	return labels, nil
+}
+
+func validateLabels(labels map[string]string) error {
+	for key := range labels {
+		if err := validateLabelKey(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateLabelKey(key string) error {
+	if !labelKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid label key %q: only [a-zA-Z0-9_./-] are allowed, starting and ending with an alphanumeric character", key)
+	}
+	return nil
+}
+
+// LabelRequirement is a single term of a label selector.
+type LabelRequirement struct {
+	Key string
+	// Op is one of "exists", "!exists", "=" and "!=".
+	Op    string
+	Value string
+}
+
+// ParseLabelRequirement parses "key", "!key", "key=value" or "key!=value".
+func ParseLabelRequirement(s string) (LabelRequirement, error) {
+	var r LabelRequirement
+	switch {
+	case strings.HasPrefix(s, "!"):
+		r = LabelRequirement{Key: s[1:], Op: "!exists"}
+	case strings.Contains(s, "!="):
+		key, value, _ := strings.Cut(s, "!=")
+		r = LabelRequirement{Key: key, Op: "!=", Value: value}
+	case strings.Contains(s, "="):
+		key, value, _ := strings.Cut(s, "=")
+		r = LabelRequirement{Key: key, Op: "=", Value: value}
+	default:
+		r = LabelRequirement{Key: s, Op: "exists"}
+	}
+
+	if err := validateLabelKey(r.Key); err != nil {
+		return r, fmt.Errorf("invalid label selector %q: %w", s, err)
+	}
+	return r, nil
+}
+
+// Matches reports whether labels satisfy the requirement.
+func (r LabelRequirement) Matches(labels map[string]string) bool {
+	value, ok := labels[r.Key]
+	switch r.Op {
+	case "exists":
+		return ok
+	case "!exists":
+		return !ok
+	case "=":
+		return ok && value == r.Value
+	case "!=":
+		return !ok || value != r.Value
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"micropod/pkg/state"
+)
+
+// logPollInterval is how often a followed console log is checked for new
+// output.
+const logPollInterval = 250 * time.Millisecond
+
+// maxConsoleLogSize is the size beyond which a console log is rotated.
+const maxConsoleLogSize = 16 << 20
+
+// Logs returns the console log of a VM. With follow, reads at the end of the
+// log wait for more output and return io.EOF once the VM has exited and is
+// not restarted, or ctx is done.
+func (m *Manager) Logs(ctx context.Context, ref string, follow bool) (io.ReadCloser, error) {
+	vm, err := m.store.ResolveVM(ref)
+	if err != nil {
+		return nil, err
+	}
+	if vm.LogPath == "" {
+		return nil, fmt.Errorf("VM %s has no console log", vm.ID)
+	}
+
+	f, err := os.Open(vm.LogPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open console log: %w", err)
+	}
+
+	return &logReader{manager: m, ctx: ctx, file: f, vmID: vm.ID, follow: follow}, nil
+}
+
+type logReader struct {
+	manager *Manager
+	ctx     context.Context
+	file    *os.File
+	vmID    string
+	follow  bool
+}
+
+func (r *logReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.file.Read(p)
+		if n > 0 || err != io.EOF || !r.follow {
+			return n, err
+		}
+		r.rewindIfRotated()
+
+		// Output written before the VM was seen exiting is read once
+		// more. An auto-removed VM's log stays readable through the
+		// open file.
+		if vm, err := r.manager.store.GetVM(r.vmID); err != nil || vm.State == "Exited" {
+			r.follow = false
+			continue
+		}
+
+		select {
+		case <-r.ctx.Done():
+			return 0, io.EOF
+		case <-time.After(logPollInterval):
+		}
+	}
+}
+
+// rewindIfRotated continues reading a log emptied by rotation at its start.
+func (r *logReader) rewindIfRotated() {
+	offset, err := r.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+	if info, err := r.file.Stat(); err == nil && info.Size() < offset {
+		r.file.Seek(0, io.SeekStart)
+	}
+}
+
+func (r *logReader) Close() error {
+	return r.file.Close()
+}
+
+// openConsoleLog opens the console log of a VM at the start of its last
+// run. A log rotated since is read from its start.
+func openConsoleLog(vm state.VM) (*os.File, error) {
+	f, err := os.Open(vm.LogPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if vm.LogOffset <= info.Size() {
+		if _, err := f.Seek(vm.LogOffset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// rotateConsoleLogs bounds the console logs of VMs with a Firecracker
+// process, which keeps appending to them.
+func (m *Manager) rotateConsoleLogs() {
+	vms, err := m.store.ListVMs()
+	if err != nil {
+		return
+	}
+	for _, vm := range vms {
+		if vm.LogPath == "" || !hasProcess(vm) {
+			continue
+		}
+		if err := rotateConsoleLog(vm.LogPath); err != nil {
+			fmt.Printf("Warning: failed to rotate console log of VM %s: %v\n", vm.ID, err)
+		}
+	}
+}
+
+// rotateConsoleLog copies a console log larger than maxConsoleLogSize to
+// its backup, replacing the previous one, and empties it. The log is
+// emptied in place since Firecracker holds it open; output written while
+// it is copied is lost.
+func rotateConsoleLog(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if info.Size() < maxConsoleLogSize {
+		return nil
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(rotatedLogPath(path))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to copy console log: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Truncate(path, 0)
+}
+
+func rotatedLogPath(path string) string {
+	return path + ".1"
+}
+
+func (m *Manager) getLogPath(vmID string) string {
+	return filepath.Join(m.config.GetLogsDir(), vmID+".log")
+}
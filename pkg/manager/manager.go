@@ -2,34 +2,111 @@ package manager
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
-	"syscall"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 
+	"micropod/pkg/agent"
+	"micropod/pkg/cgroup"
 	"micropod/pkg/config"
+	"micropod/pkg/console"
 	"micropod/pkg/firecracker"
+	"micropod/pkg/guest"
 	"micropod/pkg/image"
+	"micropod/pkg/kernel"
+	"micropod/pkg/metrics"
+	"micropod/pkg/network"
+	"micropod/pkg/procfs"
 	"micropod/pkg/rootfs"
 	"micropod/pkg/state"
+	"micropod/pkg/volume"
 )
 
 type Manager struct {
-	config 			  *config.Config
+	config        *config.Config
 	store         *state.Store
 	imageService  image.ImageService
 	rootfsCreator *rootfs.Creator
+	volumes       *volume.Store
+	kernels       *kernel.Store
+	networks      *network.Store
+	events        *EventBus
+
+	// clients holds the Firecracker processes started by this manager,
+	// keyed by VM ID, so their exit can be observed directly.
+	clientsMu sync.Mutex
+	clients   map[string]*firecracker.Client
+
+	// consoles holds the consoles of running TTY VMs, keyed by VM ID.
+	consolesMu sync.Mutex
+	consoles   map[string]*console.Console
+
+	// reservedUIDs and reservedTaps hold the jailer uids and tap devices
+	// handed out by this manager until they are cleaned up, so that VMs
+	// launched concurrently, before either is stored, get different ones.
+	allocMu      sync.Mutex
+	reservedUIDs map[int]bool
+	reservedTaps map[string]bool
+
+	// reservedAddresses holds the network addresses, as network/address,
+	// allocated to VMs that are being created and not stored yet.
+	addressesMu       sync.Mutex
+	reservedAddresses map[string]bool
+
+	// healthMonitors holds the health check goroutines of running VMs,
+	// and unhealthyKills the VMs killed for being unhealthy whose exit is
+	// not handled yet, keyed by VM ID.
+	healthMu       sync.Mutex
+	healthMonitors map[string]*healthMonitor
+	unhealthyKills map[string]bool
+
+	// pools holds the pools of config.json and their booted VMs, keyed by
+	// pool name. poolWake asks RunPools to refill them.
+	poolsMu         sync.Mutex
+	pools           map[string]*vmPool
+	poolWake        chan struct{}
+	poolInitWarning sync.Once
+
+	// metricsReaders sum up the Firecracker metrics of running VMs, keyed by
+	// VM ID.
+	metricsMu      sync.Mutex
+	metricsReaders map[string]*firecracker.MetricsReader
+
+	// exitListeners receive the exit status reported by the guests of
+	// running VMs, keyed by VM ID.
+	exitMu        sync.Mutex
+	exitListeners map[string]*exitListener
+}
+
+// VMStats is the resource usage of a VM.
+type VMStats struct {
+	VM          state.VM             `json:"vm"`
+	CollectedAt time.Time            `json:"collectedAt"`
+	Cgroup      *cgroup.Stats        `json:"cgroup,omitempty"`
+	Process     *procfs.ProcessStats `json:"process,omitempty"`
+	Firecracker *firecracker.Metrics `json:"firecracker,omitempty"`
 }
 
-type VMConfig struct {
-	VCPUs    int
-	MemoryMB int
+// VMInspect is the full record of a VM together with the configuration its
+// Firecracker process reports.
+type VMInspect struct {
+	state.VM
+	// FirecrackerConfig is the response of Firecracker's GET /vm/config. It
+	// is empty when the VM has no running process or the API is unreachable.
+	FirecrackerConfig json.RawMessage `json:"firecrackerConfig,omitempty"`
 }
 
+// jailerUIDBase is the first uid/gid handed out to jailed VMs.
+const jailerUIDBase = 900000
+
 func NewManager() *Manager {
 	cfg := config.NewConfig()
 	if err := cfg.EnsureConfigDir(); err != nil {
@@ -51,145 +128,958 @@ func NewManager() *Manager {
 		log.Fatal("Error initializing rootfs creator:", err)
 	}
 
-	return &Manager{
+	volumes, err := volume.NewStore(cfg.GetVolumesDir())
+	if err != nil {
+		log.Fatal("Error initializing volume store:", err)
+	}
+
+	kernels, err := kernel.NewStore(cfg.GetKernelsDir())
+	if err != nil {
+		log.Fatal("Error initializing kernel store:", err)
+	}
+
+	networks, err := network.NewStore(cfg.GetNetworksDir())
+	if err != nil {
+		log.Fatal("Error initializing network store:", err)
+	}
+
+	events, err := NewEventBus(cfg.GetEventsFilePath())
+	if err != nil {
+		log.Fatal("Error initializing event bus:", err)
+	}
+
+	m := &Manager{
 		config:        cfg,
 		store:         store,
 		imageService:  imageService,
 		rootfsCreator: rootfsCreator,
+		volumes:       volumes,
+		kernels:       kernels,
+		networks:      networks,
+		events:        events,
+		clients:       make(map[string]*firecracker.Client),
+		consoles:      make(map[string]*console.Console),
+
+		reservedUIDs:      make(map[int]bool),
+		reservedTaps:      make(map[string]bool),
+		reservedAddresses: make(map[string]bool),
+		healthMonitors:    make(map[string]*healthMonitor),
+		unhealthyKills:    make(map[string]bool),
+		pools:             make(map[string]*vmPool),
+		poolWake:          make(chan struct{}, 1),
+		metricsReaders:    make(map[string]*firecracker.MetricsReader),
+		exitListeners:     make(map[string]*exitListener),
 	}
+	metrics.Default.Collect(m.writeMetrics)
+
+	return m
 }
 
-func (m *Manager) RunVM(imageName string) (string, error) {
-	fmt.Printf("Starting VM for image: %s\n", imageName)
+// RunVM creates and starts a VM as described by spec and returns its ID.
+func (m *Manager) RunVM(spec VMSpec) (_ string, err error) {
+	start := time.Now()
+	source := "new"
+	defer func() {
+		if err != nil {
+			runFailures.Inc()
+			return
+		}
+		runDuration.Since(start, source)
+	}()
+
+	if err := spec.Validate(); err != nil {
+		return "", err
+	}
+	spec.APIVersion, spec.Kind = SpecAPIVersion, SpecKind
+	specHash, err := spec.Hash()
+	if err != nil {
+		return "", err
+	}
+	imageName := spec.Image
+
+	limits := spec.Limits
+	if spec.MemoryOverheadMB > 0 {
+		limits.MemoryMaxMB = spec.MemoryMB + spec.MemoryOverheadMB
+	}
+	if err := limits.Validate(); err != nil {
+		return "", fmt.Errorf("invalid resource limits: %w", err)
+	}
+
+	bootTimeout, err := parseBootTimeout(spec.BootTimeout)
+	if err != nil {
+		return "", err
+	}
+
+	restartPolicy, err := ParseRestartPolicy(spec.Restart)
+	if err != nil {
+		return "", err
+	}
+	if spec.AutoRemove && restartPolicy.Name != "no" {
+		return "", fmt.Errorf("auto-remove cannot be combined with restart policy %s", restartPolicy)
+	}
+
+	metadata := Metadata{
+		Env:         spec.Env,
+		Command:     spec.Command,
+		Secrets:     spec.Secrets,
+		Labels:      spec.Labels,
+		Annotations: spec.Annotations,
+	}
+	if err := metadata.Validate(); err != nil {
+		return "", err
+	}
+	mmdsVersion, err := resolveMMDSVersion(spec.MMDSVersion, metadata)
+	if err != nil {
+		return "", err
+	}
+
+	if spec.Name != "" {
+		if err := state.ValidateName(spec.Name); err != nil {
+			return "", err
+		}
+		// Fail before building the rootfs; AddVM enforces uniqueness.
+		if err := m.checkNameFree(spec.Name); err != nil {
+			return "", err
+		}
+	}
+
+	bootKernel, initrd, kernelArgs, err := m.bootSettings(spec)
+	if err != nil {
+		return "", err
+	}
+
+	if err := m.validateMounts(spec.Mounts); err != nil {
+		return "", err
+	}
+	if err := validateWritableDirs("tmpfs", spec.Tmpfs); err != nil {
+		return "", err
+	}
+	if err := validateWritableDirs("overlay", spec.Overlays); err != nil {
+		return "", err
+	}
+
+	initPath := m.config.GetGuestInitPath()
+	if initPath == "" {
+		if len(spec.Mounts) > 0 || len(spec.Tmpfs) > 0 || len(spec.Overlays) > 0 {
+			return "", fmt.Errorf("mounts require micropod-init, which was not found (set MICROPOD_INIT)")
+		}
+		fmt.Printf("Warning: micropod-init not found, booting the image's own init\n")
+	}
+	if err := checkKernelArgs(kernelArgs, initPath != "", mmdsVersion != "", spec.ReadOnly); err != nil {
+		return "", err
+	}
+	if err := validateNetworks(spec, initPath != ""); err != nil {
+		return "", err
+	}
+	if err := checkBootObservable(kernelArgs, initPath != "", bootTimeout); err != nil {
+		return "", err
+	}
 
 	vmID := uuid.New().String()
 	ctx := context.Background()
 
 	// Pull the image if not exists locally
-	_, err := m.imageService.PullImage(ctx, imageName)
+	img, err := m.imageService.PullImage(ctx, imageName)
 	if err != nil {
 		return "", fmt.Errorf("failed to pull image: %w", err)
 	}
 
-	// Create temporary directory for unpacking
-	tempDir := filepath.Join("/tmp", "micropod-unpack-"+vmID)
-	defer os.RemoveAll(tempDir)
-
-	// Unpack the image to the temporary directory
-	_, err = m.imageService.Unpack(ctx, imageName, tempDir)
+	healthCheck, err := resolveHealthCheck(spec, img.Config().Healthcheck, initPath != "")
 	if err != nil {
-		return "", fmt.Errorf("failed to unpack image: %w", err)
+		return "", err
 	}
 
-	// Create ext4 rootfs from the unpacked directory
-	rootfsPath, err := m.rootfsCreator.CreateFromDir(tempDir, vmID)
-	if err != nil {
-		return "", fmt.Errorf("failed to create rootfs: %w", err)
+	// The pull may have taken long enough for the name to be taken, and a
+	// pooled VM handed to a run that then fails is lost to the pool.
+	if err := m.checkNameFree(spec.Name); err != nil {
+		return "", err
 	}
+	if pooled := m.takePooledVM(spec, img.Digest()); pooled != nil {
+		vm := pooled.vm
+		imageConfig := img.Config()
+		vm.Name = spec.Name
+		vm.SpecHash = specHash
+		vm.CreatedAt = time.Now()
+		vm.RestartPolicy = restartPolicy
+		vm.AutoRemove = spec.AutoRemove
+		vm.Tmpfs = spec.Tmpfs
+		vm.Overlays = spec.Overlays
+		vm.HealthCheck = healthCheck
+		vm.BootTimeout = bootTimeout
+		vm.ImageConfig = &imageConfig
+		metadata.applyTo(&vm)
 
-	kernelPath := m.config.GetKernelPath()
+		err := m.startPooledVM(&vm, pooled.client)
+		if err == nil {
+			m.announceVM(vm, pooled.client)
+			source = "pool"
+			return vm.ID, nil
+		}
+		var inUse *state.NameInUseError
+		if errors.As(err, &inUse) {
+			return "", err
+		}
+		fmt.Printf("Warning: failed to start pooled VM %s, creating a new one: %v\n", vm.ID, err)
+	}
 
-	socketPath := m.getSocketPath(vmID)
+	var rootfsPath string
+	if spec.ReadOnly {
+		rootfsPath, err = m.sharedRootfs(ctx, img, imageName, initPath, mountPoints(spec), vmID)
+	} else {
+		rootfsPath, err = m.buildRootfs(ctx, imageName, initPath, nil, vmID)
+	}
+	if err != nil {
+		return "", err
+	}
 
-	client := firecracker.NewClient(socketPath)
+	createdVolumes, err := m.createVolumes(spec.Mounts)
+	if err != nil {
+		m.rootfsCreator.RemoveRootfs(rootfsPath)
+		return "", err
+	}
 
-	config := VMConfig{
-		VCPUs:    1,
-		MemoryMB: 512,
+	mounts := append([]state.Mount(nil), spec.Mounts...)
+	if err := m.packMounts(vmID, mounts); err != nil {
+		m.rootfsCreator.RemoveRootfs(rootfsPath)
+		m.removeVolumes(createdVolumes)
+		return "", err
 	}
 
-	if err := client.LaunchVM(kernelPath, rootfsPath, config.VCPUs, config.MemoryMB); err != nil {
+	networks, err := m.attachNetworks(spec.Networks)
+	if err != nil {
 		m.rootfsCreator.RemoveRootfs(rootfsPath)
-		return "", fmt.Errorf("failed to launch VM: %w", err)
+		m.removeMountImages(mounts)
+		m.removeVolumes(createdVolumes)
+		return "", err
 	}
+	defer m.releaseAddresses(networks)
 
 	vm := state.VM{
-		ID:             vmID,
-		ImageName:      imageName,
-		State:          "Running",
-		FirecrackerPid: client.GetPID(),
-		VMSocketPath:   socketPath,
-		RootfsPath:     rootfsPath,
-		KernelPath:     kernelPath,
-		CreatedAt:      time.Now(),
+		ID:            vmID,
+		Name:          spec.Name,
+		ImageName:     imageName,
+		State:         "Created",
+		RootfsPath:    rootfsPath,
+		KernelPath:    bootKernel.Path,
+		Kernel:        bootKernel.Name,
+		KernelVersion: bootKernel.Version,
+		KernelArgs:    kernelArgs,
+		InitrdPath:    initrd,
+		Profile:       spec.Profile,
+		SpecHash:      specHash,
+		CreatedAt:     time.Now(),
+		VCPUs:         spec.VCPUs,
+		MemoryMB:      spec.MemoryMB,
+		Jailed:        spec.Jailer,
+		Limits:        limits,
+		RestartPolicy: restartPolicy,
+		AutoRemove:    spec.AutoRemove,
+		TTY:           spec.TTY,
+		ReadOnly:      spec.ReadOnly,
+		Tmpfs:         spec.Tmpfs,
+		Overlays:      spec.Overlays,
+		MMDSVersion:   mmdsVersion,
+		Mounts:        mounts,
+		Networks:      networks,
+		HealthCheck:   healthCheck,
+		BootTimeout:   bootTimeout,
+	}
+	if initPath != "" {
+		imageConfig := img.Config()
+		vm.GuestInit = true
+		vm.ImageConfig = &imageConfig
+	}
+	metadata.applyTo(&vm)
+
+	client, err := m.launch(&vm)
+	if err != nil {
+		m.cleanup(&vm)
+		m.removeVolumes(createdVolumes)
+		return "", fmt.Errorf("failed to launch VM: %w", err)
 	}
 
 	if err := m.store.AddVM(vm); err != nil {
 		client.Stop()
-		m.rootfsCreator.RemoveRootfs(rootfsPath)
+		m.releaseConsole(vm.ID)
+		m.cleanup(&vm)
+		m.removeVolumes(createdVolumes)
 		return "", fmt.Errorf("failed to store VM state: %w", err)
 	}
 
-	fmt.Printf("VM launched successfully\n")
-	fmt.Printf("  VM ID: %s\n", vmID)
-	fmt.Printf("  Image: %s\n", imageName)
-	fmt.Printf("  PID: %d\n", client.GetPID())
-	fmt.Printf("  Socket: %s\n", socketPath)
-	fmt.Printf("  Rootfs: %s\n", rootfsPath)
-
+	m.announceVM(vm, client)
 	return vmID, nil
 }
 
-func (m *Manager) ListVMs() ([]state.VM, error) {
+// checkNameFree returns a NameInUseError if a recorded VM has name. An
+// empty name is always free.
+func (m *Manager) checkNameFree(name string) error {
+	if name == "" {
+		return nil
+	}
+	if existing, err := m.store.ResolveVM(name); err == nil && existing.Name == name {
+		return &state.NameInUseError{Name: name, ID: existing.ID}
+	}
+	return nil
+}
+
+// announceVM publishes the creation and start of a VM run created, starts
+// watching it and makes it known to the VMs on its networks.
+func (m *Manager) announceVM(vm state.VM, client *firecracker.Client) {
+	m.emit(EventCreate, vm, "")
+	m.emit(EventStart, vm, "")
+	m.trackClient(vm.ID, client)
+	m.startHealthCheck(vm)
+	m.refreshPeerHosts(vm)
+
+	fmt.Printf("Started VM %s from image %s (pid %d)\n", vm.ID, vm.ImageName, vm.FirecrackerPid)
+}
+
+// ListVMs returns the running VMs, or every recorded VM with opts.All, that
+// pass opts.Filter.
+func (m *Manager) ListVMs(opts ListOptions) ([]state.VM, error) {
 	vms, err := m.store.ListVMs()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list VMs: %w", err)
 	}
 
-	var runningVMs []state.VM
+	var matched []state.VM
 	for _, vm := range vms {
-		if m.isProcessRunning(vm.FirecrackerPid) {
-			runningVMs = append(runningVMs, vm)
-		} else {
-			m.cleanupDeadVM(vm)
+		// Pooled VMs belong to the daemon until a run takes them.
+		if vm.State == "Pooled" {
+			continue
+		}
+		if !opts.All && !m.isAlive(vm) {
+			continue
+		}
+		if opts.Filter.Match(vm) {
+			matched = append(matched, vm)
+		}
+	}
+
+	return matched, nil
+}
+
+// Events returns the lifecycle event bus.
+func (m *Manager) Events() *EventBus {
+	return m.events
+}
+
+// PauseVM pauses the vCPUs of a running VM.
+func (m *Manager) PauseVM(ref string) error {
+	return m.setPaused(ref, true)
+}
+
+// ResumeVM resumes a paused VM.
+func (m *Manager) ResumeVM(ref string) error {
+	return m.setPaused(ref, false)
+}
+
+func (m *Manager) setPaused(ref string, paused bool) error {
+	vm, err := m.store.ResolveVM(ref)
+	if err != nil {
+		return err
+	}
+
+	client := firecracker.NewClient(vm.VMSocketPath)
+	newState, eventType := "Running", EventResume
+	if paused {
+		newState, eventType = "Paused", EventPause
+		err = client.Pause()
+	} else {
+		err = client.Resume()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set VM state to %s: %w", newState, err)
+	}
+
+	if err := m.store.UpdateVMState(vm.ID, newState); err != nil {
+		return fmt.Errorf("failed to update VM state: %w", err)
+	}
+
+	vm.State = newState
+	if !paused {
+		m.syncClock(*vm)
+	}
+	m.emit(eventType, *vm, "")
+	return nil
+}
+
+// syncClock sets the guest's clock to the host's, which it falls behind of
+// while paused. Only micropod-init is sure to serve the agent, so failures
+// of other guests are ignored.
+func (m *Manager) syncClock(vm state.VM) {
+	if vm.VsockPath == "" {
+		return
+	}
+	if err := agentClientFor(vm).SyncTime(time.Now()); err != nil && vm.GuestInit {
+		fmt.Printf("Warning: failed to sync clock of VM %s: %v\n", vm.ID, err)
+	}
+}
+
+// launch starts the Firecracker process of vm, waits for the guest to boot
+// and records its runtime resources on vm. Partially created resources are
+// released on failure.
+func (m *Manager) launch(vm *state.VM) (*firecracker.Client, error) {
+	socketPath := m.getSocketPath(vm.ID)
+	client := firecracker.NewClient(socketPath)
+
+	var jail *state.Jailer
+	if vm.Jailed {
+		jailerConfig, err := m.prepareJailer(vm.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare jailer: %w", err)
+		}
+
+		socketPath = jailerConfig.SocketPath()
+		client = firecracker.NewJailedClient(jailerConfig)
+		jail = &state.Jailer{
+			UID:        jailerConfig.UID,
+			GID:        jailerConfig.GID,
+			ChrootPath: jailerConfig.ChrootDir(),
+			NetNS:      m.getNetNSName(vm.ID),
+		}
+	}
+
+	launchConfig := firecracker.LaunchConfig{
+		VMID:         vm.ID,
+		KernelPath:   vm.KernelPath,
+		RootfsPath:   vm.RootfsPath,
+		RootReadOnly: vm.ReadOnly,
+		VCPUs:        vm.VCPUs,
+		MemoryMB:     vm.MemoryMB,
+		Limits:       vm.Limits,
+		MetricsPath:  filepath.Join(m.config.GetMetricsDir(), vm.ID+".fifo"),
+	}
+
+	// The console log is kept across restarts; the exit status of this run
+	// is looked for after its current end.
+	if vm.LogPath == "" {
+		vm.LogPath = m.getLogPath(vm.ID)
+	}
+	if err := rotateConsoleLog(vm.LogPath); err != nil {
+		fmt.Printf("Warning: failed to rotate console log of VM %s: %v\n", vm.ID, err)
+	}
+	vm.LogOffset = 0
+	if info, err := os.Stat(vm.LogPath); err == nil {
+		vm.LogOffset = info.Size()
+	}
+	launchConfig.ConsoleLogPath = vm.LogPath
+
+	if vm.GuestInit {
+		drives, err := m.guestDrives(*vm)
+		if err != nil {
+			m.cleanupJailer(jail)
+			return nil, fmt.Errorf("failed to prepare guest drives: %w", err)
+		}
+		launchConfig.Drives = drives
+		// Keep kernel messages out of the console log, which then holds
+		// the workload's output.
+		launchConfig.BootArgs = []string{"init=" + guest.InitPath, "quiet"}
+	}
+	// Every guest gets a vsock device, through which micropod-init or, in
+	// images booting their own init, micropod-agent serves the agent.
+	launchConfig.VsockPath = m.getVsockPath(vm.ID)
+	launchConfig.VsockListenPorts = exitListenPorts(*vm)
+	if vm.ReadOnly {
+		launchConfig.BootArgs = append(launchConfig.BootArgs, "ro")
+	}
+	launchConfig.BootArgs = append(launchConfig.BootArgs, vm.KernelArgs)
+	launchConfig.InitrdPath = vm.InitrdPath
+
+	var tapDevice string
+	if vm.MMDSVersion != "" {
+		var err error
+		tapDevice, err = m.prepareTap(jail)
+		if err != nil {
+			m.cleanupJailer(jail)
+			return nil, fmt.Errorf("failed to prepare MMDS interface: %w", err)
+		}
+		launchConfig.MMDSVersion = vm.MMDSVersion
+		launchConfig.TapDevice = tapDevice
+		launchConfig.Metadata = metadataOf(*vm)
+	}
+
+	ifaces, err := m.prepareNetworkInterfaces(vm)
+	if err != nil {
+		m.cleanupTap(tapDevice, jail)
+		m.cleanupJailer(jail)
+		return nil, fmt.Errorf("failed to prepare network interfaces: %w", err)
+	}
+	launchConfig.NetworkInterfaces = ifaces
+
+	var vmConsole *console.Console
+	if vm.TTY {
+		var tty *os.File
+		var err error
+		vmConsole, tty, err = m.openConsole(*vm)
+		if err != nil {
+			m.cleanupNetworkInterfaces(vm)
+			m.cleanupTap(tapDevice, jail)
+			m.cleanupJailer(jail)
+			return nil, fmt.Errorf("failed to open console: %w", err)
+		}
+		defer tty.Close()
+		launchConfig.Console = tty
+	}
+
+	bootStart := time.Now()
+	if err := client.LaunchVM(launchConfig); err != nil {
+		if vmConsole != nil {
+			vmConsole.Close()
+		}
+		cgroup.Remove(client.GetCgroupPath())
+		m.cleanupNetworkInterfaces(vm)
+		m.cleanupTap(tapDevice, jail)
+		m.cleanupJailer(jail)
+		return nil, err
+	}
+	if vmConsole != nil {
+		m.trackConsole(vm.ID, vmConsole)
+	}
+	if reader := client.Metrics(); reader != nil {
+		m.trackMetrics(vm.ID, reader)
+	}
+	if listener := client.VsockListener(agent.ExitPort); listener != nil {
+		m.trackExitListener(vm.ID, listener)
+	}
+
+	vm.State = "Running"
+	vm.FirecrackerPid = client.GetPID()
+	vm.VMSocketPath = socketPath
+	vm.Jailer = jail
+	vm.CgroupPath = client.GetCgroupPath()
+	vm.MetricsPath = client.GetMetricsPath()
+	vm.TapDevice = tapDevice
+	vm.VsockPath = client.GetVsockPath()
+	vm.StartedAt = time.Now()
+	vm.Health = nil
+	if vm.HealthCheck != nil {
+		vm.Health = &state.Health{Status: state.HealthStarting}
+	}
+
+	if err := m.waitForBoot(*vm, client); err != nil {
+		bootFailures.Inc()
+		client.Stop()
+		m.releaseConsole(vm.ID)
+		m.cleanupRuntime(vm)
+		return nil, err
+	}
+	bootDuration.Since(bootStart)
+	// Guests without a real-time clock boot at the epoch.
+	select {
+	case <-client.Exited():
+	default:
+		m.syncClock(*vm)
+	}
+
+	return client, nil
+}
+
+// GetVM returns the state of a single VM. Like every method taking a VM
+// reference, it accepts a name, a full ID or an unambiguous ID prefix.
+func (m *Manager) GetVM(ref string) (*state.VM, error) {
+	return m.store.ResolveVM(ref)
+}
+
+// InspectVM returns the state of a VM along with the live configuration of
+// its Firecracker process.
+func (m *Manager) InspectVM(ref string) (*VMInspect, error) {
+	vm, err := m.store.ResolveVM(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	inspect := &VMInspect{VM: *vm}
+	if hasProcess(*vm) && m.isAlive(*vm) {
+		client := firecracker.NewClient(vm.VMSocketPath)
+		config, err := client.GetVMConfig()
+		if err != nil {
+			fmt.Printf("Warning: failed to get Firecracker configuration of VM %s: %v\n", vm.ID, err)
 		}
+		inspect.FirecrackerConfig = config
 	}
 
-	return runningVMs, nil
+	return inspect, nil
 }
 
-func (m *Manager) StopVM(vmID string) error {
-	vm, err := m.store.GetVM(vmID)
+func (m *Manager) StopVM(ref string) error {
+	vm, err := m.store.ResolveVM(ref)
 	if err != nil {
-		return fmt.Errorf("VM not found: %w", err)
+		return err
 	}
+	vmID := vm.ID
 
 	fmt.Printf("Stopping VM: %s\n", vmID)
 
-	if m.isProcessRunning(vm.FirecrackerPid) {
+	m.stopProcess(vm)
+
+	if err := m.removeVM(vm); err != nil {
+		return err
+	}
+
+	fmt.Printf("VM %s stopped and cleaned up\n", vmID)
+	return nil
+}
+
+// stopProcess shuts down the guest of a VM, kills its Firecracker process
+// if it does not exit, and copies out its copy-out directories. The VM's
+// record and resources are left to the caller.
+func (m *Manager) stopProcess(vm *state.VM) {
+	vmID := vm.ID
+	m.stopHealthCheck(vmID)
+
+	client := m.untrackClient(vmID)
+	if vm.State == "Running" && vm.GuestInit {
+		m.shutdownGuest(*vm, client)
+	}
+	if client != nil {
+		if err := client.Stop(); err != nil {
+			fmt.Printf("Warning: failed to stop process %d: %v\n", vm.FirecrackerPid, err)
+		}
+	} else if m.isProcessRunning(*vm) {
 		if err := m.killProcess(vm.FirecrackerPid); err != nil {
 			fmt.Printf("Warning: failed to kill process %d: %v\n", vm.FirecrackerPid, err)
 		}
 	}
+	m.releaseConsole(vmID)
+
+	if hasProcess(*vm) {
+		m.emitExit(EventStop, *vm, "", exitCodeStopped)
+		m.copyOut(*vm)
+	} else {
+		m.emit(EventStop, *vm, "")
+	}
+}
+
+// stopTimeout is how long StopVM waits for a guest to shut down before it
+// kills Firecracker.
+const stopTimeout = 10 * time.Second
+
+// shutdownGuest asks micropod-init to stop the workload and power off, so
+// that the guest's filesystems, including copy-out drives and volumes, are
+// flushed, and waits for Firecracker to exit. client is nil for VMs started
+// by an earlier daemon. A guest still running afterwards is killed by the
+// caller.
+func (m *Manager) shutdownGuest(vm state.VM, client *firecracker.Client) {
+	fc := client
+	if fc == nil {
+		fc = firecracker.NewClient(vm.VMSocketPath)
+	}
+	if err := fc.SendCtrlAltDel(); err != nil {
+		fmt.Printf("Warning: failed to shut down VM %s, killing it: %v\n", vm.ID, err)
+		return
+	}
+
+	deadline := time.After(stopTimeout)
+	if client != nil {
+		select {
+		case <-client.Exited():
+		case <-deadline:
+		}
+		return
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for m.isProcessRunning(vm) {
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// PruneVMs removes the exited VMs that pass filter and returns their IDs.
+func (m *Manager) PruneVMs(filter VMFilter) ([]string, error) {
+	vms, err := m.store.ListVMs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VMs: %w", err)
+	}
+
+	var removed []string
+	for _, vm := range vms {
+		if vm.State != "Exited" || !filter.Match(vm) {
+			continue
+		}
+
+		if err := m.removeVM(&vm); err != nil {
+			return removed, err
+		}
+		removed = append(removed, vm.ID)
+	}
+
+	if err := m.pruneBaseRootfs(); err != nil {
+		return removed, err
+	}
 
+	return removed, nil
+}
+
+// removeVM releases the resources of a VM whose process is gone and deletes
+// its record.
+func (m *Manager) removeVM(vm *state.VM) error {
 	if err := m.cleanup(vm); err != nil {
 		fmt.Printf("Warning: cleanup failed: %v\n", err)
 	}
 
-	if err := m.store.RemoveVM(vmID); err != nil {
+	if err := m.store.RemoveVM(vm.ID); err != nil {
 		return fmt.Errorf("failed to remove VM from state: %w", err)
 	}
 
-	fmt.Printf("VM %s stopped and cleaned up\n", vmID)
+	m.emit(EventDestroy, *vm, "")
+	m.refreshPeerHosts(*vm)
 	return nil
 }
 
+// GetVMStats returns the resource usage of the given VMs, or of every
+// running VM when none is given.
+func (m *Manager) GetVMStats(refs []string) ([]VMStats, error) {
+	var vms []state.VM
+	if len(refs) == 0 {
+		running, err := m.ListVMs(ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		vms = running
+	} else {
+		for _, ref := range refs {
+			vm, err := m.store.ResolveVM(ref)
+			if err != nil {
+				return nil, err
+			}
+			vms = append(vms, *vm)
+		}
+	}
+
+	var stats []VMStats
+	for _, vm := range vms {
+		stats = append(stats, m.collectStats(vm))
+	}
+
+	return stats, nil
+}
+
+// collectStats gathers the cgroup, host process and Firecracker metrics of a
+// VM. Sources that are unavailable are left empty.
+func (m *Manager) collectStats(vm state.VM) VMStats {
+	vmStats := VMStats{VM: vm, CollectedAt: time.Now()}
+
+	if vm.CgroupPath != "" {
+		if cgroupStats, err := cgroup.ReadStats(vm.CgroupPath); err == nil {
+			vmStats.Cgroup = cgroupStats
+		}
+	}
+
+	if processStats, err := procfs.ReadProcessStats(vm.FirecrackerPid); err == nil {
+		vmStats.Process = processStats
+	}
+
+	if reader := m.metricsReader(vm); reader != nil {
+		// A failed flush only means the latest record is counted later.
+		totals := reader.Flush(firecracker.NewClient(vm.VMSocketPath).FlushMetrics)
+		vmStats.Firecracker = &totals
+	}
+
+	return vmStats
+}
+
 func (m *Manager) getSocketPath(vmID string) string {
-	return filepath.Join("/tmp", fmt.Sprintf("firecracker-%s.sock", vmID[:8]))
+	return filepath.Join("/tmp", fmt.Sprintf("firecracker-%s.sock", vmID))
 }
 
-func (m *Manager) isProcessRunning(pid int) bool {
-	if pid <= 0 {
+func (m *Manager) getVsockPath(vmID string) string {
+	return filepath.Join("/tmp", fmt.Sprintf("firecracker-%s.vsock", vmID))
+}
+
+func (m *Manager) getNetNSName(vmID string) string {
+	return "micropod-" + vmID
+}
+
+// prepareJailer allocates a uid/gid and a network namespace for a jailed VM.
+func (m *Manager) prepareJailer(vmID string) (*firecracker.JailerConfig, error) {
+	uid, err := m.allocateJailerUID()
+	if err != nil {
+		return nil, err
+	}
+
+	netnsPath, err := network.CreateNetNS(m.getNetNSName(vmID))
+	if err != nil {
+		m.releaseJailerUID(uid)
+		return nil, err
+	}
+
+	return &firecracker.JailerConfig{
+		ID:            vmID,
+		UID:           uid,
+		GID:           uid,
+		ChrootBaseDir: m.config.GetJailerBaseDir(),
+		NetNS:         netnsPath,
+	}, nil
+}
+
+// allocateJailerUID reserves the lowest uid not used by another jailed VM.
+// cleanupJailer releases it.
+func (m *Manager) allocateJailerUID() (int, error) {
+	m.allocMu.Lock()
+	defer m.allocMu.Unlock()
+
+	vms, err := m.store.ListVMs()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list VMs: %w", err)
+	}
+
+	used := make(map[int]bool)
+	for _, vm := range vms {
+		if vm.Jailer != nil {
+			used[vm.Jailer.UID] = true
+		}
+	}
+
+	uid := jailerUIDBase
+	for used[uid] || m.reservedUIDs[uid] {
+		uid++
+	}
+	m.reservedUIDs[uid] = true
+
+	return uid, nil
+}
+
+// releaseJailerUID drops the reservation made by allocateJailerUID.
+func (m *Manager) releaseJailerUID(uid int) {
+	m.allocMu.Lock()
+	defer m.allocMu.Unlock()
+	delete(m.reservedUIDs, uid)
+}
+
+// prepareTap creates the tap device MMDS is served on, inside the jail's
+// network namespace for jailed VMs.
+func (m *Manager) prepareTap(jail *state.Jailer) (string, error) {
+	name, err := m.allocateTapDevice()
+	if err != nil {
+		return "", err
+	}
+
+	netns, uid := "", os.Geteuid()
+	if jail != nil {
+		netns, uid = jail.NetNS, jail.UID
+	}
+
+	if err := network.CreateTap(name, netns, uid); err != nil {
+		m.releaseTapDevice(name)
+		return "", err
+	}
+
+	return name, nil
+}
+
+// allocateTapDevice reserves the first tap device name not used by a VM.
+// cleanupTap releases it.
+func (m *Manager) allocateTapDevice() (string, error) {
+	m.allocMu.Lock()
+	defer m.allocMu.Unlock()
+
+	vms, err := m.store.ListVMs()
+	if err != nil {
+		return "", fmt.Errorf("failed to list VMs: %w", err)
+	}
+
+	used := make(map[string]bool)
+	for _, vm := range vms {
+		if vm.TapDevice != "" {
+			used[vm.TapDevice] = true
+		}
+	}
+
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("mptap%d", i)
+		if !used[name] && !m.reservedTaps[name] {
+			m.reservedTaps[name] = true
+			return name, nil
+		}
+	}
+}
+
+// releaseTapDevice drops the reservation made by allocateTapDevice.
+func (m *Manager) releaseTapDevice(name string) {
+	m.allocMu.Lock()
+	defer m.allocMu.Unlock()
+	delete(m.reservedTaps, name)
+}
+
+// cleanupTap deletes a VM's tap device. Devices of jailed VMs are removed
+// together with the jail's network namespace.
+func (m *Manager) cleanupTap(tapDevice string, jail *state.Jailer) error {
+	if tapDevice == "" {
+		return nil
+	}
+	defer m.releaseTapDevice(tapDevice)
+
+	if jail != nil {
+		return nil
+	}
+	return network.DeleteTap(tapDevice, "")
+}
+
+func (m *Manager) cleanupJailer(jail *state.Jailer) error {
+	if jail == nil {
+		return nil
+	}
+	defer m.releaseJailerUID(jail.UID)
+
+	var errors []error
+
+	if err := firecracker.RemoveJail(jail.ChrootPath); err != nil {
+		errors = append(errors, err)
+	}
+
+	if jail.NetNS != "" {
+		if err := network.DeleteNetNS(jail.NetNS); err != nil {
+			errors = append(errors, err)
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("jailer cleanup errors: %v", errors)
+	}
+
+	return nil
+}
+
+// isProcessRunning reports whether the Firecracker process of a VM is
+// running. The pid alone may have been reused since the VM was started by an
+// earlier daemon, so the process must also name the VM on its command line,
+// as its API socket or jailer ID, and must have started before the VM did.
+func (m *Manager) isProcessRunning(vm state.VM) bool {
+	if vm.FirecrackerPid <= 0 {
 		return false
 	}
 
-	process, err := os.FindProcess(pid)
+	args, err := procfs.ReadCmdline(vm.FirecrackerPid)
 	if err != nil {
 		return false
 	}
+	named := false
+	for _, arg := range args {
+		if strings.Contains(arg, vm.ID) {
+			named = true
+			break
+		}
+	}
+	if !named {
+		return false
+	}
+
+	if !vm.StartedAt.IsZero() {
+		// StartedAt is taken after the launch; allow for the clock
+		// tick resolution of the start time.
+		started, err := procfs.ReadStartTime(vm.FirecrackerPid)
+		if err != nil || started.After(vm.StartedAt.Add(time.Second)) {
+			return false
+		}
+	}
 
-	err = process.Signal(syscall.Signal(0))
-	return err == nil
+	return true
 }
 
 func (m *Manager) killProcess(pid int) error {
@@ -213,23 +1103,106 @@ func (m *Manager) killProcess(pid int) error {
 func (m *Manager) cleanup(vm *state.VM) error {
 	var errors []error
 
-	if err := os.Remove(vm.VMSocketPath); err != nil && !os.IsNotExist(err) {
-		errors = append(errors, fmt.Errorf("failed to remove socket: %w", err))
+	if err := m.cleanupRuntime(vm); err != nil {
+		errors = append(errors, err)
 	}
+	runtimeErrors := len(errors)
 
 	if err := m.rootfsCreator.RemoveRootfs(vm.RootfsPath); err != nil {
 		errors = append(errors, fmt.Errorf("failed to remove rootfs: %w", err))
 	}
 
+	if err := m.removeMountImages(vm.Mounts); err != nil {
+		errors = append(errors, err)
+	}
+
+	if vm.LogPath != "" {
+		for _, path := range []string{vm.LogPath, rotatedLogPath(vm.LogPath)} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				errors = append(errors, fmt.Errorf("failed to remove console log: %w", err))
+			}
+		}
+	}
+
+	if len(errors) > runtimeErrors {
+		cleanupFailures.Inc("storage")
+	}
+	if len(errors) > 0 {
+		return fmt.Errorf("cleanup errors: %v", errors)
+	}
+
+	return nil
+}
+
+// cleanupRuntime releases the resources of a VM's Firecracker process and
+// clears them from vm, keeping what is needed to launch it again.
+func (m *Manager) cleanupRuntime(vm *state.VM) error {
+	var errors []error
+
+	if vm.VMSocketPath != "" {
+		if err := os.Remove(vm.VMSocketPath); err != nil && !os.IsNotExist(err) {
+			errors = append(errors, fmt.Errorf("failed to remove socket: %w", err))
+		}
+	}
+
+	if err := m.cleanupTap(vm.TapDevice, vm.Jailer); err != nil {
+		errors = append(errors, err)
+	}
+
+	if err := m.cleanupNetworkInterfaces(vm); err != nil {
+		errors = append(errors, err)
+	}
+
+	if err := os.Remove(m.getGuestConfigPath(vm.ID)); err != nil && !os.IsNotExist(err) {
+		errors = append(errors, fmt.Errorf("failed to remove guest config: %w", err))
+	}
+
+	if err := m.cleanupJailer(vm.Jailer); err != nil {
+		errors = append(errors, err)
+	}
+
+	if err := cgroup.Remove(vm.CgroupPath); err != nil {
+		errors = append(errors, err)
+	}
+
+	if vm.MetricsPath != "" {
+		if err := os.Remove(vm.MetricsPath); err != nil && !os.IsNotExist(err) {
+			errors = append(errors, fmt.Errorf("failed to remove metrics FIFO: %w", err))
+		}
+	}
+	m.untrackMetrics(vm.ID)
+	m.untrackExitListener(vm.ID)
+
+	if vm.VsockPath != "" {
+		if err := os.Remove(vm.VsockPath); err != nil && !os.IsNotExist(err) {
+			errors = append(errors, fmt.Errorf("failed to remove vsock socket: %w", err))
+		}
+	}
+
+	vm.FirecrackerPid = 0
+	vm.VMSocketPath = ""
+	vm.Jailer = nil
+	vm.CgroupPath = ""
+	vm.MetricsPath = ""
+	vm.TapDevice = ""
+	vm.VsockPath = ""
+	vm.Health = nil
+
 	if len(errors) > 0 {
+		cleanupFailures.Inc("runtime")
 		return fmt.Errorf("cleanup errors: %v", errors)
 	}
 
 	return nil
 }
 
-func (m *Manager) cleanupDeadVM(vm state.VM) {
-	fmt.Printf("Cleaning up dead VM: %s\n", vm.ID)
+// cleanupDeadVM records why a VM died and removes it. reason and exitCode
+// must be determined before cleanup, which deletes the VM's cgroup and
+// console log.
+func (m *Manager) cleanupDeadVM(vm state.VM, reason string, exitCode int) {
+	fmt.Printf("Cleaning up dead VM: %s (%s)\n", vm.ID, reason)
+
+	m.emitExit(EventDie, vm, reason, exitCode)
 
 	if err := m.cleanup(&vm); err != nil {
 		fmt.Printf("Warning: failed to cleanup dead VM %s: %v\n", vm.ID, err)
@@ -237,5 +1210,30 @@ func (m *Manager) cleanupDeadVM(vm state.VM) {
 
 	if err := m.store.RemoveVM(vm.ID); err != nil {
 		fmt.Printf("Warning: failed to remove dead VM %s from state: %v\n", vm.ID, err)
+		return
 	}
-}
\ No newline at end of file
+
+	m.emit(EventDestroy, vm, "")
+}
+
+func (m *Manager) emit(eventType EventType, vm state.VM, exitReason string) {
+	m.events.Publish(Event{
+		Type:       eventType,
+		VMID:       vm.ID,
+		Name:       vm.Name,
+		Image:      vm.ImageName,
+		ExitReason: exitReason,
+	})
+}
+
+// emitExit publishes a die or stop event carrying the VM's exit code.
+func (m *Manager) emitExit(eventType EventType, vm state.VM, exitReason string, exitCode int) {
+	m.events.Publish(Event{
+		Type:       eventType,
+		VMID:       vm.ID,
+		Name:       vm.Name,
+		Image:      vm.ImageName,
+		ExitReason: exitReason,
+		ExitCode:   &exitCode,
+	})
+}
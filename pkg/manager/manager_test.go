@@ -0,0 +1,107 @@
+package manager
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"micropod/pkg/state"
+)
+
+func newTestStore(t *testing.T) *state.Store {
+	t.Helper()
+	statePath := filepath.Join(t.TempDir(), "vms.json")
+	if err := os.WriteFile(statePath, []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := state.NewStore(statePath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	return store
+}
+
+func TestAllocateJailerUID(t *testing.T) {
+	store := newTestStore(t)
+	if err := store.AddVM(state.VM{ID: "stored", Jailer: &state.Jailer{UID: jailerUIDBase}}); err != nil {
+		t.Fatal(err)
+	}
+	m := &Manager{store: store, reservedUIDs: make(map[int]bool)}
+
+	// VMs being launched are not stored yet; their uids stay reserved.
+	first, err := m.allocateJailerUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.allocateJailerUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != jailerUIDBase+1 || second != jailerUIDBase+2 {
+		t.Errorf("allocated uids %d and %d, want %d and %d", first, second, jailerUIDBase+1, jailerUIDBase+2)
+	}
+
+	m.releaseJailerUID(first)
+	if uid, _ := m.allocateJailerUID(); uid != first {
+		t.Errorf("released uid %d was not reused, got %d", first, uid)
+	}
+}
+
+func TestIsProcessRunning(t *testing.T) {
+	vmID := "vm-process-test"
+	cmd := exec.Command("sh", "-c", "sleep 10; true", vmID)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	m := &Manager{}
+	vm := state.VM{ID: vmID, FirecrackerPid: cmd.Process.Pid, StartedAt: time.Now()}
+	if !m.isProcessRunning(vm) {
+		t.Error("process of the VM is not found running")
+	}
+
+	other := vm
+	other.ID = "vm-other"
+	if m.isProcessRunning(other) {
+		t.Error("process naming another VM is taken for the VM's")
+	}
+
+	// A process started after the VM reuses the pid of the VM's process.
+	earlier := vm
+	earlier.StartedAt = time.Now().Add(-time.Hour)
+	if m.isProcessRunning(earlier) {
+		t.Error("process started after the VM is taken for the VM's")
+	}
+}
+
+func TestAllocateTapDevice(t *testing.T) {
+	store := newTestStore(t)
+	if err := store.AddVM(state.VM{ID: "stored", TapDevice: "mptap0"}); err != nil {
+		t.Fatal(err)
+	}
+	m := &Manager{store: store, reservedTaps: make(map[string]bool)}
+
+	first, err := m.allocateTapDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.allocateTapDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != "mptap1" || second != "mptap2" {
+		t.Errorf("allocated tap devices %s and %s, want mptap1 and mptap2", first, second)
+	}
+
+	// Devices of jailed VMs go with their namespace but are released too.
+	m.cleanupTap(first, &state.Jailer{})
+	if name, _ := m.allocateTapDevice(); name != first {
+		t.Errorf("released tap device %s was not reused, got %s", first, name)
+	}
+}
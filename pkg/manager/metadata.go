@@ -0,0 +1,138 @@
+package manager
+
+import (
+	"fmt"
+
+	"micropod/pkg/firecracker"
+	"micropod/pkg/state"
+)
+
+// defaultMMDSVersion is used when a VM has metadata only MMDS delivers but
+// no MMDS version was requested.
+const defaultMMDSVersion = "V2"
+
+// Metadata is the document published to the guest through MMDS, so the
+// workload can read its configuration without networking setup scripts.
+type Metadata struct {
+	Env     map[string]string `json:"env,omitempty"`
+	Command []string          `json:"command,omitempty"`
+	// Secrets map secret names to references, such as a Vault path, that
+	// the workload resolves itself. Secret values never go through MMDS.
+	Secrets     map[string]string `json:"secrets,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// needsMMDS reports whether the document holds anything only MMDS delivers
+// to the guest. The command and environment also reach the workload through
+// the guest config, so they alone do not enable MMDS and its tap device at
+// run.
+func (md Metadata) needsMMDS() bool {
+	return len(md.Secrets) > 0 || len(md.Labels) > 0 || len(md.Annotations) > 0
+}
+
+// IsZero reports whether the document is empty.
+func (md Metadata) IsZero() bool {
+	return len(md.Env) == 0 && len(md.Command) == 0 && !md.needsMMDS()
+}
+
+// Validate checks the keys of the document's maps.
+func (md Metadata) Validate() error {
+	if err := validateLabels(md.Labels); err != nil {
+		return err
+	}
+	if err := validateLabels(md.Annotations); err != nil {
+		return fmt.Errorf("invalid annotation: %w", err)
+	}
+	for key := range md.Env {
+		if key == "" {
+			return fmt.Errorf("invalid environment variable: empty name")
+		}
+	}
+	for name := range md.Secrets {
+		if err := validateLabelKey(name); err != nil {
+			return fmt.Errorf("invalid secret name: %w", err)
+		}
+	}
+	return nil
+}
+
+func metadataOf(vm state.VM) Metadata {
+	return Metadata{
+		Env:         vm.Env,
+		Command:     vm.Command,
+		Secrets:     vm.Secrets,
+		Labels:      vm.Labels,
+		Annotations: vm.Annotations,
+	}
+}
+
+func (md Metadata) applyTo(vm *state.VM) {
+	vm.Env = md.Env
+	vm.Command = md.Command
+	vm.Secrets = md.Secrets
+	vm.Labels = md.Labels
+	vm.Annotations = md.Annotations
+}
+
+// resolveMMDSVersion returns the MMDS version a new VM is launched with:
+// the requested one, or the default when the VM has secrets, labels or
+// annotations.
+func resolveMMDSVersion(requested string, md Metadata) (string, error) {
+	if requested != "" {
+		if err := firecracker.ValidateMMDSVersion(requested); err != nil {
+			return "", err
+		}
+		return requested, nil
+	}
+	if !md.needsMMDS() {
+		return "", nil
+	}
+	return defaultMMDSVersion, nil
+}
+
+// GetMetadata returns the metadata document of a VM.
+func (m *Manager) GetMetadata(ref string) (*Metadata, error) {
+	vm, err := m.store.ResolveVM(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	md := metadataOf(*vm)
+	return &md, nil
+}
+
+// SetMetadata replaces the metadata document of a VM and publishes it to
+// the guest if the VM is running. MMDS can only be enabled at boot, so on a
+// VM started without it any document set is published from its next start,
+// e.g. after a restart.
+func (m *Manager) SetMetadata(ref string, md Metadata) error {
+	if err := md.Validate(); err != nil {
+		return err
+	}
+
+	vm, err := m.store.ResolveVM(ref)
+	if err != nil {
+		return err
+	}
+
+	md.applyTo(vm)
+	if vm.MMDSVersion == "" && !md.IsZero() {
+		vm.MMDSVersion = defaultMMDSVersion
+	}
+	if err := m.store.UpdateVM(*vm); err != nil {
+		return fmt.Errorf("failed to update VM state: %w", err)
+	}
+
+	// The tap device only exists while the VM runs with MMDS enabled.
+	if vm.TapDevice == "" || !hasProcess(*vm) || !m.isAlive(*vm) {
+		return nil
+	}
+
+	client := firecracker.NewClient(vm.VMSocketPath)
+	if err := client.PutMetadata(md); err != nil {
+		return fmt.Errorf("failed to publish metadata: %w", err)
+	}
+
+	return nil
+}
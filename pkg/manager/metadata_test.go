@@ -0,0 +1,34 @@
+package manager
+
+import "testing"
+
+func TestResolveMMDSVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested string
+		md        Metadata
+		want      string
+	}{
+		{"no metadata", "", Metadata{}, ""},
+		{"env and command go through the guest config", "", Metadata{
+			Env:     map[string]string{"LOG_LEVEL": "debug"},
+			Command: []string{"./server"},
+		}, ""},
+		{"labels", "", Metadata{Labels: map[string]string{"tier": "web"}}, defaultMMDSVersion},
+		{"annotations", "", Metadata{Annotations: map[string]string{"owner": "alice"}}, defaultMMDSVersion},
+		{"secrets", "", Metadata{Secrets: map[string]string{"db": "vault:kv/app/db"}}, defaultMMDSVersion},
+		{"requested", "V1", Metadata{}, "V1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveMMDSVersion(tt.requested, tt.md)
+			if err != nil {
+				t.Fatalf("resolveMMDSVersion() error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveMMDSVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package manager
+
+import (
+	"sort"
+
+	"micropod/pkg/firecracker"
+	"micropod/pkg/metrics"
+	"micropod/pkg/state"
+)
+
+var (
+	runDuration = metrics.Default.NewHistogram("micropod_vm_run_duration_seconds",
+		"Time taken by run to start a VM, by whether it was taken from a pool (pool) or built (new).", metrics.DurationBuckets, "source")
+	runFailures = metrics.Default.NewCounter("micropod_vm_run_failures_total",
+		"Runs that failed to start a VM.")
+	bootDuration = metrics.Default.NewHistogram("micropod_vm_boot_duration_seconds",
+		"Time from launching Firecracker until the guest booted.", metrics.DurationBuckets)
+	bootFailures = metrics.Default.NewCounter("micropod_vm_boot_failures_total",
+		"Guests that did not boot within their boot timeout.")
+	cleanupFailures = metrics.Default.NewCounter("micropod_vm_cleanup_failures_total",
+		"VM cleanups that failed to release resources, by stage: the Firecracker process (runtime) or the VM's images and log (storage).", "stage")
+)
+
+// writeMetrics writes the metrics known at scrape time: VM counts, pools
+// and the Firecracker metrics of running VMs.
+func (m *Manager) writeMetrics(w *metrics.Writer) {
+	vms, err := m.store.ListVMs()
+	if err != nil {
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, vm := range vms {
+		if vm.State != "Pooled" {
+			counts[vm.State]++
+		}
+	}
+	states := make([]string, 0, len(counts))
+	for s := range counts {
+		states = append(states, s)
+	}
+	sort.Strings(states)
+	var vmSamples []metrics.Sample
+	for _, s := range states {
+		vmSamples = append(vmSamples, sample(float64(counts[s]), "state", s))
+	}
+	w.Write("micropod_vms", "VMs by state, excluding pooled VMs.", metrics.TypeGauge, vmSamples...)
+
+	m.writePoolMetrics(w)
+	m.writeFirecrackerMetrics(w, vms)
+}
+
+func (m *Manager) writePoolMetrics(w *metrics.Writer) {
+	var size, ready, booting, hits, misses []metrics.Sample
+	for _, p := range m.PoolStats() {
+		labels := []string{"pool", p.Name, "image", p.Image}
+		size = append(size, sample(float64(p.Size), labels...))
+		ready = append(ready, sample(float64(p.Ready), labels...))
+		booting = append(booting, sample(float64(p.Booting), labels...))
+		hits = append(hits, sample(float64(p.Hits), labels...))
+		misses = append(misses, sample(float64(p.Misses), labels...))
+	}
+
+	w.Write("micropod_pool_size", "Number of VMs a pool keeps.", metrics.TypeGauge, size...)
+	w.Write("micropod_pool_ready_vms", "Booted and paused VMs waiting in a pool.", metrics.TypeGauge, ready...)
+	w.Write("micropod_pool_booting_vms", "VMs being booted for a pool.", metrics.TypeGauge, booting...)
+	w.Write("micropod_pool_hits_total", "Runs handed a VM from a pool.", metrics.TypeCounter, hits...)
+	w.Write("micropod_pool_misses_total", "Runs a pool would have served but found empty.", metrics.TypeCounter, misses...)
+}
+
+// firecrackerCounters are the Firecracker metrics written per VM, as
+// counters named micropod_firecracker_<name>_total.
+var firecrackerCounters = []struct {
+	name, help string
+	value      func(firecracker.Metrics) uint64
+}{
+	{"block_read_bytes", "Bytes read from the VM's block devices.", func(fm firecracker.Metrics) uint64 { return fm.Block.ReadBytes }},
+	{"block_write_bytes", "Bytes written to the VM's block devices.", func(fm firecracker.Metrics) uint64 { return fm.Block.WriteBytes }},
+	{"block_reads", "Read requests to the VM's block devices.", func(fm firecracker.Metrics) uint64 { return fm.Block.ReadCount }},
+	{"block_writes", "Write requests to the VM's block devices.", func(fm firecracker.Metrics) uint64 { return fm.Block.WriteCount }},
+	{"block_flushes", "Flush requests to the VM's block devices.", func(fm firecracker.Metrics) uint64 { return fm.Block.FlushCount }},
+	{"net_rx_bytes", "Bytes received by the VM's network devices.", func(fm firecracker.Metrics) uint64 { return fm.Net.RxBytes }},
+	{"net_tx_bytes", "Bytes sent by the VM's network devices.", func(fm firecracker.Metrics) uint64 { return fm.Net.TxBytes }},
+	{"net_rx_packets", "Packets received by the VM's network devices.", func(fm firecracker.Metrics) uint64 { return fm.Net.RxPackets }},
+	{"net_tx_packets", "Packets sent by the VM's network devices.", func(fm firecracker.Metrics) uint64 { return fm.Net.TxPackets }},
+	{"vcpu_exits", "vCPU exits to the VMM.", func(fm firecracker.Metrics) uint64 { return fm.Vcpu.Exits() }},
+	{"vcpu_failures", "vCPU failures.", func(fm firecracker.Metrics) uint64 { return fm.Vcpu.Failures }},
+}
+
+func (m *Manager) writeFirecrackerMetrics(w *metrics.Writer, vms []state.VM) {
+	totals := m.collectFirecrackerMetrics(vms)
+
+	ids := make([]string, 0, len(totals))
+	for id := range totals {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	names := make(map[string]string)
+	for _, vm := range vms {
+		names[vm.ID] = vm.Name
+	}
+
+	for _, c := range firecrackerCounters {
+		var samples []metrics.Sample
+		for _, id := range ids {
+			samples = append(samples, sample(float64(c.value(totals[id])), "vm", id, "name", names[id]))
+		}
+		w.Write("micropod_firecracker_"+c.name+"_total", c.help, metrics.TypeCounter, samples...)
+	}
+}
+
+// collectFirecrackerMetrics flushes the metrics of every VM with a
+// Firecracker process and returns their totals. The totals start over when
+// the VM or the daemon restarts.
+func (m *Manager) collectFirecrackerMetrics(vms []state.VM) map[string]firecracker.Metrics {
+	totals := make(map[string]firecracker.Metrics)
+	for _, vm := range vms {
+		if vm.State == "Pooled" {
+			continue
+		}
+		if reader := m.metricsReader(vm); reader != nil {
+			// A failed flush only means the latest record is counted later.
+			totals[vm.ID] = reader.Flush(firecracker.NewClient(vm.VMSocketPath).FlushMetrics)
+		}
+	}
+	return totals
+}
+
+func (m *Manager) trackMetrics(vmID string, r *firecracker.MetricsReader) {
+	m.metricsMu.Lock()
+	defer m.metricsMu.Unlock()
+	m.metricsReaders[vmID] = r
+}
+
+// untrackMetrics stops reading the metrics of a VM whose process is gone.
+func (m *Manager) untrackMetrics(vmID string) {
+	m.metricsMu.Lock()
+	r := m.metricsReaders[vmID]
+	delete(m.metricsReaders, vmID)
+	m.metricsMu.Unlock()
+
+	if r != nil {
+		r.Close()
+	}
+}
+
+// metricsReader returns the reader of a VM's metrics FIFO. The FIFO of a VM
+// started by an earlier daemon is opened on first use. It returns nil for
+// VMs without a Firecracker process or metrics.
+func (m *Manager) metricsReader(vm state.VM) *firecracker.MetricsReader {
+	if !hasProcess(vm) || vm.MetricsPath == "" {
+		return nil
+	}
+
+	m.metricsMu.Lock()
+	defer m.metricsMu.Unlock()
+
+	if r, ok := m.metricsReaders[vm.ID]; ok {
+		return r
+	}
+	r, err := firecracker.OpenMetrics(vm.MetricsPath)
+	if err != nil {
+		return nil
+	}
+	m.metricsReaders[vm.ID] = r
+	return r
+}
+
+// sample returns a metrics sample with labels given as name, value pairs.
+func sample(value float64, labels ...string) metrics.Sample {
+	s := metrics.Sample{Value: value}
+	for i := 0; i+1 < len(labels); i += 2 {
+		s.Labels = append(s.Labels, metrics.Label{Name: labels[i], Value: labels[i+1]})
+	}
+	return s
+}
@@ -0,0 +1,86 @@
+package manager
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"micropod/pkg/firecracker"
+	"micropod/pkg/state"
+)
+
+func TestCollectFirecrackerMetrics(t *testing.T) {
+	dir := t.TempDir()
+	metricsPath := filepath.Join(dir, "metrics.fifo")
+	if err := syscall.Mkfifo(metricsPath, 0600); err != nil {
+		t.Fatalf("Failed to create FIFO: %v", err)
+	}
+	// Opening read-write does not wait for the manager to open the FIFO.
+	fifo, err := os.OpenFile(metricsPath, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Failed to open FIFO: %v", err)
+	}
+	defer fifo.Close()
+
+	// A fake Firecracker API writes the next record on every flush.
+	records := make(chan string, 2)
+	records <- `{"utc_timestamp_ms":1,"net":{"rx_bytes_count":10}}`
+	records <- `{"utc_timestamp_ms":2,"net":{"rx_bytes_count":5},"vcpu":{"exit_io_in":2}}`
+	socketPath := filepath.Join(dir, "firecracker.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case record := <-records:
+			fifo.WriteString(record + "\n")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "no more records", http.StatusBadRequest)
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	m := &Manager{metricsReaders: make(map[string]*firecracker.MetricsReader)}
+	defer func() {
+		for id := range m.metricsReaders {
+			m.untrackMetrics(id)
+		}
+	}()
+
+	// The VM was started by an earlier daemon: its FIFO is opened on use.
+	vm := state.VM{ID: "vm1", State: "Running", MetricsPath: metricsPath, VMSocketPath: socketPath}
+	pooled := state.VM{ID: "vm2", State: "Pooled", MetricsPath: metricsPath, VMSocketPath: socketPath}
+	exited := state.VM{ID: "vm3", State: "Exited", MetricsPath: metricsPath}
+
+	totals := m.collectFirecrackerMetrics([]state.VM{pooled, vm, exited})
+	if got := totals["vm1"].Net.RxBytes; got != 10 {
+		t.Errorf("rx bytes after first scrape = %d, want 10", got)
+	}
+	for _, id := range []string{"vm2", "vm3"} {
+		if _, ok := totals[id]; ok {
+			t.Errorf("VM %s has Firecracker metrics", id)
+		}
+	}
+
+	totals = m.collectFirecrackerMetrics([]state.VM{vm})
+	if got := totals["vm1"].Net.RxBytes; got != 15 {
+		t.Errorf("rx bytes after second scrape = %d, want 15", got)
+	}
+	if got := totals["vm1"].Vcpu.Exits(); got != 2 {
+		t.Errorf("vcpu exits = %d, want 2", got)
+	}
+	if len(m.metricsReaders) != 1 {
+		t.Errorf("readers = %v, want only vm1's", m.metricsReaders)
+	}
+
+	m.untrackMetrics("vm1")
+	if len(m.metricsReaders) != 0 {
+		t.Errorf("reader of a VM whose process is gone is kept: %v", m.metricsReaders)
+	}
+}
@@ -0,0 +1,348 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"micropod/pkg/firecracker"
+	"micropod/pkg/guest"
+	"micropod/pkg/network"
+	"micropod/pkg/state"
+)
+
+// CreateNetwork creates a network and its bridge.
+func (m *Manager) CreateNetwork(name string, labels map[string]string) (*network.Network, error) {
+	if err := validateLabels(labels); err != nil {
+		return nil, err
+	}
+
+	n, err := m.networks.Create(name, labels)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := network.CreateBridge(n.Bridge, n.GatewayCIDR()); err != nil {
+		m.networks.Remove(name)
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// GetNetwork returns a network together with the VMs attached to it.
+func (m *Manager) GetNetwork(name string) (*network.Network, error) {
+	n, err := m.networks.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.UsedBy, err = m.networkUsers(name); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// ListNetworks returns all networks together with the VMs attached to them.
+func (m *Manager) ListNetworks() ([]network.Network, error) {
+	networks, err := m.networks.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range networks {
+		if networks[i].UsedBy, err = m.networkUsers(networks[i].Name); err != nil {
+			return nil, err
+		}
+	}
+	return networks, nil
+}
+
+// RemoveNetwork deletes a network and its bridge once no VM is attached to
+// it, including exited VMs whose records are kept.
+func (m *Manager) RemoveNetwork(name string) error {
+	n, err := m.networks.Get(name)
+	if err != nil {
+		return err
+	}
+
+	users, err := m.networkUsers(name)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return &network.InUseError{Name: name, VMIDs: users}
+	}
+
+	if network.LinkExists(n.Bridge) {
+		if err := network.DeleteBridge(n.Bridge); err != nil {
+			return err
+		}
+	}
+
+	return m.networks.Remove(name)
+}
+
+// networkUsers returns the IDs of the VMs attached to a network.
+func (m *Manager) networkUsers(name string) ([]string, error) {
+	vms, err := m.store.ListVMs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VMs: %w", err)
+	}
+
+	var users []string
+	for _, vm := range vms {
+		for _, attachment := range vm.Networks {
+			if attachment.Network == name {
+				users = append(users, vm.ID)
+				break
+			}
+		}
+	}
+	return users, nil
+}
+
+// attachNetworks allocates an address and MAC address on each named
+// network for a new VM. The addresses stay reserved until
+// releaseAddresses is called, by which time the VM is either stored or
+// given up.
+func (m *Manager) attachNetworks(names []string) ([]state.NetworkAttachment, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	// Stored VMs are listed under the lock, so that a VM whose
+	// reservation is released once it is stored is not missed.
+	m.addressesMu.Lock()
+	defer m.addressesMu.Unlock()
+
+	vms, err := m.store.ListVMs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VMs: %w", err)
+	}
+
+	var attachments []state.NetworkAttachment
+	attached := make(map[string]bool)
+	for _, name := range names {
+		if attached[name] {
+			return nil, fmt.Errorf("network %s is given more than once", name)
+		}
+		attached[name] = true
+
+		n, err := m.networks.Get(name)
+		if err != nil {
+			return nil, err
+		}
+
+		used := make(map[string]bool)
+		for key := range m.reservedAddresses {
+			if reserved, address, _ := strings.Cut(key, "/"); reserved == name {
+				used[address] = true
+			}
+		}
+		for _, vm := range vms {
+			for _, attachment := range vm.Networks {
+				if attachment.Network == name {
+					used[addressOf(attachment)] = true
+				}
+			}
+		}
+
+		address, err := n.AllocateAddress(used)
+		if err != nil {
+			return nil, err
+		}
+		attachment := state.NetworkAttachment{Network: name, Address: address}
+		if attachment.MAC, err = network.MACAddress(addressOf(attachment)); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	for _, attachment := range attachments {
+		m.reservedAddresses[attachment.Network+"/"+addressOf(attachment)] = true
+	}
+	return attachments, nil
+}
+
+// releaseAddresses drops the reservations made by attachNetworks.
+func (m *Manager) releaseAddresses(attachments []state.NetworkAttachment) {
+	m.addressesMu.Lock()
+	defer m.addressesMu.Unlock()
+
+	for _, attachment := range attachments {
+		delete(m.reservedAddresses, attachment.Network+"/"+addressOf(attachment))
+	}
+}
+
+// addressOf returns the address of an attachment without its prefix length.
+func addressOf(attachment state.NetworkAttachment) string {
+	address, _, _ := strings.Cut(attachment.Address, "/")
+	return address
+}
+
+// prepareNetworkInterfaces creates a tap device on the bridge of each of
+// the VM's networks, recreating bridges lost to a host reboot, and records
+// the devices in vm.
+func (m *Manager) prepareNetworkInterfaces(vm *state.VM) ([]firecracker.NetworkInterface, error) {
+	var ifaces []firecracker.NetworkInterface
+	for i := range vm.Networks {
+		attachment := &vm.Networks[i]
+		n, err := m.networks.Get(attachment.Network)
+		if err != nil {
+			m.cleanupNetworkInterfaces(vm)
+			return nil, err
+		}
+		if err := network.EnsureBridge(n.Bridge, n.GatewayCIDR()); err != nil {
+			m.cleanupNetworkInterfaces(vm)
+			return nil, err
+		}
+
+		tapDevice := networkTapName(vm.ID, i)
+		if err := network.CreateTap(tapDevice, "", os.Geteuid()); err != nil {
+			m.cleanupNetworkInterfaces(vm)
+			return nil, err
+		}
+		attachment.TapDevice = tapDevice
+		if err := network.AttachToBridge(tapDevice, n.Bridge); err != nil {
+			m.cleanupNetworkInterfaces(vm)
+			return nil, err
+		}
+
+		ifaces = append(ifaces, firecracker.NetworkInterface{
+			IfaceID:     fmt.Sprintf("net%d", i),
+			HostDevName: tapDevice,
+			GuestMAC:    attachment.MAC,
+		})
+	}
+	return ifaces, nil
+}
+
+// cleanupNetworkInterfaces deletes the VM's tap devices on its networks.
+func (m *Manager) cleanupNetworkInterfaces(vm *state.VM) error {
+	var errors []error
+	for i := range vm.Networks {
+		attachment := &vm.Networks[i]
+		if attachment.TapDevice == "" {
+			continue
+		}
+		if err := network.DeleteTap(attachment.TapDevice, ""); err != nil {
+			errors = append(errors, err)
+		}
+		attachment.TapDevice = ""
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("cleanup errors: %v", errors)
+	}
+	return nil
+}
+
+// networkTapName names the tap device of a VM's index'th network after the
+// VM, within the 15 characters allowed for interface names.
+func networkTapName(vmID string, index int) string {
+	return fmt.Sprintf("mp%s-%d", strings.ReplaceAll(vmID, "-", "")[:10], index)
+}
+
+// guestNetwork returns the interfaces micropod-init configures for a VM and
+// the hosts it writes to /etc/hosts: the VM itself and every other VM that
+// shares a network with it, under its name and compose service. The hosts
+// are refreshed by refreshPeerHosts as VMs come and go.
+func (m *Manager) guestNetwork(vm state.VM) ([]guest.Interface, []guest.Host, error) {
+	if len(vm.Networks) == 0 {
+		return nil, nil, nil
+	}
+
+	vms, err := m.store.ListVMs()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list VMs: %w", err)
+	}
+
+	var ifaces []guest.Interface
+	var hosts []guest.Host
+	for _, attachment := range vm.Networks {
+		ifaces = append(ifaces, guest.Interface{MAC: attachment.MAC, Address: attachment.Address})
+		hosts = append(hosts, guest.Host{Address: addressOf(attachment), Names: hostNames(vm)})
+
+		for _, peer := range vms {
+			if peer.ID == vm.ID {
+				continue
+			}
+			for _, peerAttachment := range peer.Networks {
+				if peerAttachment.Network == attachment.Network {
+					hosts = append(hosts, guest.Host{Address: addressOf(peerAttachment), Names: hostNames(peer)})
+				}
+			}
+		}
+	}
+	return ifaces, hosts, nil
+}
+
+// refreshPeerHosts rewrites the hosts of the running VMs sharing a network
+// with vm, which was created or removed.
+func (m *Manager) refreshPeerHosts(vm state.VM) {
+	if len(vm.Networks) == 0 {
+		return
+	}
+
+	vms, err := m.store.ListVMs()
+	if err != nil {
+		fmt.Printf("Warning: failed to update hosts of VMs on the networks of %s: %v\n", vm.ID, err)
+		return
+	}
+	for _, peer := range vms {
+		if peer.ID == vm.ID || peer.State != "Running" || !peer.GuestInit || !sharesNetwork(peer, vm) {
+			continue
+		}
+		_, hosts, err := m.guestNetwork(peer)
+		if err == nil {
+			err = agentClientFor(peer).SetHosts(hosts)
+		}
+		if err != nil {
+			fmt.Printf("Warning: failed to update hosts of VM %s: %v\n", peer.ID, err)
+		}
+	}
+}
+
+// sharesNetwork reports whether two VMs are attached to a common network.
+func sharesNetwork(a, b state.VM) bool {
+	for _, x := range a.Networks {
+		for _, y := range b.Networks {
+			if x.Network == y.Network {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hostNames returns the names a VM is reached by on its networks.
+func hostNames(vm state.VM) []string {
+	names := []string{vm.ID[:12]}
+	if vm.Name != "" {
+		names = append([]string{vm.Name}, names...)
+	}
+	if service := vm.Labels[ServiceLabel]; service != "" && service != vm.Name {
+		names = append([]string{service}, names...)
+	}
+	return names
+}
+
+// validateNetworks checks what VMs attached to networks require.
+func validateNetworks(spec VMSpec, guestInit bool) error {
+	if len(spec.Networks) == 0 {
+		return nil
+	}
+	if spec.Jailer {
+		return fmt.Errorf("networks are not supported for jailed VMs, which have their own network namespace")
+	}
+	if !guestInit {
+		return fmt.Errorf("networks require micropod-init, which was not found (set MICROPOD_INIT)")
+	}
+	for _, name := range spec.Networks {
+		if err := network.ValidateName(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,493 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+
+	"micropod/pkg/cgroup"
+	"micropod/pkg/config"
+	"micropod/pkg/firecracker"
+	"micropod/pkg/state"
+)
+
+// poolCheckInterval is how often pools are refilled and checked for VMs
+// that died or whose image changed, besides right after run took a VM.
+const poolCheckInterval = 10 * time.Second
+
+// PoolStats describes a pool of config.json and how often run found a VM in
+// it since the daemon started.
+type PoolStats struct {
+	Name     string `json:"name"`
+	Image    string `json:"image"`
+	Profile  string `json:"profile,omitempty"`
+	VCPUs    int    `json:"vcpus"`
+	MemoryMB int    `json:"memoryMB"`
+	// Size is the number of VMs the pool keeps; Ready of them are booted
+	// and paused, and Booting are being booted.
+	Size    int `json:"size"`
+	Ready   int `json:"ready"`
+	Booting int `json:"booting"`
+	// Hits counts the runs handed a pooled VM, Misses the runs the pool
+	// would have served but found empty. HitRate is their ratio, 0 before
+	// the first run.
+	Hits    int     `json:"hits"`
+	Misses  int     `json:"misses"`
+	HitRate float64 `json:"hitRate"`
+}
+
+// vmPool is the state of a pool; it is guarded by Manager.poolsMu.
+type vmPool struct {
+	config  config.Pool
+	ready   []pooledVM
+	booting int
+	hits    int
+	misses  int
+}
+
+// pooledVM is a booted and paused VM waiting for a run.
+type pooledVM struct {
+	vm state.VM
+	// digest is the manifest digest of the image the VM was built from.
+	digest string
+	client *firecracker.Client
+}
+
+// poolName identifies the pool of the given settings, once defaulted.
+func poolName(p config.Pool) string {
+	return fmt.Sprintf("%s,profile=%s,vcpus=%d,memoryMB=%d", p.Image, p.Profile, p.VCPUs, p.MemoryMB)
+}
+
+// normalizePool fills in the resources a pool leaves to run's defaults.
+func normalizePool(p config.Pool) config.Pool {
+	defaults := DefaultVMSpec()
+	if p.VCPUs <= 0 {
+		p.VCPUs = defaults.VCPUs
+	}
+	if p.MemoryMB <= 0 {
+		p.MemoryMB = defaults.MemoryMB
+	}
+	return p
+}
+
+// poolFor returns the name of the pool whose VMs can run spec. Pooled VMs
+// are booted with the profile's kernel, the default kernel arguments, MMDS
+// V2 and no drives or interfaces besides the rootfs and MMDS, so specs
+// changing any of these, or the resources of the Firecracker process, cannot
+// use them.
+func poolFor(spec VMSpec) (string, bool) {
+	if spec.Jailer || spec.Limits != (cgroup.Limits{}) || spec.MemoryOverheadMB > 0 ||
+		len(spec.Mounts) > 0 || len(spec.Networks) > 0 || spec.TTY || spec.ReadOnly ||
+		spec.Kernel != "" || spec.KernelArgs != "" || spec.Initrd != "" ||
+		(spec.MMDSVersion != "" && spec.MMDSVersion != defaultMMDSVersion) {
+		return "", false
+	}
+
+	return poolName(config.Pool{Image: spec.Image, Profile: spec.Profile, VCPUs: spec.VCPUs, MemoryMB: spec.MemoryMB}), true
+}
+
+// RunPools keeps the pools of config.json filled until ctx is done, and then
+// removes their VMs. Pooled VMs left behind by an earlier daemon are removed
+// first.
+func (m *Manager) RunPools(ctx context.Context) {
+	m.removeStalePooledVMs()
+
+	ticker := time.NewTicker(poolCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		m.refillPools(ctx)
+
+		select {
+		case <-ctx.Done():
+			m.drainPools()
+			return
+		case <-ticker.C:
+		case <-m.poolWake:
+		}
+	}
+}
+
+// PoolStats returns the state of every pool, sorted by name.
+func (m *Manager) PoolStats() []PoolStats {
+	m.poolsMu.Lock()
+	defer m.poolsMu.Unlock()
+
+	stats := make([]PoolStats, 0, len(m.pools))
+	for name, pool := range m.pools {
+		s := PoolStats{
+			Name:     name,
+			Image:    pool.config.Image,
+			Profile:  pool.config.Profile,
+			VCPUs:    pool.config.VCPUs,
+			MemoryMB: pool.config.MemoryMB,
+			Size:     pool.config.Size,
+			Ready:    len(pool.ready),
+			Booting:  pool.booting,
+			Hits:     pool.hits,
+			Misses:   pool.misses,
+		}
+		if runs := pool.hits + pool.misses; runs > 0 {
+			s.HitRate = float64(pool.hits) / float64(runs)
+		}
+		stats = append(stats, s)
+	}
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// takePooledVM removes a ready VM built from the image with the given digest
+// from the pool that can run spec, and counts the hit or miss. It returns
+// nil when spec cannot use a pool or the pool has no such VM. The pool is
+// refilled in the background.
+func (m *Manager) takePooledVM(spec VMSpec, digest string) *pooledVM {
+	name, ok := poolFor(spec)
+	if !ok {
+		return nil
+	}
+
+	var taken *pooledVM
+	var stale []pooledVM
+
+	m.poolsMu.Lock()
+	pool, ok := m.pools[name]
+	if ok {
+		for taken == nil && len(pool.ready) > 0 {
+			p := pool.ready[0]
+			pool.ready = pool.ready[1:]
+			if p.digest == digest && !exited(p.client) {
+				taken = &p
+			} else {
+				stale = append(stale, p)
+			}
+		}
+		if taken != nil {
+			pool.hits++
+		} else {
+			pool.misses++
+		}
+	}
+	m.poolsMu.Unlock()
+
+	for _, p := range stale {
+		m.discardPooledVM(p.vm, p.client)
+	}
+	if ok {
+		m.wakePools()
+	}
+	return taken
+}
+
+// startPooledVM hands a pooled VM out to a run, whose settings vm holds: it
+// records the VM as running, resumes it and starts its workload. A VM that
+// fails to start is removed.
+func (m *Manager) startPooledVM(vm *state.VM, client *firecracker.Client) error {
+	pooled := *vm
+
+	vm.Pool = ""
+	vm.State = "Running"
+	vm.StartedAt = time.Now()
+	// The console log holds the boot; the workload's output starts here.
+	if info, err := os.Stat(vm.LogPath); err == nil {
+		vm.LogOffset = info.Size()
+	}
+	vm.Health = nil
+	if vm.HealthCheck != nil {
+		vm.Health = &state.Health{Status: state.HealthStarting}
+	}
+
+	if err := m.store.UpdateVM(*vm); err != nil {
+		m.discardPooledVM(pooled, client)
+		return fmt.Errorf("failed to store VM state: %w", err)
+	}
+
+	if err := client.Resume(); err != nil {
+		m.discardPooledVM(*vm, client)
+		return fmt.Errorf("failed to resume pooled VM: %w", err)
+	}
+	if err := client.PutMetadata(metadataOf(*vm)); err != nil {
+		m.discardPooledVM(*vm, client)
+		return fmt.Errorf("failed to publish metadata: %w", err)
+	}
+	m.syncClock(*vm)
+	if err := agentClientFor(*vm).Start(guestConfig(*vm)); err != nil {
+		m.discardPooledVM(*vm, client)
+		return fmt.Errorf("failed to start workload: %w", err)
+	}
+
+	return nil
+}
+
+// refillPools brings the pools in line with config.json: it removes the
+// VMs of pools that are gone, that died or whose image changed, and boots
+// VMs until every pool has its size.
+func (m *Manager) refillPools(ctx context.Context) {
+	file, err := m.config.Load()
+	if err != nil {
+		fmt.Printf("Warning: failed to load pools: %v\n", err)
+		return
+	}
+
+	wanted := make(map[string]config.Pool)
+	for _, p := range file.Pools {
+		if p.Image == "" || p.Size < 0 {
+			fmt.Printf("Warning: ignoring pool %+v: it needs an image and a non-negative size\n", p)
+			continue
+		}
+		p = normalizePool(p)
+		wanted[poolName(p)] = p
+	}
+	if len(wanted) > 0 && m.config.GetGuestInitPath() == "" {
+		m.poolInitWarning.Do(func() {
+			fmt.Printf("Warning: pools require micropod-init, which was not found (set MICROPOD_INIT)\n")
+		})
+		wanted = nil
+	}
+
+	m.poolsMu.Lock()
+	var discard []pooledVM
+	for name, pool := range m.pools {
+		if _, ok := wanted[name]; !ok {
+			discard = append(discard, pool.ready...)
+			delete(m.pools, name)
+		}
+	}
+	for name, p := range wanted {
+		pool, ok := m.pools[name]
+		if !ok {
+			pool = &vmPool{}
+			m.pools[name] = pool
+		}
+		pool.config = p
+	}
+	m.poolsMu.Unlock()
+
+	for _, p := range discard {
+		m.discardPooledVM(p.vm, p.client)
+	}
+
+	names := make([]string, 0, len(wanted))
+	for name := range wanted {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return
+		}
+		m.refillPool(ctx, name)
+	}
+}
+
+// refillPool removes the dead and outdated VMs of a pool, shrinks it to its
+// size and boots VMs until it is full. A VM that fails to boot stops the
+// refill until the next check.
+func (m *Manager) refillPool(ctx context.Context, name string) {
+	m.poolsMu.Lock()
+	pool := m.pools[name]
+	image := pool.config.Image
+	m.poolsMu.Unlock()
+
+	// Images are only pulled once; a VM built from an image that was
+	// replaced since would not run what run asks for.
+	digest := ""
+	if img, err := m.imageService.GetImage(ctx, image); err == nil {
+		digest = img.Digest()
+	}
+
+	m.poolsMu.Lock()
+	var discard, kept []pooledVM
+	for _, p := range pool.ready {
+		if exited(p.client) || (digest != "" && p.digest != digest) || len(kept) >= pool.config.Size {
+			discard = append(discard, p)
+		} else {
+			kept = append(kept, p)
+		}
+	}
+	pool.ready = kept
+	m.poolsMu.Unlock()
+
+	for _, p := range discard {
+		m.discardPooledVM(p.vm, p.client)
+	}
+
+	for ctx.Err() == nil {
+		m.poolsMu.Lock()
+		if m.pools[name] != pool || len(pool.ready) >= pool.config.Size {
+			m.poolsMu.Unlock()
+			return
+		}
+		poolConfig := pool.config
+		pool.booting++
+		m.poolsMu.Unlock()
+
+		p, err := m.bootPooledVM(ctx, name, poolConfig)
+
+		m.poolsMu.Lock()
+		pool.booting--
+		if err == nil && m.pools[name] == pool {
+			pool.ready = append(pool.ready, *p)
+			p = nil
+		}
+		m.poolsMu.Unlock()
+
+		if err != nil {
+			fmt.Printf("Warning: failed to boot VM for pool %s: %v\n", name, err)
+			return
+		}
+		// The pool was removed while the VM booted.
+		if p != nil {
+			m.discardPooledVM(p.vm, p.client)
+			return
+		}
+	}
+}
+
+// bootPooledVM builds and boots a VM for a pool, and pauses it once its
+// micropod-init waits for a workload.
+func (m *Manager) bootPooledVM(ctx context.Context, name string, p config.Pool) (*pooledVM, error) {
+	initPath := m.config.GetGuestInitPath()
+	spec := VMSpec{Image: p.Image, Profile: p.Profile, VCPUs: p.VCPUs, MemoryMB: p.MemoryMB}
+
+	bootKernel, initrd, kernelArgs, err := m.bootSettings(spec)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKernelArgs(kernelArgs, true, true, false); err != nil {
+		return nil, err
+	}
+
+	img, err := m.imageService.PullImage(ctx, p.Image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pull image: %w", err)
+	}
+
+	vmID := uuid.New().String()
+	rootfsPath, err := m.buildRootfs(ctx, p.Image, initPath, nil, vmID)
+	if err != nil {
+		return nil, err
+	}
+
+	imageConfig := img.Config()
+	vm := state.VM{
+		ID:            vmID,
+		ImageName:     p.Image,
+		State:         "Pooled",
+		RootfsPath:    rootfsPath,
+		KernelPath:    bootKernel.Path,
+		Kernel:        bootKernel.Name,
+		KernelVersion: bootKernel.Version,
+		KernelArgs:    kernelArgs,
+		InitrdPath:    initrd,
+		Profile:       p.Profile,
+		CreatedAt:     time.Now(),
+		VCPUs:         p.VCPUs,
+		MemoryMB:      p.MemoryMB,
+		RestartPolicy: state.RestartPolicy{Name: "no"},
+		// Runs publish their metadata when they take the VM.
+		MMDSVersion: defaultMMDSVersion,
+		GuestInit:   true,
+		ImageConfig: &imageConfig,
+		Pool:        name,
+	}
+
+	// The VM is recorded before it launches, so that what it is given is
+	// seen as taken by the runs and pools that launch alongside it.
+	if err := m.store.AddVM(vm); err != nil {
+		m.cleanup(&vm)
+		return nil, fmt.Errorf("failed to store VM state: %w", err)
+	}
+
+	client, err := m.launch(&vm)
+	if err != nil {
+		m.discardPooledVM(vm, nil)
+		return nil, err
+	}
+	if err := client.Pause(); err != nil {
+		m.discardPooledVM(vm, client)
+		return nil, fmt.Errorf("failed to pause VM: %w", err)
+	}
+	if err := m.store.UpdateVM(vm); err != nil {
+		m.discardPooledVM(vm, client)
+		return nil, fmt.Errorf("failed to store VM state: %w", err)
+	}
+
+	return &pooledVM{vm: vm, digest: img.Digest(), client: client}, nil
+}
+
+// drainPools removes the VMs of all pools.
+func (m *Manager) drainPools() {
+	m.poolsMu.Lock()
+	var discard []pooledVM
+	for name, pool := range m.pools {
+		discard = append(discard, pool.ready...)
+		delete(m.pools, name)
+	}
+	m.poolsMu.Unlock()
+
+	for _, p := range discard {
+		m.discardPooledVM(p.vm, p.client)
+	}
+}
+
+// removeStalePooledVMs removes pooled VMs recorded by an earlier daemon,
+// whose processes cannot be handed out without their clients.
+func (m *Manager) removeStalePooledVMs() {
+	vms, err := m.store.ListVMs()
+	if err != nil {
+		fmt.Printf("Warning: failed to list VMs: %v\n", err)
+		return
+	}
+
+	for _, vm := range vms {
+		if vm.State == "Pooled" {
+			m.discardPooledVM(vm, nil)
+		}
+	}
+}
+
+// discardPooledVM stops a pooled VM, or one that failed to start for a run,
+// and removes it without events: no run created it.
+func (m *Manager) discardPooledVM(vm state.VM, client *firecracker.Client) {
+	if client != nil {
+		client.Stop()
+	} else if m.isProcessRunning(vm) {
+		if err := m.killProcess(vm.FirecrackerPid); err != nil {
+			fmt.Printf("Warning: failed to kill process %d: %v\n", vm.FirecrackerPid, err)
+		}
+	}
+
+	if err := m.cleanup(&vm); err != nil {
+		fmt.Printf("Warning: failed to clean up pooled VM %s: %v\n", vm.ID, err)
+	}
+
+	var notFound *state.NotFoundError
+	if err := m.store.RemoveVM(vm.ID); err != nil && !errors.As(err, &notFound) {
+		fmt.Printf("Warning: failed to remove pooled VM %s from state: %v\n", vm.ID, err)
+	}
+}
+
+// wakePools has RunPools check the pools without waiting for the next tick.
+func (m *Manager) wakePools() {
+	select {
+	case m.poolWake <- struct{}{}:
+	default:
+	}
+}
+
+// exited reports whether the Firecracker process of client is gone.
+func exited(client *firecracker.Client) bool {
+	select {
+	case <-client.Exited():
+		return true
+	default:
+		return false
+	}
+}
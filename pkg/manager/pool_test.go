@@ -0,0 +1,91 @@
+package manager
+
+import (
+	"testing"
+
+	"micropod/pkg/cgroup"
+	"micropod/pkg/config"
+	"micropod/pkg/firecracker"
+	"micropod/pkg/state"
+)
+
+func TestPoolFor(t *testing.T) {
+	base := DefaultVMSpec()
+	base.Image = "alpine:latest"
+	want := poolName(normalizePool(config.Pool{Image: "alpine:latest"}))
+
+	if name, ok := poolFor(base); !ok || name != want {
+		t.Errorf("poolFor(plain spec) = %q, %v; want %q", name, ok, want)
+	}
+
+	// Per-run settings micropod-init applies after boot keep a spec eligible.
+	run := base
+	run.Name = "web"
+	run.Env = map[string]string{"MODE": "test"}
+	run.Command = []string{"sleep", "1"}
+	run.Restart = "on-failure"
+	run.Tmpfs = []string{"/cache"}
+	if name, ok := poolFor(run); !ok || name != want {
+		t.Errorf("poolFor(spec with run settings) = %q, %v; want %q", name, ok, want)
+	}
+
+	profile := base
+	profile.Profile = "fast"
+	if name, _ := poolFor(profile); name == want {
+		t.Error("spec with another profile maps to the default profile's pool")
+	}
+
+	for name, change := range map[string]func(*VMSpec){
+		"jailer":      func(s *VMSpec) { s.Jailer = true },
+		"limits":      func(s *VMSpec) { s.Limits = cgroup.Limits{PidsMax: 10} },
+		"mounts":      func(s *VMSpec) { s.Mounts = []state.Mount{{Type: "volume", Source: "data", Target: "/data"}} },
+		"networks":    func(s *VMSpec) { s.Networks = []string{"backend"} },
+		"tty":         func(s *VMSpec) { s.TTY = true },
+		"read-only":   func(s *VMSpec) { s.ReadOnly = true },
+		"kernel args": func(s *VMSpec) { s.KernelArgs = "loglevel=3" },
+		"MMDS V1":     func(s *VMSpec) { s.MMDSVersion = "V1" },
+	} {
+		spec := base
+		change(&spec)
+		if _, ok := poolFor(spec); ok {
+			t.Errorf("spec with %s can use a pool", name)
+		}
+	}
+}
+
+func TestTakePooledVM(t *testing.T) {
+	spec := DefaultVMSpec()
+	spec.Image = "alpine:latest"
+	name, _ := poolFor(spec)
+
+	m := &Manager{pools: map[string]*vmPool{}, poolWake: make(chan struct{}, 1)}
+	m.pools[name] = &vmPool{
+		config: normalizePool(config.Pool{Image: "alpine:latest", Size: 1}),
+		ready:  []pooledVM{{vm: state.VM{ID: "a"}, digest: "sha256:1", client: firecracker.NewClient("")}},
+	}
+
+	if p := m.takePooledVM(spec, "sha256:1"); p == nil || p.vm.ID != "a" {
+		t.Fatalf("takePooledVM() = %v, want VM a", p)
+	}
+	if p := m.takePooledVM(spec, "sha256:1"); p != nil {
+		t.Errorf("takePooledVM() from an empty pool = VM %s", p.vm.ID)
+	}
+	other := spec
+	other.Image = "busybox:latest"
+	if p := m.takePooledVM(other, "sha256:1"); p != nil {
+		t.Errorf("takePooledVM() without a pool = VM %s", p.vm.ID)
+	}
+
+	stats := m.PoolStats()
+	if len(stats) != 1 {
+		t.Fatalf("PoolStats() = %+v, want one pool", stats)
+	}
+	if s := stats[0]; s.Hits != 1 || s.Misses != 1 || s.HitRate != 0.5 || s.Ready != 0 || s.Size != 1 {
+		t.Errorf("PoolStats() = %+v, want 1 hit, 1 miss and no ready VM", s)
+	}
+	select {
+	case <-m.poolWake:
+	default:
+		t.Error("taking a VM did not wake the refill")
+	}
+}
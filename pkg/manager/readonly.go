@@ -0,0 +1,188 @@
+package manager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"micropod/pkg/guest"
+	"micropod/pkg/image"
+)
+
+// validateWritableDirs checks the directories given a tmpfs or an overlay.
+func validateWritableDirs(kind string, dirs []string) error {
+	for _, dir := range dirs {
+		if !filepath.IsAbs(dir) || filepath.Clean(dir) != dir {
+			return fmt.Errorf("%s path %s must be absolute and clean", kind, dir)
+		}
+		if dir == "/" {
+			return fmt.Errorf("%s cannot be mounted on /", kind)
+		}
+	}
+	return nil
+}
+
+// mountPoints returns the directories a VM mounts over, which must exist in
+// a read-only rootfs.
+func mountPoints(spec VMSpec) []string {
+	dirs := append(append([]string{}, spec.Tmpfs...), spec.Overlays...)
+	for _, mount := range spec.Mounts {
+		dirs = append(dirs, mount.Target)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// buildRootfs unpacks an image, installs micropod-init and creates the
+// directories in dirs, and builds the rootfs of vmID from the result.
+func (m *Manager) buildRootfs(ctx context.Context, imageName, initPath string, dirs []string, vmID string) (string, error) {
+	// Create temporary directory for unpacking
+	tempDir := filepath.Join("/tmp", "micropod-unpack-"+vmID)
+	defer os.RemoveAll(tempDir)
+
+	// Unpack the image to the temporary directory
+	if _, err := m.imageService.Unpack(ctx, imageName, tempDir); err != nil {
+		return "", fmt.Errorf("failed to unpack image: %w", err)
+	}
+
+	if initPath != "" {
+		if err := guest.InstallInit(tempDir, initPath); err != nil {
+			return "", err
+		}
+	}
+
+	if err := createMountPoints(tempDir, dirs); err != nil {
+		return "", err
+	}
+
+	// Create ext4 rootfs from the unpacked directory
+	rootfsPath, err := m.rootfsCreator.CreateFromDir(tempDir, vmID)
+	if err != nil {
+		return "", fmt.Errorf("failed to create rootfs: %w", err)
+	}
+	return rootfsPath, nil
+}
+
+// sharedRootfs returns the rootfs of a read-only VM: a hard link to a base
+// image shared by the read-only VMs of the same image, micropod-init and
+// mount points. The base is built and cached on first use.
+func (m *Manager) sharedRootfs(ctx context.Context, img image.Image, imageName, initPath string, dirs []string, vmID string) (string, error) {
+	key, err := baseRootfsKey(img.Digest(), initPath, dirs)
+	if err != nil {
+		return "", err
+	}
+
+	baseDir := m.getBaseRootfsDir()
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create base rootfs directory: %w", err)
+	}
+	basePath := filepath.Join(baseDir, key+".ext4")
+
+	rootfsPath := filepath.Join(m.config.GetRootfsDir(), vmID+".ext4")
+	if err := os.Link(basePath, rootfsPath); err == nil {
+		return rootfsPath, nil
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to link base rootfs: %w", err)
+	}
+
+	rootfsPath, err = m.buildRootfs(ctx, imageName, initPath, dirs, vmID)
+	if err != nil {
+		return "", err
+	}
+	// Another VM may have published the same base meanwhile.
+	if err := os.Link(rootfsPath, basePath); err != nil && !os.IsExist(err) {
+		fmt.Printf("Warning: failed to cache base rootfs: %v\n", err)
+	}
+	return rootfsPath, nil
+}
+
+// pruneBaseRootfs removes the cached base images no VM links to anymore.
+func (m *Manager) pruneBaseRootfs() error {
+	entries, err := os.ReadDir(m.getBaseRootfsDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read base rootfs directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(m.getBaseRootfsDir(), entry.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		// A VM linking the base after this check keeps its own link to
+		// the data.
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Nlink == 1 {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove base rootfs: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Manager) getBaseRootfsDir() string {
+	return filepath.Join(m.config.GetRootfsDir(), "base")
+}
+
+// baseRootfsKey identifies the content of a base rootfs.
+func baseRootfsKey(digest, initPath string, dirs []string) (string, error) {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "image %s\n", digest)
+
+	if initPath != "" {
+		f, err := os.Open(initPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read micropod-init: %w", err)
+		}
+		defer f.Close()
+
+		initHash := sha256.New()
+		if _, err := io.Copy(initHash, f); err != nil {
+			return "", fmt.Errorf("failed to read micropod-init: %w", err)
+		}
+		fmt.Fprintf(hash, "init %x\n", initHash.Sum(nil))
+	}
+
+	for _, dir := range dirs {
+		fmt.Fprintf(hash, "dir %s\n", dir)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:32], nil
+}
+
+// createMountPoints creates dirs under root. Paths leading through a
+// symlink or a file are left to the guest, so that nothing is created
+// outside root.
+func createMountPoints(root string, dirs []string) error {
+	for _, dir := range dirs {
+		path := root
+		for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+			path = filepath.Join(path, part)
+
+			info, err := os.Lstat(path)
+			if os.IsNotExist(err) {
+				if err := os.Mkdir(path, 0755); err != nil {
+					return fmt.Errorf("failed to create mount point %s: %w", dir, err)
+				}
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to create mount point %s: %w", dir, err)
+			}
+			if !info.IsDir() {
+				break
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateMountPoints(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := createMountPoints(root, []string{"/data/cache", "/escape/etc", "/tmp"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range []string{"data/cache", "tmp"} {
+		if info, err := os.Stat(filepath.Join(root, dir)); err != nil || !info.IsDir() {
+			t.Errorf("%s was not created: %v", dir, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outside, "etc")); !os.IsNotExist(err) {
+		t.Errorf("mount point was created through a symlink: %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package manager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"micropod/pkg/state"
+)
+
+const (
+	// restartBaseDelay is the delay before the first restart; it doubles
+	// with every restart up to restartMaxDelay.
+	restartBaseDelay = 100 * time.Millisecond
+	restartMaxDelay  = time.Minute
+)
+
+// ParseRestartPolicy parses no, on-failure[:N], always or unless-stopped.
+func ParseRestartPolicy(value string) (state.RestartPolicy, error) {
+	name, retries, hasRetries := strings.Cut(value, ":")
+
+	switch name {
+	case "", "no":
+		if hasRetries {
+			break
+		}
+		return state.RestartPolicy{Name: "no"}, nil
+	case "always", "unless-stopped":
+		if hasRetries {
+			break
+		}
+		return state.RestartPolicy{Name: name}, nil
+	case "on-failure":
+		policy := state.RestartPolicy{Name: name}
+		if hasRetries {
+			n, err := strconv.Atoi(retries)
+			if err != nil || n < 0 {
+				return policy, fmt.Errorf("invalid restart policy %q: retry count must be a non-negative integer", value)
+			}
+			policy.MaxRetries = n
+		}
+		return policy, nil
+	}
+
+	return state.RestartPolicy{}, fmt.Errorf("invalid restart policy %q (use no, on-failure[:N], always or unless-stopped)", value)
+}
+
+// shouldRestart decides whether a VM is restarted after an exit. Stopping a
+// VM removes it, so unless-stopped restarts exactly like always.
+func shouldRestart(policy state.RestartPolicy, restartCount int, failed bool) bool {
+	switch policy.Name {
+	case "always", "unless-stopped":
+		return true
+	case "on-failure":
+		return failed && (policy.MaxRetries == 0 || restartCount < policy.MaxRetries)
+	default:
+		return false
+	}
+}
+
+// restartDelay is the exponential backoff before restart number
+// restartCount+1.
+func restartDelay(restartCount int) time.Duration {
+	delay := restartBaseDelay
+	for i := 0; i < restartCount && delay < restartMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > restartMaxDelay {
+		delay = restartMaxDelay
+	}
+	return delay
+}
+
+// handleExit is called once the Firecracker process of a running VM is
+// gone. Its console is drained and copy-out directories are copied back
+// first. An exit status reported by micropod-init replaces reason and
+// failed. VMs started with AutoRemove are removed; the others keep their
+// record and rootfs and are either restarted or marked as exited.
+func (m *Manager) handleExit(vm state.VM, reason string, failed bool) {
+	m.stopHealthCheck(vm.ID)
+	m.releaseConsole(vm.ID)
+	m.copyOut(vm)
+
+	exitCode := exitCodeOf(failed)
+	if code, ok := m.readExitStatus(vm); ok {
+		exitCode, failed = code, code != 0
+		reason = fmt.Sprintf("workload exited with code %d", code)
+	}
+	vm.ExitCode = &exitCode
+
+	if vm.AutoRemove {
+		m.cleanupDeadVM(vm, reason, exitCode)
+		return
+	}
+
+	fmt.Printf("VM %s exited (%s)\n", vm.ID, reason)
+	m.emitExit(EventDie, vm, reason, exitCode)
+
+	if err := m.cleanupRuntime(&vm); err != nil {
+		fmt.Printf("Warning: failed to clean up exited VM %s: %v\n", vm.ID, err)
+	}
+
+	m.afterExit(vm, reason, failed)
+}
+
+// afterExit applies the restart policy to a VM whose process is gone.
+func (m *Manager) afterExit(vm state.VM, reason string, failed bool) {
+	vm.LastExitReason = reason
+
+	if !shouldRestart(vm.RestartPolicy, vm.RestartCount, failed) {
+		vm.State = "Exited"
+		if err := m.store.UpdateVM(vm); err != nil {
+			fmt.Printf("Warning: failed to update exited VM %s: %v\n", vm.ID, err)
+		}
+		return
+	}
+
+	vm.State = "Restarting"
+	if err := m.store.UpdateVM(vm); err != nil {
+		fmt.Printf("Warning: failed to update restarting VM %s: %v\n", vm.ID, err)
+		return
+	}
+
+	m.scheduleRestart(vm)
+}
+
+func (m *Manager) scheduleRestart(vm state.VM) {
+	delay := restartDelay(vm.RestartCount)
+	fmt.Printf("Restarting VM %s in %s (restart policy %s)\n", vm.ID, delay, vm.RestartPolicy)
+
+	time.AfterFunc(delay, func() {
+		m.restartVM(vm.ID)
+	})
+}
+
+// restartVM launches a VM that is waiting to be restarted. It does nothing
+// if the VM was stopped in the meantime.
+func (m *Manager) restartVM(vmID string) {
+	vm, err := m.store.GetVM(vmID)
+	if err != nil || vm.State != "Restarting" {
+		return
+	}
+
+	vm.RestartCount++
+
+	client, err := m.launch(vm)
+	if err != nil {
+		fmt.Printf("Warning: failed to restart VM %s: %v\n", vmID, err)
+		reason := fmt.Sprintf("restart failed: %v", err)
+		exitCode := exitCodeOf(true)
+		vm.ExitCode = &exitCode
+		m.emitExit(EventDie, *vm, reason, exitCode)
+		m.afterExit(*vm, reason, true)
+		return
+	}
+
+	if err := m.store.UpdateVM(*vm); err != nil {
+		fmt.Printf("Warning: failed to update restarted VM %s: %v\n", vmID, err)
+		client.Stop()
+		m.releaseConsole(vmID)
+		m.cleanupRuntime(vm)
+		return
+	}
+
+	m.emit(EventStart, *vm, "")
+	m.trackClient(vmID, client)
+	m.startHealthCheck(*vm)
+}
+
+// restoreVMs resumes restarts that were pending when the daemon stopped,
+// and the exit listeners and health checks of running VMs. VMs that were
+// running when the host went down are found dead by the supervisor and
+// restarted according to their policy.
+func (m *Manager) restoreVMs() error {
+	vms, err := m.store.ListVMs()
+	if err != nil {
+		return fmt.Errorf("failed to list VMs: %w", err)
+	}
+
+	for _, vm := range vms {
+		if vm.State == "Restarting" {
+			m.scheduleRestart(vm)
+		}
+		if hasProcess(vm) {
+			if err := m.relistenForExit(vm); err != nil {
+				fmt.Printf("Warning: failed to listen for exit status of VM %s: %v\n", vm.ID, err)
+			}
+			m.startHealthCheck(vm)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"micropod/pkg/state"
+)
+
+func TestParseRestartPolicy(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    state.RestartPolicy
+		wantErr bool
+	}{
+		{value: "", want: state.RestartPolicy{Name: "no"}},
+		{value: "no", want: state.RestartPolicy{Name: "no"}},
+		{value: "always", want: state.RestartPolicy{Name: "always"}},
+		{value: "unless-stopped", want: state.RestartPolicy{Name: "unless-stopped"}},
+		{value: "on-failure", want: state.RestartPolicy{Name: "on-failure"}},
+		{value: "on-failure:3", want: state.RestartPolicy{Name: "on-failure", MaxRetries: 3}},
+		{value: "on-failure:-1", wantErr: true},
+		{value: "always:2", wantErr: true},
+		{value: "sometimes", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRestartPolicy(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseRestartPolicy(%q): expected an error", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseRestartPolicy(%q): %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRestartPolicy(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	onFailure := state.RestartPolicy{Name: "on-failure", MaxRetries: 2}
+
+	if shouldRestart(state.RestartPolicy{Name: "no"}, 0, true) {
+		t.Error("Policy no must never restart")
+	}
+	if !shouldRestart(state.RestartPolicy{Name: "always"}, 100, false) {
+		t.Error("Policy always must restart clean exits")
+	}
+	if shouldRestart(onFailure, 0, false) {
+		t.Error("Policy on-failure must not restart clean exits")
+	}
+	if !shouldRestart(onFailure, 1, true) {
+		t.Error("Policy on-failure:2 must restart the second failure")
+	}
+	if shouldRestart(onFailure, 2, true) {
+		t.Error("Policy on-failure:2 must give up after two restarts")
+	}
+}
+
+func TestRestartDelay(t *testing.T) {
+	if got := restartDelay(0); got != 100*time.Millisecond {
+		t.Errorf("Expected first delay of 100ms, got %s", got)
+	}
+	if got := restartDelay(3); got != 800*time.Millisecond {
+		t.Errorf("Expected fourth delay of 800ms, got %s", got)
+	}
+	if got := restartDelay(50); got != time.Minute {
+		t.Errorf("Expected delay capped at 1m, got %s", got)
+	}
+}
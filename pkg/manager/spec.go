@@ -0,0 +1,307 @@
+package manager
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+
+	"micropod/pkg/cgroup"
+	"micropod/pkg/firecracker"
+	"micropod/pkg/state"
+)
+
+const (
+	// SpecAPIVersion is the version of the VMSpec format.
+	SpecAPIVersion = "micropod/v1"
+	SpecKind       = "VM"
+)
+
+// VMSpec describes a VM. run builds one from its flags; spec files hold one
+// per YAML or JSON document.
+type VMSpec struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// Name is an optional unique, human-friendly name. apply reconciles
+	// VMs by name.
+	Name     string `json:"name,omitempty"`
+	Image    string `json:"image"`
+	VCPUs    int    `json:"vcpus"`
+	MemoryMB int    `json:"memoryMB"`
+	// Jailer launches Firecracker through the jailer with its own uid/gid,
+	// chroot, cgroup and network namespace.
+	Jailer bool `json:"jailer,omitempty"`
+	// Limits are applied to the cgroup of the Firecracker process.
+	Limits cgroup.Limits `json:"limits"`
+	// MemoryOverheadMB, when set, caps the Firecracker process at the guest
+	// memory plus this much VMM overhead.
+	MemoryOverheadMB int `json:"memoryOverheadMB,omitempty"`
+	// Restart is the restart policy: no, on-failure[:N], always or
+	// unless-stopped.
+	Restart string `json:"restart,omitempty"`
+	// Labels are stored with the VM for selecting it.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations, Env, Command and Secrets are published to the guest
+	// through MMDS together with the labels.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	Command     []string          `json:"command,omitempty"`
+	Secrets     map[string]string `json:"secrets,omitempty"`
+	// MMDSVersion is V1 or V2. It defaults to V2 when there are secrets,
+	// labels or annotations to publish.
+	MMDSVersion string `json:"mmdsVersion,omitempty"`
+	// Mounts attach named volumes or block image files as extra drives,
+	// mounted in the guest by micropod-init.
+	Mounts []state.Mount `json:"mounts,omitempty"`
+	// AutoRemove deletes the VM when it exits instead of keeping it in the
+	// Exited state. It cannot be combined with a restart policy.
+	AutoRemove bool `json:"autoRemove,omitempty"`
+	// TTY connects the serial console to a pseudo-terminal so that
+	// sessions can attach to it, and runs the workload on it.
+	TTY bool `json:"tty,omitempty"`
+	// Kernel names the registry kernel to boot instead of the default.
+	Kernel string `json:"kernel,omitempty"`
+	// KernelArgs are merged into the kernel command line, overriding the
+	// defaults and the profile parameter by parameter.
+	KernelArgs string `json:"kernelArgs,omitempty"`
+	// Initrd is the path of an initial ramdisk on the daemon's host.
+	Initrd string `json:"initrd,omitempty"`
+	// ReadOnly attaches the rootfs read-only. Read-only VMs of the same
+	// image share one cached rootfs image.
+	ReadOnly bool `json:"readOnly,omitempty"`
+	// Tmpfs and Overlays are guest directories made writable by
+	// micropod-init with an empty tmpfs or a tmpfs layer over the image's
+	// content. Writes to them are discarded when the VM exits.
+	Tmpfs    []string `json:"tmpfs,omitempty"`
+	Overlays []string `json:"overlays,omitempty"`
+	// Profile names a profile of config.json providing defaults for
+	// Kernel, KernelArgs and Initrd. The profile called default applies
+	// when none is named.
+	Profile string `json:"profile,omitempty"`
+	// Networks names the networks the VM is attached to, each through its
+	// own interface. micropod-init configures their addresses.
+	Networks []string `json:"networks,omitempty"`
+	// BootTimeout bounds the wait for the guest to boot, e.g. 10s; it
+	// defaults to 30s, and 0 does not wait.
+	BootTimeout string `json:"bootTimeout,omitempty"`
+	// HealthCheck overrides the image's health check.
+	HealthCheck *HealthCheckSpec `json:"healthCheck,omitempty"`
+}
+
+// DefaultVMSpec returns the resources used when none are requested.
+func DefaultVMSpec() VMSpec {
+	return VMSpec{
+		APIVersion: SpecAPIVersion,
+		Kind:       SpecKind,
+		VCPUs:      1,
+		MemoryMB:   512,
+	}
+}
+
+// Validate checks the parts of a spec that do not depend on the host. An
+// empty APIVersion or Kind stands for the current ones.
+func (s VMSpec) Validate() error {
+	if s.APIVersion != "" && s.APIVersion != SpecAPIVersion {
+		return fmt.Errorf("unsupported apiVersion %q: expected %s", s.APIVersion, SpecAPIVersion)
+	}
+	if s.Kind != "" && s.Kind != SpecKind {
+		return fmt.Errorf("unsupported kind %q: expected %s", s.Kind, SpecKind)
+	}
+	if s.Image == "" {
+		return fmt.Errorf("image is required")
+	}
+	if s.VCPUs <= 0 || s.MemoryMB <= 0 {
+		return fmt.Errorf("vcpus and memoryMB must be positive")
+	}
+	if _, err := parseBootTimeout(s.BootTimeout); err != nil {
+		return err
+	}
+	if s.HealthCheck != nil {
+		if err := s.HealthCheck.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Hash identifies the VM a spec describes, for apply to tell whether a VM
+// still matches its spec.
+func (s VMSpec) Hash() (string, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal spec: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ParseSpecs decodes the VM specs of a spec file: YAML documents separated
+// by ---, or JSON. Fields not set take their value from DefaultVMSpec;
+// unknown fields are rejected.
+func ParseSpecs(data []byte) ([]VMSpec, error) {
+	var specs []VMSpec
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for i := 1; ; i++ {
+		var document interface{}
+		if err := decoder.Decode(&document); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to parse spec %d: %w", i, err)
+		}
+		if document == nil {
+			continue
+		}
+
+		// Decode through JSON so that the field names and checks are
+		// those of the API.
+		documentJSON, err := json.Marshal(document)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse spec %d: %w", i, err)
+		}
+		spec := DefaultVMSpec()
+		jsonDecoder := json.NewDecoder(bytes.NewReader(documentJSON))
+		jsonDecoder.DisallowUnknownFields()
+		if err := jsonDecoder.Decode(&spec); err != nil {
+			return nil, fmt.Errorf("failed to parse spec %d: %w", i, err)
+		}
+
+		var version struct {
+			APIVersion string `json:"apiVersion"`
+			Kind       string `json:"kind"`
+		}
+		json.Unmarshal(documentJSON, &version)
+		if version.APIVersion == "" || version.Kind == "" {
+			return nil, fmt.Errorf("spec %d: apiVersion and kind are required (apiVersion: %s, kind: %s)", i, SpecAPIVersion, SpecKind)
+		}
+		if err := spec.Validate(); err != nil {
+			return nil, fmt.Errorf("spec %d: %w", i, err)
+		}
+
+		specs = append(specs, spec)
+	}
+
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("no VM specs found")
+	}
+	return specs, nil
+}
+
+// Actions taken by ApplyVM.
+const (
+	ApplyCreated   = "created"
+	ApplyUpdated   = "updated"
+	ApplyUnchanged = "unchanged"
+)
+
+// ApplyResult tells what ApplyVM did to reconcile a VM with its spec.
+type ApplyResult struct {
+	Name   string `json:"name"`
+	ID     string `json:"id"`
+	Action string `json:"action"`
+}
+
+// ApplyVM makes the VM named in spec match it: the VM is created if there
+// is none, left alone if it runs from the same spec, and replaced
+// otherwise, including when it has exited. A VM whose replacement fails is
+// restored.
+func (m *Manager) ApplyVM(spec VMSpec) (*ApplyResult, error) {
+	if spec.Name == "" {
+		return nil, fmt.Errorf("apply requires a name to reconcile the VM by")
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	spec.APIVersion, spec.Kind = SpecAPIVersion, SpecKind
+	specHash, err := spec.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ApplyResult{Name: spec.Name, Action: ApplyCreated}
+	if existing, err := m.store.ResolveVM(spec.Name); err == nil && existing.Name == spec.Name {
+		if existing.SpecHash == specHash && existing.State != "Exited" {
+			result.ID, result.Action = existing.ID, ApplyUnchanged
+			return result, nil
+		}
+
+		// The name, and any volume mounted read-write, must be free
+		// before the replacement is created.
+		// The old VM is kept until the replacement runs, and restored
+		// if it fails.
+		replaced := *existing
+		if err := m.setAside(&replaced); err != nil {
+			return nil, fmt.Errorf("failed to replace VM %s: %w", existing.ID, err)
+		}
+		if result.ID, err = m.RunVM(spec); err != nil {
+			m.restoreReplaced(replaced, *existing)
+			return nil, err
+		}
+		if err := m.removeVM(&replaced); err != nil {
+			fmt.Printf("Warning: failed to remove replaced VM %s: %v\n", replaced.ID, err)
+		}
+		result.Action = ApplyUpdated
+		return result, nil
+	}
+
+	if result.ID, err = m.RunVM(spec); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// setAside stops a VM that apply replaces and frees its name, keeping its
+// record and rootfs until the replacement runs.
+func (m *Manager) setAside(vm *state.VM) error {
+	m.stopProcess(vm)
+	if err := m.cleanupRuntime(vm); err != nil {
+		fmt.Printf("Warning: failed to clean up replaced VM %s: %v\n", vm.ID, err)
+	}
+
+	vm.Name = ""
+	vm.State = "Exited"
+	return m.store.UpdateVM(*vm)
+}
+
+// restoreReplaced returns a VM set aside by apply to its original state
+// after the replacement failed: it gets its name back and is launched
+// again if it was running, or restarted later if it was waiting to be.
+func (m *Manager) restoreReplaced(vm, original state.VM) {
+	vm.Name = original.Name
+
+	var client *firecracker.Client
+	switch {
+	case hasProcess(original):
+		var err error
+		if client, err = m.launch(&vm); err != nil {
+			fmt.Printf("Warning: failed to restore replaced VM %s: %v\n", vm.ID, err)
+		}
+	case original.State == "Restarting":
+		vm.State = original.State
+	}
+
+	if err := m.store.UpdateVM(vm); err != nil {
+		fmt.Printf("Warning: failed to restore replaced VM %s: %v\n", vm.ID, err)
+		if client != nil {
+			client.Stop()
+			m.releaseConsole(vm.ID)
+			m.cleanupRuntime(&vm)
+		}
+		return
+	}
+
+	switch {
+	case client != nil:
+		m.emit(EventStart, vm, "")
+		m.trackClient(vm.ID, client)
+		m.startHealthCheck(vm)
+	case vm.State == "Restarting":
+		m.scheduleRestart(vm)
+	}
+}
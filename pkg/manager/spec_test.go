@@ -0,0 +1,75 @@
+package manager
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSpecs(t *testing.T) {
+	specs, err := ParseSpecs([]byte(`
+apiVersion: micropod/v1
+kind: VM
+name: web
+image: nginx:alpine
+memoryMB: 256
+labels:
+  team: infra
+mounts:
+  - type: volume
+    source: cache
+    target: /var/cache/nginx
+---
+{"apiVersion": "micropod/v1", "kind": "VM", "image": "alpine:latest", "command": ["sleep", "60"]}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(specs) != 2 {
+		t.Fatalf("got %d specs, want 2", len(specs))
+	}
+
+	web := specs[0]
+	if web.Name != "web" || web.MemoryMB != 256 || web.VCPUs != 1 || web.Labels["team"] != "infra" {
+		t.Errorf("web spec = %+v", web)
+	}
+	if len(web.Mounts) != 1 || web.Mounts[0].Target != "/var/cache/nginx" {
+		t.Errorf("web mounts = %+v", web.Mounts)
+	}
+	if specs[1].MemoryMB != 512 || strings.Join(specs[1].Command, " ") != "sleep 60" {
+		t.Errorf("second spec = %+v", specs[1])
+	}
+
+	for name, doc := range map[string]string{
+		"unknown field":   "apiVersion: micropod/v1\nkind: VM\nimage: alpine\nmemory: 256\n",
+		"no apiVersion":   "kind: VM\nimage: alpine\n",
+		"wrong version":   "apiVersion: micropod/v2\nkind: VM\nimage: alpine\n",
+		"no image":        "apiVersion: micropod/v1\nkind: VM\nname: web\n",
+		"unsupported":     "apiVersion: micropod/v1\nkind: VM\nimage: alpine\nports: [\"80:80\"]\n",
+		"empty file":      "",
+		"invalid yaml":    "apiVersion: [\n",
+		"negative memory": "apiVersion: micropod/v1\nkind: VM\nimage: alpine\nmemoryMB: -1\n",
+	} {
+		if _, err := ParseSpecs([]byte(doc)); err == nil {
+			t.Errorf("ParseSpecs(%s) succeeded", name)
+		}
+	}
+}
+
+func TestVMSpecHash(t *testing.T) {
+	a := DefaultVMSpec()
+	a.Image = "alpine:latest"
+	a.Env = map[string]string{"A": "1", "B": "2"}
+	b := a
+	b.Env = map[string]string{"B": "2", "A": "1"}
+
+	hashA, _ := a.Hash()
+	hashB, _ := b.Hash()
+	if hashA != hashB {
+		t.Error("equal specs hash differently")
+	}
+
+	b.MemoryMB = 1024
+	if hashB, _ = b.Hash(); hashA == hashB {
+		t.Error("different specs hash the same")
+	}
+}
@@ -0,0 +1,162 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"micropod/pkg/cgroup"
+	"micropod/pkg/firecracker"
+	"micropod/pkg/state"
+)
+
+// supervisePollInterval is how often VMs without a tracked process, such as
+// those started by an earlier daemon, are checked for liveness.
+const supervisePollInterval = 5 * time.Second
+
+// Supervise watches the Firecracker processes of all VMs until ctx is done,
+// cleans up VMs whose process has exited and bounds their console logs.
+func (m *Manager) Supervise(ctx context.Context) {
+	if err := m.restoreVMs(); err != nil {
+		fmt.Printf("Warning: failed to restore VMs: %v\n", err)
+	}
+
+	ticker := time.NewTicker(supervisePollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.reapDeadVMs(); err != nil {
+			fmt.Printf("Warning: failed to check VMs: %v\n", err)
+		}
+		m.rotateConsoleLogs()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reapDeadVMs handles VMs whose untracked process is gone.
+func (m *Manager) reapDeadVMs() error {
+	vms, err := m.store.ListVMs()
+	if err != nil {
+		return fmt.Errorf("failed to list VMs: %w", err)
+	}
+
+	for _, vm := range vms {
+		if !hasProcess(vm) || m.isAlive(vm) {
+			continue
+		}
+		reason, failed := m.exitReason(vm, nil)
+		m.handleExit(vm, reason, failed)
+	}
+
+	return nil
+}
+
+// hasProcess reports whether a VM is in a state with a Firecracker process,
+// as opposed to waiting for a restart or having exited.
+func hasProcess(vm state.VM) bool {
+	return vm.State == "Running" || vm.State == "Paused"
+}
+
+// isAlive reports whether the Firecracker process of a VM is running.
+// Tracked processes are reaped by their watcher, which handles their exit.
+func (m *Manager) isAlive(vm state.VM) bool {
+	return m.isTracked(vm.ID) || m.isProcessRunning(vm)
+}
+
+// exitReason describes why the Firecracker process of a VM is gone and
+// whether that counts as a failure. The exit status is only known for
+// processes started by this manager; an unexplained exit, e.g. after a host
+// reboot, is treated as a failure, and so is the kill of an unhealthy VM.
+func (m *Manager) exitReason(vm state.VM, exitState *os.ProcessState) (string, bool) {
+	if m.killedUnhealthy(vm.ID) {
+		return "unhealthy", true
+	}
+
+	if vm.CgroupPath != "" {
+		if stats, err := cgroup.ReadStats(vm.CgroupPath); err == nil && stats.OOMKills > 0 {
+			return "oom-killed", true
+		}
+	}
+
+	if exitState == nil {
+		return "process exited", true
+	}
+
+	if status, ok := exitState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return fmt.Sprintf("killed by signal %s", status.Signal()), true
+	}
+
+	return fmt.Sprintf("exited with code %d", exitState.ExitCode()), !exitState.Success()
+}
+
+// trackClient registers the client of a VM started by this manager and
+// watches for its process to exit.
+func (m *Manager) trackClient(vmID string, client *firecracker.Client) {
+	m.clientsMu.Lock()
+	m.clients[vmID] = client
+	m.clientsMu.Unlock()
+
+	go m.watch(vmID, client)
+}
+
+// untrackClient removes and returns the client of a VM, so that its exit is
+// no longer treated as unexpected.
+func (m *Manager) untrackClient(vmID string) *firecracker.Client {
+	m.clientsMu.Lock()
+	defer m.clientsMu.Unlock()
+
+	client := m.clients[vmID]
+	delete(m.clients, vmID)
+	return client
+}
+
+// trackedClient returns the client of a VM whose process this manager
+// watches, or nil.
+func (m *Manager) trackedClient(vmID string) *firecracker.Client {
+	m.clientsMu.Lock()
+	defer m.clientsMu.Unlock()
+	return m.clients[vmID]
+}
+
+func (m *Manager) isTracked(vmID string) bool {
+	m.clientsMu.Lock()
+	defer m.clientsMu.Unlock()
+
+	_, ok := m.clients[vmID]
+	return ok
+}
+
+func (m *Manager) watch(vmID string, client *firecracker.Client) {
+	<-client.Exited()
+
+	// The VM was stopped on purpose.
+	if m.trackedClient(vmID) != client {
+		return
+	}
+
+	// The client stays tracked while the exit is handled, so that the
+	// supervisor does not take the VM for one whose process is gone
+	// unnoticed and handle its exit as well.
+	defer func() {
+		m.clientsMu.Lock()
+		if m.clients[vmID] == client {
+			delete(m.clients, vmID)
+		}
+		m.clientsMu.Unlock()
+	}()
+
+	vm, err := m.store.GetVM(vmID)
+	if err != nil {
+		return
+	}
+
+	reason, failed := m.exitReason(*vm, client.ExitState())
+	m.handleExit(*vm, reason, failed)
+}
@@ -0,0 +1,227 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"micropod/pkg/state"
+	"micropod/pkg/volume"
+)
+
+// defaultVolumeSizeMB is the size of volumes created implicitly by run -v.
+const defaultVolumeSizeMB = 1024
+
+// ParseVolumeSpec parses a run -v value: name:/guest/path[:ro], or
+// /host/image.ext4:/guest/path[:ro] for a block image file.
+func ParseVolumeSpec(spec string) (state.Mount, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return state.Mount{}, fmt.Errorf("invalid volume %q (expected name:/path[:ro])", spec)
+	}
+
+	mount := state.Mount{Type: "volume", Source: parts[0], Target: parts[1]}
+	if strings.HasPrefix(mount.Source, "/") {
+		mount.Type = "block"
+	}
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "ro":
+			mount.ReadOnly = true
+		case "rw":
+		default:
+			return state.Mount{}, fmt.Errorf("invalid volume option %q in %q (use ro or rw)", parts[2], spec)
+		}
+	}
+
+	return mount, nil
+}
+
+// ParseMountSpec parses a run --mount value such as
+// type=block,src=/images/data.ext4,dst=/data,readonly.
+func ParseMountSpec(spec string) (state.Mount, error) {
+	mount := state.Mount{Type: "volume"}
+	for _, field := range strings.Split(spec, ",") {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "type":
+			mount.Type = value
+		case "src", "source":
+			mount.Source = value
+		case "dst", "destination", "target":
+			mount.Target = value
+		case "readonly", "ro":
+			mount.ReadOnly = value == "" || value == "true" || value == "1"
+		default:
+			return state.Mount{}, fmt.Errorf("unknown mount option %q in %q", key, spec)
+		}
+	}
+
+	return mount, nil
+}
+
+// validateMounts validates the mounts of a new VM. Named volumes that do not
+// exist yet are created by createVolumes once the image is pulled; the
+// drives of copy-in and copy-out mounts are built by packMounts, once the VM
+// has an ID.
+func (m *Manager) validateMounts(mounts []state.Mount) error {
+	targets := make(map[string]bool)
+	for _, mount := range mounts {
+		if !path.IsAbs(mount.Target) || path.Clean(mount.Target) == "/" {
+			return fmt.Errorf("invalid mount target %q: must be an absolute path other than /", mount.Target)
+		}
+		if targets[path.Clean(mount.Target)] {
+			return fmt.Errorf("duplicate mount target %s", mount.Target)
+		}
+		targets[path.Clean(mount.Target)] = true
+
+		switch mount.Type {
+		case "volume":
+			if _, err := m.volumes.Get(mount.Source); err != nil {
+				var notFound *volume.NotFoundError
+				if !errors.As(err, &notFound) {
+					return err
+				}
+				continue
+			}
+			if !mount.ReadOnly {
+				if users, err := m.volumeUsers(mount.Source, true); err != nil {
+					return err
+				} else if len(users) > 0 {
+					return fmt.Errorf("volume %s is mounted read-write by VM %s; mount it read-only to share it", mount.Source, users[0])
+				}
+			}
+		case "block":
+			if !filepath.IsAbs(mount.Source) {
+				return fmt.Errorf("invalid block mount source %q: must be an absolute path", mount.Source)
+			}
+			if _, err := os.Stat(mount.Source); err != nil {
+				return fmt.Errorf("invalid block mount source: %w", err)
+			}
+		case "copy-in":
+			if info, err := os.Stat(mount.Source); err != nil {
+				return fmt.Errorf("invalid copy-in source: %w", err)
+			} else if !info.IsDir() {
+				return fmt.Errorf("invalid copy-in source %s: not a directory", mount.Source)
+			}
+		case "copy-out":
+			if !filepath.IsAbs(mount.Source) {
+				return fmt.Errorf("invalid copy-out destination %q: must be an absolute path", mount.Source)
+			}
+			if err := validateOwner(mount.Owner); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported mount type %q (use volume, block, copy-in or copy-out)", mount.Type)
+		}
+	}
+
+	return nil
+}
+
+// createVolumes creates the named volumes of mounts that do not exist yet
+// and returns their names, so that a run failing later can remove them.
+func (m *Manager) createVolumes(mounts []state.Mount) ([]string, error) {
+	var created []string
+	for _, mount := range mounts {
+		if mount.Type != "volume" {
+			continue
+		}
+		_, err := m.volumes.Get(mount.Source)
+		if err == nil {
+			continue
+		}
+		var notFound *volume.NotFoundError
+		if !errors.As(err, &notFound) {
+			m.removeVolumes(created)
+			return nil, err
+		}
+
+		if _, err := m.CreateVolume(mount.Source, defaultVolumeSizeMB); err != nil {
+			m.removeVolumes(created)
+			return nil, err
+		}
+		created = append(created, mount.Source)
+	}
+	return created, nil
+}
+
+// removeVolumes removes the volumes createVolumes created for a run that
+// failed.
+func (m *Manager) removeVolumes(names []string) {
+	for _, name := range names {
+		if err := m.volumes.Remove(name); err != nil {
+			fmt.Printf("Warning: failed to remove volume %s: %v\n", name, err)
+		}
+	}
+}
+
+// CreateVolume creates an empty ext4 volume of sizeMB MiB.
+func (m *Manager) CreateVolume(name string, sizeMB int) (*volume.Volume, error) {
+	return m.volumes.Create(name, sizeMB, m.rootfsCreator.CreateEmpty)
+}
+
+// GetVolume returns a volume together with the VMs using it.
+func (m *Manager) GetVolume(name string) (*volume.Volume, error) {
+	vol, err := m.volumes.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if vol.UsedBy, err = m.volumeUsers(name, false); err != nil {
+		return nil, err
+	}
+	return vol, nil
+}
+
+// ListVolumes returns all volumes together with the VMs using them.
+func (m *Manager) ListVolumes() ([]volume.Volume, error) {
+	volumes, err := m.volumes.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range volumes {
+		if volumes[i].UsedBy, err = m.volumeUsers(volumes[i].Name, false); err != nil {
+			return nil, err
+		}
+	}
+	return volumes, nil
+}
+
+// RemoveVolume deletes a volume that no VM uses, including exited VMs whose
+// records are kept.
+func (m *Manager) RemoveVolume(name string) error {
+	users, err := m.volumeUsers(name, false)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return &volume.InUseError{Name: name, VMIDs: users}
+	}
+
+	return m.volumes.Remove(name)
+}
+
+// volumeUsers returns the IDs of the VMs that mount a volume, optionally
+// only those mounting it read-write.
+func (m *Manager) volumeUsers(name string, writableOnly bool) ([]string, error) {
+	vms, err := m.store.ListVMs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VMs: %w", err)
+	}
+
+	var users []string
+	for _, vm := range vms {
+		for _, mount := range vm.Mounts {
+			if mount.Type == "volume" && mount.Source == name && (!writableOnly || !mount.ReadOnly) {
+				users = append(users, vm.ID)
+				break
+			}
+		}
+	}
+	return users, nil
+}
@@ -0,0 +1,47 @@
+package manager
+
+import (
+	"testing"
+
+	"micropod/pkg/state"
+)
+
+func TestParseVolumeSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    state.Mount
+		wantErr bool
+	}{
+		{spec: "data:/data", want: state.Mount{Type: "volume", Source: "data", Target: "/data"}},
+		{spec: "data:/data:ro", want: state.Mount{Type: "volume", Source: "data", Target: "/data", ReadOnly: true}},
+		{spec: "/srv/db.ext4:/var/lib/db:rw", want: state.Mount{Type: "block", Source: "/srv/db.ext4", Target: "/var/lib/db"}},
+		{spec: "data", wantErr: true},
+		{spec: "data:/data:noexec", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseVolumeSpec(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseVolumeSpec(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseVolumeSpec(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseMountSpec(t *testing.T) {
+	got, err := ParseMountSpec("type=block,src=/srv/db.ext4,dst=/data,readonly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := state.Mount{Type: "block", Source: "/srv/db.ext4", Target: "/data", ReadOnly: true}
+	if got != want {
+		t.Errorf("ParseMountSpec() = %+v, want %+v", got, want)
+	}
+
+	if _, err := ParseMountSpec("type=block,src=/a,dst=/b,bind-propagation=shared"); err == nil {
+		t.Error("ParseMountSpec accepted an unknown option")
+	}
+}
@@ -0,0 +1,149 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"micropod/pkg/state"
+)
+
+// exitCodeStopped is the exit code of a VM killed by stop, as if its
+// workload had been sent SIGKILL.
+const exitCodeStopped = 137
+
+// WaitResult tells how a VM's run ended.
+type WaitResult struct {
+	ExitCode int    `json:"exitCode"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// WaitVM blocks until a VM exits without being restarted, or is stopped, and
+// returns its exit code: the workload's exit status reported by
+// micropod-init, 137 when the VM was stopped, or else 0 or 1 for a clean or
+// failed exit of Firecracker. A VM that already exited returns at once, even
+// when it was removed on exit.
+func (m *Manager) WaitVM(ctx context.Context, ref string) (*WaitResult, error) {
+	// Subscribe before looking at the VM so its exit cannot be missed.
+	events, unsubscribe := m.events.Subscribe()
+	defer unsubscribe()
+
+	vm, err := m.store.ResolveVM(ref)
+	if err != nil {
+		if result := m.lastExit(ref); result != nil {
+			return result, nil
+		}
+		return nil, err
+	}
+
+	if vm.State == "Exited" {
+		return exitResult(*vm), nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case e, ok := <-events:
+			if !ok {
+				return nil, fmt.Errorf("event subscription closed")
+			}
+			if e.VMID != vm.ID || e.ExitCode == nil {
+				continue
+			}
+			if e.Type == EventStop {
+				return &WaitResult{ExitCode: *e.ExitCode, Reason: "stopped"}, nil
+			}
+			// Keep waiting for a VM that is about to be restarted; its exit
+			// code tells whether the exit was a failure.
+			if e.Type == EventDie {
+				current, err := m.store.GetVM(vm.ID)
+				if err != nil || !shouldRestart(current.RestartPolicy, current.RestartCount, *e.ExitCode != 0) {
+					return &WaitResult{ExitCode: *e.ExitCode, Reason: e.ExitReason}, nil
+				}
+			}
+		}
+	}
+}
+
+// readyPollInterval is how often WaitHealthy checks on a VM.
+const readyPollInterval = 500 * time.Millisecond
+
+// WaitHealthy blocks until a VM with a health check is healthy. Without a
+// check, it waits until the VM is running and its guest agent answers, which
+// happens once micropod-init has set up the guest and started the workload;
+// VMs booting the image's own init have no agent and are healthy once they
+// run. It fails if the VM exits or turns unhealthy first.
+func (m *Manager) WaitHealthy(ctx context.Context, ref string) error {
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+
+	for {
+		vm, err := m.store.ResolveVM(ref)
+		if err != nil {
+			return err
+		}
+		if vm.State == "Exited" {
+			return fmt.Errorf("VM %s exited before becoming healthy", vm.ID)
+		}
+		if vm.Health != nil && vm.Health.Status == state.HealthUnhealthy {
+			return fmt.Errorf("VM %s is unhealthy", vm.ID)
+		}
+		if vm.State == "Running" && isHealthy(*vm) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// isHealthy reports whether a running VM passed its health check, or
+// without one, whether it boots its own init or micropod-init answers.
+func isHealthy(vm state.VM) bool {
+	if vm.HealthCheck != nil {
+		return vm.Health != nil && vm.Health.Status == state.HealthHealthy
+	}
+	if !vm.GuestInit || vm.VsockPath == "" {
+		return true
+	}
+	return agentAnswers(vm)
+}
+
+// lastExit returns how the VM with the given ID or name last ended,
+// according to the event history.
+func (m *Manager) lastExit(ref string) *WaitResult {
+	filter := EventFilter{Types: []EventType{EventDie, EventStop}, VMIDs: []string{ref}}
+	events := m.events.History(time.Time{}, time.Time{}, filter)
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+		if e.ExitCode == nil {
+			continue
+		}
+		if e.Type == EventStop {
+			return &WaitResult{ExitCode: *e.ExitCode, Reason: "stopped"}
+		}
+		return &WaitResult{ExitCode: *e.ExitCode, Reason: e.ExitReason}
+	}
+	return nil
+}
+
+func exitResult(vm state.VM) *WaitResult {
+	result := &WaitResult{Reason: vm.LastExitReason}
+	if vm.ExitCode != nil {
+		result.ExitCode = *vm.ExitCode
+	}
+	return result
+}
+
+// exitCodeOf returns the exit code recorded for a run whose workload did not
+// report an exit status.
+func exitCodeOf(failed bool) int {
+	if failed {
+		return 1
+	}
+	return 0
+}
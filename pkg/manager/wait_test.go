@@ -0,0 +1,112 @@
+package manager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"micropod/pkg/state"
+)
+
+func TestWaitVM(t *testing.T) {
+	tempDir := t.TempDir()
+
+	statePath := filepath.Join(tempDir, "vms.json")
+	if err := os.WriteFile(statePath, []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := state.NewStore(statePath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	bus, err := NewEventBus(filepath.Join(tempDir, "events.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to create event bus: %v", err)
+	}
+	bus.subscribed = make(chan struct{}, 1)
+	m := &Manager{store: store, events: bus}
+
+	// waitVM runs WaitVM in the background and returns once it subscribed.
+	waitVM := func(vmID string) <-chan *WaitResult {
+		results := make(chan *WaitResult, 1)
+		go func() {
+			result, err := m.WaitVM(context.Background(), vmID)
+			if err != nil {
+				t.Errorf("WaitVM() error: %v", err)
+			}
+			results <- result
+		}()
+		<-bus.subscribed
+		return results
+	}
+	receive := func(results <-chan *WaitResult) *WaitResult {
+		select {
+		case result := <-results:
+			return result
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for WaitVM")
+			return nil
+		}
+	}
+
+	t.Run("waits past restarts", func(t *testing.T) {
+		restarting := state.VM{ID: "vm-restarting", State: "Running", RestartPolicy: state.RestartPolicy{Name: "on-failure", MaxRetries: 1}}
+		if err := store.AddVM(restarting); err != nil {
+			t.Fatal(err)
+		}
+
+		results := waitVM(restarting.ID)
+		m.emitExit(EventDie, restarting, "workload exited with code 2", 2)
+		m.emitExit(EventStop, restarting, "stopped", exitCodeStopped)
+
+		// Returning on the first exit would report its code instead.
+		if result := receive(results); result == nil || result.ExitCode != exitCodeStopped {
+			t.Errorf("WaitVM() = %+v, want exit code %d", result, exitCodeStopped)
+		}
+	})
+
+	t.Run("returns on the final exit", func(t *testing.T) {
+		final := state.VM{ID: "vm-final", State: "Running", RestartCount: 1, RestartPolicy: state.RestartPolicy{Name: "on-failure", MaxRetries: 1}}
+		if err := store.AddVM(final); err != nil {
+			t.Fatal(err)
+		}
+
+		results := waitVM(final.ID)
+		m.emitExit(EventDie, final, "workload exited with code 3", 3)
+
+		if result := receive(results); result == nil || result.ExitCode != 3 {
+			t.Errorf("WaitVM() = %+v, want exit code 3", result)
+		}
+	})
+
+	t.Run("removed VMs are found in the history", func(t *testing.T) {
+		removed := state.VM{ID: "vm-removed", AutoRemove: true}
+		m.emitExit(EventDie, removed, "workload exited with code 0", 0)
+
+		result, err := m.WaitVM(context.Background(), removed.ID)
+		if err != nil {
+			t.Fatalf("WaitVM() error: %v", err)
+		}
+		if result.ExitCode != 0 || result.Reason != "workload exited with code 0" {
+			t.Errorf("WaitVM() = %+v", result)
+		}
+	})
+
+	t.Run("exited VMs return at once", func(t *testing.T) {
+		code := 42
+		exited := state.VM{ID: "vm-exited", State: "Exited", ExitCode: &code}
+		if err := store.AddVM(exited); err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := m.WaitVM(context.Background(), exited.ID)
+		if err != nil {
+			t.Fatalf("WaitVM() error: %v", err)
+		}
+		if result.ExitCode != 42 {
+			t.Errorf("WaitVM() exit code = %d, want 42", result.ExitCode)
+		}
+	})
+}
@@ -0,0 +1,334 @@
+// Package metrics exposes micropod's own metrics in the Prometheus text
+// format. Packages register counters and histograms with Default when they
+// are initialized; values known only when scraped, such as VM counts, come
+// from collector functions.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metric types of the exposition format.
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// DurationBuckets are the histogram buckets, in seconds, of the durations
+// micropod measures: from mounting a cached rootfs to pulling a large image.
+var DurationBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// Default is the registry served by micropodd.
+var Default = NewRegistry()
+
+// Label is a label of a sample.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a value of a metric family with its labels.
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// Registry holds metric families and writes them in registration order,
+// followed by the families of its collectors.
+type Registry struct {
+	mu         sync.Mutex
+	metrics    []writerTo
+	collectors []func(*Writer)
+}
+
+type writerTo interface {
+	writeTo(w *Writer)
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Collect registers fn to write metric families on every scrape.
+func (r *Registry) Collect(fn func(w *Writer)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, fn)
+}
+
+// WriteTo writes all metrics in the text exposition format.
+func (r *Registry) WriteTo(out io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]writerTo(nil), r.metrics...)
+	collectors := append(([]func(*Writer))(nil), r.collectors...)
+	r.mu.Unlock()
+
+	w := &Writer{w: bufio.NewWriter(out)}
+	for _, m := range metrics {
+		m.writeTo(w)
+	}
+	for _, collect := range collectors {
+		collect(w)
+	}
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	return w.n, w.err
+}
+
+// Handler serves the registry's metrics over HTTP.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+func (r *Registry) register(m writerTo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Writer writes metric families in the text exposition format.
+type Writer struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+// Write writes a metric family with the given samples. A family without
+// samples is left out.
+func (w *Writer) Write(name, help, metricType string, samples ...Sample) {
+	if len(samples) == 0 {
+		return
+	}
+	w.header(name, help, metricType)
+	for _, s := range samples {
+		w.sample(name, s.Labels, s.Value)
+	}
+}
+
+func (w *Writer) header(name, help, metricType string) {
+	w.printf("# HELP %s %s\n", name, escapeHelp(help))
+	w.printf("# TYPE %s %s\n", name, metricType)
+}
+
+func (w *Writer) sample(name string, labels []Label, value float64) {
+	w.printf("%s%s %s\n", name, formatLabels(labels), formatValue(value))
+}
+
+func (w *Writer) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(w.w, format, args...)
+	w.n += int64(n)
+	w.err = err
+}
+
+// Counter is a family of counters, one per combination of label values.
+type Counter struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]*labeledValue
+}
+
+type labeledValue struct {
+	labels []Label
+	value  float64
+}
+
+// NewCounter registers a counter family with the given label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels, values: make(map[string]*labeledValue)}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the counter with the given label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter with the given
+// label values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key, labels := labelKey(c.name, c.labels, labelValues)
+	lv, ok := c.values[key]
+	if !ok {
+		lv = &labeledValue{labels: labels}
+		c.values[key] = lv
+	}
+	lv.value += v
+}
+
+func (c *Counter) writeTo(w *Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var samples []Sample
+	for _, key := range sortedKeys(c.values) {
+		lv := c.values[key]
+		samples = append(samples, Sample{Labels: lv.labels, Value: lv.value})
+	}
+	if len(samples) == 0 && len(c.labels) == 0 {
+		samples = []Sample{{Value: 0}}
+	}
+	w.Write(c.name, c.help, TypeCounter, samples...)
+}
+
+// Histogram is a family of histograms, one per combination of label values.
+type Histogram struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []Label
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram registers a histogram family with the given upper bounds of
+// its buckets, in increasing order, and label names.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogramValue)}
+	r.register(h)
+	return h
+}
+
+// Observe records v in the histogram with the given label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key, labels := labelKey(h.name, h.labels, labelValues)
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{labels: labels, counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			hv.counts[i]++
+		}
+	}
+	hv.count++
+	hv.sum += v
+}
+
+// Since records the seconds elapsed since start.
+func (h *Histogram) Since(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *Histogram) writeTo(w *Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.values) == 0 {
+		return
+	}
+	w.header(h.name, h.help, TypeHistogram)
+	for _, key := range sortedKeys(h.values) {
+		hv := h.values[key]
+		for i, bound := range h.buckets {
+			w.sample(h.name+"_bucket", withLabel(hv.labels, "le", formatValue(bound)), float64(hv.counts[i]))
+		}
+		w.sample(h.name+"_bucket", withLabel(hv.labels, "le", "+Inf"), float64(hv.count))
+		w.sample(h.name+"_sum", hv.labels, hv.sum)
+		w.sample(h.name+"_count", hv.labels, float64(hv.count))
+	}
+}
+
+// labelKey pairs label names with values. A wrong number of values is a
+// programming error.
+func labelKey(name string, names, values []string) (string, []Label) {
+	if len(names) != len(values) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", name, len(names), len(values)))
+	}
+	labels := make([]Label, len(names))
+	for i := range names {
+		labels[i] = Label{Name: names[i], Value: values[i]}
+	}
+	return strings.Join(values, "\xff"), labels
+}
+
+func withLabel(labels []Label, name, value string) []Label {
+	return append(append([]Label(nil), labels...), Label{Name: name, Value: value})
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l.Name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(l.Value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := NewRegistry()
+	pulls := r.NewCounter("test_pulls_total", "Image pulls.", "source")
+	r.NewCounter("test_failures_total", "Failures.")
+	boots := r.NewHistogram("test_boot_seconds", "Boot latency.", []float64{0.5, 1})
+	r.NewHistogram("test_unused_seconds", "Never observed.", []float64{1})
+	r.Collect(func(w *Writer) {
+		w.Write("test_vms", "VMs by state.", TypeGauge,
+			Sample{Labels: []Label{{Name: "state", Value: "Running"}}, Value: 2},
+			Sample{Labels: []Label{{Name: "name", Value: "a \"b\"\nc\\"}}, Value: 1})
+		w.Write("test_empty", "No samples.", TypeGauge)
+	})
+
+	pulls.Inc("registry")
+	pulls.Add(2, "cache")
+	boots.Observe(0.3)
+	boots.Observe(0.7)
+	boots.Observe(3)
+
+	var out strings.Builder
+	if _, err := r.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP test_pulls_total Image pulls.
+# TYPE test_pulls_total counter
+test_pulls_total{source="cache"} 2
+test_pulls_total{source="registry"} 1
+# HELP test_failures_total Failures.
+# TYPE test_failures_total counter
+test_failures_total 0
+# HELP test_boot_seconds Boot latency.
+# TYPE test_boot_seconds histogram
+test_boot_seconds_bucket{le="0.5"} 1
+test_boot_seconds_bucket{le="1"} 2
+test_boot_seconds_bucket{le="+Inf"} 3
+test_boot_seconds_sum 4
+test_boot_seconds_count 3
+# HELP test_vms VMs by state.
+# TYPE test_vms gauge
+test_vms{state="Running"} 2
+test_vms{name="a \"b\"\nc\\"} 1
+`
+	if out.String() != want {
+		t.Errorf("WriteTo() =\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestCounterLabelCount(t *testing.T) {
+	c := NewRegistry().NewCounter("test_total", "Test.", "a", "b")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Inc() with too few label values did not panic")
+		}
+	}()
+	c.Inc("x")
+}
@@ -0,0 +1,62 @@
+package network
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// CreateBridge creates a bridge in the host namespace, gives it address, in
+// CIDR notation, and brings it up.
+func CreateBridge(name, address string) error {
+	if err := ip("", "link", "add", "name", name, "type", "bridge"); err != nil {
+		return fmt.Errorf("failed to create bridge %s: %w", name, err)
+	}
+
+	if err := ip("", "addr", "add", address, "dev", name); err != nil {
+		ip("", "link", "delete", name)
+		return fmt.Errorf("failed to set address of bridge %s: %w", name, err)
+	}
+
+	if err := ip("", "link", "set", name, "up"); err != nil {
+		ip("", "link", "delete", name)
+		return fmt.Errorf("failed to bring up bridge %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// EnsureBridge creates a bridge unless it exists already, as after a host
+// reboot, when it is gone.
+func EnsureBridge(name, address string) error {
+	if LinkExists(name) {
+		return nil
+	}
+	return CreateBridge(name, address)
+}
+
+// DeleteBridge removes a bridge. Devices attached to it are detached, not
+// deleted.
+func DeleteBridge(name string) error {
+	if err := ip("", "link", "delete", name, "type", "bridge"); err != nil {
+		return fmt.Errorf("failed to delete bridge %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// AttachToBridge adds a device of the host namespace to a bridge.
+func AttachToBridge(device, bridge string) error {
+	if err := ip("", "link", "set", device, "master", bridge); err != nil {
+		return fmt.Errorf("failed to attach %s to bridge %s: %w", device, bridge, err)
+	}
+
+	return nil
+}
+
+// LinkExists reports whether the host namespace has a network device called
+// name.
+func LinkExists(name string) bool {
+	_, err := os.Stat(filepath.Join("/sys/class/net", name))
+	return err == nil
+}
@@ -0,0 +1,34 @@
+package network
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+)
+
+const netnsDir = "/var/run/netns"
+
+// CreateNetNS creates a named network namespace and returns its path.
+func CreateNetNS(name string) (string, error) {
+	cmd := exec.Command("ip", "netns", "add", name)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to create network namespace %s: %w: %s", name, err, output)
+	}
+
+	return NetNSPath(name), nil
+}
+
+// DeleteNetNS removes a named network namespace.
+func DeleteNetNS(name string) error {
+	cmd := exec.Command("ip", "netns", "delete", name)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to delete network namespace %s: %w: %s", name, err, output)
+	}
+
+	return nil
+}
+
+// NetNSPath returns the path of a named network namespace.
+func NetNSPath(name string) string {
+	return filepath.Join(netnsDir, name)
+}
@@ -0,0 +1,254 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	metadataFile = "network.json"
+	// maxNetworks is the number of /24 subnets of the network range.
+	maxNetworks = 256
+)
+
+// Network is a bridge on the host that VMs attached to it share, with one
+// /24 subnet of 10.99.0.0/16. The bridge holds the first address of the
+// subnet; VMs get the others.
+type Network struct {
+	Name      string            `json:"name"`
+	Bridge    string            `json:"bridge"`
+	Subnet    string            `json:"subnet"`
+	Gateway   string            `json:"gateway"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	// UsedBy lists the IDs of the VMs attached to the network. It is
+	// derived from the VM state, not stored.
+	UsedBy []string `json:"usedBy,omitempty"`
+}
+
+// GatewayCIDR returns the bridge's address with the subnet's prefix length.
+func (n *Network) GatewayCIDR() string {
+	prefix, err := netip.ParsePrefix(n.Subnet)
+	if err != nil {
+		return n.Gateway
+	}
+	return fmt.Sprintf("%s/%d", n.Gateway, prefix.Bits())
+}
+
+// AllocateAddress returns the first address of the subnet that is neither
+// the gateway nor in used, in CIDR notation.
+func (n *Network) AllocateAddress(used map[string]bool) (string, error) {
+	prefix, err := netip.ParsePrefix(n.Subnet)
+	if err != nil {
+		return "", fmt.Errorf("invalid subnet of network %s: %w", n.Name, err)
+	}
+
+	// Skip the network address and the gateway.
+	addr := prefix.Addr().Next().Next()
+	for ; prefix.Contains(addr); addr = addr.Next() {
+		if !prefix.Contains(addr.Next()) {
+			// The broadcast address.
+			break
+		}
+		if !used[addr.String()] {
+			return fmt.Sprintf("%s/%d", addr, prefix.Bits()), nil
+		}
+	}
+	return "", fmt.Errorf("network %s has no free address left", n.Name)
+}
+
+// MACAddress derives a locally administered MAC address from an IPv4
+// address, so that guests on a network never share one.
+func MACAddress(address string) (string, error) {
+	addr, err := netip.ParseAddr(address)
+	if err != nil || !addr.Is4() {
+		return "", fmt.Errorf("invalid IPv4 address %q", address)
+	}
+	b := addr.As4()
+	return fmt.Sprintf("06:00:%02x:%02x:%02x:%02x", b[0], b[1], b[2], b[3]), nil
+}
+
+// NotFoundError is returned when no network has the requested name.
+type NotFoundError struct {
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("network %s not found", e.Name)
+}
+
+// ExistsError is returned when creating a network whose name is taken.
+type ExistsError struct {
+	Name string
+}
+
+func (e *ExistsError) Error() string {
+	return fmt.Sprintf("network %s already exists", e.Name)
+}
+
+// InUseError is returned when removing a network VMs are attached to.
+type InUseError struct {
+	Name  string
+	VMIDs []string
+}
+
+func (e *InUseError) Error() string {
+	return fmt.Sprintf("network %s is in use by VMs %v", e.Name, e.VMIDs)
+}
+
+var namePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ValidateName checks that name can be used as a network name.
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid network name %q: only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	return nil
+}
+
+// Store keeps the metadata of each network in its own directory. It only
+// records networks; the bridges are created by the caller.
+type Store struct {
+	dir string
+	// mu serializes subnet allocation.
+	mu sync.Mutex
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create network directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Create records a network with the first free subnet.
+func (s *Store) Create(name string, labels map[string]string) (*Network, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	networks, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]bool, len(networks))
+	for _, n := range networks {
+		used[n.Subnet] = true
+	}
+
+	index := -1
+	for i := 0; i < maxNetworks; i++ {
+		if !used[subnet(i)] {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("no free subnet left for network %s", name)
+	}
+
+	networkDir := filepath.Join(s.dir, name)
+	if err := os.Mkdir(networkDir, 0755); err != nil {
+		if os.IsExist(err) {
+			return nil, &ExistsError{Name: name}
+		}
+		return nil, fmt.Errorf("failed to create network directory: %w", err)
+	}
+
+	n := &Network{
+		Name:      name,
+		Bridge:    fmt.Sprintf("mpbr%d", index),
+		Subnet:    subnet(index),
+		Gateway:   fmt.Sprintf("10.99.%d.1", index),
+		Labels:    labels,
+		CreatedAt: time.Now(),
+	}
+
+	data, err := json.MarshalIndent(n, "", "  ")
+	if err != nil {
+		os.RemoveAll(networkDir)
+		return nil, fmt.Errorf("failed to marshal network: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(networkDir, metadataFile), data, 0644); err != nil {
+		os.RemoveAll(networkDir)
+		return nil, fmt.Errorf("failed to write network metadata: %w", err)
+	}
+
+	return n, nil
+}
+
+func subnet(index int) string {
+	return fmt.Sprintf("10.99.%d.0/24", index)
+}
+
+// Get returns the network called name.
+func (s *Store) Get(name string) (*Network, error) {
+	if ValidateName(name) != nil {
+		return nil, &NotFoundError{Name: name}
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, name, metadataFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &NotFoundError{Name: name}
+		}
+		return nil, fmt.Errorf("failed to read network metadata: %w", err)
+	}
+
+	var n Network
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal network metadata: %w", err)
+	}
+
+	return &n, nil
+}
+
+// List returns all networks sorted by name.
+func (s *Store) List() ([]Network, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read network directory: %w", err)
+	}
+
+	var networks []Network
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		n, err := s.Get(entry.Name())
+		if err != nil {
+			// Half-created networks have no metadata yet.
+			continue
+		}
+		networks = append(networks, *n)
+	}
+
+	sort.Slice(networks, func(i, j int) bool {
+		return networks[i].Name < networks[j].Name
+	})
+
+	return networks, nil
+}
+
+// Remove deletes the record of a network.
+func (s *Store) Remove(name string) error {
+	if _, err := s.Get(name); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(filepath.Join(s.dir, name)); err != nil {
+		return fmt.Errorf("failed to remove network: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package network
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"web", "db"} {
+		if _, err := store.Create(name, nil); err != nil {
+			t.Fatalf("Create(%s): %v", name, err)
+		}
+	}
+
+	var exists *ExistsError
+	if _, err := store.Create("web", nil); !errors.As(err, &exists) {
+		t.Errorf("Create(web) again: got %v, want ExistsError", err)
+	}
+	if _, err := store.Create("../etc", nil); err == nil {
+		t.Error("Create(../etc) succeeded")
+	}
+
+	web, err := store.Get("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if web.Subnet != "10.99.0.0/24" || web.Bridge != "mpbr0" || web.GatewayCIDR() != "10.99.0.1/24" {
+		t.Errorf("Get(web) = %+v, want the first subnet", web)
+	}
+
+	// A removed network's subnet is reused.
+	if err := store.Remove("web"); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := store.Create("cache", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.Subnet != "10.99.0.0/24" {
+		t.Errorf("Create(cache) subnet = %s, want 10.99.0.0/24", cache.Subnet)
+	}
+
+	networks, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 2 || networks[0].Name != "cache" || networks[1].Name != "db" {
+		t.Fatalf("List() = %+v, want cache and db", networks)
+	}
+
+	var notFound *NotFoundError
+	if _, err := store.Get("web"); !errors.As(err, &notFound) {
+		t.Errorf("Get(web) after Remove: got %v, want NotFoundError", err)
+	}
+}
+
+func TestAllocateAddress(t *testing.T) {
+	n := &Network{Name: "test", Subnet: "10.99.3.0/24", Gateway: "10.99.3.1"}
+
+	addr, err := n.AllocateAddress(map[string]bool{"10.99.3.2": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != "10.99.3.3/24" {
+		t.Errorf("AllocateAddress() = %s, want 10.99.3.3/24", addr)
+	}
+
+	used := make(map[string]bool)
+	for i := 2; i < 255; i++ {
+		addr, err := n.AllocateAddress(used)
+		if err != nil {
+			t.Fatalf("allocation %d: %v", i, err)
+		}
+		used[addr[:len(addr)-3]] = true
+	}
+	if addr, err := n.AllocateAddress(used); err == nil {
+		t.Errorf("AllocateAddress() on a full network = %s, want an error", addr)
+	}
+
+	mac, err := MACAddress("10.99.3.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mac != "06:00:0a:63:03:02" {
+		t.Errorf("MACAddress() = %s, want 06:00:0a:63:03:02", mac)
+	}
+}
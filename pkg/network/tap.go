@@ -0,0 +1,44 @@
+package network
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// CreateTap creates a tap device owned by uid and brings it up. With a
+// non-empty netns, the device is created inside that named namespace.
+func CreateTap(name, netns string, uid int) error {
+	if err := ip(netns, "tuntap", "add", "dev", name, "mode", "tap", "user", fmt.Sprint(uid)); err != nil {
+		return fmt.Errorf("failed to create tap device %s: %w", name, err)
+	}
+
+	if err := ip(netns, "link", "set", name, "up"); err != nil {
+		ip(netns, "link", "delete", name)
+		return fmt.Errorf("failed to bring up tap device %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// DeleteTap removes a tap device. Devices inside a network namespace go away
+// with the namespace and need not be deleted.
+func DeleteTap(name, netns string) error {
+	if err := ip(netns, "link", "delete", name); err != nil {
+		return fmt.Errorf("failed to delete tap device %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func ip(netns string, args ...string) error {
+	if netns != "" {
+		args = append([]string{"-n", netns}, args...)
+	}
+
+	cmd := exec.Command("ip", args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, output)
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package procfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, which is 100 on every Linux platform Firecracker
+// supports.
+const clockTicks = 100
+
+// ProcessStats is a snapshot of a host process taken from /proc.
+type ProcessStats struct {
+	// CPUTimeUsec is the user plus system CPU time in microseconds.
+	CPUTimeUsec uint64 `json:"cpuTimeUsec"`
+	RSSBytes    uint64 `json:"rssBytes"`
+	Threads     uint64 `json:"threads"`
+	// ReadBytes and WriteBytes are the bytes the process caused to be
+	// fetched from or sent to the storage layer.
+	ReadBytes  uint64 `json:"readBytes"`
+	WriteBytes uint64 `json:"writeBytes"`
+}
+
+// ReadProcessStats reads /proc/<pid>/stat, status and io.
+func ReadProcessStats(pid int) (*ProcessStats, error) {
+	stats := &ProcessStats{}
+
+	if err := readStat(pid, stats); err != nil {
+		return nil, err
+	}
+
+	status, err := readKeyed(fmt.Sprintf("/proc/%d/status", pid), ":")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read status of process %d: %w", pid, err)
+	}
+	stats.RSSBytes = parseKB(status["VmRSS"])
+	stats.Threads, _ = strconv.ParseUint(status["Threads"], 10, 64)
+
+	// io is only readable by the process owner, so it is optional.
+	if io, err := readKeyed(fmt.Sprintf("/proc/%d/io", pid), ":"); err == nil {
+		stats.ReadBytes, _ = strconv.ParseUint(io["read_bytes"], 10, 64)
+		stats.WriteBytes, _ = strconv.ParseUint(io["write_bytes"], 10, 64)
+	}
+
+	return stats, nil
+}
+
+// readStat parses utime and stime from /proc/<pid>/stat. The command name
+// may contain spaces, so fields are counted from its closing parenthesis.
+func readStat(pid int, stats *ProcessStats) error {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return fmt.Errorf("failed to read stat of process %d: %w", pid, err)
+	}
+
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return fmt.Errorf("malformed stat of process %d", pid)
+	}
+
+	// Fields after the command name start at "state" (field 3); utime and
+	// stime are fields 14 and 15.
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 13 {
+		return fmt.Errorf("malformed stat of process %d", pid)
+	}
+
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	stats.CPUTimeUsec = (utime + stime) * 1000000 / clockTicks
+
+	return nil
+}
+
+// ReadCmdline returns the command line arguments of a process.
+func ReadCmdline(pid int) ([]string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cmdline of process %d: %w", pid, err)
+	}
+
+	var args []string
+	for _, arg := range bytes.Split(bytes.TrimSuffix(data, []byte{0}), []byte{0}) {
+		args = append(args, string(arg))
+	}
+	return args, nil
+}
+
+// ReadStartTime returns when a process started, to the resolution of
+// USER_HZ. It tells a process apart from a later one that reused its pid.
+func ReadStartTime(pid int) (time.Time, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read stat of process %d: %w", pid, err)
+	}
+
+	// starttime is field 22, in clock ticks since boot.
+	end := strings.LastIndexByte(string(data), ')')
+	fields := strings.Fields(string(data[end+1:]))
+	if end < 0 || len(fields) < 20 {
+		return time.Time{}, fmt.Errorf("malformed stat of process %d", pid)
+	}
+	ticks, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("malformed stat of process %d: %w", pid, err)
+	}
+
+	stat, err := readKeyed("/proc/stat", " ")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read /proc/stat: %w", err)
+	}
+	bootTime, err := strconv.ParseInt(stat["btime"], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse boot time: %w", err)
+	}
+
+	return time.Unix(bootTime, 0).Add(time.Duration(ticks) * time.Second / clockTicks), nil
+}
+
+func readKeyed(path, sep string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), sep)
+		if ok {
+			values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+
+	return values, scanner.Err()
+}
+
+// parseKB parses values such as "1234 kB" into bytes.
+func parseKB(value string) uint64 {
+	n, _ := strconv.ParseUint(strings.TrimSuffix(value, " kB"), 10, 64)
+	return n * 1024
+}
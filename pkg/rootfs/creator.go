@@ -6,6 +6,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 type Creator struct {
@@ -26,7 +27,8 @@ func NewCreator(rootfsdir string) (*Creator, error) {
 	}, nil
 }
 
-func (c *Creator) Create(tarPath, vmID string) (string, error) {
+func (c *Creator) Create(tarPath, vmID string) (_ string, err error) {
+	defer observeBuild("tar", time.Now(), &err)
 	ext4Path := filepath.Join(c.rootfsDir, fmt.Sprintf("%s.ext4", vmID))
 	mountPoint := filepath.Join(c.mountDir, vmID)
 	
@@ -72,7 +74,8 @@ func (c *Creator) Create(tarPath, vmID string) (string, error) {
 }
 
 // CreateFromDir creates an ext4 filesystem from a directory instead of a tar file
-func (c *Creator) CreateFromDir(sourceDir, vmID string) (string, error) {
+func (c *Creator) CreateFromDir(sourceDir, vmID string) (_ string, err error) {
+	defer observeBuild("dir", time.Now(), &err)
 	ext4Path := filepath.Join(c.rootfsDir, fmt.Sprintf("%s.ext4", vmID))
 	mountPoint := filepath.Join(c.mountDir, vmID)
 	
@@ -247,4 +250,147 @@ func (c *Creator) GetSizeGB(ext4Path string) (float64, error) {
 	
 	sizeGB := float64(info.Size()) / (1024 * 1024 * 1024)
 	return sizeGB, nil
-}
\ No newline at end of file
+}
+
+// CreateEmpty creates a blank ext4 image of sizeMB MiB, e.g. for a data
+// volume.
+func (c *Creator) CreateEmpty(ext4Path string, sizeMB int) error {
+	if err := c.checkSudoAvailable(); err != nil {
+		return fmt.Errorf("sudo access required: %w", err)
+	}
+
+	f, err := os.OpenFile(ext4Path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create image file: %w", err)
+	}
+	err = f.Truncate(int64(sizeMB) * 1024 * 1024)
+	f.Close()
+	if err != nil {
+		c.cleanup(ext4Path)
+		return fmt.Errorf("failed to size image file: %w", err)
+	}
+
+	if err := c.formatExt4(ext4Path); err != nil {
+		c.cleanup(ext4Path)
+		return fmt.Errorf("failed to format ext4: %w", err)
+	}
+
+	return nil
+}
+
+// PackDir builds an image of fsType, ext4 or squashfs, at imagePath holding
+// the contents of sourceDir. ext4 images get some free space on top of the
+// directory size.
+func (c *Creator) PackDir(sourceDir, imagePath, fsType string) (err error) {
+	defer observeBuild("pack", time.Now(), &err)
+	switch fsType {
+	case "squashfs":
+		fmt.Printf("Packing %s into squashfs image %s\n", sourceDir, imagePath)
+		cmd := exec.Command("mksquashfs", sourceDir, imagePath, "-noappend", "-all-root", "-quiet")
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to create squashfs image: %w", err)
+		}
+		return nil
+	case "ext4":
+	default:
+		return fmt.Errorf("unsupported filesystem %q", fsType)
+	}
+
+	usedMB, err := dirSizeMB(sourceDir)
+	if err != nil {
+		return err
+	}
+	if err := c.CreateEmpty(imagePath, usedMB+usedMB/4+64); err != nil {
+		return err
+	}
+
+	mountPoint := filepath.Join(c.mountDir, filepath.Base(imagePath))
+	defer func() {
+		c.unmount(mountPoint)
+		c.removeMount(mountPoint)
+	}()
+
+	if err := c.createMountPoint(mountPoint); err != nil {
+		c.cleanup(imagePath)
+		return fmt.Errorf("failed to create mount point: %w", err)
+	}
+	if err := c.mount(imagePath, mountPoint); err != nil {
+		c.cleanup(imagePath)
+		return fmt.Errorf("failed to mount: %w", err)
+	}
+	if err := c.copyDir(sourceDir, mountPoint); err != nil {
+		c.cleanup(imagePath)
+		return fmt.Errorf("failed to copy directory: %w", err)
+	}
+	if err := c.unmount(mountPoint); err != nil {
+		c.cleanup(imagePath)
+		return fmt.Errorf("failed to unmount: %w", err)
+	}
+
+	return nil
+}
+
+// ExtractDir copies the contents of the ext4 image at imagePath into
+// destDir, owned by owner (uid:gid), or by the current user if owner is
+// empty.
+func (c *Creator) ExtractDir(imagePath, destDir, owner string) error {
+	if err := c.checkSudoAvailable(); err != nil {
+		return fmt.Errorf("sudo access required: %w", err)
+	}
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", destDir, err)
+	}
+
+	mountPoint := filepath.Join(c.mountDir, filepath.Base(imagePath))
+	defer func() {
+		c.unmount(mountPoint)
+		c.removeMount(mountPoint)
+	}()
+
+	if err := c.createMountPoint(mountPoint); err != nil {
+		return fmt.Errorf("failed to create mount point: %w", err)
+	}
+	if err := c.mount(imagePath, mountPoint); err != nil {
+		return fmt.Errorf("failed to mount: %w", err)
+	}
+
+	fmt.Printf("Copying %s to %s\n", mountPoint, destDir)
+	cmd := exec.Command("sudo", "cp", "-a", mountPoint+"/.", destDir)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to copy directory: %w", err)
+	}
+
+	if owner == "" {
+		owner = fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+	}
+	if err := exec.Command("sudo", "chown", "-R", owner, destDir).Run(); err != nil {
+		return fmt.Errorf("failed to change owner of %s: %w", destDir, err)
+	}
+	// lost+found belongs to the image, not to the guest directory.
+	os.Remove(filepath.Join(destDir, "lost+found"))
+
+	return nil
+}
+
+func dirSizeMB(dir string) (int, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		// Account for inodes and directory blocks.
+		size += 4096
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure %s: %w", dir, err)
+	}
+	return int(size/(1024*1024)) + 1, nil
+}
@@ -0,0 +1,24 @@
+package rootfs
+
+import (
+	"time"
+
+	"micropod/pkg/metrics"
+)
+
+var (
+	buildDuration = metrics.Default.NewHistogram("micropod_rootfs_build_duration_seconds",
+		"Time taken to build root filesystem images, by source: an image tar (tar), a directory (dir) or a directory packed into an image (pack).", metrics.DurationBuckets, "source")
+	buildFailures = metrics.Default.NewCounter("micropod_rootfs_build_failures_total",
+		"Root filesystem builds that failed, by source.", "source")
+)
+
+// observeBuild records a build from source that started at start and ended
+// with *err.
+func observeBuild(source string, start time.Time, err *error) {
+	if *err != nil {
+		buildFailures.Inc(source)
+		return
+	}
+	buildDuration.Since(start, source)
+}
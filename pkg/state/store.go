@@ -4,19 +4,225 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
+
+	"micropod/pkg/cgroup"
+	"micropod/pkg/image"
 )
 
 type VM struct {
-	ID             string    `json:"id"`
-	ImageName      string    `json:"imageName"`
-	State          string    `json:"state"`
-	FirecrackerPid int       `json:"firecrackerPid"`
-	VMSocketPath   string    `json:"vmSocketPath"`
-	RootfsPath     string    `json:"rootfsPath"`
-	KernelPath     string    `json:"kernelPath"`
-	CreatedAt      time.Time `json:"createdAt"`
+	ID             string        `json:"id"`
+	Name           string        `json:"name,omitempty"`
+	ImageName      string        `json:"imageName"`
+	State          string        `json:"state"`
+	FirecrackerPid int           `json:"firecrackerPid"`
+	VMSocketPath   string        `json:"vmSocketPath"`
+	RootfsPath     string        `json:"rootfsPath"`
+	KernelPath     string        `json:"kernelPath"`
+	CreatedAt      time.Time     `json:"createdAt"`
+	Jailer         *Jailer       `json:"jailer,omitempty"`
+	CgroupPath     string        `json:"cgroupPath,omitempty"`
+	Limits         cgroup.Limits `json:"limits"`
+	MetricsPath    string        `json:"metricsPath,omitempty"`
+	VCPUs          int           `json:"vcpus"`
+	MemoryMB       int           `json:"memoryMB"`
+	Jailed         bool          `json:"jailed,omitempty"`
+	StartedAt      time.Time     `json:"startedAt"`
+	RestartPolicy  RestartPolicy `json:"restartPolicy"`
+	RestartCount   int           `json:"restartCount"`
+	LastExitReason string        `json:"lastExitReason,omitempty"`
+	// Kernel is the registry name of the kernel the VM boots, empty for
+	// the legacy vmlinux/vmlinux.elf. KernelVersion is its release, when
+	// the image records one.
+	Kernel        string `json:"kernel,omitempty"`
+	KernelVersion string `json:"kernelVersion,omitempty"`
+	// KernelArgs are the parameters merged into the default kernel
+	// command line, from the profile and run --kernel-args. InitrdPath is
+	// the initial ramdisk, if any.
+	KernelArgs string `json:"kernelArgs,omitempty"`
+	InitrdPath string `json:"initrdPath,omitempty"`
+	// ReadOnly is set when the rootfs is attached read-only; it is then a
+	// hard link to a shared base image. Tmpfs and Overlays are the guest
+	// directories micropod-init makes writable.
+	ReadOnly bool     `json:"readOnly,omitempty"`
+	Tmpfs    []string `json:"tmpfs,omitempty"`
+	Overlays []string `json:"overlays,omitempty"`
+	// Profile is the config.json profile the VM was created with.
+	Profile string `json:"profile,omitempty"`
+	// SpecHash identifies the spec the VM was created from.
+	SpecHash string `json:"specHash,omitempty"`
+	// ExitCode is the exit status of the workload's last run, when
+	// micropod-init reported one.
+	ExitCode *int `json:"exitCode,omitempty"`
+	// AutoRemove deletes the VM once it exits and is not restarted, instead
+	// of keeping it in the Exited state.
+	AutoRemove bool `json:"autoRemove,omitempty"`
+	// Labels are user metadata for selecting VMs; annotations are passed
+	// to the guest.
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Env, Command and Secrets are published to the guest through MMDS
+	// together with the labels and annotations. Secrets map names to
+	// references, never to secret values.
+	Env     map[string]string `json:"env,omitempty"`
+	Command []string          `json:"command,omitempty"`
+	Secrets map[string]string `json:"secrets,omitempty"`
+	// MMDSVersion is V1 or V2 when the VM serves MMDS, empty otherwise.
+	MMDSVersion string `json:"mmdsVersion,omitempty"`
+	// TapDevice is the host tap device backing the guest's MMDS interface.
+	TapDevice string `json:"tapDevice,omitempty"`
+	// Networks are the networks the VM is attached to, in the order of
+	// its network interfaces.
+	Networks []NetworkAttachment `json:"networks,omitempty"`
+	// VsockPath is the host side of the vsock device the guest agent is
+	// reached on.
+	VsockPath string `json:"vsockPath,omitempty"`
+	// LogPath is the guest's console log, kept across restarts. LogOffset
+	// is where the output of the current run starts.
+	LogPath   string `json:"logPath,omitempty"`
+	LogOffset int64  `json:"logOffset,omitempty"`
+	// TTY connects the serial console to a pseudo-terminal owned by the
+	// daemon, so that sessions can attach to it.
+	TTY bool `json:"tty,omitempty"`
+	// GuestInit is set when micropod-init is installed in the rootfs; it
+	// runs the command derived from ImageConfig and mounts Mounts.
+	GuestInit   bool          `json:"guestInit,omitempty"`
+	ImageConfig *image.Config `json:"imageConfig,omitempty"`
+	Mounts      []Mount       `json:"mounts,omitempty"`
+	// BootTimeout bounds the wait for the guest to boot on every launch;
+	// zero means the default and a negative value not waiting.
+	BootTimeout time.Duration `json:"bootTimeout,omitempty"`
+	// HealthCheck is the check run against the workload, from the image
+	// or the spec. Health holds its results while the VM runs.
+	HealthCheck *image.HealthConfig `json:"healthCheck,omitempty"`
+	Health      *Health             `json:"health,omitempty"`
+	// Pool names the pool a VM in the Pooled state was booted for. Its
+	// guest waits for a workload until run hands the VM out.
+	Pool string `json:"pool,omitempty"`
+}
+
+// Health statuses of a VM with a health check.
+const (
+	HealthStarting  = "starting"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
+
+// Health is the outcome of the health checks of a VM's current run.
+type Health struct {
+	Status string `json:"status"`
+	// FailingStreak counts the consecutive failed checks.
+	FailingStreak int `json:"failingStreak"`
+	// Log holds the most recent checks, oldest first.
+	Log []HealthResult `json:"log,omitempty"`
+}
+
+// HealthResult is the outcome of a single health check.
+type HealthResult struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	ExitCode int       `json:"exitCode"`
+	Output   string    `json:"output,omitempty"`
+}
+
+// Mount attaches a named volume or a block image file to a VM.
+type Mount struct {
+	// Type is "volume", "block", "copy-in" or "copy-out".
+	Type string `json:"type"`
+	// Source is the volume name, the path of the image file, or the host
+	// directory copied into or out of the VM.
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"readOnly,omitempty"`
+	// Image and FSType describe the drive built for copy-in and copy-out
+	// mounts.
+	Image  string `json:"image,omitempty"`
+	FSType string `json:"fsType,omitempty"`
+	// Owner is the uid:gid given to the files copied out, normally the
+	// user who ran the VM. Empty means the daemon's user.
+	Owner string `json:"owner,omitempty"`
+}
+
+// NetworkAttachment is a VM's interface on a network. The address and MAC
+// are kept across restarts.
+type NetworkAttachment struct {
+	Network string `json:"network"`
+	// Address is the guest's address in CIDR notation.
+	Address string `json:"address"`
+	MAC     string `json:"mac"`
+	// TapDevice is the host tap device on the network's bridge while the
+	// VM runs.
+	TapDevice string `json:"tapDevice,omitempty"`
+}
+
+// RestartPolicy decides whether the supervisor restarts a VM after its
+// Firecracker process exits.
+type RestartPolicy struct {
+	// Name is one of "no", "on-failure", "always" or "unless-stopped".
+	// Empty means "no".
+	Name string `json:"name,omitempty"`
+	// MaxRetries limits on-failure restarts; 0 means unlimited.
+	MaxRetries int `json:"maxRetries,omitempty"`
+}
+
+func (p RestartPolicy) String() string {
+	if p.Name == "" {
+		return "no"
+	}
+	if p.Name == "on-failure" && p.MaxRetries > 0 {
+		return fmt.Sprintf("on-failure:%d", p.MaxRetries)
+	}
+	return p.Name
+}
+
+// Jailer records the isolation resources of a VM launched through the jailer.
+type Jailer struct {
+	UID        int    `json:"uid"`
+	GID        int    `json:"gid"`
+	ChrootPath string `json:"chrootPath"`
+	NetNS      string `json:"netns"`
+}
+
+// NotFoundError is returned when no VM matches the requested ID or name.
+type NotFoundError struct {
+	ID string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("VM %s not found", e.ID)
+}
+
+// NameInUseError is returned when a VM name is already taken.
+type NameInUseError struct {
+	Name string
+	ID   string
+}
+
+func (e *NameInUseError) Error() string {
+	return fmt.Sprintf("VM name %q is already in use by VM %s", e.Name, e.ID)
+}
+
+// AmbiguousError is returned when an ID prefix matches several VMs.
+type AmbiguousError struct {
+	Ref string
+	IDs []string
+}
+
+func (e *AmbiguousError) Error() string {
+	return fmt.Sprintf("VM reference %q is ambiguous, it matches %d VMs: %s", e.Ref, len(e.IDs), strings.Join(e.IDs, ", "))
+}
+
+var namePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ValidateName checks that name can be used as a VM name.
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid VM name %q: only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	return nil
 }
 
 type Store struct {
@@ -40,6 +246,14 @@ func (s *Store) AddVM(vm VM) error {
 	if err != nil {
 		return fmt.Errorf("failed to load VMs: %w", err)
 	}
+
+	if vm.Name != "" {
+		for _, existing := range vms {
+			if existing.Name == vm.Name {
+				return &NameInUseError{Name: vm.Name, ID: existing.ID}
+			}
+		}
+	}
 	
 	vms = append(vms, vm)
 	
@@ -65,7 +279,53 @@ func (s *Store) GetVM(id string) (*VM, error) {
 		}
 	}
 	
-	return nil, fmt.Errorf("VM with ID %s not found", id)
+	return nil, &NotFoundError{ID: id}
+}
+
+// ResolveVM finds a VM by full ID, name or unambiguous ID prefix, in that
+// order of precedence.
+func (s *Store) ResolveVM(ref string) (*VM, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	vms, err := s.loadVMs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load VMs: %w", err)
+	}
+
+	for _, vm := range vms {
+		if vm.ID == ref {
+			return &vm, nil
+		}
+	}
+
+	for _, vm := range vms {
+		if vm.Name != "" && vm.Name == ref {
+			return &vm, nil
+		}
+	}
+
+	var matches []VM
+	if ref != "" {
+		for _, vm := range vms {
+			if strings.HasPrefix(vm.ID, ref) {
+				matches = append(matches, vm)
+			}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, &NotFoundError{ID: ref}
+	case 1:
+		return &matches[0], nil
+	default:
+		ids := make([]string, len(matches))
+		for i, vm := range matches {
+			ids[i] = vm.ID
+		}
+		return nil, &AmbiguousError{Ref: ref, IDs: ids}
+	}
 }
 
 func (s *Store) RemoveVM(id string) error {
@@ -88,7 +348,7 @@ func (s *Store) RemoveVM(id string) error {
 	}
 	
 	if !found {
-		return fmt.Errorf("VM with ID %s not found", id)
+		return &NotFoundError{ID: id}
 	}
 	
 	if err := s.saveVMs(updatedVMs); err != nil {
@@ -105,6 +365,38 @@ func (s *Store) ListVMs() ([]VM, error) {
 	return s.loadVMs()
 }
 
+// UpdateVM replaces the stored record of vm. Like AddVM, it refuses a name
+// another VM has.
+func (s *Store) UpdateVM(vm VM) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	vms, err := s.loadVMs()
+	if err != nil {
+		return fmt.Errorf("failed to load VMs: %w", err)
+	}
+
+	found := false
+	for i := range vms {
+		if vms[i].ID == vm.ID {
+			vms[i] = vm
+			found = true
+		} else if vm.Name != "" && vms[i].Name == vm.Name {
+			return &NameInUseError{Name: vm.Name, ID: vms[i].ID}
+		}
+	}
+
+	if !found {
+		return &NotFoundError{ID: vm.ID}
+	}
+
+	if err := s.saveVMs(vms); err != nil {
+		return fmt.Errorf("failed to save VMs: %w", err)
+	}
+
+	return nil
+}
+
 func (s *Store) UpdateVMState(id string, state string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -124,7 +416,7 @@ func (s *Store) UpdateVMState(id string, state string) error {
 	}
 	
 	if !found {
-		return fmt.Errorf("VM with ID %s not found", id)
+		return &NotFoundError{ID: id}
 	}
 	
 	if err := s.saveVMs(vms); err != nil {
@@ -134,6 +426,36 @@ func (s *Store) UpdateVMState(id string, state string) error {
 	return nil
 }
 
+// UpdateVMHealth records the health of a VM.
+func (s *Store) UpdateVMHealth(id string, health *Health) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	vms, err := s.loadVMs()
+	if err != nil {
+		return fmt.Errorf("failed to load VMs: %w", err)
+	}
+
+	found := false
+	for i, vm := range vms {
+		if vm.ID == id {
+			vms[i].Health = health
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return &NotFoundError{ID: id}
+	}
+
+	if err := s.saveVMs(vms); err != nil {
+		return fmt.Errorf("failed to save VMs: %w", err)
+	}
+
+	return nil
+}
+
 func (s *Store) loadVMs() ([]VM, error) {
 	data, err := os.ReadFile(s.filePath)
 	if err != nil {
@@ -0,0 +1,100 @@
+package state
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestStore(t *testing.T, vms ...VM) *Store {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "vms.json")
+	if err := os.WriteFile(path, []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, vm := range vms {
+		if err := store.AddVM(vm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func TestResolveVM(t *testing.T) {
+	store := newTestStore(t,
+		VM{ID: "abc123-0000", Name: "web"},
+		VM{ID: "abd456-0000", Name: "abc123-0000x"},
+		VM{ID: "web-0000"},
+	)
+
+	tests := []struct {
+		ref    string
+		wantID string
+	}{
+		{"abc123-0000", "abc123-0000"},
+		{"web", "abc123-0000"},
+		{"abc1", "abc123-0000"},
+		{"abd", "abd456-0000"},
+		{"web-", "web-0000"},
+	}
+	for _, tt := range tests {
+		vm, err := store.ResolveVM(tt.ref)
+		if err != nil {
+			t.Errorf("ResolveVM(%q): %v", tt.ref, err)
+			continue
+		}
+		if vm.ID != tt.wantID {
+			t.Errorf("ResolveVM(%q) = %s, want %s", tt.ref, vm.ID, tt.wantID)
+		}
+	}
+
+	var ambiguous *AmbiguousError
+	if _, err := store.ResolveVM("ab"); !errors.As(err, &ambiguous) || len(ambiguous.IDs) != 2 {
+		t.Errorf("ResolveVM(\"ab\") = %v, want ambiguous error with 2 IDs", err)
+	}
+
+	var notFound *NotFoundError
+	if _, err := store.ResolveVM("zzz"); !errors.As(err, &notFound) {
+		t.Errorf("ResolveVM(\"zzz\") = %v, want not found error", err)
+	}
+	if _, err := store.ResolveVM(""); !errors.As(err, &notFound) {
+		t.Errorf("ResolveVM(\"\") = %v, want not found error", err)
+	}
+}
+
+func TestAddVMRejectsDuplicateName(t *testing.T) {
+	store := newTestStore(t, VM{ID: "a", Name: "web"})
+
+	var inUse *NameInUseError
+	if err := store.AddVM(VM{ID: "b", Name: "web"}); !errors.As(err, &inUse) || inUse.ID != "a" {
+		t.Errorf("AddVM with duplicate name = %v, want name in use by a", err)
+	}
+	if err := store.AddVM(VM{ID: "c"}); err != nil {
+		t.Errorf("AddVM without name: %v", err)
+	}
+	if err := store.AddVM(VM{ID: "d"}); err != nil {
+		t.Errorf("AddVM second VM without name: %v", err)
+	}
+}
+
+func TestUpdateVMRejectsDuplicateName(t *testing.T) {
+	store := newTestStore(t, VM{ID: "a", Name: "web"}, VM{ID: "b"})
+
+	var inUse *NameInUseError
+	if err := store.UpdateVM(VM{ID: "b", Name: "web"}); !errors.As(err, &inUse) || inUse.ID != "a" {
+		t.Errorf("UpdateVM with duplicate name = %v, want name in use by a", err)
+	}
+	if err := store.UpdateVM(VM{ID: "a", Name: "web", State: "Running"}); err != nil {
+		t.Errorf("UpdateVM keeping its own name: %v", err)
+	}
+	if err := store.UpdateVM(VM{ID: "b", Name: "api"}); err != nil {
+		t.Errorf("UpdateVM with a new name: %v", err)
+	}
+}
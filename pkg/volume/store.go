@@ -0,0 +1,185 @@
+// Package volume manages named volumes: ext4 image files that are attached
+// to VMs as extra drives.
+package volume
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+const (
+	metadataFile = "volume.json"
+	diskFile     = "disk.ext4"
+)
+
+// Volume is a named ext4 image file.
+type Volume struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	SizeMB    int       `json:"sizeMB"`
+	CreatedAt time.Time `json:"createdAt"`
+	// UsedBy lists the IDs of the VMs the volume is attached to. It is
+	// derived from the VM state, not stored.
+	UsedBy []string `json:"usedBy,omitempty"`
+}
+
+// NotFoundError is returned when no volume has the requested name.
+type NotFoundError struct {
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("volume %s not found", e.Name)
+}
+
+// ExistsError is returned when creating a volume whose name is taken.
+type ExistsError struct {
+	Name string
+}
+
+func (e *ExistsError) Error() string {
+	return fmt.Sprintf("volume %s already exists", e.Name)
+}
+
+// InUseError is returned when removing a volume attached to VMs.
+type InUseError struct {
+	Name  string
+	VMIDs []string
+}
+
+func (e *InUseError) Error() string {
+	return fmt.Sprintf("volume %s is in use by VMs %v", e.Name, e.VMIDs)
+}
+
+var namePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ValidateName checks that name can be used as a volume name.
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid volume name %q: only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	return nil
+}
+
+// Store keeps each volume in its own directory with the image file and its
+// metadata.
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create volume directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Create creates a volume of sizeMB MiB. format must create the image file
+// at the given path.
+func (s *Store) Create(name string, sizeMB int, format func(path string, sizeMB int) error) (*Volume, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	if sizeMB <= 0 {
+		return nil, fmt.Errorf("volume size must be positive")
+	}
+
+	volumeDir := filepath.Join(s.dir, name)
+	if err := os.Mkdir(volumeDir, 0755); err != nil {
+		if os.IsExist(err) {
+			return nil, &ExistsError{Name: name}
+		}
+		return nil, fmt.Errorf("failed to create volume directory: %w", err)
+	}
+
+	vol := &Volume{
+		Name:      name,
+		Path:      filepath.Join(volumeDir, diskFile),
+		SizeMB:    sizeMB,
+		CreatedAt: time.Now(),
+	}
+
+	if err := format(vol.Path, sizeMB); err != nil {
+		os.RemoveAll(volumeDir)
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(vol, "", "  ")
+	if err != nil {
+		os.RemoveAll(volumeDir)
+		return nil, fmt.Errorf("failed to marshal volume: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(volumeDir, metadataFile), data, 0644); err != nil {
+		os.RemoveAll(volumeDir)
+		return nil, fmt.Errorf("failed to write volume metadata: %w", err)
+	}
+
+	return vol, nil
+}
+
+// Get returns the volume called name.
+func (s *Store) Get(name string) (*Volume, error) {
+	if ValidateName(name) != nil {
+		return nil, &NotFoundError{Name: name}
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, name, metadataFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &NotFoundError{Name: name}
+		}
+		return nil, fmt.Errorf("failed to read volume metadata: %w", err)
+	}
+
+	var vol Volume
+	if err := json.Unmarshal(data, &vol); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal volume metadata: %w", err)
+	}
+
+	return &vol, nil
+}
+
+// List returns all volumes sorted by name.
+func (s *Store) List() ([]Volume, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read volume directory: %w", err)
+	}
+
+	var volumes []Volume
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		vol, err := s.Get(entry.Name())
+		if err != nil {
+			// Half-created volumes have no metadata yet.
+			continue
+		}
+		volumes = append(volumes, *vol)
+	}
+
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].Name < volumes[j].Name
+	})
+
+	return volumes, nil
+}
+
+// Remove deletes a volume and its image file.
+func (s *Store) Remove(name string) error {
+	if _, err := s.Get(name); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(filepath.Join(s.dir, name)); err != nil {
+		return fmt.Errorf("failed to remove volume: %w", err)
+	}
+
+	return nil
+}
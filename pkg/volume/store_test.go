@@ -0,0 +1,51 @@
+package volume
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func touch(path string, sizeMB int) error {
+	return os.WriteFile(path, nil, 0644)
+}
+
+func TestStore(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"data", "cache"} {
+		if _, err := store.Create(name, 64, touch); err != nil {
+			t.Fatalf("Create(%s): %v", name, err)
+		}
+	}
+
+	var exists *ExistsError
+	if _, err := store.Create("data", 64, touch); !errors.As(err, &exists) {
+		t.Errorf("Create(data) again: got %v, want ExistsError", err)
+	}
+	if _, err := store.Create("../etc", 64, touch); err == nil {
+		t.Error("Create(../etc) succeeded")
+	}
+	if _, err := store.Create("broken", 64, func(string, int) error { return errors.New("mkfs failed") }); err == nil {
+		t.Error("Create with a failing format succeeded")
+	}
+
+	volumes, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 2 || volumes[0].Name != "cache" || volumes[1].Name != "data" {
+		t.Fatalf("List() = %+v, want cache and data", volumes)
+	}
+
+	if err := store.Remove("data"); err != nil {
+		t.Fatal(err)
+	}
+	var notFound *NotFoundError
+	if _, err := store.Get("data"); !errors.As(err, &notFound) {
+		t.Errorf("Get(data) after Remove: got %v, want NotFoundError", err)
+	}
+}
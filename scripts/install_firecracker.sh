@@ -38,6 +38,12 @@ if [ -f "release-${VERSION}-x86_64/firecracker-${VERSION}-x86_64" ]
 then
     sudo cp "release-${VERSION}-x86_64/firecracker-${VERSION}-x86_64" /usr/local/bin/firecracker
     echo "Firecracker 安装成功！"
+    # jailer 用于 micropod run --jailer
+    if [ -f "release-${VERSION}-x86_64/jailer-${VERSION}-x86_64" ]
+    then
+        sudo cp "release-${VERSION}-x86_64/jailer-${VERSION}-x86_64" /usr/local/bin/jailer
+        echo "Jailer 安装成功！"
+    fi
     echo "您现在可以通过运行 'firecracker --version' 来验证安装。"
 else
     echo "错误：在解压后的目录中找不到 Firecracker 可执行文件。"